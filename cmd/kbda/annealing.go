@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
 	"strings"
 	"time"
 	"unicode"
@@ -63,8 +64,33 @@ type SimulatedAnnealingParams struct {
 	Iterations    int
 	Restarts      int
 	RandomSeed    int64
+	// Параметры рестартов с возмущением: часть рестартов начинается не со
+	// случайной раскладки, а с лучшей найденной раскладки после PerturbationSwaps
+	// случайных перестановок
+	PerturbationSwaps    int     // Количество случайных перестановок при возмущении
+	PerturbationFraction float64 // Доля рестартов с возмущением (0 - отключено, 1 - все рестарты)
+	// Ограничение поиска по времени: если Deadline задан, рестарты выполняются до его
+	// наступления независимо от Restarts, текущий рестарт при этом прерывается
+	Deadline time.Time
+	// Канал прерывания поиска (Ctrl+C во время команд g и beat): после его закрытия текущий
+	// рестарт прерывается, новые рестарты не начинаются, возвращаются найденные раскладки
+	Interrupt <-chan struct{}
+	// Пользовательская целевая функция поиска (команда g expr="..."), если не задана,
+	// оптимизируется общая оценка раскладки WeightedScore
+	Objective *Expression
 }
 
+// score возвращает значение целевой функции поиска для результата анализа
+func (params SimulatedAnnealingParams) score(analysis *LayoutAnalysis) float64 {
+	if params.Objective != nil {
+		return params.Objective.Evaluate(analysis)
+	}
+	return analysis.WeightedScore
+}
+
+// deadlineCheckInterval количество итераций между проверками времени окончания поиска
+const deadlineCheckInterval = 100
+
 // SimulatedAnnealingResult содержит результат поиска
 type SimulatedAnnealingResult struct {
 	Layout   Layout
@@ -80,7 +106,87 @@ func DefaultSAParams() SimulatedAnnealingParams {
 		Iterations:  10000,
 		Restarts:    5,
 		RandomSeed:  time.Now().UnixNano(),
+
+		PerturbationSwaps:    defaultPerturbationSwaps,
+		PerturbationFraction: 0,
+	}
+}
+
+// defaultPerturbationSwaps количество случайных перестановок при возмущении по умолчанию
+const defaultPerturbationSwaps = 5
+
+// usePerturbation определяет, нужно ли начать рестарт с возмущенной лучшей раскладки
+func (params SimulatedAnnealingParams) usePerturbation(restart int, results []SimulatedAnnealingResult) bool {
+	if restart == 0 || len(results) == 0 || params.PerturbationSwaps <= 0 {
+		return false
+	}
+	return rand.Float64() < params.PerturbationFraction
+}
+
+// timedOut проверяет, истекло ли время, отведенное на поиск
+func (params SimulatedAnnealingParams) timedOut() bool {
+	return !params.Deadline.IsZero() && !time.Now().Before(params.Deadline)
+}
+
+// interrupted проверяет, был ли поиск прерван пользователем
+func (params SimulatedAnnealingParams) interrupted() bool {
+	select {
+	case <-params.Interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopped проверяет, нужно ли прервать текущий рестарт по времени или по запросу пользователя
+func (params SimulatedAnnealingParams) stopped() bool {
+	return params.timedOut() || params.interrupted()
+}
+
+// nextRestart определяет, нужно ли выполнять рестарт с номером restart. Без ограничения
+// по времени выполняется Restarts рестартов, с ограничением - рестарты продолжаются до
+// Deadline, после чего выводится количество выполненных рестартов
+func (params SimulatedAnnealingParams) nextRestart(restart int) bool {
+	if params.interrupted() {
+		fmt.Printf("Поиск прерван, выполнено рестартов: %d\n", restart)
+		return false
+	}
+	if params.Deadline.IsZero() {
+		return restart < params.Restarts
+	}
+	if params.timedOut() {
+		fmt.Printf("Время поиска истекло, выполнено рестартов: %d\n", restart)
+		return false
+	}
+	return true
+}
+
+// restartLabel возвращает номер рестарта для вывода прогресса
+func (params SimulatedAnnealingParams) restartLabel(restart int) string {
+	if params.Deadline.IsZero() {
+		return fmt.Sprintf("%d/%d", restart+1, params.Restarts)
 	}
+	return fmt.Sprintf("%d (до %s)", restart+1, params.Deadline.Format("15:04:05"))
+}
+
+// bestResultLayout возвращает раскладку с наименьшим score из результатов
+func bestResultLayout(results []SimulatedAnnealingResult) Layout {
+	best := 0
+	for i := range results {
+		if results[i].betterThan(results[best]) {
+			best = i
+		}
+	}
+	return results[best].Layout
+}
+
+// perturbLayout применяет к раскладке swaps случайных перестановок, используя
+// функцию генерации соседней раскладки, соответствующую режиму поиска
+func perturbLayout(layout Layout, swaps int, neighbor func(*Layout) Layout) Layout {
+	for i := 0; i < swaps; i++ {
+		layout = neighbor(&layout)
+	}
+	return layout
 }
 
 // SearchOptimalLayout выполняет поиск оптимальной раскладки
@@ -92,17 +198,23 @@ func SearchOptimalLayout(config *KeyboardConfig, langData *LanguageData, params
 
 	var bestResults []SimulatedAnnealingResult
 
-	for restart := 0; restart < params.Restarts; restart++ {
-		fmt.Printf("Рестарт %d/%d\n", restart+1, params.Restarts)
+	for restart := 0; params.nextRestart(restart); restart++ {
+		fmt.Printf("Рестарт %s\n", params.restartLabel(restart))
 
 		currentLayout := initialLayout
-		if restart > 0 {
+		if params.usePerturbation(restart, bestResults) {
+			// Начинаем с лучшей найденной раскладки после случайных перестановок
+			currentLayout = perturbLayout(bestResultLayout(bestResults), params.PerturbationSwaps, func(l *Layout) Layout {
+				return generateNeighbor(l, config)
+			})
+			fmt.Printf("  Рестарт с возмущением лучшей раскладки (%d перестановок)\n", params.PerturbationSwaps)
+		} else if restart > 0 {
 			// Для остальных рестартов генерируем новую начальную раскладку
 			currentLayout = generateRandomLayout(config, langData)
 		}
 
 		currentAnalysis := AnalyzeLayout(&currentLayout, config, langData)
-		currentScore := currentAnalysis.WeightedScore
+		currentScore := params.score(currentAnalysis)
 
 		bestLayoutRestart := currentLayout
 		bestScoreRestart := currentScore
@@ -111,10 +223,14 @@ func SearchOptimalLayout(config *KeyboardConfig, langData *LanguageData, params
 		temperature := params.InitialTemp
 
 		for iter := 0; iter < params.Iterations; iter++ {
+			if iter%deadlineCheckInterval == 0 && params.stopped() {
+				break
+			}
+
 			// Генерируем соседнее решение
 			neighborLayout := generateNeighbor(&currentLayout, config)
 			neighborAnalysis := AnalyzeLayout(&neighborLayout, config, langData)
-			neighborScore := neighborAnalysis.WeightedScore
+			neighborScore := params.score(neighborAnalysis)
 
 			// Вычисляем дельту
 			delta := neighborScore - currentScore
@@ -126,7 +242,7 @@ func SearchOptimalLayout(config *KeyboardConfig, langData *LanguageData, params
 				currentScore = neighborScore
 
 				// Обновляем лучшее решение для этого рестарта
-				if currentScore < bestScoreRestart {
+				if isBetterResult(currentScore, currentAnalysis, &currentLayout, bestScoreRestart, bestAnalysisRestart, &bestLayoutRestart) {
 					bestLayoutRestart = currentLayout
 					bestScoreRestart = currentScore
 					bestAnalysisRestart = currentAnalysis
@@ -173,17 +289,23 @@ func SearchOptimalLayoutFromLayouts(config *KeyboardConfig, langData *LanguageDa
 
 	var bestResults []SimulatedAnnealingResult
 
-	for restart := 0; restart < params.Restarts; restart++ {
-		fmt.Printf("Рестарт %d/%d\n", restart+1, params.Restarts)
+	for restart := 0; params.nextRestart(restart); restart++ {
+		fmt.Printf("Рестарт %s\n", params.restartLabel(restart))
 
 		currentLayout := initialLayout
-		if restart > 0 {
+		if params.usePerturbation(restart, bestResults) {
+			// Начинаем с лучшей найденной раскладки после случайных перестановок
+			currentLayout = perturbLayout(bestResultLayout(bestResults), params.PerturbationSwaps, func(l *Layout) Layout {
+				return generateNeighbor(l, config)
+			})
+			fmt.Printf("  Рестарт с возмущением лучшей раскладки (%d перестановок)\n", params.PerturbationSwaps)
+		} else if restart > 0 {
 			// Для остальных рестартов генерируем новую начальную раскладку
 			currentLayout = GenerateRandomLayoutFromLayouts(config, layouts, langData)
 		}
 
 		currentAnalysis := AnalyzeLayout(&currentLayout, config, langData)
-		currentScore := currentAnalysis.WeightedScore
+		currentScore := params.score(currentAnalysis)
 
 		bestLayoutRestart := currentLayout
 		bestScoreRestart := currentScore
@@ -192,10 +314,14 @@ func SearchOptimalLayoutFromLayouts(config *KeyboardConfig, langData *LanguageDa
 		temperature := params.InitialTemp
 
 		for iter := 0; iter < params.Iterations; iter++ {
+			if iter%deadlineCheckInterval == 0 && params.stopped() {
+				break
+			}
+
 			// Генерируем соседнее решение
 			neighborLayout := generateNeighbor(&currentLayout, config)
 			neighborAnalysis := AnalyzeLayout(&neighborLayout, config, langData)
-			neighborScore := neighborAnalysis.WeightedScore
+			neighborScore := params.score(neighborAnalysis)
 
 			// Вычисляем дельту
 			delta := neighborScore - currentScore
@@ -207,7 +333,7 @@ func SearchOptimalLayoutFromLayouts(config *KeyboardConfig, langData *LanguageDa
 				currentScore = neighborScore
 
 				// Обновляем лучшее решение для этого рестарта
-				if currentScore < bestScoreRestart {
+				if isBetterResult(currentScore, currentAnalysis, &currentLayout, bestScoreRestart, bestAnalysisRestart, &bestLayoutRestart) {
 					bestLayoutRestart = currentLayout
 					bestScoreRestart = currentScore
 					bestAnalysisRestart = currentAnalysis
@@ -285,7 +411,8 @@ func generateRandomLayout(config *KeyboardConfig, langData *LanguageData) Layout
 // GenerateRandomLayoutFromLayouts генерирует случайную раскладку, используя только буквы из существующих раскладок
 func GenerateRandomLayoutFromLayouts(config *KeyboardConfig, layouts *ParsedLayouts, langData *LanguageData) Layout {
 	layout := Layout{
-		Name: "random",
+		Name:      "random",
+		NumberRow: layouts.Layouts[0].NumberRow, // Цифровой ряд не участвует в перестановках
 	}
 
 	// Create a lowercase version of the first layout and track original uppercase positions
@@ -368,6 +495,92 @@ func GenerateRandomLayoutFromLayouts(config *KeyboardConfig, layouts *ParsedLayo
 	return layout
 }
 
+// GenerateGreedyLayout детерминированно строит раскладку: самый частый из неразмещенных
+// символов ставится на самую легкую из свободных позиций. Фиксированные позиции
+// определяются так же, как при случайном поиске: по заглавным буквам базовой раскладки,
+// а если их нет, по конфигурации. Если базовая раскладка не задана, используются все
+// символы из языковых данных.
+func GenerateGreedyLayout(config *KeyboardConfig, langData *LanguageData, baseLayout *Layout) Layout {
+	layout := Layout{
+		Name: "greedy",
+	}
+
+	var lowercaseBaseLayout *Layout
+	var uppercasePositions [3][10]bool
+	hasUppercase := false
+	lettersMap := make(map[string]bool)
+	if baseLayout != nil {
+		layout.NumberRow = baseLayout.NumberRow // Цифровой ряд не участвует в размещении
+		lowercaseBaseLayout, uppercasePositions = createLowercaseLayout(baseLayout)
+		hasUppercase = hasUppercaseLetters(baseLayout)
+		for row := 0; row < 3; row++ {
+			for col := 0; col < 10; col++ {
+				key := lowercaseBaseLayout.Keys[row][col]
+				if key != "" && key != " " {
+					lettersMap[key] = true
+				}
+			}
+		}
+	} else {
+		for char := range langData.Characters {
+			lettersMap[char] = true
+		}
+	}
+
+	// Размещаем фиксированные буквы и собираем свободные позиции
+	var freePositions [][2]int
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			fixed := config.FixedPositions[row][col]
+			switch {
+			case hasUppercase && uppercasePositions[row][col]:
+				layout.Keys[row][col] = lowercaseBaseLayout.Keys[row][col]
+				delete(lettersMap, layout.Keys[row][col])
+			case hasUppercase:
+				freePositions = append(freePositions, [2]int{row, col})
+			case fixed == "x":
+				// Без базовой раскладки позиция 'x' остается пустой
+				if lowercaseBaseLayout != nil {
+					layout.Keys[row][col] = lowercaseBaseLayout.Keys[row][col]
+					delete(lettersMap, layout.Keys[row][col])
+				}
+			case fixed != ".":
+				layout.Keys[row][col] = strings.ToLower(fixed)
+				delete(lettersMap, layout.Keys[row][col])
+			default:
+				freePositions = append(freePositions, [2]int{row, col})
+			}
+		}
+	}
+
+	// Свободные буквы по убыванию частоты
+	freeLetters := make([]string, 0, len(lettersMap))
+	for char := range lettersMap {
+		freeLetters = append(freeLetters, char)
+	}
+	sort.Slice(freeLetters, func(i, j int) bool {
+		freqI, freqJ := langData.Characters[freeLetters[i]], langData.Characters[freeLetters[j]]
+		if freqI != freqJ {
+			return freqI > freqJ
+		}
+		return freeLetters[i] < freeLetters[j]
+	})
+
+	// Свободные позиции по возрастанию усилия, при равенстве сохраняется порядок рядов и колонок
+	sort.SliceStable(freePositions, func(i, j int) bool {
+		return keyEffort(config, freePositions[i][0], freePositions[i][1]) < keyEffort(config, freePositions[j][0], freePositions[j][1])
+	})
+
+	for i, pos := range freePositions {
+		if i >= len(freeLetters) {
+			break
+		}
+		layout.Keys[pos[0]][pos[1]] = freeLetters[i]
+	}
+
+	return layout
+}
+
 // generateNeighbor генерирует соседнее решение путём обмена двух букв
 func generateNeighbor(layout *Layout, config *KeyboardConfig) Layout {
 	neighbor := *layout
@@ -422,20 +635,93 @@ func generateNeighbor(layout *Layout, config *KeyboardConfig) Layout {
 }
 
 
-// sortResultsByScore сортирует результаты по score (лучшие первыми)
+// scoreTieTolerance разница оценок, при которой раскладки считаются равноценными
+const scoreTieTolerance = 1e-9
+
+// isBetterResult сравнивает раскладку с текущей лучшей: меньшая оценка лучше, при равных
+// оценках предпочитается меньшая нагрузка на мизинцы, затем на верхний ряд, затем
+// раскладка с меньшей строкой клавиш, чтобы повторные запуски сходились к одному результату
+func isBetterResult(score float64, analysis *LayoutAnalysis, layout *Layout, bestScore float64, bestAnalysis *LayoutAnalysis, bestLayout *Layout) bool {
+	if score < bestScore-scoreTieTolerance {
+		return true
+	}
+	if score > bestScore+scoreTieTolerance || analysis == nil || bestAnalysis == nil {
+		return false
+	}
+	if analysis.PinkyLoad != bestAnalysis.PinkyLoad {
+		return analysis.PinkyLoad < bestAnalysis.PinkyLoad
+	}
+	if analysis.EffortByRow[0] != bestAnalysis.EffortByRow[0] {
+		return analysis.EffortByRow[0] < bestAnalysis.EffortByRow[0]
+	}
+	return layoutKeysString(layout) < layoutKeysString(bestLayout)
+}
+
+// betterThan проверяет, что результат лучше другого с учетом дополнительных критериев isBetterResult
+func (r SimulatedAnnealingResult) betterThan(other SimulatedAnnealingResult) bool {
+	return isBetterResult(r.Score, r.Analysis, &r.Layout, other.Score, other.Analysis, &other.Layout)
+}
+
+// layoutKeysString возвращает клавиши основных рядов раскладки одной строкой
+func layoutKeysString(layout *Layout) string {
+	var sb strings.Builder
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			sb.WriteString(layout.Keys[row][col])
+		}
+	}
+	return sb.String()
+}
+
+// sortResultsByScore сортирует результаты по score (лучшие первыми), равноценные
+// результаты упорядочиваются по дополнительным критериям isBetterResult
 func sortResultsByScore(results []SimulatedAnnealingResult) {
 	// Простая сортировка пузырьком
 	for i := 0; i < len(results); i++ {
 		for j := i + 1; j < len(results); j++ {
-			if results[j].Score < results[i].Score {
+			if results[j].betterThan(results[i]) {
 				results[i], results[j] = results[j], results[i]
 			}
 		}
 	}
 }
 
+// baseNeighborFunc генерирует соседнюю раскладку с учетом базовой раскладки и позиций заглавных букв
+type baseNeighborFunc func(layout *Layout, config *KeyboardConfig, baseLayout *Layout, uppercasePositions [3][10]bool) Layout
+
 // SearchOptimalLayoutFromSpecificLayout выполняет поиск оптимальной раскладки, используя заданную раскладку в качестве начальной точки
 func SearchOptimalLayoutFromSpecificLayout(config *KeyboardConfig, langData *LanguageData, layouts *ParsedLayouts, params SimulatedAnnealingParams, numBest int, startLayout Layout) []SimulatedAnnealingResult {
+	return searchFromSpecificLayout(config, langData, params, numBest, startLayout, generateNeighborFromBaseLayoutWithUppercaseInfo)
+}
+
+// SearchOptimalLayoutFingerLocked выполняет поиск оптимальной раскладки от заданной раскладки,
+// сохраняя закрепление букв за пальцами: переставляются только клавиши одного пальца
+func SearchOptimalLayoutFingerLocked(config *KeyboardConfig, langData *LanguageData, params SimulatedAnnealingParams, numBest int, startLayout Layout) []SimulatedAnnealingResult {
+	return searchFromSpecificLayout(config, langData, params, numBest, startLayout, generateSameFingerNeighbor)
+}
+
+// Половины клавиатуры для поиска SearchOptimalLayoutHalfLocked
+const (
+	HalfLeft  = 0 // Колонки 1-5
+	HalfRight = 1 // Колонки 6-10
+)
+
+// SearchOptimalLayoutHalfLocked выполняет поиск оптимальной раскладки от заданной раскладки,
+// переставляя клавиши только внутри указанной половины (HalfLeft или HalfRight), вторая половина не меняется
+func SearchOptimalLayoutHalfLocked(config *KeyboardConfig, langData *LanguageData, params SimulatedAnnealingParams, numBest int, startLayout Layout, half int) []SimulatedAnnealingResult {
+	return searchFromSpecificLayout(config, langData, params, numBest, startLayout, func(layout *Layout, config *KeyboardConfig, baseLayout *Layout, uppercasePositions [3][10]bool) Layout {
+		return generateSameHalfNeighbor(layout, config, baseLayout, uppercasePositions, half)
+	})
+}
+
+// SearchOptimalLayoutHomeRowLocked выполняет поиск оптимальной раскладки от заданной раскладки,
+// сохраняя средний (домашний) ряд: переставляются только клавиши верхнего и нижнего рядов
+func SearchOptimalLayoutHomeRowLocked(config *KeyboardConfig, langData *LanguageData, params SimulatedAnnealingParams, numBest int, startLayout Layout) []SimulatedAnnealingResult {
+	return searchFromSpecificLayout(config, langData, params, numBest, startLayout, generateOffHomeRowNeighbor)
+}
+
+// searchFromSpecificLayout выполняет поиск от заданной раскладки с указанным генератором соседних раскладок
+func searchFromSpecificLayout(config *KeyboardConfig, langData *LanguageData, params SimulatedAnnealingParams, numBest int, startLayout Layout, neighbor baseNeighborFunc) []SimulatedAnnealingResult {
 	rand.Seed(params.RandomSeed)
 
 	// Create a lowercase version of the start layout to normalize all letters to lowercase
@@ -444,8 +730,8 @@ func SearchOptimalLayoutFromSpecificLayout(config *KeyboardConfig, langData *Lan
 
 	var bestResults []SimulatedAnnealingResult
 
-	for restart := 0; restart < params.Restarts; restart++ {
-		fmt.Printf("Рестарт %d/%d\n", restart+1, params.Restarts)
+	for restart := 0; params.nextRestart(restart); restart++ {
+		fmt.Printf("Рестарт %s\n", params.restartLabel(restart))
 
 		// Use the lowercase starting layout
 		currentLayout := *lowercaseStartLayout
@@ -457,10 +743,18 @@ func SearchOptimalLayoutFromSpecificLayout(config *KeyboardConfig, langData *Lan
 				bestOfPrevious = bestResults[0].Layout
 			}
 			currentLayout = bestOfPrevious
+
+			// Part of the restarts start from the perturbed best layout to escape local minima
+			if params.usePerturbation(restart, bestResults) {
+				currentLayout = perturbLayout(currentLayout, params.PerturbationSwaps, func(l *Layout) Layout {
+					return neighbor(l, config, lowercaseStartLayout, uppercasePositions)
+				})
+				fmt.Printf("  Рестарт с возмущением лучшей раскладки (%d перестановок)\n", params.PerturbationSwaps)
+			}
 		}
 
 		currentAnalysis := AnalyzeLayout(&currentLayout, config, langData)
-		currentScore := currentAnalysis.WeightedScore
+		currentScore := params.score(currentAnalysis)
 
 		bestLayoutRestart := currentLayout
 		bestScoreRestart := currentScore
@@ -469,11 +763,15 @@ func SearchOptimalLayoutFromSpecificLayout(config *KeyboardConfig, langData *Lan
 		temperature := params.InitialTemp
 
 		for iter := 0; iter < params.Iterations; iter++ {
+			if iter%deadlineCheckInterval == 0 && params.stopped() {
+				break
+			}
+
 			// Generate neighboring solution - using only characters in the start layout
 			// Pass the original uppercase positions to respect them as fixed
-			neighborLayout := generateNeighborFromBaseLayoutWithUppercaseInfo(&currentLayout, config, lowercaseStartLayout, uppercasePositions)
+			neighborLayout := neighbor(&currentLayout, config, lowercaseStartLayout, uppercasePositions)
 			neighborAnalysis := AnalyzeLayout(&neighborLayout, config, langData)
-			neighborScore := neighborAnalysis.WeightedScore
+			neighborScore := params.score(neighborAnalysis)
 
 			// Calculate delta
 			delta := neighborScore - currentScore
@@ -485,7 +783,7 @@ func SearchOptimalLayoutFromSpecificLayout(config *KeyboardConfig, langData *Lan
 				currentScore = neighborScore
 
 				// Update best solution for this restart
-				if currentScore < bestScoreRestart {
+				if isBetterResult(currentScore, currentAnalysis, &currentLayout, bestScoreRestart, bestAnalysisRestart, &bestLayoutRestart) {
 					bestLayoutRestart = currentLayout
 					bestScoreRestart = currentScore
 					bestAnalysisRestart = currentAnalysis
@@ -520,42 +818,48 @@ func SearchOptimalLayoutFromSpecificLayout(config *KeyboardConfig, langData *Lan
 
 
 
+// RandomLayoutCharacters возвращает отсортированный набор символов, из которых строится случайная раскладка:
+// символы существующих раскладок в нижнем регистре, а если раскладок нет - все символы языка
+func RandomLayoutCharacters(layouts *ParsedLayouts, langData *LanguageData) []string {
+	// Extract characters from existing layouts (convert to lowercase to avoid uppercase letters)
+	charsMap := make(map[string]bool)
+	for _, layout := range layouts.Layouts {
+		for row := 0; row < 3; row++ {
+			for col := 0; col < 10; col++ {
+				key := layout.Keys[row][col]
+				if key != "" && key != " " {
+					charsMap[strings.ToLower(key)] = true // Convert to lowercase
+				}
+			}
+		}
+	}
+
+	// Fallback to all available characters if no layouts exist
+	if len(charsMap) == 0 {
+		for char := range langData.Characters {
+			charsMap[char] = true
+		}
+	}
+
+	letters := make([]string, 0, len(charsMap))
+	for char := range charsMap {
+		letters = append(letters, char)
+	}
+	sort.Strings(letters)
+	return letters
+}
+
 // SearchOptimalLayoutFromRandomLayout performs search for optimal layout starting from random layout ignoring fixed positions
 func SearchOptimalLayoutFromRandomLayout(config *KeyboardConfig, langData *LanguageData, layouts *ParsedLayouts, params SimulatedAnnealingParams, numBest int) []SimulatedAnnealingResult {
 	rand.Seed(params.RandomSeed)
 
 	var bestResults []SimulatedAnnealingResult
 
-	for restart := 0; restart < params.Restarts; restart++ {
-		fmt.Printf("Рестарт %d/%d\n", restart+1, params.Restarts)
+	for restart := 0; params.nextRestart(restart); restart++ {
+		fmt.Printf("Рестарт %s\n", params.restartLabel(restart))
 
 		// Create a random layout from only those characters present in existing layouts
-		var letters []string
-
-		// Extract characters from existing layouts (convert to lowercase to avoid uppercase letters)
-		charsMap := make(map[string]bool)
-		for _, layout := range layouts.Layouts {
-			for row := 0; row < 3; row++ {
-				for col := 0; col < 10; col++ {
-					key := layout.Keys[row][col]
-					if key != "" && key != " " {
-						charsMap[strings.ToLower(key)] = true  // Convert to lowercase
-					}
-				}
-			}
-		}
-
-		// Convert map to slice
-		for char := range charsMap {
-			letters = append(letters, char)
-		}
-
-		// Fallback to all available characters if no layouts exist
-		if len(letters) == 0 {
-			for char := range langData.Characters {
-				letters = append(letters, char)
-			}
-		}
+		letters := RandomLayoutCharacters(layouts, langData)
 
 		// Shuffle the letters
 		rand.Shuffle(len(letters), func(i, j int) {
@@ -577,8 +881,16 @@ func SearchOptimalLayoutFromRandomLayout(config *KeyboardConfig, langData *Langu
 			}
 		}
 
+		// Part of the restarts start from the perturbed best layout instead of a random one
+		if params.usePerturbation(restart, bestResults) {
+			currentLayout = perturbLayout(bestResultLayout(bestResults), params.PerturbationSwaps, func(l *Layout) Layout {
+				return generateRandomNeighborIgnoreFixed(l, config, langData)
+			})
+			fmt.Printf("  Рестарт с возмущением лучшей раскладки (%d перестановок)\n", params.PerturbationSwaps)
+		}
+
 		currentAnalysis := AnalyzeLayout(&currentLayout, config, langData)
-		currentScore := currentAnalysis.WeightedScore
+		currentScore := params.score(currentAnalysis)
 
 		bestLayoutRestart := currentLayout
 		bestScoreRestart := currentScore
@@ -587,10 +899,14 @@ func SearchOptimalLayoutFromRandomLayout(config *KeyboardConfig, langData *Langu
 		temperature := params.InitialTemp
 
 		for iter := 0; iter < params.Iterations; iter++ {
+			if iter%deadlineCheckInterval == 0 && params.stopped() {
+				break
+			}
+
 			// Generate neighboring solution - ignores fixed positions for random search
 			neighborLayout := generateRandomNeighborIgnoreFixed(&currentLayout, config, langData)
 			neighborAnalysis := AnalyzeLayout(&neighborLayout, config, langData)
-			neighborScore := neighborAnalysis.WeightedScore
+			neighborScore := params.score(neighborAnalysis)
 
 			// Calculate delta
 			delta := neighborScore - currentScore
@@ -602,7 +918,7 @@ func SearchOptimalLayoutFromRandomLayout(config *KeyboardConfig, langData *Langu
 				currentScore = neighborScore
 
 				// Update best solution for this restart
-				if currentScore < bestScoreRestart {
+				if isBetterResult(currentScore, currentAnalysis, &currentLayout, bestScoreRestart, bestAnalysisRestart, &bestLayoutRestart) {
 					bestLayoutRestart = currentLayout
 					bestScoreRestart = currentScore
 					bestAnalysisRestart = currentAnalysis
@@ -789,6 +1105,32 @@ func generateNeighborFromBaseLayoutWithUppercaseInfo(layout *Layout, config *Key
 		chars = append(chars, char)
 	}
 
+	// Находим позиции, которые не зафиксированы и не пусты
+	swapPositions := baseLayoutSwapPositions(layout, config, baseLayout, uppercasePositions)
+
+	if len(swapPositions) < 2 {
+		return neighbor
+	}
+
+	// Выбираем две случайные позиции
+	idx1 := rand.Intn(len(swapPositions))
+	idx2 := rand.Intn(len(swapPositions))
+	for idx2 == idx1 && len(swapPositions) > 1 {
+		idx2 = rand.Intn(len(swapPositions))
+	}
+
+	pos1 := swapPositions[idx1]
+	pos2 := swapPositions[idx2]
+
+	// Обмениваем буквы
+	neighbor.Keys[pos1[0]][pos1[1]], neighbor.Keys[pos2[0]][pos2[1]] =
+		neighbor.Keys[pos2[0]][pos2[1]], neighbor.Keys[pos1[0]][pos1[1]]
+
+	return neighbor
+}
+
+// baseLayoutSwapPositions возвращает позиции, которые участвуют в перестановках при поиске от базовой раскладки
+func baseLayoutSwapPositions(layout *Layout, config *KeyboardConfig, baseLayout *Layout, uppercasePositions [3][10]bool) [][2]int {
 	// Check if the original base layout had uppercase letters
 	hasUppercase := false
 	for row := 0; row < 3; row++ {
@@ -803,7 +1145,6 @@ func generateNeighborFromBaseLayoutWithUppercaseInfo(layout *Layout, config *Key
 		}
 	}
 
-	// Находим позиции, которые не зафиксированы и не пусты
 	var swapPositions [][2]int
 	for row := 0; row < 3; row++ {
 		for col := 0; col < 10; col++ {
@@ -828,19 +1169,112 @@ func generateNeighborFromBaseLayoutWithUppercaseInfo(layout *Layout, config *Key
 		}
 	}
 
-	if len(swapPositions) < 2 {
+	return swapPositions
+}
+
+// generateSameFingerNeighbor генерирует соседнюю раскладку, переставляя две клавиши одного пальца,
+// так что каждая буква остается закрепленной за своим пальцем
+func generateSameFingerNeighbor(layout *Layout, config *KeyboardConfig, baseLayout *Layout, uppercasePositions [3][10]bool) Layout {
+	neighbor := *layout
+
+	// Группируем доступные для перестановки позиции по пальцам
+	var groups [8][][2]int
+	for _, pos := range baseLayoutSwapPositions(layout, config, baseLayout, uppercasePositions) {
+		finger := getFingerForKey(pos[0], pos[1])
+		groups[finger] = append(groups[finger], pos)
+	}
+
+	// Выбираем пальцы, для которых есть хотя бы две позиции
+	var fingers []int
+	for finger, positions := range groups {
+		if len(positions) >= 2 {
+			fingers = append(fingers, finger)
+		}
+	}
+
+	if len(fingers) == 0 {
 		return neighbor
 	}
 
+	positions := groups[fingers[rand.Intn(len(fingers))]]
+
 	// Выбираем две случайные позиции
-	idx1 := rand.Intn(len(swapPositions))
-	idx2 := rand.Intn(len(swapPositions))
-	for idx2 == idx1 && len(swapPositions) > 1 {
-		idx2 = rand.Intn(len(swapPositions))
+	idx1 := rand.Intn(len(positions))
+	idx2 := rand.Intn(len(positions) - 1)
+	if idx2 >= idx1 {
+		idx2++
 	}
 
-	pos1 := swapPositions[idx1]
-	pos2 := swapPositions[idx2]
+	pos1 := positions[idx1]
+	pos2 := positions[idx2]
+
+	// Обмениваем буквы
+	neighbor.Keys[pos1[0]][pos1[1]], neighbor.Keys[pos2[0]][pos2[1]] =
+		neighbor.Keys[pos2[0]][pos2[1]], neighbor.Keys[pos1[0]][pos1[1]]
+
+	return neighbor
+}
+
+// generateSameHalfNeighbor генерирует соседнюю раскладку, переставляя две клавиши внутри
+// указанной половины клавиатуры с учетом фиксированных позиций
+func generateSameHalfNeighbor(layout *Layout, config *KeyboardConfig, baseLayout *Layout, uppercasePositions [3][10]bool, half int) Layout {
+	neighbor := *layout
+
+	// Оставляем только доступные для перестановки позиции в колонках нужной половины
+	var positions [][2]int
+	for _, pos := range baseLayoutSwapPositions(layout, config, baseLayout, uppercasePositions) {
+		if (pos[1] >= 5) == (half == HalfRight) {
+			positions = append(positions, pos)
+		}
+	}
+
+	if len(positions) < 2 {
+		return neighbor
+	}
+
+	// Выбираем две случайные позиции
+	idx1 := rand.Intn(len(positions))
+	idx2 := rand.Intn(len(positions) - 1)
+	if idx2 >= idx1 {
+		idx2++
+	}
+
+	pos1 := positions[idx1]
+	pos2 := positions[idx2]
+
+	// Обмениваем буквы
+	neighbor.Keys[pos1[0]][pos1[1]], neighbor.Keys[pos2[0]][pos2[1]] =
+		neighbor.Keys[pos2[0]][pos2[1]], neighbor.Keys[pos1[0]][pos1[1]]
+
+	return neighbor
+}
+
+// generateOffHomeRowNeighbor генерирует соседнюю раскладку, переставляя две клавиши верхнего
+// или нижнего ряда с учетом фиксированных позиций, средний ряд не меняется
+func generateOffHomeRowNeighbor(layout *Layout, config *KeyboardConfig, baseLayout *Layout, uppercasePositions [3][10]bool) Layout {
+	neighbor := *layout
+
+	// Оставляем только доступные для перестановки позиции вне среднего ряда
+	var positions [][2]int
+	for _, pos := range baseLayoutSwapPositions(layout, config, baseLayout, uppercasePositions) {
+		if pos[0] != 1 {
+			positions = append(positions, pos)
+		}
+	}
+
+	if len(positions) < 2 {
+		return neighbor
+	}
+
+	// Выбираем две случайные позиции
+	idx1 := rand.Intn(len(positions))
+	idx2 := rand.Intn(len(positions) - 1)
+	if idx2 >= idx1 {
+		idx2++
+	}
+
+	pos1 := positions[idx1]
+	pos2 := positions[idx2]
 
 	// Обмениваем буквы
 	neighbor.Keys[pos1[0]][pos1[1]], neighbor.Keys[pos2[0]][pos2[1]] =
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// layoutKeys возвращает отсортированные клавиши раскладки
+func layoutKeys(layout Layout) []string {
+	var keys []string
+	for row := 0; row < 3; row++ {
+		keys = append(keys, layout.Keys[row][:]...)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPerturbLayoutStaysCloseToParent(t *testing.T) {
+	config := selftestTestConfig(t)
+	langData := selftestLanguage()
+	const swaps = 3
+
+	rand.Seed(1)
+	for i := 0; i < 100; i++ {
+		parent := selftestLayout
+		seed := perturbLayout(parent, swaps, func(l *Layout) Layout {
+			return generateRandomNeighborIgnoreFixed(l, config, langData)
+		})
+
+		changed := 0
+		for row := 0; row < 3; row++ {
+			for col := 0; col < 10; col++ {
+				if seed.Keys[row][col] != parent.Keys[row][col] {
+					changed++
+				}
+			}
+		}
+		if changed == 0 {
+			t.Fatalf("возмущенная раскладка совпадает с исходной")
+		}
+		if changed > 2*swaps {
+			t.Fatalf("изменено клавиш: %d, больше %d для %d перестановок", changed, 2*swaps, swaps)
+		}
+		if got, want := layoutKeys(seed), layoutKeys(parent); !equalStrings(got, want) {
+			t.Fatalf("набор клавиш изменился при возмущении: %v", got)
+		}
+	}
+}
+
+func TestUsePerturbation(t *testing.T) {
+	results := []SimulatedAnnealingResult{{Layout: selftestLayout}}
+	params := DefaultSAParams()
+	if params.PerturbationFraction != 0 {
+		t.Fatalf("рестарты с возмущением должны быть отключены по умолчанию, доля %.2f", params.PerturbationFraction)
+	}
+	for restart := 0; restart < 10; restart++ {
+		if params.usePerturbation(restart, results) {
+			t.Fatalf("рестарт %d с возмущением при доле 0", restart)
+		}
+	}
+
+	params.PerturbationFraction = 1
+	if params.usePerturbation(0, results) {
+		t.Errorf("первый рестарт не должен начинаться с возмущения")
+	}
+	if params.usePerturbation(1, nil) {
+		t.Errorf("рестарт с возмущением без найденных раскладок")
+	}
+	if !params.usePerturbation(1, results) {
+		t.Errorf("рестарт без возмущения при доле 1")
+	}
+}
+
+func TestPerturbationConfig(t *testing.T) {
+	config := selftestTestConfig(t)
+	if config.PerturbationSwaps != defaultPerturbationSwaps || config.PerturbationFraction != 0 {
+		t.Errorf("значения по умолчанию: perturbation_swaps=%d, perturbation_fraction=%.2f", config.PerturbationSwaps, config.PerturbationFraction)
+	}
+
+	if err := parseWeights([]string{"perturbation_swaps=8", "perturbation_fraction=0.25"}, config); err != nil {
+		t.Fatalf("ошибка разбора параметров возмущения: %v", err)
+	}
+	if config.PerturbationSwaps != 8 || config.PerturbationFraction != 0.25 {
+		t.Errorf("разобрано perturbation_swaps=%d, perturbation_fraction=%.2f", config.PerturbationSwaps, config.PerturbationFraction)
+	}
+
+	for _, line := range []string{"perturbation_swaps=-1", "perturbation_fraction=1.5"} {
+		if err := parseWeights([]string{line}, config); err == nil {
+			t.Errorf("%s: ожидалась ошибка", line)
+		}
+	}
+}
+
+// equalStrings сравнивает два среза строк
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
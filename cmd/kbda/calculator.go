@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"math"
 	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 // Finger indices for finger assignment
@@ -33,6 +36,63 @@ func getFingerForKey(row, col int) int {
 	return fingerMap[col]
 }
 
+// Номера больших пальцев для колонок, заданных параметром thumb_cols
+const (
+	thumbFingerLeft  = 8
+	thumbFingerRight = 9
+)
+
+// isThumbColumn проверяет, нажимаются ли клавиши колонки большим пальцем
+func isThumbColumn(config *KeyboardConfig, col int) bool {
+	for _, thumbCol := range config.ThumbCols {
+		if thumbCol == col {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcludedColumn проверяет, исключается ли колонка из расчета вертикальных биграмм (HVB, FVB),
+// SRB и ножниц (HSB, FSB). Колонки задаются параметром excluded_cols, без него исключаются
+// колонки больших пальцев thumb_cols, а без обоих параметров - внутренние колонки указательных пальцев 5 и 6
+func isExcludedColumn(config *KeyboardConfig, col int) bool {
+	if len(config.ExcludedCols) > 0 {
+		for _, excludedCol := range config.ExcludedCols {
+			if excludedCol == col {
+				return true
+			}
+		}
+		return false
+	}
+	if len(config.ThumbCols) > 0 {
+		return isThumbColumn(config, col)
+	}
+	return col == 4 || col == 5
+}
+
+// fingerForKey возвращает палец для позиции с учетом колонок больших пальцев
+func fingerForKey(config *KeyboardConfig, row, col int) int {
+	if isThumbColumn(config, col) {
+		if getHalf(col) == 0 {
+			return thumbFingerLeft
+		}
+		return thumbFingerRight
+	}
+	return getFingerForKey(row, col)
+}
+
+// symmetricFinger возвращает номер пальца без учета руки (0 - мизинец, 3 - указательный),
+// так что симметричные пальцы левой и правой руки получают одинаковый номер
+func symmetricFinger(finger int) int {
+	if finger == thumbFingerRight {
+		return thumbFingerLeft
+	}
+	if finger > 3 && finger < thumbFingerLeft {
+		return 7 - finger
+	}
+	return finger
+}
+
 // getHalf возвращает номер половинки (0 - левая, 1 - правая)
 func getHalf(col int) int {
 	if col < 5 {
@@ -41,6 +101,60 @@ func getHalf(col int) int {
 	return 1
 }
 
+// numberRowIndex индекс цифрового ряда в таблице позиций клавиш
+const numberRowIndex = 3
+
+// physicalRow возвращает номер ряда с учетом расположения цифрового ряда
+// над верхним рядом (используется для расчета вертикальных расстояний)
+func physicalRow(row int) int {
+	if row == numberRowIndex {
+		return -1
+	}
+	return row
+}
+
+// keyEffort возвращает усилие для позиции (row, col). Если для цифрового ряда
+// усилия в конфигурации не заданы, используются усилия верхнего ряда
+func keyEffort(config *KeyboardConfig, row, col int) float64 {
+	if row == numberRowIndex {
+		if len(config.NumberRowEfforts) == 10 {
+			return config.NumberRowEfforts[col]
+		}
+		return config.EffortMatrix[0][col]
+	}
+	return config.EffortMatrix[row][col]
+}
+
+// classifyLSB определяет, является ли биграмма LSB (Lateral Stretch Bigram):
+// обе клавиши на одной половинке в колонках 3-5 или 6-8. Второе значение
+// показывает, что биграмма набирается указательным и средним пальцами
+// (условие строгого режима LSB_strict_mode)
+func classifyLSB(half1, half2, col1, col2, finger1, finger2 int) (bool, bool) {
+	if half1 != half2 {
+		return false, false
+	}
+
+	// Клавиши больших пальцев в LSB не учитываются
+	if finger1 >= thumbFingerLeft || finger2 >= thumbFingerLeft {
+		return false, false
+	}
+
+	// Это колонки 2-4 или 5-7 (в индексах 0-9)
+	isPattern := (col1 == 2 && col2 == 4) || (col1 == 4 && col2 == 2) || (col1 == 5 && col2 == 7) || (col1 == 7 && col2 == 5)
+	if !isPattern {
+		return false, false
+	}
+
+	// Проверяем, что один символ находится на указательном пальце (индексы 3 и 4),
+	// а другой на среднем (индексы 2 и 5)
+	finger1IsIndex := (finger1 == 3 || finger1 == 4)
+	finger2IsIndex := (finger2 == 3 || finger2 == 4)
+	finger1IsMiddle := (finger1 == 2 || finger1 == 5)
+	finger2IsMiddle := (finger2 == 2 || finger2 == 5)
+
+	return true, (finger1IsIndex && finger2IsMiddle) || (finger2IsIndex && finger1IsMiddle)
+}
+
 // AnalyzeLayout анализирует раскладку и возвращает результаты
 func AnalyzeLayout(layout *Layout, config *KeyboardConfig, langData *LanguageData) *LayoutAnalysis {
 	analysis := &LayoutAnalysis{
@@ -49,15 +163,7 @@ func AnalyzeLayout(layout *Layout, config *KeyboardConfig, langData *LanguageDat
 	}
 
 	// Создаём таблицу позиций буквы -> (row, col)
-	keyPos := make(map[string][2]int)
-	for row := 0; row < 3; row++ {
-		for col := 0; col < 10; col++ {
-			key := layout.Keys[row][col]
-			if key != "" {
-				keyPos[key] = [2]int{row, col}
-			}
-		}
-	}
+	keyPos := buildKeyPositions(layout, config, langData)
 
 	// Рассчитываем суммарное усилие
 	calculateEffort(layout, config, langData, keyPos, analysis)
@@ -71,15 +177,97 @@ func AnalyzeLayout(layout *Layout, config *KeyboardConfig, langData *LanguageDat
 	// Рассчитываем FDI как сумму разниц нагрузки по каждой паре пальцев на разных руках
 	analysis.FDI = calculateFDI(analysis, config)
 
+	// Рассчитываем стандартное отклонение нагрузки по восьми пальцам
+	analysis.FingerStdDev = calculateFingerStdDev(analysis)
+
+	// Рассчитываем нагрузку на мизинцы как сумму нагрузки на пальцы P1 и P8
+	analysis.PinkyLoad = analysis.EffortByFinger[0] + analysis.EffortByFinger[7]
+
+	// Рассчитываем долю нажатий на домашние позиции
+	analysis.HomeUse = calculateHomeUse(config, langData, keyPos)
+
 	// Рассчитываем MEP (Maximum Effort Penalty) как сумму превышений нагрузки по всем пальцам, домноженных на величину штрафа для каждого пальца
 	analysis.MEP = calculateMEP(analysis, config)
 
+	// Рассчитываем FTP (Finger Travel Penalty) как сумму превышений перемещения по всем пальцам, домноженных на величину штрафа для каждого пальца
+	analysis.FTP = calculateTravelPenalty(analysis, config)
+
 	// Рассчитываем взвешенную оценку
 	calculateWeightedScore(config, analysis)
 
 	return analysis
 }
 
+// splitBigram разделяет биграмму на две клавиши. Клавиша раскладки может состоять из нескольких
+// символов Unicode (например, буква с комбинируемым знаком ударения), поэтому биграмма длиннее
+// двух символов разделяется так, чтобы обе части были клавишами из keyPos (или пробелом)
+func splitBigram(bigram string, keyPos map[string][2]int) (string, string, bool) {
+	runes := []rune(bigram)
+	if len(runes) == 2 {
+		return string(runes[0]), string(runes[1]), true
+	}
+
+	isKey := func(token string) bool {
+		_, exists := keyPos[token]
+		return exists || token == " "
+	}
+	for i := 1; i < len(runes); i++ {
+		first, second := string(runes[:i]), string(runes[i:])
+		if isKey(first) && isKey(second) {
+			return first, second, true
+		}
+	}
+	return "", "", false
+}
+
+// buildKeyPositions создаёт таблицу позиций символов раскладки -> (row, col)
+func buildKeyPositions(layout *Layout, config *KeyboardConfig, langData *LanguageData) map[string][2]int {
+	keyPos := make(map[string][2]int)
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			key := layout.Keys[row][col]
+			if key != "" {
+				keyPos[key] = [2]int{row, col}
+			}
+		}
+	}
+
+	// Клавиши цифрового ряда (если он есть) анализируются, только если
+	// символ не встречается в основных рядах
+	if len(layout.NumberRow) == 10 {
+		for col, key := range layout.NumberRow {
+			if _, exists := keyPos[key]; key != "" && !exists {
+				keyPos[key] = [2]int{numberRowIndex, col}
+			}
+		}
+	}
+
+	// При case_sensitive=1 заглавная буква - отдельная клавиша со своей частотой
+	if config.CaseSensitive {
+		return keyPos
+	}
+
+	// Для букв в верхнем регистре (закрепленные позиции), частоты которых
+	// заданы только в нижнем регистре, используем частоту строчной буквы
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			key := layout.Keys[row][col]
+			lowerKey := strings.ToLower(key)
+			if key == "" || lowerKey == key {
+				continue
+			}
+			if _, exists := langData.Characters[key]; exists {
+				continue
+			}
+			if _, exists := keyPos[lowerKey]; !exists {
+				keyPos[lowerKey] = [2]int{row, col}
+			}
+		}
+	}
+
+	return keyPos
+}
+
 // calculateEffort рассчитывает усилие для раскладки
 func calculateEffort(layout *Layout, config *KeyboardConfig, langData *LanguageData, keyPos map[string][2]int, analysis *LayoutAnalysis) {
 	totalEffort := 0.0
@@ -93,37 +281,53 @@ func calculateEffort(layout *Layout, config *KeyboardConfig, langData *LanguageD
 		}
 
 		row, col := pos[0], pos[1]
-		effort := config.EffortMatrix[row][col]
+		effort := keyEffort(config, row, col)
 		totalEffort += effort * freq
 		totalFreq += freq
 	}
 
-	// Усилие для равномерного распределения (каждая буква 1/30)
+	// Пробел нажимается большим пальцем вне основной матрицы усилий, поэтому он входит
+	// в общее усилие, но не в нагрузку по рядам, пальцам и половинкам
+	pressEffort, pressFreq := totalEffort, totalFreq
+	if freq, exists := langData.Characters[" "]; exists && config.SpaceCol > 0 {
+		pressEffort += config.SpaceEffort * freq
+		pressFreq += freq
+	}
+
+	// Усилие для равномерного распределения (каждая буква 1/30, или 1/40 с цифровым рядом)
 	uniformEffort := 0.0
 	for row := 0; row < 3; row++ {
 		for col := 0; col < 10; col++ {
 			uniformEffort += config.EffortMatrix[row][col]
 		}
 	}
-	uniformEffort /= 30.0
+	uniformKeys := 30.0
+	if len(layout.NumberRow) == 10 {
+		for col := 0; col < 10; col++ {
+			uniformEffort += keyEffort(config, numberRowIndex, col)
+		}
+		uniformKeys += 10.0
+	}
+	uniformEffort /= uniformKeys
 
-	if totalFreq > 0 {
+	if pressFreq > 0 {
 		// Нормируем на усилие равномерного распределения
 		// Результат в процентах от 0.95 (если усилие равномерное)
-		avgEffort := totalEffort / totalFreq
+		avgEffort := pressEffort / pressFreq
+		analysis.RawEffort = avgEffort
 		analysis.TotalEffort = avgEffort / uniformEffort * 100.0
 	}
 
 	// Рассчитываем усилия по рядам так, чтобы они суммировались в 100%
-	rowTotalEfforts := [3]float64{}
-	rowTotalFreqs := [3]float64{}
+	rowTotalEfforts := [4]float64{}
+	rowTotalFreqs := [4]float64{}
 	for char, freq := range langData.Characters {
 		pos, exists := keyPos[char]
 		if !exists {
 			continue
 		}
 		row := pos[0]
-		rowTotalEfforts[row] += config.EffortMatrix[pos[0]][pos[1]] * freq
+		rowTotalEfforts[row] += keyEffort(config, pos[0], pos[1]) * freq
 		rowTotalFreqs[row] += freq
 	}
 
@@ -131,6 +335,7 @@ func calculateEffort(layout *Layout, config *KeyboardConfig, langData *LanguageD
 		for row := 0; row < 3; row++ {
 			analysis.EffortByRow[row] = (rowTotalFreqs[row] / totalFreq) * 100.0
 		}
+		analysis.NumberRowLoad = (rowTotalFreqs[numberRowIndex] / totalFreq) * 100.0
 	}
 
 	// Рассчитываем усилия по пальцам так, чтобы они суммировались в 100%
@@ -195,28 +400,54 @@ func calculateBigrams(layout *Layout, config *KeyboardConfig, langData *Language
 	fsb2 := 0.0  // Full Scissors Bigrams (вне строгого режима)
 	lsb2 := 0.0  // Lateral Stretch Bigrams (вне строгого режима)
 	skb := 0.0   // Same Key Bigrams
+	ics := 0.0   // Index Center Stretch (указательный палец, основная и центральная колонка на одной руке)
+	symsfb := 0.0 // Symmetric Same Finger Bigrams (один и тот же палец без учета руки)
+	rowjump := 0.0 // Row Jump (верхний и нижний ряд на одной руке, любые пальцы)
+	lsball := 0.0  // Lateral Stretch Bigrams для всех пар пальцев (одна рука, через колонку, тот же или соседний ряд)
+	fingerTravel := [8]float64{} // Перемещение пальцев в биграммах одного пальца
 
 	totalBigramFreq := 0.0
+	spaceBigramFreq := 0.0    // Частота биграмм с пробелом, которые учитываются только в SHB
+	languageBigramFreq := 0.0 // Частота всех биграмм языка, которые могут быть учтены в анализе
+
+	// Среднее усилие основных клавиш для нормировки множителя effort_scaled_bigrams
+	meanEffort := meanKeyEffort(config)
 
 	// Проход по всем биграммам
 	for bigram, freq := range langData.Bigrams {
-		runes := []rune(bigram)
-		if len(runes) != 2 {
+		char1, char2, ok := splitBigram(bigram, keyPos)
+		if !ok {
 			continue
 		}
 
-		char1 := string(runes[0])
-		char2 := string(runes[1])
+		// Биграммы с пробелом не входят в покрытие, если пробел не анализируется
+		if config.SpaceCol > 0 || (char1 != " " && char2 != " ") {
+			languageBigramFreq += freq
+		}
 
 		pos1, exists1 := keyPos[char1]
 		pos2, exists2 := keyPos[char2]
 
+		// Биграммы с пробелом учитываются только в количестве биграмм одной руки и не входят
+		// в общую частоту биграмм, на которую нормируются остальные показатели
+		if isSpaceBigram(config, char1, char2, exists1, exists2) {
+			spaceBigramFreq += freq
+			letterCol := pos2[1]
+			if char2 == " " {
+				letterCol = pos1[1]
+			}
+			if getHalf(letterCol) == getHalf(config.SpaceCol-1) {
+				shb += freq
+			}
+			continue
+		}
+
 		if !exists1 || !exists2 {
 			continue
 		}
 
-		row1, col1 := pos1[0], pos1[1]
-		row2, col2 := pos2[0], pos2[1]
+		row1, col1 := physicalRow(pos1[0]), pos1[1]
+		row2, col2 := physicalRow(pos2[0]), pos2[1]
 
 		totalBigramFreq += freq
 
@@ -225,8 +456,8 @@ func calculateBigrams(layout *Layout, config *KeyboardConfig, langData *Language
 		half2 := getHalf(col2)
 
 		// Проверяем пальцы для обоих символов
-		finger1 := getFingerForKey(row1, col1)
-		finger2 := getFingerForKey(row2, col2)
+		finger1 := fingerForKey(config, row1, col1)
+		finger2 := fingerForKey(config, row2, col2)
 
 		// Вычисляем расстояния
 		rowDiff := abs(row1 - row2)
@@ -237,40 +468,63 @@ func calculateBigrams(layout *Layout, config *KeyboardConfig, langData *Language
 			shb += freq
 		}
 
+		// Частота для штрафных метрик: при effort_scaled_bigrams биграмма на тяжелых клавишах
+		// штрафуется сильнее, чем на легких. SHB, SRB, AFI, AFO, SKB и перемещение пальцев не масштабируются
+		penaltyFreq := freq * bigramEffortScale(config, pos1, pos2, meanEffort)
+
+		// RowJump - переход между верхним и нижним рядом на одной руке в обход среднего ряда,
+		// в отличие от FVB и FSB учитывается при любом сочетании пальцев
+		if half1 == half2 && ((row1 == 0 && row2 == 2) || (row1 == 2 && row2 == 0)) {
+			rowjump += penaltyFreq
+		}
+
 		// SFB - Same Finger Bigrams (процент биграмм, которые набираются одним пальцем)
 		if finger1 == finger2 {
-			sfb += freq
+			sfb += penaltyFreq
+
+			// Перемещение пальца между клавишами биграммы (расстояние в клавишах)
+			if finger1 < 8 {
+				fingerTravel[finger1] += freq * math.Hypot(float64(rowDiff), float64(colDiff))
+			}
+		}
+
+		// SymSFB - Symmetric Same Finger Bigrams (один и тот же палец без учета руки,
+		// например левый и правый указательный)
+		if symmetricFinger(finger1) == symmetricFinger(finger2) {
+			symsfb += penaltyFreq
 		}
 
 		// Рассчитываем метрики только если оба символа на одной половинке
 		if half1 == half2 {
 			// HVB - Half Vertical Bigrams (один палец, одна колонка, соседние ряды, исключая колонки 5 и 6)
-			if finger1 == finger2 && col1 == col2 && rowDiff == 1 && col1 != 4 && col1 != 5 {
-				hvb += freq
+			if finger1 == finger2 && col1 == col2 && rowDiff == 1 && !isExcludedColumn(config, col1) {
+				hvb += penaltyFreq
 			}
 
 			// FVB - Full Vertical Bigrams (один палец, одна колонка, через ряд, исключая колонки 5 и 6)
-			if finger1 == finger2 && col1 == col2 && rowDiff == 2 && col1 != 4 && col1 != 5 {
-				fvb += freq
+			if finger1 == finger2 && col1 == col2 && rowDiff == 2 && !isExcludedColumn(config, col1) {
+				fvb += penaltyFreq
 			}
 
 			// HDB - Half Diagonal Bigrams (один палец, соседние колонки и соседние ряды)
 			if finger1 == finger2 && rowDiff == 1 && colDiff == 1 {
-				hdb += freq
+				hdb += penaltyFreq
 			}
 
 			// FDB - Full Diagonal Bigrams (один палец, соседние колонки через ряд)
 			if finger1 == finger2 && rowDiff == 2 && colDiff == 1 {
-				fdb += freq
+				fdb += penaltyFreq
 			}
 
-			// HFB - Horizontal Finger Bigrams (один палец, один ряд, соседние колонки)
+			// HFB - Horizontal Finger Bigrams (один палец, один ряд, соседние колонки). При разметке пальцев
+			// по умолчанию две соседние колонки отведены только указательным пальцам (3-4 и 5-6), поэтому
+			// HFB - это переходы указательного пальца между двумя своими колонками в одном ряду
 			if finger1 == finger2 && row1 == row2 && colDiff == 1 {
-				hfb += freq
+				hfb += penaltyFreq
 			}
 
 			// SRB - Same Row Bigrams (одна рука, один ряд, исключая колонки 5 и 6)
-			if row1 == row2 && col1 != 4 && col1 != 5 && col2 != 4 && col2 != 5 {
+			if row1 == row2 && !isExcludedColumn(config, col1) && !isExcludedColumn(config, col2) {
 				srb += freq
 			}
 
@@ -279,15 +533,7 @@ func calculateBigrams(layout *Layout, config *KeyboardConfig, langData *Language
 			finger2IsSpecial := (finger2 == 1 || finger2 == 2 || finger2 == 5 || finger2 == 6)
 
 			// HSB - Half Scissors Bigrams (одна рука, разные пальцы, соседние ряды, один из пальцев 2,3,6,7, исключая колонки 5 и 6)
-			// Проверяем, что обе клавиши находятся на одной руке (левой: колонки 0-3 или правой: колонки 6-9)
-			leftHandCols1 := col1 >= 0 && col1 <= 3
-			rightHandCols1 := col1 >= 6 && col1 <= 9
-			leftHandCols2 := col2 >= 0 && col2 <= 3
-			rightHandCols2 := col2 >= 6 && col2 <= 9
-
-			isSameHand := (leftHandCols1 && leftHandCols2) || (rightHandCols1 && rightHandCols2)
-
-			if isSameHand && finger1 != finger2 && rowDiff == 1 && col1 != 4 && col1 != 5 && col2 != 4 && col2 != 5 {
+			if finger1 != finger2 && rowDiff == 1 && !isExcludedColumn(config, col1) && !isExcludedColumn(config, col2) {
 				// Проверяем, находится ли нижний из двух рядов на специфичном пальце (2,3,6,7)
 				lowerRow := row1
 				if row2 > row1 {
@@ -299,69 +545,69 @@ func calculateBigrams(layout *Layout, config *KeyboardConfig, langData *Language
 				if config.Weights.HSBStrictMode == 1 {
 					// Строгий режим: только если соответствует критериям
 					if isHSBValid {
-						hsb += freq
+						hsb += penaltyFreq
 					} else {
 						// Если не соответствует строгому режиму, добавляем к HSB2
-						hsb2 += freq
+						hsb2 += penaltyFreq
 					}
 				} else {
 					// Нестрогий режим: всегда добавляем к основному HSB, если это HSB паттерн
-					hsb += freq
+					hsb += penaltyFreq
 					// HSB2 остается равным 0 в нестрогом режиме
 				}
 			}
 
 			// FSB - Full Scissors Bigrams (одна рука, разные пальцы, 1 и 3 ряд, один из пальцев 2, 3, 6 или 7, исключая колонки 5 и 6)
-			// Проверяем, что обе клавиши находятся на одной руке (левой: колонки 0-3 или правой: колонки 6-9)
-			if isSameHand && finger1 != finger2 && rowDiff == 2 && ((row1 == 0 && row2 == 2) || (row1 == 2 && row2 == 0)) && col1 != 4 && col1 != 5 && col2 != 4 && col2 != 5 {
+			if finger1 != finger2 && rowDiff == 2 && ((row1 == 0 && row2 == 2) || (row1 == 2 && row2 == 0)) && !isExcludedColumn(config, col1) && !isExcludedColumn(config, col2) {
 				// Проверяем, находится ли 3-й ряд (индекс 2) на специфичном пальце (2,3,6,7)
 				isFSBValid := (row1 == 2 && finger1IsSpecial) || (row2 == 2 && finger2IsSpecial)
 
 				if config.Weights.FSBStrictMode == 1 {
 					// Строгий режим: только если соответствует критериям
 					if isFSBValid {
-						fsb += freq
+						fsb += penaltyFreq
 					} else {
 						// Если не соответствует строгому режиму, добавляем к FSB2
-						fsb2 += freq
+						fsb2 += penaltyFreq
 					}
 				} else {
 					// Нестрогий режим: всегда добавляем к основному FSB, если это FSB паттерн
-					fsb += freq
+					fsb += penaltyFreq
 					// FSB2 остается равным 0 в нестрогом режиме
 				}
 			}
 
 			// LSB - Lateral Stretch Bigram (указательный и средний на одной руке через вертикальный ряд, колонки 3-5 или 6-8)
-			// Палец 1 = колонка 1 (индекс 1), Палец 2 = колонка 2 (индекс 2), Палец 5 = колонка 7 (индекс 6), Палец 6 = колонка 8 (индекс 7)
-			// Это колонки 2-4 или 5-7 (в индексах 0-9)
-			isLSBPattern := (col1 == 2 && col2 == 4) || (col1 == 4 && col2 == 2) || (col1 == 5 && col2 == 7) || (col1 == 7 && col2 == 5) // колонки 3-5 или 6-8
+			isLSBPattern, isLSBValid := classifyLSB(half1, half2, col1, col2, finger1, finger2)
 
 			if isLSBPattern {
-				// Проверяем, что один символ находится на указательном пальце (2 или 5), а другой на среднем (3 или 6)
-				finger1IsIndex := (finger1 == 1 || finger1 == 4) // Палец 2 или 5 (указательный)
-				finger2IsMiddle := (finger2 == 2 || finger2 == 5) // Палец 3 или 6 (средний)
-
-				finger2IsIndex := (finger2 == 1 || finger2 == 4) // Палец 2 или 5 (указательный)
-				finger1IsMiddle := (finger1 == 2 || finger1 == 5) // Палец 3 или 6 (средний)
-
-				isLSBValid := (finger1IsIndex && finger2IsMiddle) || (finger2IsIndex && finger1IsMiddle)
-
 				if config.Weights.LSBStrictMode == 1 {
 					// Строгий режим: только если соответствует критериям
 					if isLSBValid {
-						lsb += freq
+						lsb += penaltyFreq
 					} else {
 						// Если не соответствует строгому режиму, добавляем к LSB2
-						lsb2 += freq
+						lsb2 += penaltyFreq
 					}
 				} else {
 					// Нестрогий режим: всегда добавляем к основному LSB, если это LSB паттерн
-					lsb += freq
+					lsb += penaltyFreq
 					// LSB2 остается равным 0 в нестрогом режиме
 				}
 			}
 
+			// LSB_all - растяжение между клавишами одной руки через колонку в том же или соседнем ряду,
+			// в отличие от LSB учитывается для любых пальцев, в том числе безымянного и мизинца
+			if colDiff == 2 && rowDiff <= 1 {
+				lsball += penaltyFreq
+			}
+
+			// ICS - Index Center Stretch (указательный палец переходит между основной колонкой 4 или 7 и центральной колонкой 5 или 6)
+			isICSPattern := finger1 == finger2 && (finger1 == 3 || finger1 == 4) && colDiff == 1
+			if isICSPattern {
+				ics += penaltyFreq
+			}
+
 			// AFI - Adjacent Fingers In (соседние клавиши в одном ряду нажимаются по направлению к центру)
 			// AFO - Adjacent Fingers Out (соседние клавиши в одном ряду нажимаются по направлению от центра)
 			// Центр между колонками 4 и 5 (индексы 4 и 5)
@@ -405,9 +651,17 @@ func calculateBigrams(layout *Layout, config *KeyboardConfig, langData *Language
 		}
 	}
 
+	if languageBigramFreq > 0 {
+		analysis.BigramCoverage = (totalBigramFreq + spaceBigramFreq) / languageBigramFreq * 100.0
+	}
+
+	// SHB нормируется на частоту биграмм вместе с биграммами с пробелом
+	if shbTotal := totalBigramFreq + spaceBigramFreq; shbTotal > 0 {
+		analysis.BigramAnalysis.SHB = (shb / shbTotal) * 100.0
+	}
+
 	if totalBigramFreq > 0 {
 		// Нормируем все значения на общую частоту биграмм
-		analysis.BigramAnalysis.SHB = (shb / totalBigramFreq) * 100.0
 		analysis.BigramAnalysis.SFB = (sfb / totalBigramFreq) * 100.0
 		analysis.BigramAnalysis.HVB = (hvb / totalBigramFreq) * 100.0
 		analysis.BigramAnalysis.FVB = (fvb / totalBigramFreq) * 100.0
@@ -425,11 +679,139 @@ func calculateBigrams(layout *Layout, config *KeyboardConfig, langData *Language
 		analysis.BigramAnalysis.FSB2 = (fsb2 / totalBigramFreq) * 100.0
 		analysis.BigramAnalysis.LSB2 = (lsb2 / totalBigramFreq) * 100.0
 		analysis.BigramAnalysis.SKB = (skb / totalBigramFreq) * 100.0
+		analysis.BigramAnalysis.ICS = (ics / totalBigramFreq) * 100.0
+		analysis.BigramAnalysis.SymSFB = (symsfb / totalBigramFreq) * 100.0
+		analysis.BigramAnalysis.RowJump = (rowjump / totalBigramFreq) * 100.0
+		analysis.BigramAnalysis.LSBAll = (lsball / totalBigramFreq) * 100.0
+		for finger := 0; finger < 8; finger++ {
+			analysis.BigramAnalysis.FingerTravel[finger] = (fingerTravel[finger] / totalBigramFreq) * 100.0
+		}
 		// TIB уже рассчитан в цикле по биграммам, нормируем его
 		analysis.BigramAnalysis.TIB = (analysis.BigramAnalysis.TIB / totalBigramFreq) * 100.0
 	}
 }
 
+// meanKeyEffort возвращает среднее усилие основных 30 клавиш
+func meanKeyEffort(config *KeyboardConfig) float64 {
+	total := 0.0
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			total += config.EffortMatrix[row][col]
+		}
+	}
+	return total / 30.0
+}
+
+// bigramEffortScale возвращает множитель штрафных метрик биграммы при effort_scaled_bigrams=1:
+// среднее усилие двух клавиш, отнесенное к среднему усилию всех клавиш, чтобы значения
+// метрик оставались сопоставимыми с обычным режимом. По умолчанию множитель равен 1
+func bigramEffortScale(config *KeyboardConfig, pos1, pos2 [2]int, meanEffort float64) float64 {
+	if !config.EffortScaledBigrams || meanEffort <= 0 {
+		return 1
+	}
+	return (keyEffort(config, pos1[0], pos1[1]) + keyEffort(config, pos2[0], pos2[1])) / 2 / meanEffort
+}
+
+// BigramContribution описывает вклад отдельной биграммы во взвешенную оценку биграмм раскладки
+type BigramContribution struct {
+	Bigram       string
+	Freq         float64  // Доля биграммы среди биграмм, набираемых на раскладке (%); для биграмм с пробелом - среди биграмм SHB
+	Categories   []string // Метрики, которые учитывают биграмму
+	Contribution float64  // Вклад биграммы в сумму метрик биграмм, домноженных на коэффициенты
+}
+
+// AnalyzeBigramContributions рассчитывает вклад каждой биграммы раскладки во взвешенную оценку.
+// Для классификации биграмма анализируется отдельно теми же правилами, что и в calculateBigrams,
+// поэтому сумма вкладов всех биграмм равна сумме метрик биграмм в таблице lb. SHB нормируется
+// на биграммы вместе с биграммами пробела (space_col), остальные метрики - без них
+func AnalyzeBigramContributions(layout *Layout, config *KeyboardConfig, langData *LanguageData) []BigramContribution {
+	keyPos := buildKeyPositions(layout, config, langData)
+
+	totalBigramFreq := 0.0
+	spaceBigramFreq := 0.0
+	for bigram, freq := range langData.Bigrams {
+		char1, char2, ok := splitBigram(bigram, keyPos)
+		if !ok {
+			continue
+		}
+		_, exists1 := keyPos[char1]
+		_, exists2 := keyPos[char2]
+		if isSpaceBigram(config, char1, char2, exists1, exists2) {
+			spaceBigramFreq += freq
+		} else if exists1 && exists2 {
+			totalBigramFreq += freq
+		}
+	}
+
+	var contributions []BigramContribution
+	shbTotal := totalBigramFreq + spaceBigramFreq
+	if shbTotal == 0 {
+		return contributions
+	}
+
+	for bigram, freq := range langData.Bigrams {
+		char1, char2, ok := splitBigram(bigram, keyPos)
+		if !ok {
+			continue
+		}
+		_, exists1 := keyPos[char1]
+		_, exists2 := keyPos[char2]
+		space := isSpaceBigram(config, char1, char2, exists1, exists2)
+		if !space && (!exists1 || !exists2) {
+			continue
+		}
+
+		// Метрики отдельной биграммы равны 100% для тех категорий, в которые она попадает
+		single := &LanguageData{Bigrams: map[string]float64{bigram: freq}}
+		singleAnalysis := &LayoutAnalysis{}
+		calculateBigrams(layout, config, single, keyPos, singleAnalysis)
+
+		shbShare := freq / shbTotal
+		share := shbShare
+		if !space {
+			share = freq / totalBigramFreq
+		}
+		// SHB входит в сумму с долей среди биграмм SHB, остальные метрики - с долей среди биграмм раскладки
+		shbEffort := config.Weights.SHB * singleAnalysis.BigramAnalysis.SHB
+		contributions = append(contributions, BigramContribution{
+			Bigram:       bigram,
+			Freq:         share * 100.0,
+			Categories:   bigramCategories(&singleAnalysis.BigramAnalysis),
+			Contribution: (calculateBigramEffortSum(config, singleAnalysis)-shbEffort)*share + shbEffort*shbShare,
+		})
+	}
+
+	return contributions
+}
+
+// isSpaceBigram проверяет, что биграмма состоит из пробела и символа раскладки и учитывается
+// только в SHB, потому что пробел анализируется в колонке space_col
+func isSpaceBigram(config *KeyboardConfig, char1, char2 string, exists1, exists2 bool) bool {
+	return config.SpaceCol > 0 && (char1 == " " && exists2 || char2 == " " && exists1)
+}
+
+// bigramCategories возвращает названия метрик с ненулевым значением
+func bigramCategories(ba *BigramAnalysis) []string {
+	metrics := []struct {
+		name  string
+		value float64
+	}{
+		{"SHB", ba.SHB}, {"SFB", ba.SFB}, {"HVB", ba.HVB}, {"FVB", ba.FVB}, {"HDB", ba.HDB},
+		{"FDB", ba.FDB}, {"HFB", ba.HFB}, {"HSB", ba.HSB}, {"FSB", ba.FSB}, {"LSB", ba.LSB},
+		{"SRB", ba.SRB}, {"AFI", ba.AFI}, {"AFO", ba.AFO}, {"ICS", ba.ICS}, {"HSB2", ba.HSB2},
+		{"FSB2", ba.FSB2}, {"LSB2", ba.LSB2}, {"SKB", ba.SKB}, {"SymSFB", ba.SymSFB}, {"RowJump", ba.RowJump},
+		{"LSB_all", ba.LSBAll}, {"TIB", ba.TIB},
+	}
+
+	var categories []string
+	for _, metric := range metrics {
+		if metric.value != 0 {
+			categories = append(categories, metric.name)
+		}
+	}
+	return categories
+}
+
 // calculateBigramEffortSum calculates the sum of all bigram values multiplied by their corresponding coefficients
 func calculateBigramEffortSum(config *KeyboardConfig, analysis *LayoutAnalysis) float64 {
 	// Calculate sum of all bigram coefficients multiplied by their weights
@@ -447,6 +829,11 @@ func calculateBigramEffortSum(config *KeyboardConfig, analysis *LayoutAnalysis)
 	bigramEffort += config.Weights.SRB * analysis.BigramAnalysis.SRB
 	bigramEffort += config.Weights.AFI * analysis.BigramAnalysis.AFI
 	bigramEffort += config.Weights.AFO * analysis.BigramAnalysis.AFO
+	bigramEffort += config.Weights.ICS * analysis.BigramAnalysis.ICS
+	bigramEffort += config.Weights.SymSFB * analysis.BigramAnalysis.SymSFB
+	bigramEffort += config.Weights.RowJumpNorm * analysis.BigramAnalysis.RowJump
+	bigramEffort += config.Weights.LSBAll * analysis.BigramAnalysis.LSBAll
+	bigramEffort += config.Weights.SKB * analysis.BigramAnalysis.SKB
 	bigramEffort += analysis.BigramAnalysis.TIB  // Добавляем TIB к общей сумме
 
 	return bigramEffort
@@ -455,31 +842,97 @@ func calculateBigramEffortSum(config *KeyboardConfig, analysis *LayoutAnalysis)
 // calculateWeightedScore рассчитывает взвешенную оценку
 func calculateWeightedScore(config *KeyboardConfig, analysis *LayoutAnalysis) {
 	score := 0.0
+	for _, term := range ScoreTerms(config, analysis) {
+		score += term.Contribution()
+	}
+	analysis.WeightedScore = score
+}
+
+// ScoreTerms возвращает слагаемые итоговой оценки раскладки в порядке суммирования:
+// общее усилие и коэффициенты биграмм, домноженные на соответствующие нормирующие
+// коэффициенты, а также TIB и MEP, которые входят в оценку без веса
+func ScoreTerms(config *KeyboardConfig, analysis *LayoutAnalysis) []ScoreTerm {
+	return []ScoreTerm{
+		{"Effort", analysis.TotalEffort, config.Weights.TotalEffortNorm},
+		{"SHB", analysis.BigramAnalysis.SHB, config.Weights.SHB},
+		{"SFB", analysis.BigramAnalysis.SFB, config.Weights.SFB},
+		{"HVB", analysis.BigramAnalysis.HVB, config.Weights.HVB},
+		{"FVB", analysis.BigramAnalysis.FVB, config.Weights.FVB},
+		{"HDB", analysis.BigramAnalysis.HDB, config.Weights.HDB},
+		{"FDB", analysis.BigramAnalysis.FDB, config.Weights.FDB},
+		{"HFB", analysis.BigramAnalysis.HFB, config.Weights.HFB},
+		{"HSB", analysis.BigramAnalysis.HSB, config.Weights.HSB},
+		{"FSB", analysis.BigramAnalysis.FSB, config.Weights.FSB},
+		{"LSB", analysis.BigramAnalysis.LSB, config.Weights.LSB},
+		{"SRB", analysis.BigramAnalysis.SRB, config.Weights.SRB},
+		{"AFI", analysis.BigramAnalysis.AFI, config.Weights.AFI},
+		{"AFO", analysis.BigramAnalysis.AFO, config.Weights.AFO},
+		{"ICS", analysis.BigramAnalysis.ICS, config.Weights.ICS},
+		{"SymSFB", analysis.BigramAnalysis.SymSFB, config.Weights.SymSFB},
+		{"RowJump", analysis.BigramAnalysis.RowJump, config.Weights.RowJumpNorm},
+		{"LSB_all", analysis.BigramAnalysis.LSBAll, config.Weights.LSBAll},
+		{"SKB", analysis.BigramAnalysis.SKB, config.Weights.SKB},
+		{"TIB", analysis.BigramAnalysis.TIB, 1},
+		{"HDI", analysis.HDI, config.Weights.HDI},
+		{"HandBias", HandBiasDeviation(config, analysis), config.Weights.HandBias},
+		{"FDI", analysis.FDI, config.Weights.FDI},
+		{"MEP", analysis.MEP, 1}, // Штраф за превышение максимальной нагрузки
+		{"FTP", analysis.FTP, 1}, // Штраф за превышение максимального перемещения пальцев
+		{"Pinky", analysis.PinkyLoad, config.Weights.PinkyNorm},
+		{"Home", analysis.HomeUse, -config.Weights.HomeUseNorm}, // Чем больше нажатий на домашние позиции, тем ниже оценка
+	}
+}
 
-	// Вычисляем оценку как сумму общего усилия и всех коэффициентов для биграмм,
-	// домноженных на соответствующие нормирующие коэффициенты
-	score += config.Weights.TotalEffortNorm * analysis.TotalEffort
-
-	// Добавляем коэффициенты биграмм
-	score += config.Weights.SHB * analysis.BigramAnalysis.SHB
-	score += config.Weights.SFB * analysis.BigramAnalysis.SFB
-	score += config.Weights.HVB * analysis.BigramAnalysis.HVB
-	score += config.Weights.FVB * analysis.BigramAnalysis.FVB
-	score += config.Weights.HDB * analysis.BigramAnalysis.HDB
-	score += config.Weights.FDB * analysis.BigramAnalysis.FDB
-	score += config.Weights.HFB * analysis.BigramAnalysis.HFB
-	score += config.Weights.HSB * analysis.BigramAnalysis.HSB
-	score += config.Weights.FSB * analysis.BigramAnalysis.FSB
-	score += config.Weights.LSB * analysis.BigramAnalysis.LSB
-	score += config.Weights.SRB * analysis.BigramAnalysis.SRB
-	score += config.Weights.AFI * analysis.BigramAnalysis.AFI
-	score += config.Weights.AFO * analysis.BigramAnalysis.AFO
-	score += analysis.BigramAnalysis.TIB  // Добавляем TIB к оценке
-	score += config.Weights.HDI * analysis.HDI
-	score += config.Weights.FDI * analysis.FDI
-	score += analysis.MEP  // Добавляем штраф за превышение максимальной нагрузки
+// defaultHandBiasTarget целевая доля нагрузки на правую руку (%) по умолчанию
+const defaultHandBiasTarget = 50.0
 
-	analysis.WeightedScore = score
+// HandBiasDeviation возвращает отклонение нагрузки на правую руку от целевой доли hand_bias_target (%).
+// В отличие от HDI позволяет задать намеренно несимметричное распределение нагрузки по рукам
+func HandBiasDeviation(config *KeyboardConfig, analysis *LayoutAnalysis) float64 {
+	return math.Abs(analysis.EffortByHalf[1] - config.HandBiasTarget)
+}
+
+// calculateFingerStdDev рассчитывает стандартное отклонение нагрузки по пальцам (%):
+// 0 при равномерной нагрузке на все восемь пальцев
+func calculateFingerStdDev(analysis *LayoutAnalysis) float64 {
+	mean := 0.0
+	for _, effort := range analysis.EffortByFinger {
+		mean += effort
+	}
+	mean /= float64(len(analysis.EffortByFinger))
+
+	variance := 0.0
+	for _, effort := range analysis.EffortByFinger {
+		variance += (effort - mean) * (effort - mean)
+	}
+	return math.Sqrt(variance / float64(len(analysis.EffortByFinger)))
+}
+
+// calculateHomeUse рассчитывает долю нажатий (%), приходящихся на домашние позиции
+// config.HomeKeys. В отличие от нагрузки на средний ряд R2 учитываются только клавиши
+// под пальцами в исходном положении, без центральных колонок
+func calculateHomeUse(config *KeyboardConfig, langData *LanguageData, keyPos map[string][2]int) float64 {
+	homeKeys := make(map[[2]int]bool, len(config.HomeKeys))
+	for _, pos := range config.HomeKeys {
+		homeKeys[[2]int{pos / 10, pos % 10}] = true
+	}
+
+	homeFreq, totalFreq := 0.0, 0.0
+	for char, freq := range langData.Characters {
+		pos, exists := keyPos[char]
+		if !exists {
+			continue
+		}
+		totalFreq += freq
+		if homeKeys[pos] {
+			homeFreq += freq
+		}
+	}
+
+	if totalFreq == 0 {
+		return 0
+	}
+	return homeFreq / totalFreq * 100.0
 }
 
 // calculateFDI рассчитывает Finger Disbalance Index
@@ -491,20 +944,26 @@ func calculateFDI(analysis *LayoutAnalysis, config *KeyboardConfig) float64 {
 
 	// Пальцы: 0=P1, 1=P2, 2=P3, 3=P4, 4=P5, 5=P6, 6=P7, 7=P8
 	// Left hand fingers: 0-4 (P1-P5), Right hand fingers: 5-7 (P6-P8)
-
-	// Pairs:
-	// P1 (0) - P8 (7)
-	fdi += config.Weights.D18 * math.Abs(analysis.EffortByFinger[0] - analysis.EffortByFinger[7])
-	// P2 (1) - P7 (6)
-	fdi += config.Weights.D27 * math.Abs(analysis.EffortByFinger[1] - analysis.EffortByFinger[6])
-	// P3 (2) - P6 (5)
-	fdi += config.Weights.D36 * math.Abs(analysis.EffortByFinger[2] - analysis.EffortByFinger[5])
-	// P4 (3) - P5 (4)
-	fdi += config.Weights.D45 * math.Abs(analysis.EffortByFinger[3] - analysis.EffortByFinger[4])
+	for _, pair := range mirroredFingerPairs {
+		fdi += pair.weight(&config.Weights) * math.Abs(analysis.EffortByFinger[pair.left]-analysis.EffortByFinger[pair.right])
+	}
 
 	return fdi
 }
 
+// mirroredFingerPairs пары симметричных пальцев левой и правой руки, для которых
+// рассчитывается FDI, с соответствующими весовыми коэффициентами D18, D27, D36 и D45
+var mirroredFingerPairs = []struct {
+	left, right int
+	weightName  string
+	weight      func(weights *WeightConfig) float64
+}{
+	{0, 7, "D18", func(weights *WeightConfig) float64 { return weights.D18 }}, // P1 (0) - P8 (7)
+	{1, 6, "D27", func(weights *WeightConfig) float64 { return weights.D27 }}, // P2 (1) - P7 (6)
+	{2, 5, "D36", func(weights *WeightConfig) float64 { return weights.D36 }}, // P3 (2) - P6 (5)
+	{3, 4, "D45", func(weights *WeightConfig) float64 { return weights.D45 }}, // P4 (3) - P5 (4)
+}
+
 // calculateMEP рассчитывает Maximum Effort Penalty
 func calculateMEP(analysis *LayoutAnalysis, config *KeyboardConfig) float64 {
 	// MEP рассчитывается как сумма превышений нагрузки по всем пальцам и рядам, домноженных на величину штрафа для каждого пальца/ряда
@@ -539,27 +998,142 @@ func calculateMEP(analysis *LayoutAnalysis, config *KeyboardConfig) float64 {
 	return mep
 }
 
-// FormatAnalysis форматирует результаты анализа для вывода
-func FormatAnalysis(analysis *LayoutAnalysis) string {
-	// Выводим усилия по пальцам (8), рядам (3), половинкам (2), hdi, fdi, mep, общее усилие и score
-	return fmt.Sprintf(
-		"%-4s %-16s %5.1f %5.1f %5.1f %5.1f %5.1f %5.1f %5.1f %5.1f  %5.1f %5.1f %5.1f  %5.1f %5.1f  %4.1f %4.1f %5.1f %7.2f %7.2f",
+// calculateTravelPenalty рассчитывает Finger Travel Penalty
+func calculateTravelPenalty(analysis *LayoutAnalysis, config *KeyboardConfig) float64 {
+	// FTP рассчитывается как сумма превышений перемещения по всем пальцам, домноженных на величину штрафа для каждого пальца
+	ftp := 0.0
+
+	for finger := 0; finger < 8; finger++ {
+		maxFingerTravel := config.MaxFingerTravel[finger]
+
+		// Если максимальное перемещение для пальца равно 0, штраф не применяется
+		if maxFingerTravel > 0 {
+			excess := analysis.BigramAnalysis.FingerTravel[finger] - maxFingerTravel
+			if excess > 0 {
+				ftp += excess * config.FingerTravelPenalties[finger]
+			}
+		}
+	}
+
+	return ftp
+}
+
+// precisionFormatRe находит числовые колонки в строках формата таблиц
+var precisionFormatRe = regexp.MustCompile(`%(\d+)(\.(\d+))?([fs])`)
+
+// adjustPrecision изменяет ширину и количество знаков после запятой числовых колонок
+// формата таблицы на delta (настройка precision). Колонки с выравниванием по левому
+// краю (номер и название раскладки) не изменяются. Возвращает новую строку формата
+// и количество измененных колонок для расчета длины разделителя
+func adjustPrecision(format string, delta int) (string, int) {
+	count := 0
+	adjusted := precisionFormatRe.ReplaceAllStringFunc(format, func(spec string) string {
+		m := precisionFormatRe.FindStringSubmatch(spec)
+		width, _ := strconv.Atoi(m[1])
+		count++
+		if m[2] == "" {
+			return fmt.Sprintf("%%%d%s", width+delta, m[4])
+		}
+		decimals, _ := strconv.Atoi(m[3])
+		return fmt.Sprintf("%%%d.%d%s", width+delta, decimals+delta, m[4])
+	})
+	return adjusted, count
+}
+
+// Допустимый диапазон настройки точности вывода таблиц
+const (
+	minTablePrecision = -1
+	maxTablePrecision = 3
+)
+
+// tablePrecision возвращает настройку точности вывода таблиц из конфигурации
+func tablePrecision(config *KeyboardConfig) int {
+	if config == nil {
+		return 0
+	}
+	return config.Precision
+}
+
+// Строки формата таблиц при точности по умолчанию (precision=0)
+const (
+	analysisHeaderFormat = " %-3s %-16s %5s %5s %5s %5s %5s %5s %5s %5s %6s %5s %5s %6s %5s %5s %4s %4s %5s %5s %5s %7s %7s"
+	analysisRowFormat    = "%-4s %-16s %5.1f %5.1f %5.1f %5.1f %5.1f %5.1f %5.1f %5.1f  %5.1f %5.1f %5.1f  %5.1f %5.1f  %4.1f %4.1f %4.1f %5.1f %5.1f %5.1f %7.2f %7.2f"
+	bigramHeaderFormat   = " %-3s %-16s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %8s %7s"
+	bigramRowFormat      = "%-4s %-16s %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %8.2f %7.2f"
+)
+
+// FormatAnalysisHeader форматирует заголовок таблицы со статистикой по нагрузке
+func FormatAnalysisHeader(config *KeyboardConfig) string {
+	return formatAnalysisHeader(tablePrecision(config))
+}
+
+// FormatAnalysisHeaderWithOptions форматирует заголовок таблицы со статистикой по нагрузке
+// с учетом выбранных колонок и режима --normalize
+func FormatAnalysisHeaderWithOptions(config *KeyboardConfig, opts TableOptions) string {
+	precision := opts.precision(config)
+	if !opts.selects(analysisColumns) {
+		return opts.withRelativeHeader(formatAnalysisHeader(precision))
+	}
+	format, _ := adjustPrecision(analysisRowFormat, precision)
+	return opts.withRelativeHeader(formatSelectedHeader(format, analysisColumns, opts.Columns))
+}
+
+func formatAnalysisHeader(precision int) string {
+	format, columns := adjustPrecision(analysisHeaderFormat, precision)
+	header := fmt.Sprintf(format,
+		"№", "Layout", "F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "R1", "R2", "R3", "Left", "Right", "HDI", "FDI", "FSD", "MEP", "Pinky", "Home", "Effort", "Score")
+	return header + "\n" + strings.Repeat("-", 151+columns*precision)
+}
+
+// FormatBigramAnalysisHeader форматирует заголовок таблицы со статистикой по биграммам
+func FormatBigramAnalysisHeader(config *KeyboardConfig) string {
+	return formatBigramAnalysisHeader(tablePrecision(config))
+}
+
+// FormatBigramAnalysisHeaderWithOptions форматирует заголовок таблицы со статистикой по биграммам
+// с учетом выбранных колонок и режима --normalize
+func FormatBigramAnalysisHeaderWithOptions(config *KeyboardConfig, opts TableOptions) string {
+	precision := opts.precision(config)
+	if !opts.selects(bigramColumns) {
+		return opts.withRelativeHeader(formatBigramAnalysisHeader(precision))
+	}
+	format, _ := adjustPrecision(bigramRowFormat, precision)
+	return opts.withRelativeHeader(formatSelectedHeader(format, bigramColumns, opts.Columns))
+}
+
+func formatBigramAnalysisHeader(precision int) string {
+	format, columns := adjustPrecision(bigramHeaderFormat, precision)
+	header := fmt.Sprintf(format,
+		"№", "Layout", "SHB", "SFB", "HVB", "FVB", "HDB", "FDB", "HFB", "HSB", "FSB", "LSB", "SRB", "AFI", "AFO", "SKB", "SymSFB", "TIB", "Total", "Score")
+	return header + "\n" + strings.Repeat("-", 150+columns*precision)
+}
+
+// analysisColumns названия числовых колонок таблицы со статистикой по нагрузке (команда l)
+var analysisColumns = []string{"F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "R1", "R2", "R3", "Left", "Right", "HDI", "FDI", "FSD", "MEP", "Pinky", "Home", "Effort", "Score"}
+
+// bigramColumns названия числовых колонок таблицы со статистикой по биграммам (команда lb)
+var bigramColumns = []string{"SHB", "SFB", "HVB", "FVB", "HDB", "FDB", "HFB", "HSB", "FSB", "LSB", "SRB", "AFI", "AFO", "SKB", "SymSFB", "TIB", "Total", "Score"}
+
+// analysisRowValues возвращает значения строки таблицы со статистикой по нагрузке
+func analysisRowValues(analysis *LayoutAnalysis) []interface{} {
+	// Выводим усилия по пальцам (8), рядам (3), половинкам (2), hdi, fdi, fsd, mep, общее усилие и score
+	return []interface{}{
 		fmt.Sprintf("[%d]", analysis.LayoutIndex),
 		analysis.LayoutName,
 		analysis.EffortByFinger[0], analysis.EffortByFinger[1], analysis.EffortByFinger[2], analysis.EffortByFinger[3],
 		analysis.EffortByFinger[4], analysis.EffortByFinger[5], analysis.EffortByFinger[6], analysis.EffortByFinger[7],
 		analysis.EffortByRow[0], analysis.EffortByRow[1], analysis.EffortByRow[2],
-		analysis.EffortByHalf[0], analysis.EffortByHalf[1], analysis.HDI, analysis.FDI, analysis.MEP,
+		analysis.EffortByHalf[0], analysis.EffortByHalf[1], analysis.HDI, analysis.FDI, analysis.FingerStdDev, analysis.MEP, analysis.PinkyLoad,
+		analysis.HomeUse,
 		analysis.TotalEffort,   // Display as percentage without % sign
 		analysis.WeightedScore, // Display as percentage without % sign
-	)
+	}
 }
 
-// FormatBigramAnalysis форматирует результаты анализа биграмм для вывода в виде таблицы
-func FormatBigramAnalysis(analysis *LayoutAnalysis) string {
+// bigramRowValues возвращает значения строки таблицы со статистикой по биграммам
+func bigramRowValues(analysis *LayoutAnalysis) []interface{} {
 	bigramEffortSum := calculateBigramEffortSum(analysis.Config, analysis)
-	return fmt.Sprintf(
-		"%-4s %-16s %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %8.2f %7.2f",
+	return []interface{}{
 		fmt.Sprintf("[%d]", analysis.LayoutIndex),
 		analysis.LayoutName,
 		analysis.BigramAnalysis.SHB,  // SHB - Same Hand Bigram
@@ -575,61 +1149,453 @@ func FormatBigramAnalysis(analysis *LayoutAnalysis) string {
 		analysis.BigramAnalysis.SRB,  // SRB - Same Row Bigrams
 		analysis.BigramAnalysis.AFI,  // AFI - Adjacent Fingers In
 		analysis.BigramAnalysis.AFO,  // AFO - Adjacent Fingers Out
+		analysis.BigramAnalysis.SKB,  // SKB - Same Key Bigrams
+		analysis.BigramAnalysis.SymSFB, // SymSFB - Symmetric Same Finger Bigrams
 		analysis.BigramAnalysis.TIB,  // TIB - Total on Individual Bigrams
 		bigramEffortSum,              // Sum of all bigram values multiplied by coefficients
 		analysis.WeightedScore,       // Display as percentage
-	)
+	}
+}
+
+// FormatAnalysis форматирует результаты анализа для вывода
+func FormatAnalysis(analysis *LayoutAnalysis) string {
+	format, _ := adjustPrecision(analysisRowFormat, tablePrecision(analysis.Config))
+	return fmt.Sprintf(format, analysisRowValues(analysis)...)
+}
+
+// FormatBigramAnalysis форматирует результаты анализа биграмм для вывода в виде таблицы
+func FormatBigramAnalysis(analysis *LayoutAnalysis) string {
+	format, _ := adjustPrecision(bigramRowFormat, tablePrecision(analysis.Config))
+	return fmt.Sprintf(format, bigramRowValues(analysis)...)
 }
 
 // FormatAnalysisWithHighlights форматирует результаты анализа с подсветкой числовых значений
 func FormatAnalysisWithHighlights(analysis *LayoutAnalysis) string {
-	// Форматируем базовую строку
-	baseString := fmt.Sprintf(
-		"%-4s %-16s %5.1f %5.1f %5.1f %5.1f %5.1f %5.1f %5.1f %5.1f  %5.1f %5.1f %5.1f  %5.1f %5.1f  %4.1f %4.1f %5.1f %7.2f %7.2f",
-		fmt.Sprintf("[%d]", analysis.LayoutIndex),
-		analysis.LayoutName,
-		analysis.EffortByFinger[0], analysis.EffortByFinger[1], analysis.EffortByFinger[2], analysis.EffortByFinger[3],
-		analysis.EffortByFinger[4], analysis.EffortByFinger[5], analysis.EffortByFinger[6], analysis.EffortByFinger[7],
-		analysis.EffortByRow[0], analysis.EffortByRow[1], analysis.EffortByRow[2],
-		analysis.EffortByHalf[0], analysis.EffortByHalf[1], analysis.HDI, analysis.FDI, analysis.MEP,
-		analysis.TotalEffort,   // Display as percentage without % sign
-		analysis.WeightedScore, // Display as percentage without % sign
-	)
-
 	// Подсвечиваем числовые значения в строке зеленым цветом (158,206,88)
 	// Ищем числовые значения и оборачиваем их в цветовой код
-	return colorizeNumbers(baseString)
+	return colorizeNumbers(FormatAnalysis(analysis))
 }
 
 // FormatBigramAnalysisWithHighlights форматирует результаты анализа биграмм с подсветкой числовых значений
 func FormatBigramAnalysisWithHighlights(analysis *LayoutAnalysis) string {
-	bigramEffortSum := calculateBigramEffortSum(analysis.Config, analysis)
+	// Подсвечиваем числовые значения в строке зеленым цветом (158,206,88)
+	return colorizeNumbers(FormatBigramAnalysis(analysis))
+}
 
-	// Форматируем базовую строку
-	baseString := fmt.Sprintf(
-		"%-4s %-16s %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %6.2f %8.2f %7.2f",
-		fmt.Sprintf("[%d]", analysis.LayoutIndex),
-		analysis.LayoutName,
-		analysis.BigramAnalysis.SHB,  // SHB - Same Hand Bigram
-		analysis.BigramAnalysis.SFB,  // SFB - Same Finger Bigrams
-		analysis.BigramAnalysis.HVB,  // HVB - Half Vertical Bigrams
-		analysis.BigramAnalysis.FVB,  // FVB - Full Vertical Bigrams
-		analysis.BigramAnalysis.HDB,  // HDB - Half Diagonal Bigrams
-		analysis.BigramAnalysis.FDB,  // FDB - Full Diagonal Bigrams
-		analysis.BigramAnalysis.HFB,  // HFB - Horizontal Finger Bigrams
-		analysis.BigramAnalysis.HSB,  // HSB - Half Scissors Bigrams
-		analysis.BigramAnalysis.FSB,  // FSB - Full Scissors Bigrams
-		analysis.BigramAnalysis.LSB,  // LSB - Lateral Stretch Bigram
-		analysis.BigramAnalysis.SRB,  // SRB - Same Row Bigrams
-		analysis.BigramAnalysis.AFI,  // AFI - Adjacent Fingers In
-		analysis.BigramAnalysis.AFO,  // AFO - Adjacent Fingers Out
-		analysis.BigramAnalysis.TIB,  // TIB - Total on Individual Bigrams
-		bigramEffortSum,              // Sum of all bigram values multiplied by coefficients
-		analysis.WeightedScore,       // Display as percentage
-	)
+// MetricThreshold задает целевое значение показателя для подсветки в таблицах l и lb
+type MetricThreshold struct {
+	Limit   float64
+	AtLeast bool // true - значение должно быть не меньше Limit, иначе не больше
+}
 
-	// Подсвечиваем числовые значения в строке зеленым цветом (158,206,88)
-	return colorizeNumbers(baseString)
+// Passes проверяет, удовлетворяет ли значение порогу
+func (t MetricThreshold) Passes(value float64) bool {
+	if t.AtLeast {
+		return value >= t.Limit
+	}
+	return value <= t.Limit
+}
+
+// Цвета ячеек, прошедших и не прошедших проверку порога
+const (
+	thresholdPassColor = "\033[38;2;158;206;88m"
+	thresholdFailColor = "\033[38;2;215;100;100m"
+)
+
+// formatSpecRe находит спецификаторы формата в строке формата таблицы
+var formatSpecRe = regexp.MustCompile(`%[-+ #0]*\d*(\.\d+)?[a-zA-Z]`)
+
+// MetricValue возвращает значение показателя раскладки по названию колонки таблиц l и lb
+func MetricValue(analysis *LayoutAnalysis, metric string) (float64, bool) {
+	for i, column := range analysisColumns {
+		if column == metric {
+			return analysisRowValues(analysis)[i+2].(float64), true
+		}
+	}
+	for i, column := range bigramColumns {
+		if column == metric {
+			return bigramRowValues(analysis)[i+2].(float64), true
+		}
+	}
+	return 0, false
+}
+
+// FormatMarkdownTables форматирует таблицы l и lb для указанных анализов в виде таблиц
+// Markdown без цветовых кодов. Точность чисел такая же, как в таблицах l и lb
+func FormatMarkdownTables(analyses []*LayoutAnalysis, config *KeyboardConfig) string {
+	precision := tablePrecision(config)
+	analysisFormat, _ := adjustPrecision(analysisRowFormat, precision)
+	bigramFormat, _ := adjustPrecision(bigramRowFormat, precision)
+
+	analysisRows := make([][]interface{}, len(analyses))
+	bigramRows := make([][]interface{}, len(analyses))
+	for i, analysis := range analyses {
+		analysisRows[i] = analysisRowValues(analysis)
+		bigramRows[i] = markUnavailableBigrams(analysis, bigramRowValues(analysis))
+	}
+
+	return formatMarkdownTable(analysisFormat, analysisColumns, analysisRows) + "\n" +
+		formatMarkdownTable(bigramFormat, bigramColumns, bigramRows)
+}
+
+// formatMarkdownTable форматирует строки таблицы в виде таблицы Markdown с выравниванием
+// колонок по ширине. Количество знаков после запятой берется из строки формата rowFormat,
+// числовые колонки выравниваются по правому краю
+func formatMarkdownTable(rowFormat string, columns []string, rows [][]interface{}) string {
+	specs := precisionFormatRe.FindAllStringSubmatch(rowFormat, -1)
+	header := append([]string{"№", "Layout"}, columns...)
+
+	cells := make([][]string, len(rows))
+	for i, row := range rows {
+		cells[i] = make([]string, len(row))
+		for j, value := range row {
+			if number, ok := value.(float64); ok && j >= 2 && j-2 < len(specs) {
+				decimals, _ := strconv.Atoi(specs[j-2][3])
+				cells[i][j] = strconv.FormatFloat(number, 'f', decimals, 64)
+			} else {
+				cells[i][j] = strings.ReplaceAll(fmt.Sprint(value), "|", "\\|")
+			}
+		}
+	}
+
+	widths := make([]int, len(header))
+	for j, name := range header {
+		widths[j] = utf8.RuneCountInString(name)
+		for _, row := range cells {
+			if width := utf8.RuneCountInString(row[j]); width > widths[j] {
+				widths[j] = width
+			}
+		}
+		if widths[j] < 3 {
+			widths[j] = 3
+		}
+	}
+
+	pad := func(text string, width int, right bool) string {
+		padding := strings.Repeat(" ", width-utf8.RuneCountInString(text))
+		if right {
+			return padding + text
+		}
+		return text + padding
+	}
+
+	var sb strings.Builder
+	line := make([]string, len(header))
+	for j, name := range header {
+		line[j] = pad(name, widths[j], j >= 2)
+	}
+	sb.WriteString("| " + strings.Join(line, " | ") + " |\n")
+	for j := range header {
+		if j >= 2 {
+			line[j] = strings.Repeat("-", widths[j]-1) + ":"
+		} else {
+			line[j] = strings.Repeat("-", widths[j])
+		}
+	}
+	sb.WriteString("| " + strings.Join(line, " | ") + " |\n")
+	for _, row := range cells {
+		for j, cell := range row {
+			line[j] = pad(cell, widths[j], j >= 2)
+		}
+		sb.WriteString("| " + strings.Join(line, " | ") + " |\n")
+	}
+	return sb.String()
+}
+
+// TableOptions настройки вывода таблиц l и lb
+type TableOptions struct {
+	Reference  *LayoutAnalysis            // Эталонная раскладка режима --normalize (nil - без нормировки)
+	Thresholds map[string]MetricThreshold // Пороги показателей для подсветки ячеек
+	Columns    map[string]bool            // Выводимые колонки (пусто - все колонки)
+	Relative   *LayoutAnalysis            // Раскладка команды rel, относительно которой выводится улучшение Score (nil - не выводится)
+}
+
+// relativeColumn название колонки с улучшением Score относительно раскладки команды rel
+const relativeColumn = "Rel"
+
+// RelativeImprovement возвращает улучшение оценки раскладки относительно эталонной в процентах:
+// положительное значение - оценка лучше (меньше) эталонной
+func RelativeImprovement(analysis, reference *LayoutAnalysis) float64 {
+	if reference.WeightedScore == 0 {
+		return math.NaN()
+	}
+	return (reference.WeightedScore - analysis.WeightedScore) / math.Abs(reference.WeightedScore) * 100
+}
+
+// withRelativeColumn добавляет в строку таблицы колонку Rel, если задана раскладка команды rel
+func (opts TableOptions) withRelativeColumn(analysis *LayoutAnalysis, format string, values []interface{}, columns []string) (string, []interface{}, []string) {
+	if opts.Relative == nil {
+		return format, values, columns
+	}
+	improvement := fmt.Sprintf("%+.1f%%", RelativeImprovement(analysis, opts.Relative))
+	return format + " %7s", append(values, improvement), append(append([]string{}, columns...), relativeColumn)
+}
+
+// withRelativeHeader добавляет в заголовок таблицы колонку Rel, если задана раскладка команды rel
+func (opts TableOptions) withRelativeHeader(header string) string {
+	if opts.Relative == nil {
+		return header
+	}
+	title, dashes, _ := strings.Cut(header, "\n")
+	return fmt.Sprintf("%s %7s\n%s%s", title, relativeColumn, dashes, strings.Repeat("-", 8))
+}
+
+// precision возвращает точность вывода таблицы: в режиме --normalize отношения
+// выводятся с одним дополнительным знаком после запятой
+func (opts TableOptions) precision(config *KeyboardConfig) int {
+	if opts.Reference != nil {
+		return tablePrecision(config) + 1
+	}
+	return tablePrecision(config)
+}
+
+// selects проверяет, выбрана ли хотя бы одна из колонок таблицы. Таблица, в которой
+// не выбрано ни одной колонки, выводится полностью
+func (opts TableOptions) selects(columns []string) bool {
+	for _, column := range columns {
+		if opts.Columns[column] {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatAnalysisWithThresholds форматирует строку таблицы l цветом строки rowColor,
+// колонки с заданными порогами окрашиваются в зеленый или красный цвет. Если задана
+// эталонная раскладка, значения выводятся в виде отношения к ее значениям
+func FormatAnalysisWithThresholds(analysis *LayoutAnalysis, opts TableOptions, rowColor string) string {
+	values := analysisRowValues(analysis)
+	if opts.Reference != nil {
+		values = normalizeRowValues(values, analysisRowValues(opts.Reference))
+	}
+	format, _ := adjustPrecision(analysisRowFormat, opts.precision(analysis.Config))
+	columns := analysisColumns
+	if opts.selects(analysisColumns) {
+		format, values, columns = selectColumns(format, values, analysisColumns, opts.Columns)
+	}
+	format, values, columns = opts.withRelativeColumn(analysis, format, values, columns)
+	return formatRowWithThresholds(format, values, columns, opts.Thresholds, rowColor)
+}
+
+// FormatBigramAnalysisWithThresholds форматирует строку таблицы lb цветом строки rowColor,
+// колонки с заданными порогами окрашиваются в зеленый или красный цвет. Если задана
+// эталонная раскладка, значения выводятся в виде отношения к ее значениям
+func FormatBigramAnalysisWithThresholds(analysis *LayoutAnalysis, opts TableOptions, rowColor string) string {
+	values := bigramRowValues(analysis)
+	if opts.Reference != nil {
+		values = normalizeRowValues(values, bigramRowValues(opts.Reference))
+	}
+	values = markUnavailableBigrams(analysis, values)
+	format, _ := adjustPrecision(bigramRowFormat, opts.precision(analysis.Config))
+	columns := bigramColumns
+	if opts.selects(bigramColumns) {
+		format, values, columns = selectColumns(format, values, bigramColumns, opts.Columns)
+	}
+	format = unavailableCellsFormat(format, values)
+	format, values, columns = opts.withRelativeColumn(analysis, format, values, columns)
+	return formatRowWithThresholds(format, values, columns, opts.Thresholds, rowColor)
+}
+
+// unavailableValue выводится в таблицах вместо показателей биграмм, если они не рассчитаны
+const unavailableValue = "N/A"
+
+// HasBigramData проверяет, учтена ли в анализе раскладки хотя бы одна биграмма языка
+func (analysis *LayoutAnalysis) HasBigramData() bool {
+	return analysis.BigramCoverage > 0
+}
+
+// markUnavailableBigrams заменяет показатели биграмм в строке таблицы lb на N/A, если в анализе
+// не учтено ни одной биграммы (в файле языка нет биграмм или нет биграмм из символов раскладки).
+// Общая оценка Score (последняя колонка) остается числом
+func markUnavailableBigrams(analysis *LayoutAnalysis, values []interface{}) []interface{} {
+	if analysis.HasBigramData() {
+		return values
+	}
+	marked := make([]interface{}, len(values))
+	copy(marked, values)
+	for i := 2; i < len(marked)-1; i++ {
+		marked[i] = unavailableValue
+	}
+	return marked
+}
+
+// unavailableCellsFormat заменяет в строке формата спецификаторы числовых колонок, значения
+// которых выводятся как N/A, на строковые спецификаторы той же ширины
+func unavailableCellsFormat(format string, values []interface{}) string {
+	var sb strings.Builder
+	for i, segment := range splitFormat(format) {
+		spec := segment.spec
+		if _, isText := values[i].(string); isText && i >= 2 {
+			if m := specWidthRe.FindStringSubmatch(spec); m != nil {
+				spec = "%" + m[1] + "s"
+			}
+		}
+		sb.WriteString(segment.separator + spec)
+	}
+	return sb.String()
+}
+
+// formatSegment часть строки формата таблицы: разделитель перед колонкой и ее спецификатор
+type formatSegment struct {
+	separator string
+	spec      string
+}
+
+// splitFormat разбивает строку формата таблицы на колонки
+func splitFormat(format string) []formatSegment {
+	var segments []formatSegment
+	last := 0
+	for _, loc := range formatSpecRe.FindAllStringIndex(format, -1) {
+		segments = append(segments, formatSegment{format[last:loc[0]], format[loc[0]:loc[1]]})
+		last = loc[1]
+	}
+	return segments
+}
+
+// selectColumns оставляет в строке формата и значениях строки таблицы номер и название
+// раскладки и выбранные числовые колонки. Возвращает также названия оставшихся колонок
+func selectColumns(format string, values []interface{}, columns []string, selected map[string]bool) (string, []interface{}, []string) {
+	var sb strings.Builder
+	var keptValues []interface{}
+	var keptColumns []string
+	for i, segment := range splitFormat(format) {
+		if i >= 2 {
+			if !selected[columns[i-2]] {
+				continue
+			}
+			keptColumns = append(keptColumns, columns[i-2])
+		}
+		sb.WriteString(segment.separator + segment.spec)
+		keptValues = append(keptValues, values[i])
+	}
+	return sb.String(), keptValues, keptColumns
+}
+
+// specWidthRe выделяет ширину колонки из спецификатора формата
+var specWidthRe = regexp.MustCompile(`^%-?(\d+)`)
+
+// formatSelectedHeader формирует заголовок таблицы с выбранными колонками по строке формата
+// строк таблицы, чтобы названия колонок совпадали с шириной значений
+func formatSelectedHeader(rowFormat string, columns []string, selected map[string]bool) string {
+	names := append([]string{"№", "Layout"}, columns...)
+	var sb strings.Builder
+	for i, segment := range splitFormat(rowFormat) {
+		if i >= 2 && !selected[columns[i-2]] {
+			continue
+		}
+		width := 0
+		if m := specWidthRe.FindStringSubmatch(segment.spec); m != nil {
+			width, _ = strconv.Atoi(m[1])
+		}
+		if i < 2 {
+			sb.WriteString(fmt.Sprintf("%s%-*s", segment.separator, width, names[i]))
+		} else {
+			sb.WriteString(fmt.Sprintf("%s%*s", segment.separator, width, names[i]))
+		}
+	}
+	header := sb.String()
+	return header + "\n" + strings.Repeat("-", utf8.RuneCountInString(header))
+}
+
+// normalizeRowValues делит числовые значения строки таблицы на значения эталонной строки.
+// Если значение эталона равно нулю, отношение не определено и выводится как NaN
+func normalizeRowValues(values, reference []interface{}) []interface{} {
+	normalized := make([]interface{}, len(values))
+	copy(normalized, values)
+	for i := 2; i < len(values); i++ {
+		value, refValue := values[i].(float64), reference[i].(float64)
+		if refValue == 0 {
+			normalized[i] = math.NaN()
+		} else {
+			normalized[i] = value / refValue
+		}
+	}
+	return normalized
+}
+
+// referenceLayouts встроенные эталонные раскладки для режима --normalize и команды rel
+var referenceLayouts = []Layout{
+	{
+		Name: "йцукен",
+		Keys: [3][10]string{
+			{"й", "ц", "у", "к", "е", "н", "г", "ш", "щ", "з"},
+			{"ф", "ы", "в", "а", "п", "р", "о", "л", "д", "ж"},
+			{"я", "ч", "с", "м", "и", "т", "ь", "б", "ю", "х"},
+		},
+	},
+	{
+		Name: "qwerty",
+		Keys: [3][10]string{
+			{"q", "w", "e", "r", "t", "y", "u", "i", "o", "p"},
+			{"a", "s", "d", "f", "g", "h", "j", "k", "l", ";"},
+			{"z", "x", "c", "v", "b", "n", "m", ",", ".", "/"},
+		},
+	},
+}
+
+// findReferenceLayout возвращает встроенную эталонную раскладку по имени без учета регистра
+func findReferenceLayout(name string) (*Layout, bool) {
+	for i := range referenceLayouts {
+		if strings.EqualFold(referenceLayouts[i].Name, name) {
+			return &referenceLayouts[i], true
+		}
+	}
+	return nil, false
+}
+
+// ReferenceLayout возвращает встроенную эталонную раскладку, которая покрывает
+// наибольшую долю частот символов языка
+func ReferenceLayout(langData *LanguageData) *Layout {
+	best, bestCoverage := 0, -1.0
+	for i := range referenceLayouts {
+		coverage := 0.0
+		for _, keys := range referenceLayouts[i].Keys {
+			for _, key := range keys {
+				coverage += langData.Characters[key]
+			}
+		}
+		if coverage > bestCoverage {
+			best, bestCoverage = i, coverage
+		}
+	}
+	return &referenceLayouts[best]
+}
+
+// formatRowWithThresholds форматирует строку таблицы по отдельным ячейкам. Первые два
+// значения (номер и название раскладки) не имеют названия колонки в columns
+func formatRowWithThresholds(format string, values []interface{}, columns []string, thresholds map[string]MetricThreshold, rowColor string) string {
+	if len(thresholds) == 0 {
+		row := fmt.Sprintf(format, values...)
+		if rowColor == "" {
+			return row
+		}
+		return rowColor + row + "\033[0m"
+	}
+
+	var sb strings.Builder
+	last := 0
+	for i, loc := range formatSpecRe.FindAllStringIndex(format, -1) {
+		sb.WriteString(format[last:loc[0]])
+		last = loc[1]
+
+		cell := fmt.Sprintf(format[loc[0]:loc[1]], values[i])
+		color := rowColor
+		if value, isNumber := values[i].(float64); isNumber && i >= 2 {
+			if threshold, exists := thresholds[columns[i-2]]; exists {
+				if threshold.Passes(value) {
+					color = thresholdPassColor
+				} else {
+					color = thresholdFailColor
+				}
+			}
+		}
+		if color != "" {
+			cell = color + cell + "\033[0m"
+		}
+		sb.WriteString(cell)
+	}
+	sb.WriteString(format[last:])
+	return sb.String()
 }
 
 // colorizeNumbers подсвечивает числовые значения в строке зеленым цветом
@@ -655,3 +1621,13 @@ func abs(x int) int {
 func absFloat(x float64) float64 {
 	return math.Abs(x)
 }
+
+// truncateRunes обрезает строку до n символов Unicode, чтобы не разрезать многобайтовые символы
+// (например, кириллицу в названиях раскладок)
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// selftestTestConfig разбирает встроенную конфигурацию команды selftest
+func selftestTestConfig(t *testing.T) *KeyboardConfig {
+	t.Helper()
+	config, err := parseKeyboardConfig(strings.Split(strings.TrimSpace(selftestConfig), "\n"))
+	if err != nil {
+		t.Fatalf("ошибка разбора встроенной конфигурации: %v", err)
+	}
+	return config
+}
+
+// selftestSpaceLanguage возвращает языковые данные selftest с пробелом и биграммами пробела
+func selftestSpaceLanguage() *LanguageData {
+	langData := selftestLanguage()
+	langData.Characters[" "] = 0.18
+	for bigram, freq := range map[string]float64{"e ": 0.03, " t": 0.025, "s ": 0.012, " a": 0.011, "d ": 0.01, " h": 0.008} {
+		langData.Bigrams[bigram] = freq
+	}
+	return langData
+}
+
+func TestSpaceBigramsDoNotDiluteBigramMetrics(t *testing.T) {
+	config := selftestTestConfig(t)
+	plain := AnalyzeLayout(&selftestLayout, config, selftestLanguage())
+
+	config.SpaceCol = 5
+	withSpace := AnalyzeLayout(&selftestLayout, config, selftestSpaceLanguage())
+
+	for _, name := range []string{"SFB", "HSB", "FSB", "LSB", "AFI", "RowJump"} {
+		before, _ := MetricValue(plain, name)
+		after, _ := MetricValue(withSpace, name)
+		if math.Abs(before-after) > 1e-9 {
+			t.Errorf("%s изменился при учете пробела: %.6f -> %.6f", name, before, after)
+		}
+	}
+	if withSpace.BigramAnalysis.SHB == plain.BigramAnalysis.SHB {
+		t.Errorf("SHB не учитывает биграммы с пробелом: %.6f", withSpace.BigramAnalysis.SHB)
+	}
+}
+
+func TestBigramContributionsSumToBigramEffort(t *testing.T) {
+	config := selftestTestConfig(t)
+	for _, spaceCol := range []int{0, 5} {
+		config.SpaceCol = spaceCol
+		langData := selftestSpaceLanguage()
+		analysis := AnalyzeLayout(&selftestLayout, config, langData)
+
+		sum := 0.0
+		for _, contribution := range AnalyzeBigramContributions(&selftestLayout, config, langData) {
+			sum += contribution.Contribution
+		}
+		if want := calculateBigramEffortSum(config, analysis); math.Abs(sum-want) > 1e-6 {
+			t.Errorf("space_col=%d: сумма вкладов биграмм %.6f, сумма метрик биграмм %.6f", spaceCol, sum, want)
+		}
+	}
+}
+
+// Клавиши из нескольких символов Unicode: e с комбинируемым ударением и n с комбинируемой тильдой
+const (
+	combinedE = "e\u0301"
+	combinedN = "n\u0303"
+)
+
+// replaceKeys возвращает копию языковых данных, в которой клавиши заменены по таблице replace
+func replaceKeys(langData *LanguageData, replace map[string]string) *LanguageData {
+	result := &LanguageData{Characters: make(map[string]float64), Bigrams: make(map[string]float64)}
+	for char, freq := range langData.Characters {
+		if replacement, exists := replace[char]; exists {
+			char = replacement
+		}
+		result.Characters[char] = freq
+	}
+	for bigram, freq := range langData.Bigrams {
+		runes := []rune(bigram)
+		first, second := string(runes[0]), string(runes[1])
+		if replacement, exists := replace[first]; exists {
+			first = replacement
+		}
+		if replacement, exists := replace[second]; exists {
+			second = replacement
+		}
+		result.Bigrams[first+second] = freq
+	}
+	return result
+}
+
+func TestMultiRuneKeysBigrams(t *testing.T) {
+	config := selftestTestConfig(t)
+	replace := map[string]string{"e": combinedE, "n": combinedN}
+
+	combinedLayout := selftestLayout
+	combinedLayout.Keys[0][2] = combinedE
+	combinedLayout.Keys[2][5] = combinedN
+	combinedData := replaceKeys(selftestLanguage(), replace)
+
+	keyPos := buildKeyPositions(&combinedLayout, config, combinedData)
+	for bigram, want := range map[string][2]string{
+		combinedE + "t":       {combinedE, "t"},
+		"t" + combinedE:       {"t", combinedE},
+		combinedE + combinedN: {combinedE, combinedN},
+	} {
+		first, second, ok := splitBigram(bigram, keyPos)
+		if !ok || first != want[0] || second != want[1] {
+			t.Errorf("splitBigram(%q) = %q, %q, %v", bigram, first, second, ok)
+		}
+	}
+
+	// Раскладка с составными клавишами анализируется так же, как раскладка с обычными буквами
+	plain := AnalyzeLayout(&selftestLayout, config, selftestLanguage())
+	combined := AnalyzeLayout(&combinedLayout, config, combinedData)
+	for _, name := range []string{"SHB", "SFB", "HSB", "FSB", "LSB", "AFI", "AFO"} {
+		want, _ := MetricValue(plain, name)
+		got, _ := MetricValue(combined, name)
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s с составными клавишами %.6f, с обычными %.6f", name, got, want)
+		}
+	}
+	if math.Abs(plain.WeightedScore-combined.WeightedScore) > 1e-9 {
+		t.Errorf("оценка с составными клавишами %.6f, с обычными %.6f", combined.WeightedScore, plain.WeightedScore)
+	}
+}
+
+func TestUppercasePinnedKeyUsesLowercaseFrequency(t *testing.T) {
+	config := selftestTestConfig(t)
+	langData := selftestLanguage()
+
+	pinned := selftestLayout
+	pinned.Keys[0][2] = "E"
+	pinned.Keys[1][0] = "A"
+
+	plain := AnalyzeLayout(&selftestLayout, config, langData)
+	analysis := AnalyzeLayout(&pinned, config, langData)
+	if math.Abs(analysis.TotalEffort-plain.TotalEffort) > 1e-9 {
+		t.Errorf("усилие раскладки с закрепленными E и A %.6f, без закрепления %.6f", analysis.TotalEffort, plain.TotalEffort)
+	}
+	if math.Abs(analysis.WeightedScore-plain.WeightedScore) > 1e-9 {
+		t.Errorf("оценка раскладки с закрепленными E и A %.6f, без закрепления %.6f", analysis.WeightedScore, plain.WeightedScore)
+	}
+
+	// При case_sensitive=1 заглавная буква - отдельная клавиша без частоты в языковых данных
+	config.CaseSensitive = true
+	if sensitive := AnalyzeLayout(&pinned, config, langData); sensitive.WeightedScore == plain.WeightedScore {
+		t.Errorf("при case_sensitive=1 заглавные E и A получили частоту строчных букв")
+	}
+}
+
+func TestClassifyLSB(t *testing.T) {
+	cases := []struct {
+		name                     string
+		half1, half2, col1, col2 int
+		finger1, finger2         int
+		pattern, valid           bool
+	}{
+		{"средний и указательный слева", 0, 0, 2, 4, 2, 3, true, true},
+		{"указательный и средний справа", 1, 1, 5, 7, 4, 5, true, true},
+		{"безымянный вместо среднего", 0, 0, 2, 4, 1, 3, true, false},
+		{"разные половинки", 0, 1, 4, 2, 3, 2, false, false},
+		{"соседние колонки", 0, 0, 3, 4, 3, 3, false, false},
+		{"большой палец", 0, 0, 2, 4, 2, thumbFingerLeft, false, false},
+	}
+	for _, c := range cases {
+		pattern, valid := classifyLSB(c.half1, c.half2, c.col1, c.col2, c.finger1, c.finger2)
+		if pattern != c.pattern || valid != c.valid {
+			t.Errorf("%s: classifyLSB = %v, %v, ожидалось %v, %v", c.name, pattern, valid, c.pattern, c.valid)
+		}
+	}
+}
+
+func TestLSBMatchesBigramTypeTable(t *testing.T) {
+	langData := selftestLanguage()
+	for _, strict := range []int{0, 1} {
+		config := selftestTestConfig(t)
+		config.Weights.LSBStrictMode = strict
+		analysis := AnalyzeLayout(&selftestLayout, config, langData)
+
+		lsb := 0
+		for i, metric := range bigramTypeColumns {
+			if metric == "LSB" {
+				lsb = i
+			}
+		}
+		total, sum := 0.0, 0.0
+		for _, contribution := range AnalyzeBigramContributions(&selftestLayout, config, langData) {
+			total += langData.Bigrams[contribution.Bigram]
+		}
+		for _, bg := range bigramTypeLists(&selftestLayout, config, langData)[lsb] {
+			sum += bg.Freq
+		}
+		if sum == 0 {
+			t.Fatalf("LSB_strict_mode=%d: в данных selftest нет биграмм LSB", strict)
+		}
+		if got := sum / total * 100.0; math.Abs(got-analysis.BigramAnalysis.LSB) > 1e-9 {
+			t.Errorf("LSB_strict_mode=%d: LSB в таблице команды a %.6f, в таблице lb %.6f", strict, got, analysis.BigramAnalysis.LSB)
+		}
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	cases := map[string]string{
+		"йцукен":                "йцукен",
+		"Диктор (оптимизация)":  "Диктор (оптимиза",
+		"qwerty-abcdefghijklmn": "qwerty-abcdefghi",
+	}
+	for name, want := range cases {
+		if got := truncateRunes(name, 16); got != want {
+			t.Errorf("truncateRunes(%q, 16) = %q, ожидалось %q", name, got, want)
+		}
+	}
+}
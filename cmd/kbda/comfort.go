@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// comfortTerm показатель неудобства, который входит в оценку удобства раскладки (команда comfort).
+// Штраф начисляется за каждый процент сверх допустимого значения Allowance с коэффициентом Coeff
+type comfortTerm struct {
+	Name      string
+	Label     string
+	Allowance float64 // Допустимое значение (%), до которого штраф не начисляется
+	Coeff     float64 // Штраф за каждый процент сверх допустимого значения
+	value     func(analysis *LayoutAnalysis) float64
+}
+
+// comfortTerms фиксированные коэффициенты оценки удобства. Они не зависят от весов config.txt,
+// чтобы оценка была одинаковой у всех пользователей. Допустимые значения соответствуют хорошим
+// современным раскладкам (SFB около 1%, отсутствие полных ножниц), коэффициенты - тому, насколько
+// неудобны показатели относительно друг друга: полные ножницы тяжелее всего, SFB - самый частый
+// источник неудобства, половинчатые ножницы и разбаланс рук заметны только при больших значениях
+var comfortTerms = []comfortTerm{
+	{"SFB", "Биграммы одного пальца", 1.0, 4.0, func(a *LayoutAnalysis) float64 {
+		return a.BigramAnalysis.SFB
+	}},
+	{"FSB", "Полные ножницы", 0.0, 6.0, func(a *LayoutAnalysis) float64 {
+		return a.BigramAnalysis.FSB + a.BigramAnalysis.FSB2
+	}},
+	{"HSB", "Половинчатые ножницы", 5.0, 0.5, func(a *LayoutAnalysis) float64 {
+		return a.BigramAnalysis.HSB + a.BigramAnalysis.HSB2
+	}},
+	{"LSB", "Боковые растяжения", 1.0, 2.0, func(a *LayoutAnalysis) float64 {
+		return a.BigramAnalysis.LSB + a.BigramAnalysis.LSB2
+	}},
+	{"Pinky", "Нагрузка на мизинцы", 16.0, 1.5, func(a *LayoutAnalysis) float64 {
+		return a.PinkyLoad
+	}},
+	{"Hands", "Разница нагрузки рук", 4.0, 1.0, func(a *LayoutAnalysis) float64 {
+		return math.Abs(a.EffortByHalf[0] - a.EffortByHalf[1])
+	}},
+}
+
+// comfortPenalty возвращает штраф показателя за превышение допустимого значения
+func (t comfortTerm) comfortPenalty(value float64) float64 {
+	return t.Coeff * math.Max(0, value-t.Allowance)
+}
+
+// comfortAnalysis анализирует раскладку для оценки удобства. Показатели биграмм рассчитываются без
+// домножения на усилие (effort_scaled_bigrams), чтобы они оставались долями биграмм в процентах
+func comfortAnalysis(layout *Layout, config *KeyboardConfig, langData *LanguageData) *LayoutAnalysis {
+	comfortConfig := *config
+	comfortConfig.EffortScaledBigrams = false
+	return AnalyzeLayout(layout, &comfortConfig, langData)
+}
+
+// ComfortScore возвращает оценку удобства раскладки от 0 до 100: из 100 вычитаются штрафы
+// показателей comfortTerms, 100 - ни один показатель не превышает допустимого значения
+func ComfortScore(analysis *LayoutAnalysis) float64 {
+	score := 100.0
+	for _, term := range comfortTerms {
+		score -= term.comfortPenalty(term.value(analysis))
+	}
+	return math.Max(0, score)
+}
+
+// CommandComfort выводит оценку удобства раскладки N с фиксированными коэффициентами, не зависящими
+// от весов конфигурации, и штрафы каждого показателя. Без номера выводит оценки всех раскладок
+func (ch *CommandHandler) CommandComfort(args string) error {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return ch.printComfortList()
+	}
+
+	layoutNum, err := strconv.Atoi(args)
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", args)
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNum)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNum)
+	}
+
+	analysis := comfortAnalysis(layout, ch.config, ch.langData)
+	fmt.Printf("[%d] %s - оценка удобства с фиксированными коэффициентами (не зависит от весов конфигурации)\n", layoutNum, layout.Name)
+	fmt.Printf("%-7s %-22s %8s %8s %6s %8s\n", "Metric", "", "Значение", "Допуск", "Коэфф", "Штраф")
+	fmt.Println(strings.Repeat("-", 64))
+	for _, term := range comfortTerms {
+		value := term.value(analysis)
+		fmt.Printf("%-7s %-22s %8.2f %8.1f %6.1f %8.2f\n", term.Name, term.Label, value, term.Allowance, term.Coeff, term.comfortPenalty(value))
+	}
+	fmt.Println(strings.Repeat("-", 64))
+	fmt.Printf("Оценка удобства: %.1f из 100\n", ComfortScore(analysis))
+	if len(ch.langData.Bigrams) == 0 {
+		fmt.Println("В языковых данных нет биграмм, оценка учитывает только нагрузку на мизинцы и руки")
+	}
+	return nil
+}
+
+// printComfortList выводит оценку удобства всех загруженных раскладок по убыванию
+func (ch *CommandHandler) printComfortList() error {
+	if len(ch.layouts.Layouts) == 0 {
+		return fmt.Errorf("нет загруженных раскладок")
+	}
+
+	type comfortRow struct {
+		index int
+		name  string
+		score float64
+	}
+	rows := make([]comfortRow, len(ch.layouts.Layouts))
+	for i := range ch.layouts.Layouts {
+		layout := &ch.layouts.Layouts[i]
+		rows[i] = comfortRow{i + 1, layout.Name, ComfortScore(comfortAnalysis(layout, ch.config, ch.langData))}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].score > rows[j].score })
+
+	fmt.Println("Оценка удобства с фиксированными коэффициентами (comfort N - штрафы по показателям)")
+	fmt.Printf("%-4s %-20s %7s\n", "№", "Layout", "Comfort")
+	fmt.Println(strings.Repeat("-", 33))
+	for _, row := range rows {
+		name := row.name
+		if len([]rune(name)) > 20 {
+			name = string([]rune(name)[:20])
+		}
+		fmt.Printf("%-4s %-20s %7.1f\n", fmt.Sprintf("[%d]", row.index), name, row.score)
+	}
+	return nil
+}
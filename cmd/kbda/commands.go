@@ -4,15 +4,22 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 	"github.com/eiannone/keyboard"
 )
 
 // CommandHandler обрабатывает команды
 type CommandHandler struct {
-	langData               *LanguageData
+	langData               *LanguageData              // Языковые данные для анализа (без исключенных символов)
+	fullLangData           *LanguageData              // Языковые данные в том виде, в котором они загружены из файла
+	excludedChars          map[string]bool            // Символы, исключенные из анализа командой exclude
+	excludedBigrams        map[string]bool            // Биграммы, исключенные из анализа командой exclude bigrams
 	config                 *KeyboardConfig
 	layouts                *ParsedLayouts
 	analyses               []LayoutAnalysis
@@ -22,17 +29,30 @@ type CommandHandler struct {
 	isInvertedLayoutActive bool                       // Flag to indicate if inverted layout should be displayed for index 0
 	highlightedLayouts     map[int]bool               // Store highlighted layouts by number
 	configTracker          *ConfigChangeTracker       // Track configuration changes
+	showFreqOverlay        bool                       // Выводить частоты символов под клавишами в командах p и a
+	thresholds             map[string]MetricThreshold // Пороги показателей для подсветки ячеек в таблицах l и lb
+	history                []historyEntry             // Изменения раскладок и коэффициентов за текущую сессию
+	columns                map[string]bool            // Колонки таблиц l и lb, выбранные командой columns (пусто - все)
+	normalize              bool                       // Выводить показатели в таблицах l и lb относительно эталонной раскладки
+	relative               string                     // Встроенная раскладка команды rel для колонки Rel (пусто - выключено, auto - по языку)
+	bufferSlots            map[int]Layout             // Слоты для временного хранения раскладок командой buf
 	langFile               string
 	configFile             string
 	layoutFile             string
 	outputFile             string  // File where new layouts will be saved
 	effortFile             string  // Optional file for effort matrix (if provided via --effort option)
+	keyFreq                map[string]float64 // Собственные частоты символов из файла --keyfreq (nil - частоты языкового файла)
+	bigramFreq             map[string]float64 // Частоты биграмм из файла --bigrams (nil - биграммы языкового файла)
+	noBigramsWarned        bool               // Предупреждение об отсутствии биграмм в данных языка уже выведено
 }
 
 // NewCommandHandler создаёт новый обработчик команд
 func NewCommandHandler(langData *LanguageData, config *KeyboardConfig, layouts *ParsedLayouts, langFile, configFile, layoutFile, outputFile, effortFile string) *CommandHandler {
-	return &CommandHandler{
+	ch := &CommandHandler{
 		langData:               langData,
+		fullLangData:           langData,
+		excludedChars:          make(map[string]bool),
+		excludedBigrams:        make(map[string]bool),
 		config:                 config,
 		layouts:                layouts,
 		bestResults:            make([]SimulatedAnnealingResult, 0),
@@ -40,6 +60,9 @@ func NewCommandHandler(langData *LanguageData, config *KeyboardConfig, layouts *
 		searchResultLayout:     nil,
 		isInvertedLayoutActive: false,
 		highlightedLayouts:     make(map[int]bool),
+		thresholds:             make(map[string]MetricThreshold),
+		columns:                make(map[string]bool),
+		bufferSlots:            make(map[int]Layout),
 		configTracker:          NewConfigChangeTracker(config.Weights),
 		langFile:               langFile,
 		configFile:             configFile,
@@ -47,6 +70,8 @@ func NewCommandHandler(langData *LanguageData, config *KeyboardConfig, layouts *
 		outputFile:             outputFile,
 		effortFile:             effortFile,
 	}
+	ch.warnIfNoBigrams()
+	return ch
 }
 
 // parseIndexRanges парсит спецификацию индексов и диапазонов (например "2,5,7-9,11-15")
@@ -214,12 +239,17 @@ func (ch *CommandHandler) CommandList(args string) error {
 
 		// Format layout number: for search result layout [0], show it as [0], for others show as [index]
 		// Check if layout should be highlighted
+		// При загрузке нескольких файлов рядом с именем выводится исходный файл раскладки
+		name := layout.Name
+		if idx > 0 && layout.Source != "" {
+			name = fmt.Sprintf("%s (файл %s)", layout.Name, layout.Source)
+		}
 		if ch.highlightedLayouts[idx] {
-			fmt.Printf("\033[38;2;249;226;175m[%d] %s\033[0m\n", idx, layout.Name)
+			fmt.Printf("\033[38;2;249;226;175m[%d] %s\033[0m\n", idx, name)
 		} else if idx == 0 {
-			fmt.Printf("[%d] %s\n", idx, layout.Name)
+			fmt.Printf("[%d] %s\n", idx, name)
 		} else {
-			fmt.Printf("[%d] %s\n", idx, layout.Name)
+			fmt.Printf("[%d] %s\n", idx, name)
 		}
 
 		// Находим максимальную частоту для нормирования
@@ -236,10 +266,13 @@ func (ch *CommandHandler) CommandList(args string) error {
 			}
 		}
 
+		// Цифровой ряд (если он есть) выводится над основными рядами
+		ch.printNumberRow(layout, maxFreq)
+
 		// Выводим строки раскладки с цветным форматированием
 		for row := 0; row < 3; row++ {
 			for col := 0; col < 10; col++ {
-				if col == 5 {
+				if col == ch.config.SplitCol {
 					fmt.Print(" ")
 				}
 
@@ -269,8 +302,14 @@ func (ch *CommandHandler) CommandList(args string) error {
 
 				// Display with frequency-based color
 				fmt.Printf("\033[38;2;%d;%d;%dm%s\033[0m ", r, g, b, key)
+				if ch.showFreqOverlay {
+					fmt.Print(" ")
+				}
 			}
 			fmt.Println()
+			if ch.showFreqOverlay {
+				ch.printFreqOverlayRow(layout, row)
+			}
 		}
 		fmt.Println()
 	}
@@ -280,71 +319,14 @@ func (ch *CommandHandler) CommandList(args string) error {
 
 // CommandLayoutList выводит обе таблицы - со статистикой по нажатиям клавиш и по биграммам
 func (ch *CommandHandler) CommandLayoutList(args string) error {
-	var indicesToAnalyze []int
-	maxIndex := ch.getLayoutCount()
-
-	// Если указаны аргументы, парсим их
-	if strings.TrimSpace(args) != "" {
-		// Parse ranges but include the search result layout [0] if needed
-		parts := strings.Split(strings.TrimSpace(args), ",")
-		for _, part := range parts {
-			part = strings.TrimSpace(part)
-
-			if strings.Contains(part, "-") {
-				// Это диапазон
-				rangeParts := strings.Split(part, "-")
-				if len(rangeParts) != 2 {
-					return fmt.Errorf("неверный формат диапазона: %s", part)
-				}
-
-				start, err := strconv.Atoi(strings.TrimSpace(rangeParts[0]))
-				if err != nil {
-					return fmt.Errorf("неверное начало диапазона: %s", rangeParts[0])
-				}
-
-				end, err := strconv.Atoi(strings.TrimSpace(rangeParts[1]))
-				if err != nil {
-					return fmt.Errorf("неверный конец диапазона: %s", rangeParts[1])
-				}
-
-				for i := start; i <= end; i++ {
-					if i >= 0 && i < maxIndex {
-						// Check if this index is valid
-						if i == 0 && (ch.searchResultLayout != nil || (ch.invertedLayout != nil && ch.isInvertedLayoutActive)) {
-							indicesToAnalyze = append(indicesToAnalyze, i)
-						} else if i > 0 && i <= len(ch.layouts.Layouts) {
-							indicesToAnalyze = append(indicesToAnalyze, i)
-						}
-					}
-				}
-			} else {
-				// Это одиночный индекс
-				idx, err := strconv.Atoi(part)
-				if err != nil {
-					return fmt.Errorf("неверный индекс: %s", part)
-				}
+	args, less, err := parseSortOption(args, append(append([]string{}, analysisColumns...), bigramColumns...))
+	if err != nil {
+		return err
+	}
 
-				if idx >= 0 && idx < maxIndex {
-					// Check if this index is valid
-					if idx == 0 && (ch.searchResultLayout != nil || (ch.invertedLayout != nil && ch.isInvertedLayoutActive)) {
-						indicesToAnalyze = append(indicesToAnalyze, idx)
-					} else if idx > 0 && idx <= len(ch.layouts.Layouts) {
-						indicesToAnalyze = append(indicesToAnalyze, idx)
-					}
-				}
-			}
-		}
-		sort.Ints(indicesToAnalyze)
-	} else {
-		// Анализируем все раскладки
-		for i := 0; i < maxIndex; i++ {
-			// Check if this index is valid
-			if i == 0 && (ch.searchResultLayout != nil || (ch.invertedLayout != nil && ch.isInvertedLayoutActive)) {
-				indicesToAnalyze = append(indicesToAnalyze, i)
-			} else if i > 0 && i <= len(ch.layouts.Layouts) {
-				indicesToAnalyze = append(indicesToAnalyze, i)
-			}
-		}
+	indicesToAnalyze, err := ch.parseLayoutSelection(args)
+	if err != nil {
+		return err
 	}
 
 	// Собираем все анализы
@@ -365,8 +347,9 @@ func (ch *CommandHandler) CommandLayoutList(args string) error {
 	}
 
 	// Сортируем по возрастанию значения общей оценки раскладки (WeightedScore)
-	sort.Slice(analyses, func(i, j int) bool {
-		return analyses[i].WeightedScore < analyses[j].WeightedScore
+	// или по колонке, указанной параметром sort=
+	sort.SliceStable(analyses, func(i, j int) bool {
+		return less(analyses[i], analyses[j])
 	})
 
 	// Найдем индекс лучшей раскладки из загруженных (не [0]), если таковая существует
@@ -379,58 +362,163 @@ func (ch *CommandHandler) CommandLayoutList(args string) error {
 		}
 	}
 
+	opts := ch.tableOptions()
+
 	// Выводим заголовок для таблицы статистики по нажатиям клавиш
-	fmt.Printf(" %-3s %-16s %5s %5s %5s %5s %5s %5s %5s %5s %6s %5s %5s %6s %5s %5s %4s %5s %7s %7s\n",
-		"№", "Layout", "F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "R1", "R2", "R3", "Left", "Right", "HDI", "FDI", "MEP", "Effort", "Score")
-	fmt.Println(strings.Repeat("-", 134))
+	ch.printAnalysisHeader(opts)
 
 	// Выводим отсортированные анализы
 	for _, analysis := range analyses {
+		rowColor := ""
 		if analysis.LayoutIndex == 0 {
 			// The search result layout [0] gets yellow color (special treatment still applies)
 			// If also highlighted by user, yellow takes precedence
-			fmt.Printf("\033[38;2;249;226;175m%s\033[0m\n", FormatAnalysis(analysis))
+			rowColor = "\033[38;2;249;226;175m"
 		} else if analysis.LayoutIndex == bestLoadedLayoutIndex {
 			// The best loaded layout gets green color (special treatment still applies)
 			// If also highlighted by user, green takes precedence
-			fmt.Printf("\033[38;2;158;206;88m%s\033[0m\n", FormatAnalysis(analysis))
+			rowColor = "\033[38;2;158;206;88m"
 		} else if ch.highlightedLayouts[analysis.LayoutIndex] {
 			// User-highlighted layout gets yellow color
-			fmt.Printf("\033[38;2;249;226;175m%s\033[0m\n", FormatAnalysis(analysis))
-		} else {
-			fmt.Println(FormatAnalysis(analysis))
+			rowColor = "\033[38;2;249;226;175m"
 		}
+		fmt.Println(FormatAnalysisWithThresholds(analysis, opts, rowColor))
 	}
 
 	// Пустая строка между таблицами
 	fmt.Println()
 
 	// Выводим заголовок для таблицы биграмм
-	fmt.Printf(" %-3s %-16s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %8s %7s\n",
-		"№", "Layout", "SHB", "SFB", "HVB", "FVB", "HDB", "FDB", "HFB", "HSB", "FSB", "LSB", "SRB", "AFI", "AFO", "TIB", "Total", "Score")
-	fmt.Println(strings.Repeat("-", 136))
+	ch.printBigramAnalysisHeader(opts)
 
 	// Выводим отсортированные анализы в формате таблицы биграмм
 	for _, analysis := range analyses {
+		rowColor := ""
 		if analysis.LayoutIndex == 0 {
 			// The search result layout [0] gets yellow color (special treatment still applies)
 			// If also highlighted by user, yellow takes precedence
-			fmt.Printf("\033[38;2;249;226;175m%s\033[0m\n", FormatBigramAnalysis(analysis))
+			rowColor = "\033[38;2;249;226;175m"
 		} else if analysis.LayoutIndex == bestLoadedLayoutIndex {
 			// The best loaded layout gets green color (special treatment still applies)
 			// If also highlighted by user, green takes precedence
-			fmt.Printf("\033[38;2;158;206;88m%s\033[0m\n", FormatBigramAnalysis(analysis))
+			rowColor = "\033[38;2;158;206;88m"
 		} else if ch.highlightedLayouts[analysis.LayoutIndex] {
 			// User-highlighted layout gets yellow color
-			fmt.Printf("\033[38;2;249;226;175m%s\033[0m\n", FormatBigramAnalysis(analysis))
-		} else {
-			fmt.Println(FormatBigramAnalysis(analysis))
+			rowColor = "\033[38;2;249;226;175m"
+		}
+		fmt.Println(FormatBigramAnalysisWithThresholds(analysis, opts, rowColor))
+	}
+
+	return nil
+}
+
+// CommandMarkdown выводит таблицы l и lb для всех или указанных раскладок в виде таблиц
+// Markdown для вставки в документацию
+func (ch *CommandHandler) CommandMarkdown(args string) error {
+	args, less, err := parseSortOption(args, append(append([]string{}, analysisColumns...), bigramColumns...))
+	if err != nil {
+		return err
+	}
+
+	indices, err := ch.parseLayoutSelection(args)
+	if err != nil {
+		return err
+	}
+
+	var analyses []*LayoutAnalysis
+	for _, idx := range indices {
+		layout, found := ch.getLayoutByIndex(idx)
+		if !found {
+			continue
 		}
+		analysis := AnalyzeLayout(layout, ch.config, ch.langData)
+		analysis.LayoutIndex = idx
+		analyses = append(analyses, analysis)
+	}
+	if len(analyses) == 0 {
+		return fmt.Errorf("нет раскладок для вывода")
 	}
 
+	sort.SliceStable(analyses, func(i, j int) bool {
+		return less(analyses[i], analyses[j])
+	})
+
+	fmt.Print(FormatMarkdownTables(analyses, ch.config))
 	return nil
 }
 
+// parseLayoutSelection возвращает номера раскладок по спецификации "N,M,L-K" (пустая
+// спецификация - все раскладки). Номер 0 включается, только если буфер [0] не пуст
+func (ch *CommandHandler) parseLayoutSelection(args string) ([]int, error) {
+	var indicesToAnalyze []int
+	maxIndex := ch.getLayoutCount()
+
+	// Если указаны аргументы, парсим их
+	if strings.TrimSpace(args) != "" {
+		// Parse ranges but include the search result layout [0] if needed
+		parts := strings.Split(strings.TrimSpace(args), ",")
+		for _, part := range parts {
+			part = strings.TrimSpace(part)
+
+			if strings.Contains(part, "-") {
+				// Это диапазон
+				rangeParts := strings.Split(part, "-")
+				if len(rangeParts) != 2 {
+					return nil, fmt.Errorf("неверный формат диапазона: %s", part)
+				}
+
+				start, err := strconv.Atoi(strings.TrimSpace(rangeParts[0]))
+				if err != nil {
+					return nil, fmt.Errorf("неверное начало диапазона: %s", rangeParts[0])
+				}
+
+				end, err := strconv.Atoi(strings.TrimSpace(rangeParts[1]))
+				if err != nil {
+					return nil, fmt.Errorf("неверный конец диапазона: %s", rangeParts[1])
+				}
+
+				for i := start; i <= end; i++ {
+					if i >= 0 && i < maxIndex {
+						// Check if this index is valid
+						if i == 0 && (ch.searchResultLayout != nil || (ch.invertedLayout != nil && ch.isInvertedLayoutActive)) {
+							indicesToAnalyze = append(indicesToAnalyze, i)
+						} else if i > 0 && i <= len(ch.layouts.Layouts) {
+							indicesToAnalyze = append(indicesToAnalyze, i)
+						}
+					}
+				}
+			} else {
+				// Это одиночный индекс
+				idx, err := strconv.Atoi(part)
+				if err != nil {
+					return nil, fmt.Errorf("неверный индекс: %s", part)
+				}
+
+				if idx >= 0 && idx < maxIndex {
+					// Check if this index is valid
+					if idx == 0 && (ch.searchResultLayout != nil || (ch.invertedLayout != nil && ch.isInvertedLayoutActive)) {
+						indicesToAnalyze = append(indicesToAnalyze, idx)
+					} else if idx > 0 && idx <= len(ch.layouts.Layouts) {
+						indicesToAnalyze = append(indicesToAnalyze, idx)
+					}
+				}
+			}
+		}
+		sort.Ints(indicesToAnalyze)
+	} else {
+		// Анализируем все раскладки
+		for i := 0; i < maxIndex; i++ {
+			// Check if this index is valid
+			if i == 0 && (ch.searchResultLayout != nil || (ch.invertedLayout != nil && ch.isInvertedLayoutActive)) {
+				indicesToAnalyze = append(indicesToAnalyze, i)
+			} else if i > 0 && i <= len(ch.layouts.Layouts) {
+				indicesToAnalyze = append(indicesToAnalyze, i)
+			}
+		}
+	}
+	return indicesToAnalyze, nil
+}
+
 // CommandCoefficients выводит используемые в анализе и поиске коэффициенты из конфигурационного файла с нумерацией
 func (ch *CommandHandler) CommandCoefficients(args string) error {
 	weights := &ch.config.Weights
@@ -466,6 +554,14 @@ func (ch *CommandHandler) CommandCoefficients(args string) error {
 	fmt.Println("27. PR1 (Штраф для 1 ряда за превышение максимального усилия):", ch.config.Weights.RowPenalty1)
 	fmt.Println("28. PR2 (Штраф для 2 ряда за превышение максимального усилия):", ch.config.Weights.RowPenalty2)
 	fmt.Println("29. PR3 (Штраф для 3 ряда за превышение максимального усилия):", ch.config.Weights.RowPenalty3)
+	fmt.Println("30. PinkyNorm (Нормирующий коэффициент для нагрузки на мизинцы):", weights.PinkyNorm)
+	fmt.Println("31. ICS (Index Center Stretch - растяжение указательного пальца в центральную колонку):", weights.ICS)
+	fmt.Println("32. SymSFB (Symmetric Same Finger Bigrams - один и тот же палец с учетом зеркальной руки):", weights.SymSFB)
+	fmt.Println("33. HomeUseNorm (Нормирующий коэффициент для доли нажатий на домашние позиции, уменьшает оценку):", weights.HomeUseNorm)
+	fmt.Println("34. RowJumpNorm (Нормирующий коэффициент для переходов между верхним и нижним рядом одной руки):", weights.RowJumpNorm)
+	fmt.Println("35. LSB_all (Lateral Stretch Bigrams - растяжение через колонку для всех пар пальцев):", weights.LSBAll)
+	fmt.Println("36. SKB (Same Key Bigrams - повторное нажатие одной и той же клавиши):", weights.SKB)
+	fmt.Printf("37. HandBias (отклонение нагрузки на правую руку от целевой доли %g%%): %v\n", ch.config.HandBiasTarget, weights.HandBias)
 
 	// Выводим список индивидуальных коэффициентов биграмм
 	if len(ch.config.BigramIndividualCoeffs) > 0 {
@@ -499,156 +595,244 @@ func (ch *CommandHandler) CommandCoefficients(args string) error {
 
 // CommandSetCoefficient устанавливает значение коэффициента по номеру
 func (ch *CommandHandler) CommandSetCoefficient(args string) error {
+	num, value, err := parseCoefficientArgs(args, "set")
+	if err != nil {
+		return err
+	}
+
+	message, err := setCoefficient(ch.config, ch.configTracker, num, value)
+	if err != nil {
+		return err
+	}
+	fmt.Println(message)
+	return nil
+}
+
+// CommandSetCoefficientPreview показывает, как изменятся оценки и места загруженных раскладок
+// при новом значении коэффициента. Значение устанавливается в копии конфигурации, которая
+// после анализа отбрасывается, поэтому конфигурация сессии не изменяется
+func (ch *CommandHandler) CommandSetCoefficientPreview(args string) error {
+	num, value, err := parseCoefficientArgs(args, "set?")
+	if err != nil {
+		return err
+	}
+	if len(ch.layouts.Layouts) == 0 {
+		return fmt.Errorf("нет загруженных раскладок")
+	}
+
+	previewConfig := *ch.config
+	if _, err := setCoefficient(&previewConfig, NewConfigChangeTracker(previewConfig.Weights), num, value); err != nil {
+		return err
+	}
+
+	type previewRow struct {
+		index    int
+		name     string
+		score    float64
+		newScore float64
+		rank     int
+		newRank  int
+	}
+	rows := make([]previewRow, len(ch.layouts.Layouts))
+	for i := range ch.layouts.Layouts {
+		layout := &ch.layouts.Layouts[i]
+		rows[i] = previewRow{
+			index:    i + 1,
+			name:     layout.Name,
+			score:    AnalyzeLayout(layout, ch.config, ch.langData).WeightedScore,
+			newScore: AnalyzeLayout(layout, &previewConfig, ch.langData).WeightedScore,
+		}
+	}
+
+	// Места раскладок по текущей и по новой оценке (меньшая оценка лучше)
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].score < rows[j].score })
+	for i := range rows {
+		rows[i].rank = i + 1
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].newScore < rows[j].newScore })
+	for i := range rows {
+		rows[i].newRank = i + 1
+	}
+
+	fmt.Printf("Предварительный просмотр: коэффициент %d = %g (конфигурация сессии не изменяется)\n\n", num, value)
+	fmt.Printf("%-4s %-20s %10s %10s %10s %9s\n", "№", "Layout", "Score", "New", "Diff", "Rank")
+	fmt.Println(strings.Repeat("-", 68))
+	for _, row := range rows {
+		fmt.Printf("%-4d %-20s %10.2f %10.2f %+10.2f %4d → %d\n",
+			row.index, truncateRunes(row.name, 20), row.score, row.newScore, row.newScore-row.score, row.rank, row.newRank)
+	}
+
+	best := rows[0]
+	if best.rank == 1 {
+		fmt.Printf("\nЛучшая раскладка не изменится: [%d] %s\n", best.index, best.name)
+	} else {
+		fmt.Printf("\nЛучшей станет раскладка [%d] %s (сейчас на %d месте)\n", best.index, best.name, best.rank)
+	}
+	return nil
+}
+
+// parseCoefficientArgs разбирает аргументы "N значение" команд set и set?
+func parseCoefficientArgs(args string, command string) (int, float64, error) {
 	parts := strings.Fields(args)
 	if len(parts) != 2 {
-		return fmt.Errorf("используйте: set N значение (где N - номер коэффициента, значение - новое значение)")
+		return 0, 0, fmt.Errorf("используйте: %s N значение (где N - номер коэффициента, значение - новое значение)", command)
 	}
 
-	numStr := parts[0]
-	valueStr := parts[1]
-
-	num, err := strconv.Atoi(numStr)
+	num, err := strconv.Atoi(parts[0])
 	if err != nil {
-		return fmt.Errorf("некорректный номер коэффициента: %v", err)
+		return 0, 0, fmt.Errorf("некорректный номер коэффициента: %v", err)
 	}
 
-	value, err := strconv.ParseFloat(valueStr, 64)
+	value, err := strconv.ParseFloat(parts[1], 64)
 	if err != nil {
-		return fmt.Errorf("некорректное значение коэффициента: %v", err)
+		return 0, 0, fmt.Errorf("некорректное значение коэффициента: %v", err)
 	}
+	return num, value, nil
+}
 
-	weights := &ch.config.Weights
+// setCoefficient устанавливает в конфигурации значение коэффициента по номеру, отмечает его
+// как измененный в tracker и возвращает сообщение для пользователя
+func setCoefficient(config *KeyboardConfig, tracker *ConfigChangeTracker, num int, value float64) (string, error) {
+	weights := &config.Weights
 
 	// Устанавливаем значение коэффициента по номеру (только используемые в анализе и поиске)
 	switch num {
 	case 1:
 		weights.TotalEffortNorm = value
-		ch.configTracker.SetWeight("TotalEffortNorm", value)
+		tracker.SetWeight("TotalEffortNorm", value)
 	case 2:
 		weights.HDI = value
-		ch.configTracker.SetWeight("HDI", value)
+		tracker.SetWeight("HDI", value)
 	case 3:
 		weights.FDI = value
-		ch.configTracker.SetWeight("FDI", value)
+		tracker.SetWeight("FDI", value)
 	case 4:
 		weights.D18 = value
-		ch.configTracker.SetWeight("D18", value)
+		tracker.SetWeight("D18", value)
 	case 5:
 		weights.D27 = value
-		ch.configTracker.SetWeight("D27", value)
+		tracker.SetWeight("D27", value)
 	case 6:
 		weights.D36 = value
-		ch.configTracker.SetWeight("D36", value)
+		tracker.SetWeight("D36", value)
 	case 7:
 		weights.D45 = value
-		ch.configTracker.SetWeight("D45", value)
+		tracker.SetWeight("D45", value)
 	case 8:
 		weights.SHB = value
-		ch.configTracker.SetWeight("SHB", value)
+		tracker.SetWeight("SHB", value)
 	case 9:
 		weights.SFB = value
-		ch.configTracker.SetWeight("SFB", value)
+		tracker.SetWeight("SFB", value)
 	case 10:
 		weights.HVB = value
-		ch.configTracker.SetWeight("HVB", value)
+		tracker.SetWeight("HVB", value)
 	case 11:
 		weights.FVB = value
-		ch.configTracker.SetWeight("FVB", value)
+		tracker.SetWeight("FVB", value)
 	case 12:
 		weights.HDB = value
-		ch.configTracker.SetWeight("HDB", value)
+		tracker.SetWeight("HDB", value)
 	case 13:
 		weights.FDB = value
-		ch.configTracker.SetWeight("FDB", value)
+		tracker.SetWeight("FDB", value)
 	case 14:
 		weights.HFB = value
-		ch.configTracker.SetWeight("HFB", value)
+		tracker.SetWeight("HFB", value)
 	case 15:
 		weights.HSB = value
-		ch.configTracker.SetWeight("HSB", value)
+		tracker.SetWeight("HSB", value)
 	case 16:
 		weights.FSB = value
-		ch.configTracker.SetWeight("FSB", value)
+		tracker.SetWeight("FSB", value)
 	case 17:
 		weights.LSB = value
-		ch.configTracker.SetWeight("LSB", value)
+		tracker.SetWeight("LSB", value)
 	case 18:
 		weights.SRB = value
-		ch.configTracker.SetWeight("SRB", value)
+		tracker.SetWeight("SRB", value)
 	case 19:
 		weights.AFI = value
-		ch.configTracker.SetWeight("AFI", value)
+		tracker.SetWeight("AFI", value)
 	case 20:
 		weights.AFO = value
-		ch.configTracker.SetWeight("AFO", value)
+		tracker.SetWeight("AFO", value)
 	case 21:
 		weights.HSBStrictMode = int(value)
-		ch.configTracker.SetIntWeight("HSBStrictMode", int(value))
+		tracker.SetIntWeight("HSBStrictMode", int(value))
 	case 22:
 		weights.FSBStrictMode = int(value)
-		ch.configTracker.SetIntWeight("FSBStrictMode", int(value))
+		tracker.SetIntWeight("FSBStrictMode", int(value))
 	case 23:
 		weights.LSBStrictMode = int(value)
-		ch.configTracker.SetIntWeight("LSBStrictMode", int(value))
+		tracker.SetIntWeight("LSBStrictMode", int(value))
 	case 24:
-		ch.config.Weights.MaxRowEffort1 = value
-		ch.config.MaxRowEfforts[0] = value
-		ch.configTracker.SetWeight("MaxRowEffort1", value)
-		fmt.Printf("MR1 (максимальное усилие для 1 ряда) установлено в значение: %g\n", value)
-		return nil
+		config.Weights.MaxRowEffort1 = value
+		config.MaxRowEfforts[0] = value
+		tracker.SetWeight("MaxRowEffort1", value)
+		return fmt.Sprintf("MR1 (максимальное усилие для 1 ряда) установлено в значение: %g", value), nil
 	case 25:
-		ch.config.Weights.MaxRowEffort2 = value
-		ch.config.MaxRowEfforts[1] = value
-		ch.configTracker.SetWeight("MaxRowEffort2", value)
-		fmt.Printf("MR2 (максимальное усилие для 2 ряда) установлено в значение: %g\n", value)
-		return nil
+		config.Weights.MaxRowEffort2 = value
+		config.MaxRowEfforts[1] = value
+		tracker.SetWeight("MaxRowEffort2", value)
+		return fmt.Sprintf("MR2 (максимальное усилие для 2 ряда) установлено в значение: %g", value), nil
 	case 26:
-		ch.config.Weights.MaxRowEffort3 = value
-		ch.config.MaxRowEfforts[2] = value
-		ch.configTracker.SetWeight("MaxRowEffort3", value)
-		fmt.Printf("MR3 (максимальное усилие для 3 ряда) установлено в значение: %g\n", value)
-		return nil
+		config.Weights.MaxRowEffort3 = value
+		config.MaxRowEfforts[2] = value
+		tracker.SetWeight("MaxRowEffort3", value)
+		return fmt.Sprintf("MR3 (максимальное усилие для 3 ряда) установлено в значение: %g", value), nil
 	case 27:
-		ch.config.Weights.RowPenalty1 = value
-		ch.config.RowEffortPenalties[0] = value
-		ch.configTracker.SetWeight("RowPenalty1", value)
-		fmt.Printf("PR1 (штраф для 1 ряда за превышение максимального усилия) установлено в значение: %g\n", value)
-		return nil
+		config.Weights.RowPenalty1 = value
+		config.RowEffortPenalties[0] = value
+		tracker.SetWeight("RowPenalty1", value)
+		return fmt.Sprintf("PR1 (штраф для 1 ряда за превышение максимального усилия) установлено в значение: %g", value), nil
 	case 28:
-		ch.config.Weights.RowPenalty2 = value
-		ch.config.RowEffortPenalties[1] = value
-		ch.configTracker.SetWeight("RowPenalty2", value)
-		fmt.Printf("PR2 (штраф для 2 ряда за превышение максимального усилия) установлено в значение: %g\n", value)
-		return nil
+		config.Weights.RowPenalty2 = value
+		config.RowEffortPenalties[1] = value
+		tracker.SetWeight("RowPenalty2", value)
+		return fmt.Sprintf("PR2 (штраф для 2 ряда за превышение максимального усилия) установлено в значение: %g", value), nil
 	case 29:
-		ch.config.Weights.RowPenalty3 = value
-		ch.config.RowEffortPenalties[2] = value
-		ch.configTracker.SetWeight("RowPenalty3", value)
-		fmt.Printf("PR3 (штраф для 3 ряда за превышение максимального усилия) установлено в значение: %g\n", value)
-		return nil
+		config.Weights.RowPenalty3 = value
+		config.RowEffortPenalties[2] = value
+		tracker.SetWeight("RowPenalty3", value)
+		return fmt.Sprintf("PR3 (штраф для 3 ряда за превышение максимального усилия) установлено в значение: %g", value), nil
+	case 30:
+		weights.PinkyNorm = value
+		tracker.SetWeight("PinkyNorm", value)
+	case 31:
+		weights.ICS = value
+		tracker.SetWeight("ICS", value)
+	case 32:
+		weights.SymSFB = value
+		tracker.SetWeight("SymSFB", value)
+	case 33:
+		weights.HomeUseNorm = value
+		tracker.SetWeight("HomeUseNorm", value)
+	case 34:
+		weights.RowJumpNorm = value
+		tracker.SetWeight("RowJumpNorm", value)
+	case 35:
+		weights.LSBAll = value
+		tracker.SetWeight("LSBAll", value)
+	case 36:
+		weights.SKB = value
+		tracker.SetWeight("SKB", value)
+	case 37:
+		weights.HandBias = value
+		tracker.SetWeight("HandBias", value)
 	default:
-		return fmt.Errorf("номер коэффициента %d вне диапазона (1-28)", num)
+		return "", fmt.Errorf("номер коэффициента %d вне диапазона (1-37)", num)
 	}
 
-	fmt.Printf("Коэффициент %d установлен в значение: %g\n", num, value)
-	return nil
+	return fmt.Sprintf("Коэффициент %d установлен в значение: %g", num, value), nil
 }
 
-// CommandReload перезагружает все файлы
-func (ch *CommandHandler) CommandReload(langFile, configFile, layoutFile string) error {
-	langData, err := LoadLanguageData(langFile)
-	if err != nil {
-		return err
-	}
-
+// loadConfigWithChanges загружает конфигурацию из файла, применяя к ней измененные за сессию веса
+// и матрицу усилий из отдельного файла, если он был указан
+func (ch *CommandHandler) loadConfigWithChanges(configFile string) (*KeyboardConfig, error) {
 	config, err := LoadKeyboardConfig(configFile)
 	if err != nil {
-		return err
-	}
-
-	layouts, err := LoadLayouts(layoutFile)
-	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Применяем измененные веса к новой конфигурации
@@ -656,49 +840,240 @@ func (ch *CommandHandler) CommandReload(langFile, configFile, layoutFile string)
 
 	// Если был указан отдельный файл с усилиями, загружаем матрицу усилий из него
 	if ch.effortFile != "" && ch.effortFile != configFile {
-		effortMatrix, err := LoadEffortMatrix(ch.effortFile)
+		effortMatrix, numberRowEfforts, err := LoadEffortMatrix(ch.effortFile)
 		if err != nil {
 			fmt.Printf("Предупреждение: не удалось загрузить матрицу усилий из файла %s: %v\n", ch.effortFile, err)
 		} else {
 			// Обновляем матрицу усилий в конфигурации
 			config.EffortMatrix = effortMatrix
+			config.NumberRowEfforts = numberRowEfforts
 		}
 	}
 
-	ch.langData = langData
-	ch.config = config
-	ch.layouts = layouts
-	ch.analyses = nil
-	// Reset the inverted layout active flag since everything is being reloaded
-	ch.isInvertedLayoutActive = false
-
-	// Обновляем базовую конфигурацию в существующем трекере, чтобы сохранить информацию об изменениях
-	ch.configTracker.UpdateBaseConfig(config.Weights)
+	return config, nil
+}
 
-	fmt.Println("Файлы перезагружены успешно")
+// requireSingleLayoutFile возвращает ошибку для команд, перезаписывающих файл раскладок,
+// если раскладки загружены из нескольких файлов (--layout a.txt,b.txt или append)
+func (ch *CommandHandler) requireSingleLayoutFile(command string) error {
+	if files := splitLayoutFiles(ch.layoutFile); len(files) > 1 {
+		return fmt.Errorf("команда %s недоступна: раскладки загружены из нескольких файлов (%s)", command, strings.Join(files, ", "))
+	}
 	return nil
 }
 
-// CommandInfo анализирует раскладки и выводит информацию
-func (ch *CommandHandler) CommandInfo(args string) error {
-	var indicesToAnalyze []int
-	maxIndex := ch.getLayoutCount()
+// CommandAppend добавляет к загруженным раскладкам раскладки из другого файла. Раскладки
+// помечаются исходным файлом, при совпадении имен к имени добавляется имя файла
+func (ch *CommandHandler) CommandAppend(args string) error {
+	fileName := strings.TrimSpace(args)
+	if fileName == "" {
+		return fmt.Errorf("используйте: append file")
+	}
+	absFileName, _ := filepath.Abs(fileName)
+	for _, file := range splitLayoutFiles(ch.layoutFile) {
+		if absFile, _ := filepath.Abs(file); absFile == absFileName {
+			return fmt.Errorf("раскладки из файла %s уже загружены", fileName)
+		}
+	}
 
-	// Если указаны аргументы, парсим их
-	if strings.TrimSpace(args) != "" {
-		// Parse ranges but include the search result layout [0] if needed
-		parts := strings.Split(strings.TrimSpace(args), ",")
-		for _, part := range parts {
-			part = strings.TrimSpace(part)
+	parsedLayouts, err := LoadLayouts(fileName)
+	if err != nil {
+		return err
+	}
 
-			if strings.Contains(part, "-") {
-				// Это диапазон
-				rangeParts := strings.Split(part, "-")
-				if len(rangeParts) != 2 {
-					return fmt.Errorf("неверный формат диапазона: %s", part)
-				}
+	// Раскладки, загруженные из единственного файла, помечаются им при первом добавлении
+	source := filepath.Base(ch.layoutFile)
+	for i := range ch.layouts.Layouts {
+		if ch.layouts.Layouts[i].Source == "" {
+			ch.layouts.Layouts[i].Source = source
+		}
+	}
 
-				start, err := strconv.Atoi(strings.TrimSpace(rangeParts[0]))
+	first := len(ch.layouts.Layouts) + 1
+	appendLayouts(ch.layouts, parsedLayouts.Layouts, filepath.Base(fileName))
+	ch.layoutFile += layoutFileListSeparator + fileName
+	ch.analyses = nil
+
+	fmt.Printf("Добавлено раскладок из файла %s: %d (номера %d-%d), всего раскладок: %d\n",
+		fileName, len(parsedLayouts.Layouts), first, len(ch.layouts.Layouts), len(ch.layouts.Layouts))
+	return nil
+}
+
+// CommandReload перезагружает все файлы
+func (ch *CommandHandler) CommandReload(langFile, configFile, layoutFile string) error {
+	langData, err := LoadLanguageData(langFile)
+	if err != nil {
+		return err
+	}
+
+	config, err := ch.loadConfigWithChanges(configFile)
+	if err != nil {
+		return err
+	}
+
+	layouts, err := LoadLayoutFiles(layoutFile)
+	if err != nil {
+		return err
+	}
+
+	ch.setLanguageData(langData)
+	ch.config = config
+	ch.layouts = layouts
+	ch.analyses = nil
+	// Reset the inverted layout active flag since everything is being reloaded
+	ch.isInvertedLayoutActive = false
+
+	// Обновляем базовую конфигурацию в существующем трекере, чтобы сохранить информацию об изменениях
+	ch.configTracker.UpdateBaseConfig(config.Weights)
+
+	fmt.Println("Файлы перезагружены успешно")
+	return nil
+}
+
+// CommandSoftReload перезагружает конфигурацию и файл языка без файла раскладок, сохраняя
+// временную раскладку [0] и выделенные раскладки, и выводит оценку [0] с новой конфигурацией
+func (ch *CommandHandler) CommandSoftReload() error {
+	// Оценка временной раскладки до перезагрузки для сравнения
+	buffer, hasBuffer := ch.getLayoutByIndex(0)
+	var oldScore float64
+	if hasBuffer {
+		oldScore = AnalyzeLayout(buffer, ch.config, ch.langData).WeightedScore
+	}
+
+	langData, err := LoadLanguageData(ch.langFile)
+	if err != nil {
+		return err
+	}
+
+	config, err := ch.loadConfigWithChanges(ch.configFile)
+	if err != nil {
+		return err
+	}
+
+	ch.setLanguageData(langData)
+	ch.config = config
+	ch.analyses = nil
+
+	// Обновляем базовую конфигурацию в существующем трекере, чтобы сохранить информацию об изменениях
+	ch.configTracker.UpdateBaseConfig(config.Weights)
+
+	fmt.Println("Конфигурация и файл языка перезагружены, временная раскладка [0] сохранена")
+	if !hasBuffer {
+		return nil
+	}
+
+	analysis := AnalyzeLayout(buffer, ch.config, ch.langData)
+	fmt.Println()
+	fmt.Println(FormatAnalysisHeader(ch.config))
+	fmt.Println(FormatAnalysis(analysis))
+	fmt.Printf("\nОценка [0]: %.2f (до перезагрузки %.2f)\n", analysis.WeightedScore, oldScore)
+
+	return nil
+}
+
+// watchPollInterval интервал проверки времени изменения файла раскладок
+const watchPollInterval = 500 * time.Millisecond
+
+// watchReloadAttempts количество попыток перезагрузки, если файл еще записывается
+const watchReloadAttempts = 5
+
+// CommandWatch следит за файлом раскладок и при его изменении перезагружает
+// данные и выводит таблицу ll до нажатия любой клавиши
+func (ch *CommandHandler) CommandWatch(args string) error {
+	if err := ch.requireSingleLayoutFile("watch"); err != nil {
+		return err
+	}
+	info, err := os.Stat(ch.layoutFile)
+	if err != nil {
+		return fmt.Errorf("не удалось получить информацию о файле %s: %w", ch.layoutFile, err)
+	}
+	lastModTime := info.ModTime()
+
+	if err := ch.CommandLayoutList(args); err != nil {
+		return err
+	}
+
+	fmt.Printf("Отслеживание изменений файла %s\n", ch.layoutFile)
+	fmt.Println("\x1b[38;2;215;100;100m\nДля остановки нажмите любую клавишу.\n\x1b[0m")
+
+	if err := keyboard.Open(); err != nil {
+		return fmt.Errorf("не удалось открыть клавиатуру: %w", err)
+	}
+	defer keyboard.Close()
+
+	// Канал для сигнала завершения
+	done := make(chan struct{})
+	go func() {
+		keyboard.GetKey()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			fmt.Println("Отслеживание остановлено")
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(ch.layoutFile)
+		if err != nil || !info.ModTime().After(lastModTime) {
+			// Файл может временно отсутствовать во время сохранения редактором
+			continue
+		}
+		lastModTime = info.ModTime()
+
+		// Файл может быть еще не записан до конца, поэтому перезагружаем его только после того,
+		// как в нем появятся полные раскладки, и повторяем попытку
+		var reloadErr error
+		for attempt := 0; attempt < watchReloadAttempts; attempt++ {
+			if reloadErr = CheckLayoutsFileComplete(ch.layoutFile); reloadErr == nil {
+				if reloadErr = ch.CommandReload(ch.langFile, ch.configFile, ch.layoutFile); reloadErr == nil {
+					break
+				}
+			}
+			time.Sleep(watchPollInterval)
+		}
+		if reloadErr != nil {
+			fmt.Printf("Ошибка при перезагрузке: %v\n", reloadErr)
+			continue
+		}
+
+		fmt.Printf("\nФайл изменен (%s)\n", lastModTime.Format("15:04:05"))
+		if err := ch.CommandLayoutList(args); err != nil {
+			fmt.Printf("Ошибка: %v\n", err)
+		}
+	}
+}
+
+// CommandInfo анализирует раскладки и выводит информацию
+func (ch *CommandHandler) CommandInfo(args string) error {
+	args, less, err := parseSortOption(args, analysisColumns)
+	if err != nil {
+		return err
+	}
+
+	var indicesToAnalyze []int
+	maxIndex := ch.getLayoutCount()
+
+	// Если указаны аргументы, парсим их
+	if strings.TrimSpace(args) != "" {
+		// Parse ranges but include the search result layout [0] if needed
+		parts := strings.Split(strings.TrimSpace(args), ",")
+		for _, part := range parts {
+			part = strings.TrimSpace(part)
+
+			if strings.Contains(part, "-") {
+				// Это диапазон
+				rangeParts := strings.Split(part, "-")
+				if len(rangeParts) != 2 {
+					return fmt.Errorf("неверный формат диапазона: %s", part)
+				}
+
+				start, err := strconv.Atoi(strings.TrimSpace(rangeParts[0]))
 				if err != nil {
 					return fmt.Errorf("неверное начало диапазона: %s", rangeParts[0])
 				}
@@ -766,8 +1141,9 @@ func (ch *CommandHandler) CommandInfo(args string) error {
 	}
 
 	// Сортируем по возрастанию значения общей оценки раскладки (WeightedScore)
-	sort.Slice(analyses, func(i, j int) bool {
-		return analyses[i].WeightedScore < analyses[j].WeightedScore
+	// или по колонке, указанной параметром sort=
+	sort.SliceStable(analyses, func(i, j int) bool {
+		return less(analyses[i], analyses[j])
 	})
 
 	// Найдем индекс лучшей раскладки из загруженных (не [0]), если таковая существует
@@ -780,27 +1156,27 @@ func (ch *CommandHandler) CommandInfo(args string) error {
 		}
 	}
 
+	opts := ch.tableOptions()
+
 	// Выводим заголовок
-	fmt.Printf(" %-3s %-16s %5s %5s %5s %5s %5s %5s %5s %5s %6s %5s %5s %6s %5s %5s %4s %5s %7s %7s\n",
-		"№", "Layout", "F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "R1", "R2", "R3", "Left", "Right", "HDI", "FDI", "MEP", "Effort", "Score")
-	fmt.Println(strings.Repeat("-", 134))
+	ch.printAnalysisHeader(opts)
 
 	// Выводим отсортированные анализы
 	for _, analysis := range analyses {
+		rowColor := ""
 		if analysis.LayoutIndex == 0 {
 			// The search result layout [0] gets yellow color (special treatment still applies)
 			// If also highlighted by user, yellow takes precedence
-			fmt.Printf("\033[38;2;249;226;175m%s\033[0m\n", FormatAnalysis(analysis))
+			rowColor = "\033[38;2;249;226;175m"
 		} else if analysis.LayoutIndex == bestLoadedLayoutIndex {
 			// The best loaded layout gets green color (special treatment still applies)
 			// If also highlighted by user, green takes precedence
-			fmt.Printf("\033[38;2;158;206;88m%s\033[0m\n", FormatAnalysis(analysis))
+			rowColor = "\033[38;2;158;206;88m"
 		} else if ch.highlightedLayouts[analysis.LayoutIndex] {
 			// User-highlighted layout gets yellow color
-			fmt.Printf("\033[38;2;249;226;175m%s\033[0m\n", FormatAnalysis(analysis))
-		} else {
-			fmt.Println(FormatAnalysis(analysis))
+			rowColor = "\033[38;2;249;226;175m"
 		}
+		fmt.Println(FormatAnalysisWithThresholds(analysis, opts, rowColor))
 	}
 
 	return nil
@@ -808,6 +1184,11 @@ func (ch *CommandHandler) CommandInfo(args string) error {
 
 // CommandBigrams анализирует биграммы
 func (ch *CommandHandler) CommandBigrams(args string) error {
+	args, less, err := parseSortOption(args, bigramColumns)
+	if err != nil {
+		return err
+	}
+
 	var indicesToAnalyze []int
 	maxIndex := ch.getLayoutCount()
 
@@ -893,8 +1274,9 @@ func (ch *CommandHandler) CommandBigrams(args string) error {
 	}
 
 	// Сортируем по возрастанию значения общей оценки раскладки (WeightedScore)
-	sort.Slice(analyses, func(i, j int) bool {
-		return analyses[i].WeightedScore < analyses[j].WeightedScore
+	// или по колонке, указанной параметром sort=
+	sort.SliceStable(analyses, func(i, j int) bool {
+		return less(analyses[i], analyses[j])
 	})
 
 	// Найдем индекс лучшей раскладки из загруженных (не [0]), если таковая существует
@@ -907,27 +1289,27 @@ func (ch *CommandHandler) CommandBigrams(args string) error {
 		}
 	}
 
+	opts := ch.tableOptions()
+
 	// Выводим заголовок для таблицы биграмм
-	fmt.Printf(" %-3s %-16s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %8s %7s\n",
-		"№", "Layout", "SHB", "SFB", "HVB", "FVB", "HDB", "FDB", "HFB", "HSB", "FSB", "LSB", "SRB", "AFI", "AFO", "TIB", "Total", "Score")
-	fmt.Println(strings.Repeat("-", 136))
+	ch.printBigramAnalysisHeader(opts)
 
 	// Выводим отсортированные анализы в формате таблицы
 	for _, analysis := range analyses {
+		rowColor := ""
 		if analysis.LayoutIndex == 0 {
 			// The search result layout [0] gets yellow color (special treatment still applies)
 			// If also highlighted by user, green takes precedence
-			fmt.Printf("\033[38;2;249;226;175m%s\033[0m\n", FormatBigramAnalysis(analysis))
+			rowColor = "\033[38;2;249;226;175m"
 		} else if analysis.LayoutIndex == bestLoadedLayoutIndex {
 			// The best loaded layout gets green color (special treatment still applies)
 			// If also highlighted by user, green takes precedence
-			fmt.Printf("\033[38;2;158;206;88m%s\033[0m\n", FormatBigramAnalysis(analysis))
+			rowColor = "\033[38;2;158;206;88m"
 		} else if ch.highlightedLayouts[analysis.LayoutIndex] {
 			// User-highlighted layout gets yellow color
-			fmt.Printf("\033[38;2;249;226;175m%s\033[0m\n", FormatBigramAnalysis(analysis))
-		} else {
-			fmt.Println(FormatBigramAnalysis(analysis))
+			rowColor = "\033[38;2;249;226;175m"
 		}
+		fmt.Println(FormatBigramAnalysisWithThresholds(analysis, opts, rowColor))
 	}
 
 	return nil
@@ -1011,6 +1393,18 @@ func (ch *CommandHandler) CommandHighlight(args string) error {
 	return nil
 }
 
+// historyEntry запись истории изменений за сессию
+type historyEntry struct {
+	Time    time.Time
+	Command string
+}
+
+// historyCommands команды, которые записываются в историю изменений
+var historyCommands = map[string]bool{
+	"sw": true, "inv": true, "set": true, "tune": true, "n": true, "d": true, "s": true, "sort": true,
+	"edit": true, "bic": true, "balance": true, "baseline": true,
+}
+
 // ParseCommand парсит и выполняет команду
 func (ch *CommandHandler) ParseCommand(cmd string) error {
 	cmd = strings.TrimSpace(cmd)
@@ -1026,6 +1420,19 @@ func (ch *CommandHandler) ParseCommand(cmd string) error {
 		args = parts[1]
 	}
 
+	if err := ch.executeCommand(command, args); err != nil {
+		return err
+	}
+
+	// Успешно выполненные команды, изменяющие раскладки или коэффициенты, записываются в историю
+	if historyCommands[command] {
+		ch.history = append(ch.history, historyEntry{Time: time.Now(), Command: cmd})
+	}
+	return nil
+}
+
+// executeCommand выполняет команду с разобранными аргументами
+func (ch *CommandHandler) executeCommand(command, args string) error {
 	switch command {
 	case "p":
 		return ch.CommandList(args)
@@ -1033,6 +1440,12 @@ func (ch *CommandHandler) ParseCommand(cmd string) error {
 		return ch.CommandInfo(args)
 	case "r":
 		return ch.CommandReload(ch.langFile, ch.configFile, ch.layoutFile)
+	case "rr":
+		return ch.CommandSoftReload()
+	case "watch":
+		return ch.CommandWatch(args)
+	case "md":
+		return ch.CommandMarkdown(args)
 	case "lb":
 		return ch.CommandBigrams(args)
 	case "ll":
@@ -1041,18 +1454,48 @@ func (ch *CommandHandler) ParseCommand(cmd string) error {
 		return ch.CommandCoefficients(args)
 	case "set":
 		return ch.CommandSetCoefficient(args)
+	case "set?":
+		return ch.CommandSetCoefficientPreview(args)
 	case "s":
 		return ch.CommandSave(args)
 	case "sort":
 		return ch.CommandSort(args)
+	case "reseat":
+		return ch.CommandReseat(args)
+	case "verify":
+		return ch.CommandVerify(args)
+	case "append":
+		return ch.CommandAppend(args)
 	case "g":
 		return ch.CommandAnalyze(args)
 	case "gg":
 		return ch.CommandContinuousAnalyze(args)
+	case "beat":
+		return ch.CommandBeat(args)
+	case "selftest":
+		return ch.CommandSelfTest(args)
+	case "gf":
+		return ch.CommandFingerLockedAnalyze(args)
+	case "gh":
+		return ch.CommandHalfLockedAnalyze(args)
+	case "ghr":
+		return ch.CommandHomeRowLockedAnalyze(args)
 	case "inv":
 		return ch.CommandInvert(args)
+	case "orient":
+		return ch.CommandOrient(args)
+	case "moved":
+		return ch.CommandMoved(args)
 	case "sw":
 		return ch.CommandSwapLetters(args)
+	case "sw?":
+		return ch.CommandSwapPreview(args)
+	case "closeto":
+		return ch.CommandCloseTo(args)
+	case "place":
+		return ch.CommandPlace(args)
+	case "edit":
+		return ch.CommandEdit(args)
 	case "d":
 		return ch.CommandDelete(args)
 	case "n":
@@ -1065,6 +1508,70 @@ func (ch *CommandHandler) ParseCommand(cmd string) error {
 		return ch.CommandDetailedInfo(args)
 	case "b":
 		return ch.CommandBigramLetter(args)
+	case "freq":
+		return ch.CommandFreqOverlay(args)
+	case "langinfo":
+		return ch.CommandLanguageInfo(args)
+	case "ematrix":
+		return ch.CommandEffortMatrix(args)
+	case "precision":
+		return ch.CommandPrecision(args)
+	case "percent":
+		return ch.CommandPercentDisplay(args)
+	case "coverage":
+		return ch.CommandCoverage(args)
+	case "topbigrams":
+		return ch.CommandTopBigrams(args)
+	case "variants":
+		return ch.CommandVariants(args)
+	case "sample":
+		return ch.CommandSample(args)
+	case "greedy":
+		return ch.CommandGreedy(args)
+	case "dist":
+		return ch.CommandDistance(args)
+	case "bic":
+		return ch.CommandBigramCoeffs(args)
+	case "multilang":
+		return ch.CommandMultiLang(args)
+	case "cfgdiff":
+		return ch.CommandConfigDiff(args)
+	case "columns":
+		return ch.CommandColumns(args)
+	case "rel":
+		return ch.CommandRelative(args)
+	case "keymap":
+		return ch.CommandKeymap(args)
+	case "scoreb":
+		return ch.CommandScoreBreakdown(args)
+	case "why":
+		return ch.CommandWhy(args)
+	case "comfort":
+		return ch.CommandComfort(args)
+	case "tune":
+		return ch.CommandTune(args)
+	case "balance":
+		return ch.CommandBalance(args)
+	case "baseline":
+		return ch.CommandBaseline(args)
+	case "chars":
+		return ch.CommandCharEfforts(args)
+	case "vc":
+		return ch.CommandVowelBalance(args)
+	case "fingerbalance":
+		return ch.CommandFingerBalance(args)
+	case "exclude":
+		return ch.CommandExclude(args)
+	case "include":
+		return ch.CommandInclude(args)
+	case "threshold":
+		return ch.CommandThreshold(args)
+	case "history":
+		return ch.CommandHistory(args)
+	case "buf":
+		return ch.CommandBuffer(args)
+	case "session":
+		return ch.CommandSession(args)
 	case "help":
 		printHelp()
 	case "exit", "quit", "q":
@@ -1076,18 +1583,74 @@ func (ch *CommandHandler) ParseCommand(cmd string) error {
 	return nil
 }
 
-// CommandAnalyze выполняет поиск оптимальной раскладки
-func (ch *CommandHandler) CommandAnalyze(args string) error {
-	// Сброс всех временных раскладок перед началом нового поиска
-	ch.searchResultLayout = nil
-	ch.invertedLayout = nil
-	ch.isInvertedLayoutActive = false
-	ch.bestResults = make([]SimulatedAnnealingResult, 0)
+// splitSearchExpression выделяет из аргументов команды g пользовательскую целевую функцию
+// expr="..." (в кавычках выражение может содержать пробелы) и возвращает остальные аргументы
+func splitSearchExpression(args string) (string, *Expression, error) {
+	start := strings.Index(args, "expr=")
+	if start < 0 {
+		return args, nil, nil
+	}
+	if start > 0 && !unicode.IsSpace(rune(args[start-1])) {
+		return args, nil, nil
+	}
 
-	// Parse arguments
-	var layoutNumber int = 0  // Layout number to start from (0 for random search)
-	var shouldUseRandomLayout bool = false  // Flag for random search
-	numBest := 1
+	source := args[start+len("expr="):]
+	end := len(args)
+	if strings.HasPrefix(source, "\"") {
+		closing := strings.Index(source[1:], "\"")
+		if closing < 0 {
+			return "", nil, fmt.Errorf("не закрыта кавычка в expr=")
+		}
+		end = start + len("expr=") + closing + 2
+		source = source[1 : closing+1]
+	} else if space := strings.IndexFunc(source, unicode.IsSpace); space >= 0 {
+		end = start + len("expr=") + space
+		source = source[:space]
+	}
+
+	rest := args[:start] + args[end:]
+	if strings.Contains(rest, "expr=") {
+		return "", nil, fmt.Errorf("целевая функция expr= указана несколько раз")
+	}
+	expression, err := ParseExpression(source)
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка в expr=: %w", err)
+	}
+	return rest, expression, nil
+}
+
+// splitSearchTimeout выделяет из аргументов команды g ограничение времени поиска
+// в формате длительности Go (например, 10s или 2m) и возвращает остальные аргументы
+func splitSearchTimeout(args string) (string, time.Duration, error) {
+	var rest []string
+	var timeout time.Duration
+	for _, part := range strings.Fields(args) {
+		if _, err := strconv.Atoi(part); err == nil {
+			rest = append(rest, part)
+			continue
+		}
+		duration, err := time.ParseDuration(part)
+		if err != nil {
+			rest = append(rest, part)
+			continue
+		}
+		if timeout > 0 {
+			return "", 0, fmt.Errorf("ограничение времени поиска указано несколько раз")
+		}
+		if duration <= 0 {
+			return "", 0, fmt.Errorf("некорректное ограничение времени поиска: %s", part)
+		}
+		timeout = duration
+	}
+	return strings.Join(rest, " "), timeout, nil
+}
+
+// parseSearchArgs разбирает аргументы команд поиска g и gg: [N] [количество результатов] [файл].
+// Число больше количества раскладок считается количеством результатов для поиска от случайной раскладки,
+// нечисловой аргумент - именем файла для сохранения найденных раскладок
+func (ch *CommandHandler) parseSearchArgs(args string) (layoutNumber int, numBest int, shouldUseRandomLayout bool, fileName string, err error) {
+	layoutNumber = 0  // Layout number to start from (0 for random search)
+	numBest = 1
 
 	if strings.TrimSpace(args) != "" {
 		parts := strings.Fields(strings.TrimSpace(args))
@@ -1105,11 +1668,60 @@ func (ch *CommandHandler) CommandAnalyze(args string) error {
 					shouldUseRandomLayout = true
 				}
 			} else {
-				// Invalid number provided
-				return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+				// If it's not a number, treat as file name for random search
+				fileName = parts[0]
+				shouldUseRandomLayout = true
+				layoutNumber = 0 // Indicates random search
+				numBest = 1
 			}
 		} else if len(parts) == 2 {
-			// Both layout number and number of best specified
+			// Could be layout number + number of best, or layout number + filename, or number of best + filename
+			num1, err1 := strconv.Atoi(parts[0])
+			num2, err2 := strconv.Atoi(parts[1])
+
+			if err1 == nil && err2 == nil && num1 > 0 && num2 > 0 {
+				// Both are numbers: layout number + number of best
+				layoutNum := num1
+				num := num2
+				// Check if layout number is valid
+				if layoutNum <= len(ch.layouts.Layouts) {
+					layoutNumber = layoutNum
+					numBest = num
+				} else {
+					return 0, 0, false, "", fmt.Errorf("номер раскладки %d вне диапазона", layoutNum)
+				}
+			} else if err1 == nil && num1 > 0 && num2 <= 0 {
+				// First is a number (layout number), second is not a valid positive number
+				// So second should be treated as filename
+				layoutNum := num1
+				if layoutNum <= len(ch.layouts.Layouts) {
+					layoutNumber = layoutNum
+					fileName = parts[1]
+					numBest = 1
+				} else {
+					return 0, 0, false, "", fmt.Errorf("номер раскладки %d вне диапазона", layoutNum)
+				}
+			} else if err1 == nil && num1 > len(ch.layouts.Layouts) && err2 != nil {
+				// First number exceeds layout count and second is not a number
+				// So first is number of results for random search, second is filename
+				layoutNumber = 0 // Indicates random search
+				numBest = num1
+				shouldUseRandomLayout = true
+				fileName = parts[1]
+			} else {
+				// First is not a number, so it's filename and second is number of results
+				fileName = parts[0]
+				num, err := strconv.Atoi(parts[1])
+				if err == nil && num > 0 {
+					layoutNumber = 0 // Indicates random search
+					numBest = num
+					shouldUseRandomLayout = true
+				} else {
+					return 0, 0, false, "", fmt.Errorf("некорректный формат параметров")
+				}
+			}
+		} else if len(parts) == 3 {
+			// layout number + number of best + filename
 			layoutNum, err1 := strconv.Atoi(parts[0])
 			num, err2 := strconv.Atoi(parts[1])
 			if err1 == nil && err2 == nil && layoutNum > 0 && num > 0 {
@@ -1117,14 +1729,15 @@ func (ch *CommandHandler) CommandAnalyze(args string) error {
 				if layoutNum <= len(ch.layouts.Layouts) {
 					layoutNumber = layoutNum
 					numBest = num
+					fileName = parts[2]
 				} else {
-					return fmt.Errorf("номер раскладки %d вне диапазона", layoutNum)
+					return 0, 0, false, "", fmt.Errorf("номер раскладки %d вне диапазона", layoutNum)
 				}
 			} else {
-				return fmt.Errorf("некорректный формат параметров")
+				return 0, 0, false, "", fmt.Errorf("некорректный формат параметров")
 			}
 		} else {
-			return fmt.Errorf("некорректное количество параметров")
+			return 0, 0, false, "", fmt.Errorf("некорректное количество параметров")
 		}
 	} else {
 		// No arguments - random search
@@ -1133,44 +1746,250 @@ func (ch *CommandHandler) CommandAnalyze(args string) error {
 		numBest = 1
 	}
 
-	// Determine the appropriate output message based on whether we're using a random layout or a specific one
-	var results []SimulatedAnnealingResult
-	params := DefaultSAParams()
+	return layoutNumber, numBest, shouldUseRandomLayout, fileName, nil
+}
 
-	if shouldUseRandomLayout {
-		fmt.Printf("Поиск оптимальной раскладки (Simulated Annealing) - исходная раскладка [случайная], выведет %d лучших результатов\n", numBest)
-		// Use random layout search instead of existing layout, using only characters from existing layouts
-		results = SearchOptimalLayoutFromRandomLayout(ch.config, ch.langData, ch.layouts, params, numBest)
-	} else {
-		// Specific layout was provided
-		var startLayout Layout
-		if layoutNumber > 0 && layoutNumber <= len(ch.layouts.Layouts) {
-			// Use the specified layout as the starting layout
-			startLayout = ch.layouts.Layouts[layoutNumber-1] // Convert to 0-based index
-		} else {
-			// Fallback: use the layout with lowest weighted score if not found
-			bestIndex := 0
-			bestScore := math.MaxFloat64
-			for i, layout := range ch.layouts.Layouts {
-				analysis := AnalyzeLayout(&layout, ch.config, ch.langData)
-				if analysis.WeightedScore < bestScore {
-					bestScore = analysis.WeightedScore
-					bestIndex = i
-				}
-			}
-			startLayout = ch.layouts.Layouts[bestIndex]
+// printRandomCharacterPool выводит набор символов, из которых строится случайная раскладка,
+// и предупреждает о символах языка, которых нет ни в одной загруженной раскладке
+func (ch *CommandHandler) printRandomCharacterPool() {
+	letters := RandomLayoutCharacters(ch.layouts, ch.langData)
+	fmt.Printf("Символы для случайной раскладки (%d): %s\n", len(letters), strings.Join(letters, ""))
+
+	inPool := make(map[string]bool, len(letters))
+	for _, char := range letters {
+		inPool[char] = true
+	}
+	var missing []string
+	for char := range ch.langData.Characters {
+		if !inPool[char] {
+			missing = append(missing, char)
 		}
-		fmt.Printf("Поиск оптимальной раскладки (Simulated Annealing) - исходная раскладка [%d], выведет %d лучших результатов\n", layoutNumber, numBest)
-		// Use the starting layout for the search
-		results = SearchOptimalLayoutFromSpecificLayout(ch.config, ch.langData, ch.layouts, params, numBest, startLayout)
+	}
+	if len(missing) == 0 {
+		return
 	}
 
-	// Check if any of the found layouts match existing layouts
-	newLayoutFound := false
-	for _, result := range results {
-		isExisting := false
-		for _, existingLayout := range ch.layouts.Layouts {
-			if result.Layout.Equals(&existingLayout) {
+	// Самые частые отсутствующие символы выводим первыми
+	sort.Slice(missing, func(i, j int) bool {
+		fi, fj := ch.langData.Characters[missing[i]], ch.langData.Characters[missing[j]]
+		if fi != fj {
+			return fi > fj
+		}
+		return missing[i] < missing[j]
+	})
+	parts := make([]string, len(missing))
+	for i, char := range missing {
+		parts[i] = fmt.Sprintf("%s (%.2f%%)", char, ch.langData.Characters[char]*100)
+	}
+	fmt.Printf("Предупреждение: символы языка отсутствуют в загруженных раскладках и не будут размещены: %s\n", strings.Join(parts, ", "))
+}
+
+// CommandAnalyze выполняет поиск оптимальной раскладки
+func (ch *CommandHandler) CommandAnalyze(args string) error {
+	// Сброс всех временных раскладок перед началом нового поиска
+	ch.searchResultLayout = nil
+	ch.invertedLayout = nil
+	ch.isInvertedLayoutActive = false
+	ch.bestResults = make([]SimulatedAnnealingResult, 0)
+
+	args, objective, err := splitSearchExpression(args)
+	if err != nil {
+		return err
+	}
+	args, timeout, err := splitSearchTimeout(args)
+	if err != nil {
+		return err
+	}
+
+	layoutNumber, numBest, shouldUseRandomLayout, fileName, err := ch.parseSearchArgs(args)
+	if err != nil {
+		return err
+	}
+
+	params := ch.searchParams()
+	if timeout > 0 {
+		params.Deadline = time.Now().Add(timeout)
+		fmt.Printf("Ограничение времени поиска: %s\n", timeout)
+	}
+	if objective != nil {
+		params.Objective = objective
+		fmt.Printf("Целевая функция поиска: %s (вместо общей оценки Score)\n", objective.Source)
+	}
+	results := ch.runSearch(layoutNumber, numBest, shouldUseRandomLayout, params)
+	if objective != nil && objective.NonFinite() > 0 {
+		fmt.Printf("Предупреждение: выражение %s не определено (деление на ноль, корень из отрицательного числа) при оценке раскладок: %d, такие раскладки получили значение +Inf\n",
+			objective.Source, objective.NonFinite())
+	}
+
+	// Дописываем найденные раскладки в файл, если он указан
+	if fileName != "" {
+		for _, result := range results {
+			if err := ch.saveLayoutToFile(result.Layout, fileName); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Найденные раскладки (%d) добавлены в файл %s\n", len(results), fileName)
+	}
+
+	return ch.showSearchResults(results)
+}
+
+// CommandBeat выполняет поиск оптимальной раскладки, как g, но оставляет только результаты,
+// которые лучше k-й лучшей из загруженных раскладок. Если таких нет, буфер [0] не меняется
+func (ch *CommandHandler) CommandBeat(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 1 {
+		return fmt.Errorf("используйте: beat k [N] [file] [T] (k - место среди загруженных раскладок, которое нужно превзойти)")
+	}
+	k, err := strconv.Atoi(parts[0])
+	if err != nil || k < 1 || k > len(ch.layouts.Layouts) {
+		return fmt.Errorf("некорректное место k: %s (допустимо от 1 до %d)", parts[0], len(ch.layouts.Layouts))
+	}
+
+	searchArgs, timeout, err := splitSearchTimeout(strings.Join(parts[1:], " "))
+	if err != nil {
+		return err
+	}
+	layoutNumber, numBest, shouldUseRandomLayout, fileName, err := ch.parseSearchArgs(searchArgs)
+	if err != nil {
+		return err
+	}
+
+	// Оценка k-й лучшей загруженной раскладки вычисляется один раз до поиска
+	scores := make([]float64, len(ch.layouts.Layouts))
+	for i := range ch.layouts.Layouts {
+		scores[i] = AnalyzeLayout(&ch.layouts.Layouts[i], ch.config, ch.langData).WeightedScore
+	}
+	sort.Float64s(scores)
+	bar := scores[k-1]
+	fmt.Printf("Порог: оценка %d-й лучшей загруженной раскладки %.2f\n", k, bar)
+
+	params := ch.searchParams()
+	if timeout > 0 {
+		params.Deadline = time.Now().Add(timeout)
+		fmt.Printf("Ограничение времени поиска: %s\n", timeout)
+	}
+	results := ch.runSearch(layoutNumber, numBest, shouldUseRandomLayout, params)
+
+	// Отбрасываем раскладки не лучше порога и уже имеющиеся в файле
+	var competitive []SimulatedAnnealingResult
+	for _, result := range results {
+		if result.Score >= bar-scoreTieTolerance {
+			continue
+		}
+		isExisting := false
+		for i := range ch.layouts.Layouts {
+			if result.Layout.Equals(&ch.layouts.Layouts[i]) {
+				isExisting = true
+				break
+			}
+		}
+		if !isExisting {
+			competitive = append(competitive, result)
+		}
+	}
+
+	if len(competitive) == 0 {
+		fmt.Printf("Раскладок лучше %d-й лучшей загруженной (%.2f) не найдено, отброшено результатов: %d\n", k, bar, len(results))
+		return nil
+	}
+	fmt.Printf("Найдено раскладок лучше %d-й лучшей загруженной (%.2f): %d из %d\n", k, bar, len(competitive), len(results))
+
+	// Дописываем найденные раскладки в файл, если он указан
+	if fileName != "" {
+		for _, result := range competitive {
+			if err := ch.saveLayoutToFile(result.Layout, fileName); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Найденные раскладки (%d) добавлены в файл %s\n", len(competitive), fileName)
+	}
+
+	ch.invertedLayout = nil
+	ch.isInvertedLayoutActive = false
+	return ch.showSearchResults(competitive)
+}
+
+// searchParams возвращает параметры поиска по умолчанию с параметрами рестартов с возмущением из конфигурации
+func (ch *CommandHandler) searchParams() SimulatedAnnealingParams {
+	params := DefaultSAParams()
+	params.PerturbationSwaps = ch.config.PerturbationSwaps
+	params.PerturbationFraction = ch.config.PerturbationFraction
+	return params
+}
+
+// runSearch выполняет поиск оптимальной раскладки от случайной раскладки или от раскладки
+// layoutNumber (если она не найдена, от лучшей из загруженных) для команд g и beat
+func (ch *CommandHandler) runSearch(layoutNumber, numBest int, shouldUseRandomLayout bool, params SimulatedAnnealingParams) []SimulatedAnnealingResult {
+	// Ctrl+C прерывает только поиск, загруженные и измененные раскладки сохраняются
+	interrupt, restoreSignals := interruptOnSignal()
+	defer restoreSignals()
+	params.Interrupt = interrupt
+
+	// Determine the appropriate output message based on whether we're using a random layout or a specific one
+	var results []SimulatedAnnealingResult
+	if shouldUseRandomLayout {
+		fmt.Printf("Поиск оптимальной раскладки (Simulated Annealing) - исходная раскладка [случайная], выведет %d лучших результатов\n", numBest)
+		ch.printRandomCharacterPool()
+		// Use random layout search instead of existing layout, using only characters from existing layouts
+		results = SearchOptimalLayoutFromRandomLayout(ch.config, ch.langData, ch.layouts, params, numBest)
+	} else {
+		// Specific layout was provided
+		var startLayout Layout
+		if layoutNumber > 0 && layoutNumber <= len(ch.layouts.Layouts) {
+			// Use the specified layout as the starting layout
+			startLayout = ch.layouts.Layouts[layoutNumber-1] // Convert to 0-based index
+		} else {
+			// Fallback: use the layout with lowest weighted score if not found
+			bestIndex := 0
+			bestScore := math.MaxFloat64
+			for i, layout := range ch.layouts.Layouts {
+				analysis := AnalyzeLayout(&layout, ch.config, ch.langData)
+				if analysis.WeightedScore < bestScore {
+					bestScore = analysis.WeightedScore
+					bestIndex = i
+				}
+			}
+			startLayout = ch.layouts.Layouts[bestIndex]
+		}
+		fmt.Printf("Поиск оптимальной раскладки (Simulated Annealing) - исходная раскладка [%d], выведет %d лучших результатов\n", layoutNumber, numBest)
+		// Use the starting layout for the search
+		results = SearchOptimalLayoutFromSpecificLayout(ch.config, ch.langData, ch.layouts, params, numBest, startLayout)
+	}
+	return results
+}
+
+// interruptOnSignal перехватывает SIGINT (Ctrl+C) и возвращает канал, который закрывается при
+// получении сигнала, и функцию, восстанавливающую обработку сигнала по умолчанию
+func interruptOnSignal() (<-chan struct{}, func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	interrupt := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			fmt.Println("\x1b[38;2;215;100;100m\nПолучен сигнал остановки поиска (Ctrl+C), будут выведены лучшие найденные раскладки...\n\x1b[0m")
+			close(interrupt)
+		case <-finished:
+		}
+	}()
+
+	return interrupt, func() {
+		signal.Stop(signals)
+		close(finished)
+	}
+}
+
+// showSearchResults сохраняет результаты поиска в буфер [0] и выводит их
+func (ch *CommandHandler) showSearchResults(results []SimulatedAnnealingResult) error {
+	// Check if any of the found layouts match existing layouts
+	newLayoutFound := false
+	for _, result := range results {
+		isExisting := false
+		for _, existingLayout := range ch.layouts.Layouts {
+			if result.Layout.Equals(&existingLayout) {
 				isExisting = true
 				break
 			}
@@ -1224,7 +2043,7 @@ func (ch *CommandHandler) CommandAnalyze(args string) error {
 		// Выводим строки раскладки с цветным форматированием
 		for row := 0; row < 3; row++ {
 			for col := 0; col < 10; col++ {
-				if col == 5 {
+				if col == ch.config.SplitCol {
 					fmt.Print(" ")
 				}
 
@@ -1265,159 +2084,234 @@ func (ch *CommandHandler) CommandAnalyze(args string) error {
 	return nil
 }
 
-// CommandContinuousAnalyze выполняет непрерывный поиск оптимальных раскладок
-func (ch *CommandHandler) CommandContinuousAnalyze(args string) error {
+// CommandFingerLockedAnalyze выполняет поиск оптимальной раскладки, в котором буквы
+// переставляются только между клавишами одного пальца
+func (ch *CommandHandler) CommandFingerLockedAnalyze(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 1 || len(parts) > 2 {
+		return fmt.Errorf("используйте: gf N [M] (N - номер базовой раскладки, M - количество результатов)")
+	}
+
+	layoutNumber, err := strconv.Atoi(parts[0])
+	if err != nil || layoutNumber < 1 || layoutNumber > len(ch.layouts.Layouts) {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+
+	numBest := 1
+	if len(parts) == 2 {
+		numBest, err = strconv.Atoi(parts[1])
+		if err != nil || numBest < 1 {
+			return fmt.Errorf("некорректное количество результатов: %s", parts[1])
+		}
+	}
+
 	// Сброс всех временных раскладок перед началом нового поиска
 	ch.searchResultLayout = nil
 	ch.invertedLayout = nil
 	ch.isInvertedLayoutActive = false
+	ch.bestResults = make([]SimulatedAnnealingResult, 0)
 
-	// Parse arguments
-	var layoutNumber int = 0  // Layout number to start from (0 for random search)
-	var shouldUseRandomLayout bool = false  // Flag for random search
-	var fileName string = ""  // Optional file name to save results
-	numBest := 1
+	fmt.Printf("Поиск оптимальной раскладки с закреплением букв за пальцами - исходная раскладка [%d], выведет %d лучших результатов\n", layoutNumber, numBest)
+	startLayout := ch.layouts.Layouts[layoutNumber-1]
+	results := SearchOptimalLayoutFingerLocked(ch.config, ch.langData, ch.searchParams(), numBest, startLayout)
 
-	if strings.TrimSpace(args) != "" {
-		parts := strings.Fields(strings.TrimSpace(args))
-		if len(parts) == 1 {
-			num, err := strconv.Atoi(parts[0])
-			if err == nil && num > 0 {
-				// If number is valid and within range of available layouts, treat as layout number
-				if num <= len(ch.layouts.Layouts) {
-					layoutNumber = num
-					numBest = 1 // Default to 1 result when only layout number is specified
-				} else {
-					// If number exceeds number of layouts, treat as number of results for random search
-					layoutNumber = 0 // Indicates random search
-					numBest = num
-					shouldUseRandomLayout = true
-				}
-			} else {
-				// If it's not a number, treat as file name for random search
-				fileName = parts[0]
-				shouldUseRandomLayout = true
-				layoutNumber = 0 // Indicates random search
-				numBest = 1
-			}
-		} else if len(parts) == 2 {
-			// Could be layout number + number of best, or layout number + filename, or number of best + filename
-			num1, err1 := strconv.Atoi(parts[0])
-			num2, err2 := strconv.Atoi(parts[1])
+	return ch.showSearchResults(results)
+}
 
-			if err1 == nil && err2 == nil && num1 > 0 && num2 > 0 {
-				// Both are numbers: layout number + number of best
-				layoutNum := num1
-				num := num2
-				// Check if layout number is valid
-				if layoutNum <= len(ch.layouts.Layouts) {
-					layoutNumber = layoutNum
-					numBest = num
-				} else {
-					return fmt.Errorf("номер раскладки %d вне диапазона", layoutNum)
-				}
-			} else if err1 == nil && num1 > 0 && num2 <= 0 {
-				// First is a number (layout number), second is not a valid positive number
-				// So second should be treated as filename
-				layoutNum := num1
-				if layoutNum <= len(ch.layouts.Layouts) {
-					layoutNumber = layoutNum
-					fileName = parts[1]
-					numBest = 1
-				} else {
-					return fmt.Errorf("номер раскладки %d вне диапазона", layoutNum)
-				}
-			} else if err1 == nil && num1 > len(ch.layouts.Layouts) && err2 != nil {
-				// First number exceeds layout count and second is not a number
-				// So first is number of results for random search, second is filename
-				layoutNumber = 0 // Indicates random search
-				numBest = num1
-				shouldUseRandomLayout = true
-				fileName = parts[1]
-			} else {
-				// First is not a number, so it's filename and second is number of results
-				fileName = parts[0]
-				num, err := strconv.Atoi(parts[1])
-				if err == nil && num > 0 {
-					layoutNumber = 0 // Indicates random search
-					numBest = num
-					shouldUseRandomLayout = true
-				} else {
-					return fmt.Errorf("некорректный формат параметров")
-				}
-			}
-		} else if len(parts) == 3 {
-			// layout number + number of best + filename
-			layoutNum, err1 := strconv.Atoi(parts[0])
-			num, err2 := strconv.Atoi(parts[1])
-			if err1 == nil && err2 == nil && layoutNum > 0 && num > 0 {
-				// Check if layout number is valid
-				if layoutNum <= len(ch.layouts.Layouts) {
-					layoutNumber = layoutNum
-					numBest = num
-					fileName = parts[2]
-				} else {
-					return fmt.Errorf("номер раскладки %d вне диапазона", layoutNum)
-				}
-			} else {
-				return fmt.Errorf("некорректный формат параметров")
-			}
-		} else {
-			return fmt.Errorf("некорректное количество параметров")
-		}
-	} else {
-		// No arguments - random search
-		shouldUseRandomLayout = true
-		layoutNumber = 0 // Indicates random search
-		numBest = 1
+// CommandHalfLockedAnalyze выполняет поиск оптимальной раскладки, в котором клавиши
+// переставляются только внутри левой или правой половины, вторая половина остается без изменений
+func (ch *CommandHandler) CommandHalfLockedAnalyze(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 1 || len(parts) > 3 {
+		return fmt.Errorf("используйте: gh left|right [N] [M] (N - номер базовой раскладки, M - количество результатов)")
 	}
 
-	// Determine the appropriate output message based on whether we're using a random layout or a specific one
-	params := DefaultSAParams()
-
-	// Initialize best results
-	bestResults := make([]SimulatedAnnealingResult, 0, numBest)
-
-	fmt.Printf("Непрерывный поиск оптимальной раскладки (Simulated Annealing) - начат\n")
-	fmt.Println("\x1b[38;2;215;100;100m\nДля остановки поиска нажмите клавишу Q или Esc.\n\x1b[0m")
-	fmt.Printf("Параметры: исходная раскладка %s, количество результатов: %d\n",
-		func() string {
-			if shouldUseRandomLayout {
-				return "[случайная]"
-			} else {
-				return fmt.Sprintf("[%d]", layoutNumber)
-			}
-		}(), numBest)
+	var half int
+	var halfName string
+	switch parts[0] {
+	case "left":
+		half, halfName = HalfLeft, "левая (колонки 1-5)"
+	case "right":
+		half, halfName = HalfRight, "правая (колонки 6-10)"
+	default:
+		return fmt.Errorf("некорректная половина: %s (используйте left или right)", parts[0])
+	}
 
-	// Открываем клавиатуру в неблокирующем режиме
-	if err := keyboard.Open(); err != nil {
-		panic(err)
+	layoutNumber := 1
+	if len(parts) >= 2 {
+		var err error
+		layoutNumber, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("некорректный номер раскладки: %s", parts[1])
+		}
+	}
+	layout, ok := ch.getLayoutByIndex(layoutNumber)
+	if !ok {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
 	}
-	defer keyboard.Close()
 
-	// Канал для сигнала завершения
-	done := make(chan struct{})
+	numBest := 1
+	if len(parts) == 3 {
+		var err error
+		numBest, err = strconv.Atoi(parts[2])
+		if err != nil || numBest < 1 {
+			return fmt.Errorf("некорректное количество результатов: %s", parts[2])
+		}
+	}
 
-	// Горутина: ждём нажатие 'q' или Esc
-	go func() {
-		for {
-			char, key, err := keyboard.GetKey()
-			if err != nil {
-				panic(err)
-			}
+	// Копируем исходную раскладку до сброса временных раскладок, она может быть раскладкой [0]
+	startLayout := *layout
 
-			if key == 3 || char == 3 || char == 'q' || char == 'Q' || key == keyboard.KeyEsc {
-				fmt.Println("\x1b[38;2;215;100;100m\nПолучен сигнал остановки поиска. Дождитесь завершения итерации...\n\x1b[0m")
-				close(done)
-				return
-			}
+	// Сброс всех временных раскладок перед началом нового поиска
+	ch.searchResultLayout = nil
+	ch.invertedLayout = nil
+	ch.isInvertedLayoutActive = false
+	ch.bestResults = make([]SimulatedAnnealingResult, 0)
+
+	fmt.Printf("Поиск оптимальной раскладки внутри половины - исходная раскладка [%d], выведет %d лучших результатов\n", layoutNumber, numBest)
+	fmt.Printf("Оптимизируется %s половина, вторая половина и фиксированные позиции не меняются\n", halfName)
+	results := SearchOptimalLayoutHalfLocked(ch.config, ch.langData, ch.searchParams(), numBest, startLayout, half)
+
+	return ch.showSearchResults(results)
+}
+
+// CommandHomeRowLockedAnalyze выполняет поиск оптимальной раскладки от раскладки N,
+// сохраняя ее средний (домашний) ряд: переставляются только клавиши верхнего и нижнего рядов
+func (ch *CommandHandler) CommandHomeRowLockedAnalyze(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 1 || len(parts) > 2 {
+		return fmt.Errorf("используйте: ghr N [M] (N - номер базовой раскладки, M - количество результатов)")
+	}
+
+	layoutNumber, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+	layout, ok := ch.getLayoutByIndex(layoutNumber)
+	if !ok {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+
+	numBest := 1
+	if len(parts) == 2 {
+		numBest, err = strconv.Atoi(parts[1])
+		if err != nil || numBest < 1 {
+			return fmt.Errorf("некорректное количество результатов: %s", parts[1])
 		}
-	}()
+	}
+
+	// Копируем исходную раскладку до сброса временных раскладок, она может быть раскладкой [0]
+	startLayout := *layout
+
+	// Сброс всех временных раскладок перед началом нового поиска
+	ch.searchResultLayout = nil
+	ch.invertedLayout = nil
+	ch.isInvertedLayoutActive = false
+	ch.bestResults = make([]SimulatedAnnealingResult, 0)
+
+	var homeKeys []string
+	for _, key := range startLayout.Keys[1] {
+		if key != "" {
+			homeKeys = append(homeKeys, key)
+		}
+	}
+
+	fmt.Printf("Поиск оптимальной раскладки с закрепленным средним рядом - исходная раскладка [%d], выведет %d лучших результатов\n", layoutNumber, numBest)
+	fmt.Printf("Закреплены клавиши среднего ряда: %s\n", strings.Join(homeKeys, " "))
+	fmt.Println("Переставляются только клавиши верхнего и нижнего рядов, фиксированные позиции не меняются")
+	results := SearchOptimalLayoutHomeRowLocked(ch.config, ch.langData, ch.searchParams(), numBest, startLayout)
+
+	return ch.showSearchResults(results)
+}
+
+// ggFallbackIterations количество итераций непрерывного поиска, если клавиатуру
+// для остановки поиска открыть не удалось
+const ggFallbackIterations = 10
+
+// CommandContinuousAnalyze выполняет непрерывный поиск оптимальных раскладок
+func (ch *CommandHandler) CommandContinuousAnalyze(args string) error {
+	// Сброс всех временных раскладок перед началом нового поиска
+	ch.searchResultLayout = nil
+	ch.invertedLayout = nil
+	ch.isInvertedLayoutActive = false
+
+	// Минимальное улучшение оценки из конфигурации можно переопределить аргументом min=
+	minImprovement := ch.config.GGMinImprovement
+	var searchArgs []string
+	for _, part := range strings.Fields(args) {
+		if strings.HasPrefix(part, "min=") {
+			value, err := strconv.ParseFloat(strings.TrimPrefix(part, "min="), 64)
+			if err != nil || value < 0 {
+				return fmt.Errorf("некорректное минимальное улучшение: %s", strings.TrimPrefix(part, "min="))
+			}
+			minImprovement = value
+			continue
+		}
+		searchArgs = append(searchArgs, part)
+	}
+
+	layoutNumber, numBest, shouldUseRandomLayout, fileName, err := ch.parseSearchArgs(strings.Join(searchArgs, " "))
+	if err != nil {
+		return err
+	}
+
+	// Determine the appropriate output message based on whether we're using a random layout or a specific one
+	params := ch.searchParams()
+
+	// Initialize best results
+	bestResults := make([]SimulatedAnnealingResult, 0, numBest)
+
+	fmt.Printf("Непрерывный поиск оптимальной раскладки (Simulated Annealing) - начат\n")
+	fmt.Println("\x1b[38;2;215;100;100m\nДля остановки поиска нажмите клавишу Q или Esc.\n\x1b[0m")
+	fmt.Printf("Параметры: исходная раскладка %s, количество результатов: %d\n",
+		func() string {
+			if shouldUseRandomLayout {
+				return "[случайная]"
+			} else {
+				return fmt.Sprintf("[%d]", layoutNumber)
+			}
+		}(), numBest)
+	fmt.Printf("Минимальное улучшение оценки для новой лучшей раскладки: %g\n", minImprovement)
+
+	// Канал для сигнала завершения
+	done := make(chan struct{})
+	// Ошибка чтения клавиатуры, остановившая поиск (записывается до закрытия done)
+	var keyErr error
+	// Количество итераций без клавиатуры (0 - до нажатия клавиши остановки)
+	maxIterations := 0
+
+	// Открываем клавиатуру в неблокирующем режиме. Если терминал недоступен,
+	// выполняем фиксированное количество итераций без возможности остановки
+	if err := keyboard.Open(); err != nil {
+		fmt.Printf("Предупреждение: не удалось открыть клавиатуру (%v), будет выполнено итераций: %d\n", err, ggFallbackIterations)
+		maxIterations = ggFallbackIterations
+	} else {
+		defer keyboard.Close()
+
+		// Горутина: ждём нажатие 'q' или Esc
+		go func() {
+			for {
+				char, key, err := keyboard.GetKey()
+				if err != nil {
+					keyErr = err
+					fmt.Printf("\x1b[38;2;215;100;100m\nОшибка чтения клавиатуры: %v. Поиск будет остановлен после завершения итерации...\n\x1b[0m\n", err)
+					close(done)
+					return
+				}
+
+				if key == 3 || char == 3 || char == 'q' || char == 'Q' || key == keyboard.KeyEsc {
+					fmt.Println("\x1b[38;2;215;100;100m\nПолучен сигнал остановки поиска. Дождитесь завершения итерации...\n\x1b[0m")
+					close(done)
+					return
+				}
+			}
+		}()
+	}
 
 	iteration := 0
 	stopRequested := false
 
-	for !stopRequested {
+	for !stopRequested && (maxIterations == 0 || iteration < maxIterations) {
 		iteration++
 		fmt.Printf("\n--- Итерация %d ---\n", iteration)
 
@@ -1472,7 +2366,7 @@ func (ch *CommandHandler) CommandContinuousAnalyze(args string) error {
 				isBetterThanBest = true
 			} else {
 				// Compare with the best result in current bestResults
-				isBetterThanBest = result.Score < bestResults[0].Score
+				isBetterThanBest = result.Score < bestResults[0].Score-minImprovement
 			}
 
 			if !isExisting && isBetterThanBest {
@@ -1513,7 +2407,7 @@ func (ch *CommandHandler) CommandContinuousAnalyze(args string) error {
 			// Выводим строки раскладки с цветным форматированием
 			for row := 0; row < 3; row++ {
 				for col := 0; col < 10; col++ {
-					if col == 5 {
+					if col == ch.config.SplitCol {
 						fmt.Print(" ")
 					}
 
@@ -1606,7 +2500,7 @@ func (ch *CommandHandler) CommandContinuousAnalyze(args string) error {
 			// Выводим строки раскладки с цветным форматированием
 			for row := 0; row < 3; row++ {
 				for col := 0; col < 10; col++ {
-					if col == 5 {
+					if col == ch.config.SplitCol {
 						fmt.Print(" ")
 					}
 
@@ -1647,11 +2541,45 @@ func (ch *CommandHandler) CommandContinuousAnalyze(args string) error {
 		fmt.Println("Не найдено ни одной раскладки.")
 	}
 
+	if keyErr != nil {
+		return fmt.Errorf("поиск остановлен из-за ошибки чтения клавиатуры: %w", keyErr)
+	}
 	return nil
 }
 
+// mirrorLayout возвращает зеркальную копию раскладки относительно центра между половинками:
+// колонка i становится колонкой (9-i), так что половинки меняются руками с отражением
+func mirrorLayout(layout *Layout, name string) Layout {
+	mirrored := Layout{Name: name}
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			mirrored.Keys[row][col] = layout.Keys[row][9-col]
+		}
+	}
+	return mirrored
+}
+
+// swapHandsLayout возвращает копию раскладки, в которой половинки поменялись местами без отражения:
+// колонка i становится колонкой (i+5) mod 10
+func swapHandsLayout(layout *Layout, name string) Layout {
+	swapped := Layout{Name: name}
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			swapped.Keys[row][col] = layout.Keys[row][(col+5)%10]
+		}
+	}
+	return swapped
+}
+
 // CommandInvert выводит указанную раскладку в инвертированном виде (зеркально относительно центра)
 func (ch *CommandHandler) CommandInvert(args string) error {
+	// Последний аргумент stats дополнительно выводит анализ исходной и инвертированной раскладок
+	showStats := false
+	if fields := strings.Fields(args); len(fields) > 0 && fields[len(fields)-1] == "stats" {
+		showStats = true
+		args = strings.Join(fields[:len(fields)-1], " ")
+	}
+
 	// Если аргументы не указаны, проверяем есть ли результаты поиска
 	if strings.TrimSpace(args) == "" {
 		// Проверяем наличие результатов поиска
@@ -1664,18 +2592,7 @@ func (ch *CommandHandler) CommandInvert(args string) error {
 		layout := ch.bestResults[0].Layout
 
 		// Создаем инвертированную (зеркальную) копию раскладки
-		invertedLayout := Layout{
-			Name: layout.Name + " (inv)",
-			Keys: [3][10]string{},
-		}
-
-		// Зеркально отражаем раскладку по вертикали (относительно центра между раскладками)
-		// Это означает отражение по горизонтали: колонка i становится колонкой (9-i)
-		for row := 0; row < 3; row++ {
-			for col := 0; col < 10; col++ {
-				invertedLayout.Keys[row][col] = layout.Keys[row][9-col]
-			}
-		}
+		invertedLayout := mirrorLayout(&layout, layout.Name+" (inv)")
 
 		fmt.Printf("\n%s\n", invertedLayout.Name)
 		fmt.Println(strings.Repeat("-", len(invertedLayout.Name)))
@@ -1704,7 +2621,7 @@ func (ch *CommandHandler) CommandInvert(args string) error {
 		// Выводим строки раскладки с цветным форматированием
 		for row := 0; row < 3; row++ {
 			for col := 0; col < 10; col++ {
-				if col == 5 {
+				if col == ch.config.SplitCol {
 					fmt.Print(" ")
 				}
 
@@ -1737,6 +2654,9 @@ func (ch *CommandHandler) CommandInvert(args string) error {
 			fmt.Println()
 		}
 		fmt.Println()
+		if showStats {
+			ch.printInvertStats(&layout, &invertedLayout, 0)
+		}
 		return nil
 	}
 
@@ -1775,18 +2695,7 @@ func (ch *CommandHandler) CommandInvert(args string) error {
 					}
 
 					// Создаем инвертированную (зеркальную) копию раскладки
-					invertedLayout := Layout{
-						Name: layoutToInvert.Name + " (inverted again)",
-						Keys: [3][10]string{},
-					}
-
-					// Зеркально отражаем раскладку по вертикали (относительно центра между раскладками)
-					// Это означает отражение по горизонтали: колонка i становится колонкой (9-i)
-					for row := 0; row < 3; row++ {
-						for col := 0; col < 10; col++ {
-							invertedLayout.Keys[row][col] = layoutToInvert.Keys[row][9-col]
-						}
-					}
+					invertedLayout := mirrorLayout(layoutToInvert, layoutToInvert.Name+" (inverted again)")
 
 					fmt.Printf("\n%s\n", invertedLayout.Name)
 					fmt.Println(strings.Repeat("-", len(invertedLayout.Name)))
@@ -1812,7 +2721,7 @@ func (ch *CommandHandler) CommandInvert(args string) error {
 					// Выводим строки раскладки с цветным форматированием
 					for row := 0; row < 3; row++ {
 						for col := 0; col < 10; col++ {
-							if col == 5 {
+							if col == ch.config.SplitCol {
 								fmt.Print(" ")
 							}
 
@@ -1845,6 +2754,9 @@ func (ch *CommandHandler) CommandInvert(args string) error {
 						fmt.Println()
 					}
 					fmt.Println()
+					if showStats {
+						ch.printInvertStats(layoutToInvert, &invertedLayout, 0)
+					}
 					return nil
 				} else if num > 0 {
 					if num <= len(ch.layouts.Layouts) {
@@ -1864,18 +2776,7 @@ func (ch *CommandHandler) CommandInvert(args string) error {
 		layout := ch.layouts.Layouts[idx]
 
 		// Создаем инвертированную (зеркальную) копию раскладки
-		invertedLayout := Layout{
-			Name: layout.Name + " (inv)",
-			Keys: [3][10]string{},
-		}
-
-		// Зеркально отражаем раскладку по вертикали (относительно центра между раскладками)
-		// Это означает отражение по горизонтали: колонка i становится колонкой (9-i)
-		for row := 0; row < 3; row++ {
-			for col := 0; col < 10; col++ {
-				invertedLayout.Keys[row][col] = layout.Keys[row][9-col]
-			}
-		}
+		invertedLayout := mirrorLayout(&layout, layout.Name+" (inv)")
 
 		fmt.Printf("\n%s\n", invertedLayout.Name)
 		fmt.Println(strings.Repeat("-", len(invertedLayout.Name)))
@@ -1903,7 +2804,7 @@ func (ch *CommandHandler) CommandInvert(args string) error {
 		// Выводим строки раскладки с цветным форматированием
 		for row := 0; row < 3; row++ {
 			for col := 0; col < 10; col++ {
-				if col == 5 {
+				if col == ch.config.SplitCol {
 					fmt.Print(" ")
 				}
 
@@ -1936,11 +2837,292 @@ func (ch *CommandHandler) CommandInvert(args string) error {
 			fmt.Println()
 		}
 		fmt.Println()
+		if showStats {
+			ch.printInvertStats(&layout, &invertedLayout, idx+1)
+		}
+	}
+
+	return nil
+}
+
+// printInvertStats выводит таблицы анализа исходной и инвертированной раскладок одну под другой.
+// При зеркальном отражении руки меняются местами, поэтому меняются HDI и показатели по рукам
+func (ch *CommandHandler) printInvertStats(layout, invertedLayout *Layout, layoutIndex int) {
+	analyses := []*LayoutAnalysis{
+		AnalyzeLayout(layout, ch.config, ch.langData),
+		AnalyzeLayout(invertedLayout, ch.config, ch.langData),
+	}
+	analyses[0].LayoutIndex = layoutIndex
+	for _, analysis := range analyses {
+		if len([]rune(analysis.LayoutName)) > 16 {
+			analysis.LayoutName = string([]rune(analysis.LayoutName)[:16])
+		}
+	}
+
+	fmt.Println(FormatAnalysisHeader(ch.config))
+	for _, analysis := range analyses {
+		fmt.Println(FormatAnalysis(analysis))
+	}
+	fmt.Println()
+	fmt.Println(FormatBigramAnalysisHeader(ch.config))
+	for _, analysis := range analyses {
+		fmt.Println(FormatBigramAnalysis(analysis))
+	}
+	fmt.Println()
+}
+
+// CommandOrient сравнивает раскладку с ее зеркальной копией, а с аргументом swap также с вариантами,
+// в которых половинки поменялись местами, и сохраняет лучшую ориентацию во временную раскладку [0]
+func (ch *CommandHandler) CommandOrient(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 1 || len(parts) > 2 || (len(parts) == 2 && parts[1] != "swap") {
+		return fmt.Errorf("используйте: orient N [swap] (номер раскладки, swap - добавить варианты с переставленными половинками)")
+	}
+	layoutNumber, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNumber)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+
+	variants := []Layout{*layout, mirrorLayout(layout, layout.Name+" (inv)")}
+	if len(parts) == 2 {
+		swapped := swapHandsLayout(layout, layout.Name+" (swap)")
+		variants = append(variants, swapped, mirrorLayout(&swapped, layout.Name+" (swap inv)"))
+	}
+
+	analyses := make([]*LayoutAnalysis, len(variants))
+	best := 0
+	for i := range variants {
+		analyses[i] = AnalyzeLayout(&variants[i], ch.config, ch.langData)
+		if len([]rune(analyses[i].LayoutName)) > 16 {
+			analyses[i].LayoutName = string([]rune(analyses[i].LayoutName)[:16])
+		}
+		// Другая ориентация выбирается только при строго лучшей оценке, при равенстве остается исходная
+		if analyses[i].WeightedScore < analyses[best].WeightedScore-scoreTieTolerance {
+			best = i
+		}
+	}
+	analyses[0].LayoutIndex = layoutNumber
+
+	fmt.Println(FormatAnalysisHeader(ch.config))
+	for _, analysis := range analyses {
+		fmt.Println(FormatAnalysis(analysis))
+	}
+	fmt.Println()
+
+	if best == 0 {
+		fmt.Printf("Лучшая ориентация - исходная раскладка [%d] (%.2f)\n", layoutNumber, analyses[0].WeightedScore)
+		return nil
+	}
+
+	bestLayout := variants[best]
+	ch.searchResultLayout = &bestLayout
+	ch.invertedLayout = nil
+	ch.isInvertedLayoutActive = false
+
+	fmt.Printf("Лучшая ориентация - %s (%.2f против %.2f у исходной раскладки)\n", bestLayout.Name, analyses[best].WeightedScore, analyses[0].WeightedScore)
+	fmt.Println("Раскладка записана во временную раскладку [0], для сохранения используйте команду s")
+	ch.printColoredLayout(&bestLayout)
+
+	return nil
+}
+
+// CommandBuffer управляет слотами для временного хранения раскладок в памяти, чтобы
+// результат поиска или инверсии в буфере [0] не терялся при следующей операции:
+// buf [list], buf save N [имя], buf load N, buf show N, buf del N
+func (ch *CommandHandler) CommandBuffer(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) == 0 || (len(parts) == 1 && parts[0] == "list") {
+		ch.printBufferSlots()
+		return nil
+	}
+
+	usage := fmt.Errorf("используйте: buf [list] | buf save N [имя] | buf load N | buf show N | buf del N (N - номер слота от 1)")
+	if len(parts) < 2 || (parts[0] != "save" && len(parts) != 2) {
+		return usage
+	}
+	slot, err := strconv.Atoi(parts[1])
+	if err != nil || slot < 1 {
+		return fmt.Errorf("некорректный номер слота: %s", parts[1])
+	}
+
+	switch parts[0] {
+	case "save":
+		buffer, exists := ch.getLayoutByIndex(0)
+		if !exists {
+			return fmt.Errorf("временная раскладка [0] отсутствует")
+		}
+		saved := copyLayout(buffer)
+		saved.Name = strings.TrimPrefix(saved.Name, "[0] ")
+		if len(parts) > 2 {
+			saved.Name = strings.Join(parts[2:], " ")
+		}
+		if _, exists := ch.bufferSlots[slot]; exists {
+			fmt.Printf("Слот %d перезаписан\n", slot)
+		}
+		ch.bufferSlots[slot] = saved
+		fmt.Printf("Раскладка [0] скопирована в слот %d под именем '%s'\n", slot, saved.Name)
+
+	case "load":
+		saved, exists := ch.bufferSlots[slot]
+		if !exists {
+			return fmt.Errorf("слот %d пуст", slot)
+		}
+		loaded := copyLayout(&saved)
+		ch.searchResultLayout = &loaded
+		ch.invertedLayout = nil
+		ch.isInvertedLayoutActive = false
+		fmt.Printf("Раскладка '%s' из слота %d загружена во временную раскладку [0]\n", saved.Name, slot)
+
+	case "show":
+		saved, exists := ch.bufferSlots[slot]
+		if !exists {
+			return fmt.Errorf("слот %d пуст", slot)
+		}
+		fmt.Printf("Слот %d: %s\n", slot, saved.Name)
+		ch.printColoredLayout(&saved)
+		fmt.Printf("Score: %.2f\n", AnalyzeLayout(&saved, ch.config, ch.langData).WeightedScore)
+
+	case "del":
+		if _, exists := ch.bufferSlots[slot]; !exists {
+			return fmt.Errorf("слот %d пуст", slot)
+		}
+		delete(ch.bufferSlots, slot)
+		fmt.Printf("Слот %d очищен\n", slot)
+
+	default:
+		return usage
+	}
+	return nil
+}
+
+// printBufferSlots выводит занятые слоты с оценками раскладок при текущей конфигурации
+func (ch *CommandHandler) printBufferSlots() {
+	if len(ch.bufferSlots) == 0 {
+		fmt.Println("Слоты пусты, для копирования временной раскладки [0] используйте buf save N")
+		return
+	}
+
+	slots := make([]int, 0, len(ch.bufferSlots))
+	for slot := range ch.bufferSlots {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+
+	fmt.Printf("%-5s %-16s %7s\n", "Слот", "Layout", "Score")
+	fmt.Println(strings.Repeat("-", 30))
+	for _, slot := range slots {
+		layout := ch.bufferSlots[slot]
+		score := AnalyzeLayout(&layout, ch.config, ch.langData).WeightedScore
+		fmt.Printf("%-5s %-16s %7.2f\n", fmt.Sprintf("[%d]", slot), layout.Name, score)
+	}
+}
+
+// copyLayout возвращает копию раскладки, не разделяющую с исходной цифровой ряд
+func copyLayout(layout *Layout) Layout {
+	copied := *layout
+	if layout.NumberRow != nil {
+		copied.NumberRow = append([]string(nil), layout.NumberRow...)
+	}
+	return copied
+}
+
+// Цвета клавиш временной раскладки [0] в команде moved
+const (
+	movedColorSame  = "\033[38;2;150;150;150m" // Клавиша осталась на месте
+	movedColorMoved = "\033[38;2;249;226;175m" // Клавиша есть в исходной раскладке, но на другой позиции
+	movedColorNew   = "\033[38;2;158;206;88m"  // Клавиши нет в исходной раскладке
+)
+
+// CommandMoved выводит раскладку N рядом с временной раскладкой [0], выделяя цветом клавиши [0]:
+// оставшиеся на месте, перемещенные и отсутствующие в раскладке N
+func (ch *CommandHandler) CommandMoved(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 1 {
+		return fmt.Errorf("используйте: moved N (номер раскладки для сравнения с временной раскладкой [0])")
+	}
+	layoutNumber, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+	base, exists := ch.getLayoutByIndex(layoutNumber)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+	current, exists := ch.getLayoutByIndex(0)
+	if !exists {
+		return fmt.Errorf("нет временной раскладки [0] для сравнения")
+	}
+
+	baseKeys := make(map[string]bool)
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			baseKeys[strings.ToLower(base.Keys[row][col])] = true
+		}
+	}
+
+	fmt.Printf("[%d] %s -> [0]\n", layoutNumber, base.Name)
+	same, moved, added := 0, 0, 0
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			if col == ch.config.SplitCol {
+				fmt.Print(" ")
+			}
+			fmt.Printf("%s ", base.Keys[row][col])
+		}
+		fmt.Print("   ")
+		for col := 0; col < 10; col++ {
+			if col == ch.config.SplitCol {
+				fmt.Print(" ")
+			}
+			key := current.Keys[row][col]
+			color := movedColorSame
+			switch {
+			case strings.ToLower(key) == strings.ToLower(base.Keys[row][col]):
+				same++
+			case baseKeys[strings.ToLower(key)]:
+				color = movedColorMoved
+				moved++
+			default:
+				color = movedColorNew
+				added++
+			}
+			fmt.Printf("%s%s\033[0m ", color, key)
+		}
+		fmt.Println()
 	}
+	fmt.Printf("\nНа месте: %d, %sперемещено: %d\033[0m, %sновых: %d\033[0m\n", same, movedColorMoved, moved, movedColorNew, added)
 
 	return nil
 }
 
+// setWrittenLayouts обновляет список раскладок в памяти после перезаписи файла командами
+// sort, d и n. Файл языка и конфигурация при этом не менялись, поэтому повторно не
+// читаются. Комментарии при перезаписи не сохраняются, кроме директивы разделителя клавиш
+func (ch *CommandHandler) setWrittenLayouts(layouts []Layout) {
+	written := make([]Layout, len(layouts))
+	for i, layout := range layouts {
+		layout.PreComments = nil
+		layout.PostComments = nil
+		written[i] = layout
+	}
+
+	header := []string{}
+	if directive := layoutSeparatorHeader(ch.layouts.Separator); directive != "" {
+		header = append(header, strings.TrimSuffix(directive, "\n"))
+	}
+
+	ch.layouts = &ParsedLayouts{
+		Layouts:            written,
+		FileHeaderComments: header,
+		Separator:          ch.layouts.Separator,
+	}
+	ch.analyses = nil
+}
+
 // CommandSave сохраняет указанную раскладку в конец файла раскладок
 func (ch *CommandHandler) CommandSave(args string) error {
 	var layoutToSave Layout
@@ -1986,68 +3168,49 @@ func (ch *CommandHandler) CommandSave(args string) error {
 		}
 	}
 
-	// Открываем файл для добавления
-	file, err := os.OpenFile(ch.outputFile, os.O_APPEND|os.O_WRONLY, 0644)
+	// Не дописываем раскладку, если такое же расположение клавиш уже есть в файле
+	existingLayouts, err := LoadLayoutsOrEmpty(ch.outputFile)
 	if err != nil {
-		return fmt.Errorf("ошибка открытия файла %s для добавления: %v", ch.outputFile, err)
+		return err
 	}
-	defer file.Close()
-
-	// Записываем пустую строку-разделитель перед новой раскладкой
-	// Проверяем, чтобы новые раскладки отделялись от уже имеющихся в файле пустой строкой
-	if _, err := file.WriteString("\n"); err != nil {
-		return fmt.Errorf("ошибка записи разделителя: %v", err)
+	for i := range existingLayouts.Layouts {
+		if existingLayouts.Layouts[i].SameKeys(&layoutToSave, ch.config.CaseSensitive) {
+			fmt.Printf("Раскладка с таким же расположением клавиш уже есть в файле %s: [%d] %s, сохранение пропущено\n",
+				ch.outputFile, i+1, existingLayouts.Layouts[i].Name)
+			return nil
+		}
 	}
 
-	// Записываем имя раскладки
-	if _, err := file.WriteString(fmt.Sprintf("%s\n", layoutToSave.Name)); err != nil {
-		return fmt.Errorf("ошибка записи имени раскладки: %v", err)
+	// Дописываем раскладку к текущему содержимому файла
+	existingContent, err := os.ReadFile(ch.outputFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ошибка чтения файла %s: %v", ch.outputFile, err)
 	}
+	var content strings.Builder
+	content.Write(existingContent)
 
-	// Записываем строки раскладки
-	for row := 0; row < 3; row++ {
-		line := ""
-		for col := 0; col < 10; col++ {
-			if col > 0 {
-				line += " "
-			}
-			line += layoutToSave.Keys[row][col]
-			// Добавляем дополнительный пробел между половинками (между 5 и 6 столбцом)
-			if col == 4 {
-				line += " "
-			}
-		}
-		if _, err := file.WriteString(line + "\n"); err != nil {
-			return fmt.Errorf("ошибка записи строки раскладки: %v", err)
-		}
+	// Новые раскладки отделяются от уже имеющихся в файле пустой строкой
+	content.WriteString("\n")
+	content.WriteString(layoutToSave.Name + "\n")
+	for _, keys := range layoutFileRows(layoutToSave) {
+		content.WriteString(formatLayoutRow(keys, ch.layouts.Separator) + "\n")
 	}
 
-	// Перезагружаем файл раскладок, чтобы обновить внутреннее состояние
-	newLangData, newConfig, newParsedLayouts, err := LoadAllData(ch.langFile, ch.configFile, ch.outputFile)
-	if err != nil {
-		return fmt.Errorf("ошибка перезагрузки данных: %v", err)
+	// Комментарии сохраняемой раскладки в файл не записываются
+	layoutToSave.PreComments = nil
+	layoutToSave.PostComments = nil
+	writtenLayouts := append(existingLayouts.Layouts, layoutToSave)
+
+	// Файл заменяется только после того, как записанные раскладки прочитаны без искажений
+	if err := ReplaceLayoutsFile(ch.outputFile, content.String(), writtenLayouts); err != nil {
+		return err
 	}
 
-	// Применяем измененные веса к новой конфигурации
-	ch.configTracker.ApplyToConfig(newConfig)
-
-	// Если был указан отдельный файл с усилиями, загружаем матрицу усилий из него
-	if ch.effortFile != "" && ch.effortFile != ch.configFile {
-		effortMatrix, err := LoadEffortMatrix(ch.effortFile)
-		if err != nil {
-			fmt.Printf("Предупреждение: не удалось загрузить матрицу усилий из файла %s: %v\n", ch.effortFile, err)
-		} else {
-			// Обновляем матрицу усилий в конфигурации
-			newConfig.EffortMatrix = effortMatrix
-		}
-	}
-
-	ch.langData = newLangData
-	ch.config = newConfig
-	ch.layouts = newParsedLayouts
-
-	// Обновляем базовую конфигурацию в трекере, но сохраняем информацию об изменённых параметрах
-	ch.configTracker.UpdateBaseConfig(newConfig.Weights)
+	// Файл раскладок после дописывания совпадает с прочитанным ранее содержимым и новой
+	// раскладкой, поэтому обновляем список в памяти без повторного чтения файлов
+	existingLayouts.Layouts = writtenLayouts
+	ch.layouts = existingLayouts
+	ch.analyses = nil
 
 	// После сохранения раскладки, очищаем все временные раскладки, так как они больше не актуальны
 	ch.searchResultLayout = nil
@@ -2064,6 +3227,13 @@ func (ch *CommandHandler) CommandSort(args string) error {
 	if strings.TrimSpace(args) != "" {
 		return fmt.Errorf("команда sort не принимает аргументов")
 	}
+	for _, file := range splitLayoutFiles(ch.layoutFile) {
+		if file == ch.outputFile {
+			if err := ch.requireSingleLayoutFile("sort"); err != nil {
+				return err
+			}
+		}
+	}
 
 	// Анализируем все раскладки, чтобы получить их оценки
 	type ScoredLayout struct {
@@ -2086,83 +3256,175 @@ func (ch *CommandHandler) CommandSort(args string) error {
 		return scoredLayouts[i].Score < scoredLayouts[j].Score
 	})
 
-	// Перезаписываем файл с раскладками в отсортированном порядке
-	file, err := os.Create(ch.outputFile)
-	if err != nil {
-		return fmt.Errorf("ошибка создания файла %s: %v", ch.outputFile, err)
-	}
-	defer file.Close()
-
+	// Перезаписываем файл с раскладками в отсортированном порядке.
+	// Комментарии заголовка при перезаписи не сохраняются, поэтому директиву разделителя клавиш записываем отдельно
+	var content strings.Builder
+	content.WriteString(layoutSeparatorHeader(ch.layouts.Separator))
+	sortedLayouts := make([]Layout, len(scoredLayouts))
 	for i, scoredLayout := range scoredLayouts {
-		// Записываем имя раскладки
+		// Добавляем пустую строку-разделитель между раскладками (кроме первой)
 		if i > 0 {
-			// Добавляем пустую строку-разделитель между раскладками (кроме первой)
-			if _, err := file.WriteString("\n"); err != nil {
-				return fmt.Errorf("ошибка записи разделителя: %v", err)
-			}
+			content.WriteString("\n")
 		}
 
 		// Используем оригинальное имя раскладки
-		if _, err := file.WriteString(fmt.Sprintf("%s\n", scoredLayout.Layout.Name)); err != nil {
-			return fmt.Errorf("ошибка записи имени раскладки: %v", err)
+		content.WriteString(scoredLayout.Layout.Name + "\n")
+		for _, keys := range layoutFileRows(scoredLayout.Layout) {
+			content.WriteString(formatLayoutRow(keys, ch.layouts.Separator) + "\n")
 		}
+		sortedLayouts[i] = scoredLayout.Layout
+	}
 
-		// Записываем строки раскладки
+	// Файл заменяется только после того, как отсортированные раскладки прочитаны без искажений
+	if err := ReplaceLayoutsFile(ch.outputFile, content.String(), sortedLayouts); err != nil {
+		return err
+	}
+
+	// Обновляем список раскладок в памяти в том виде, в котором он записан в файл
+	ch.setWrittenLayouts(sortedLayouts)
+
+	// После сортировки файла очищаем временный результат поиска [0],
+	// так как нумерация всех раскладок изменилась
+	ch.searchResultLayout = nil
+	// Also reset the inverted layout active flag if it was active
+	if ch.isInvertedLayoutActive {
+		ch.isInvertedLayoutActive = false
+	}
+	ch.bestResults = make([]SimulatedAnnealingResult, 0)
+
+	fmt.Printf("Раскладки успешно отсортированы по возрастанию общей оценки (всего: %d)\n", len(scoredLayouts))
+	return nil
+}
+
+// CommandReseat нормализует файл с раскладками: удаляет пустые раскладки, задает имена
+// безымянным раскладкам и перезаписывает файл с единообразным форматированием
+func (ch *CommandHandler) CommandReseat(args string) error {
+	if strings.TrimSpace(args) != "" {
+		return fmt.Errorf("команда reseat не принимает аргументов")
+	}
+	if err := ch.requireSingleLayoutFile("reseat"); err != nil {
+		return err
+	}
+
+	parsedLayouts, err := LoadLayouts(ch.layoutFile)
+	if err != nil {
+		return err
+	}
+
+	var layouts []Layout
+	removed := 0
+	renamed := 0
+
+	for _, layout := range parsedLayouts.Layouts {
+		// Пропускаем раскладки без единой клавиши
+		isEmpty := true
 		for row := 0; row < 3; row++ {
-			line := ""
 			for col := 0; col < 10; col++ {
-				if col > 0 {
-					line += " "
+				if layout.Keys[row][col] != "" {
+					isEmpty = false
 				}
-				line += scoredLayout.Layout.Keys[row][col]
-				// Добавляем дополнительный пробел между половинками (между 5 и 6 столбцом)
-				if col == 4 {
-					line += " "
-				}
-			}
-			if _, err := file.WriteString(line + "\n"); err != nil {
-				return fmt.Errorf("ошибка записи строки раскладки: %v", err)
 			}
 		}
+		if isEmpty {
+			removed++
+			continue
+		}
+
+		name := strings.TrimSpace(layout.Name)
+		if name == "" {
+			name = fmt.Sprintf("layout %d", len(layouts)+1)
+		}
+		if name != layout.Name {
+			layout.Name = name
+			renamed++
+		}
+
+		layouts = append(layouts, layout)
+	}
+
+	if len(layouts) == 0 {
+		return fmt.Errorf("в файле %s не осталось ни одной раскладки", ch.layoutFile)
+	}
+
+	parsedLayouts.Layouts = layouts
+	if err := WriteLayoutsToFile(parsedLayouts, ch.layoutFile); err != nil {
+		return err
+	}
+
+	ch.layouts = parsedLayouts
+	ch.analyses = nil
+
+	// Нумерация раскладок могла измениться, поэтому очищаем выделения и буфер [0]
+	ch.highlightedLayouts = make(map[int]bool)
+	ch.searchResultLayout = nil
+	ch.isInvertedLayoutActive = false
+	ch.bestResults = make([]SimulatedAnnealingResult, 0)
+
+	fmt.Printf("Файл %s нормализован: раскладок %d, удалено пустых %d, переименовано %d\n",
+		ch.layoutFile, len(layouts), removed, renamed)
+	return nil
+}
+
+// CommandVerify проверяет, что активная раскладка [0] и загруженные раскладки записываются
+// в файл раскладок и читаются из него обратно без изменений
+func (ch *CommandHandler) CommandVerify(args string) error {
+	if strings.TrimSpace(args) != "" {
+		return fmt.Errorf("команда verify не принимает аргументов")
+	}
+
+	indices := []int{}
+	if active, exists := ch.getLayoutByIndex(0); exists && active != nil {
+		indices = append(indices, 0)
+	}
+	for i := range ch.layouts.Layouts {
+		indices = append(indices, i+1)
+	}
+	if len(indices) == 0 {
+		return fmt.Errorf("нет раскладок для проверки")
 	}
 
-	// Перезагружаем файл раскладок, чтобы обновить внутреннее состояние
-	newLangData, newConfig, newParsedLayouts, err := LoadAllData(ch.langFile, ch.configFile, ch.outputFile)
+	file, err := os.CreateTemp("", "kbda-verify-*.txt")
 	if err != nil {
-		return fmt.Errorf("ошибка перезагрузки данных: %v", err)
+		return fmt.Errorf("ошибка создания временного файла: %v", err)
 	}
+	file.Close()
+	defer os.Remove(file.Name())
 
-	// Применяем измененные веса к новой конфигурации
-	ch.configTracker.ApplyToConfig(newConfig)
+	header := []string{}
+	if directive := layoutSeparatorHeader(ch.layouts.Separator); directive != "" {
+		header = append(header, strings.TrimSuffix(directive, "\n"))
+	}
 
-	// Если был указан отдельный файл с усилиями, загружаем матрицу усилий из него
-	if ch.effortFile != "" && ch.effortFile != ch.configFile {
-		effortMatrix, err := LoadEffortMatrix(ch.effortFile)
+	// Каждую раскладку записываем в отдельный файл, чтобы ошибка в одной раскладке
+	// не сдвигала чтение остальных
+	failed := 0
+	for _, idx := range indices {
+		layout, _ := ch.getLayoutByIndex(idx)
+		written := ParsedLayouts{Layouts: []Layout{*layout}, FileHeaderComments: header, Separator: ch.layouts.Separator}
+		if err := WriteLayoutsToFile(&written, file.Name()); err != nil {
+			return err
+		}
+
+		read, err := LoadLayoutsOrEmpty(file.Name())
 		if err != nil {
-			fmt.Printf("Предупреждение: не удалось загрузить матрицу усилий из файла %s: %v\n", ch.effortFile, err)
+			return fmt.Errorf("ошибка чтения временного файла: %v", err)
+		}
+		diff := ""
+		if len(read.Layouts) != 1 {
+			diff = fmt.Sprintf("читается раскладок: %d", len(read.Layouts))
 		} else {
-			// Обновляем матрицу усилий в конфигурации
-			newConfig.EffortMatrix = effortMatrix
+			diff = layoutRoundTripDiff(layout, &read.Layouts[0])
+		}
+		if diff != "" {
+			fmt.Printf("[%d] %s: %s\n", idx, layout.Name, diff)
+			failed++
 		}
 	}
 
-	ch.langData = newLangData
-	ch.config = newConfig
-	ch.layouts = newParsedLayouts
-
-	// Обновляем базовую конфигурацию в трекере, но сохраняем информацию об изменённых параметрах
-	ch.configTracker.UpdateBaseConfig(newConfig.Weights)
-
-	// После сортировки файла очищаем временный результат поиска [0],
-	// так как нумерация всех раскладок изменилась
-	ch.searchResultLayout = nil
-	// Also reset the inverted layout active flag if it was active
-	if ch.isInvertedLayoutActive {
-		ch.isInvertedLayoutActive = false
+	if failed > 0 {
+		return fmt.Errorf("раскладок, которые после записи в файл читаются иначе: %d из %d", failed, len(indices))
 	}
-	ch.bestResults = make([]SimulatedAnnealingResult, 0)
-
-	fmt.Printf("Раскладки успешно отсортированы по возрастанию общей оценки (всего: %d)\n", len(scoredLayouts))
+	fmt.Printf("Все раскладки (%d) записываются в файл и читаются обратно без изменений\n", len(indices))
 	return nil
 }
 
@@ -2223,6 +3485,13 @@ func (ch *CommandHandler) CommandDelete(args string) error {
 		}
 	}
 
+	// Удаление загруженных раскладок перезаписывает файл раскладок
+	if len(indices) > 1 || !indices[0] {
+		if err := ch.requireSingleLayoutFile("d"); err != nil {
+			return err
+		}
+	}
+
 	// Handle deletion of temporary layout at index 0 (search result or inverted layout)
 	if indices[0] {
 		ch.searchResultLayout = nil
@@ -2240,30 +3509,25 @@ func (ch *CommandHandler) CommandDelete(args string) error {
 	}
 
 	// Write the new layout list to the file
-	file, err := os.Create("layout.txt")
+	file, err := os.Create(ch.layoutFile)
 	if err != nil {
-		return fmt.Errorf("ошибка создания файла layout.txt: %v", err)
+		return fmt.Errorf("ошибка создания файла %s: %v", ch.layoutFile, err)
 	}
 	defer file.Close()
 
+	// Комментарии заголовка при перезаписи не сохраняются, поэтому директиву разделителя клавиш записываем отдельно
+	if _, err := file.WriteString(layoutSeparatorHeader(ch.layouts.Separator)); err != nil {
+		return fmt.Errorf("ошибка записи разделителя клавиш: %v", err)
+	}
+
 	for i, layout := range newLayouts {
 		if _, err := file.WriteString(fmt.Sprintf("%s\n", layout.Name)); err != nil {
 			return fmt.Errorf("ошибка записи имени раскладки: %v", err)
 		}
 
 		// Записываем строки раскладки
-		for row := 0; row < 3; row++ {
-			line := ""
-			for col := 0; col < 10; col++ {
-				if col > 0 {
-					line += " "
-				}
-				line += layout.Keys[row][col]
-				// Добавляем дополнительный пробел между половинками (между 5 и 6 столбцом)
-				if col == 4 {
-					line += " "
-				}
-			}
+		for _, keys := range layoutFileRows(layout) {
+			line := formatLayoutRow(keys, ch.layouts.Separator)
 			if _, err := file.WriteString(line + "\n"); err != nil {
 				return fmt.Errorf("ошибка записи строки раскладки: %v", err)
 			}
@@ -2278,34 +3542,7 @@ func (ch *CommandHandler) CommandDelete(args string) error {
 	}
 
 	// Update the in-memory layout list
-	ch.layouts.Layouts = newLayouts
-
-	// Reload data to update internal state
-	newLangData, newConfig, newParsedLayouts, err := LoadAllData(ch.langFile, ch.configFile, ch.layoutFile)
-	if err != nil {
-		return fmt.Errorf("ошибка перезагрузки данных: %v", err)
-	}
-
-	// Применяем измененные веса к новой конфигурации
-	ch.configTracker.ApplyToConfig(newConfig)
-
-	// Если был указан отдельный файл с усилиями, загружаем матрицу усилий из него
-	if ch.effortFile != "" && ch.effortFile != ch.configFile {
-		effortMatrix, err := LoadEffortMatrix(ch.effortFile)
-		if err != nil {
-			fmt.Printf("Предупреждение: не удалось загрузить матрицу усилий из файла %s: %v\n", ch.effortFile, err)
-		} else {
-			// Обновляем матрицу усилий в конфигурации
-			newConfig.EffortMatrix = effortMatrix
-		}
-	}
-
-	ch.langData = newLangData
-	ch.config = newConfig
-	ch.layouts = newParsedLayouts
-
-	// Обновляем базовую конфигурацию в трекере, но сохраняем информацию об изменённых параметрах
-	ch.configTracker.UpdateBaseConfig(newConfig.Weights)
+	ch.setWrittenLayouts(newLayouts)
 
 	// Print which layouts were deleted
 	fmt.Printf("Успешно удалены раскладки: ")
@@ -2354,35 +3591,33 @@ func (ch *CommandHandler) CommandRename(args string) error {
 		}
 		return nil
 	} else if num > 0 && num <= len(ch.layouts.Layouts) {
+		if err := ch.requireSingleLayoutFile("n"); err != nil {
+			return err
+		}
 		// Update the layout name in memory
 		oldName := ch.layouts.Layouts[num-1].Name
 		ch.layouts.Layouts[num-1].Name = newName
 
 		// Rewrite the whole layout file with updated name
-		file, err := os.Create("layout.txt")
+		file, err := os.Create(ch.layoutFile)
 		if err != nil {
-			return fmt.Errorf("ошибка создания файла layout.txt: %v", err)
+			return fmt.Errorf("ошибка создания файла %s: %v", ch.layoutFile, err)
 		}
 		defer file.Close()
 
+		// Комментарии заголовка при перезаписи не сохраняются, поэтому директиву разделителя клавиш записываем отдельно
+		if _, err := file.WriteString(layoutSeparatorHeader(ch.layouts.Separator)); err != nil {
+			return fmt.Errorf("ошибка записи разделителя клавиш: %v", err)
+		}
+
 		for i, layout := range ch.layouts.Layouts {
 			if _, err := file.WriteString(fmt.Sprintf("%s\n", layout.Name)); err != nil {
 				return fmt.Errorf("ошибка записи имени раскладки: %v", err)
 			}
 
 			// Write layout rows with proper spacing (double space between halves)
-			for row := 0; row < 3; row++ {
-				line := ""
-				for col := 0; col < 10; col++ {
-					if col > 0 {
-						line += " "
-					}
-					line += layout.Keys[row][col]
-					// Add extra space between halves (after the 5th key/column index 4)
-					if col == 4 {
-						line += " "
-					}
-				}
+			for _, keys := range layoutFileRows(layout) {
+				line := formatLayoutRow(keys, ch.layouts.Separator)
 				if _, err := file.WriteString(line + "\n"); err != nil {
 					return fmt.Errorf("ошибка записи строки раскладки: %v", err)
 				}
@@ -2398,21 +3633,8 @@ func (ch *CommandHandler) CommandRename(args string) error {
 
 		fmt.Printf("Раскладка #%d успешно переименована из '%s' в '%s'\n", num, oldName, newName)
 
-		// Reload the layouts to update internal state
-		newLangData, newConfig, newLayouts, err := LoadAllData(ch.langFile, ch.configFile, ch.layoutFile)
-		if err != nil {
-			return fmt.Errorf("ошибка перезагрузки данных: %v", err)
-		}
-
-		// Применяем измененные веса к новой конфигурации
-		ch.configTracker.ApplyToConfig(newConfig)
-
-		ch.langData = newLangData
-		ch.config = newConfig
-		ch.layouts = newLayouts
-
-		// Обновляем базовую конфигурацию в трекере, но сохраняем информацию об изменённых параметрах
-		ch.configTracker.UpdateBaseConfig(newConfig.Weights)
+		// Update the in-memory layout list
+		ch.setWrittenLayouts(ch.layouts.Layouts)
 	} else {
 		return fmt.Errorf("номер раскладки вне диапазона")
 	}
@@ -2447,6 +3669,20 @@ func (ch *CommandHandler) CommandDetailedInfo(args string) error {
 	fmt.Printf("LSB2 = %.2f\n", analysis.BigramAnalysis.LSB2)
 	fmt.Printf("HSB2 = %.2f\n", analysis.BigramAnalysis.HSB2)
 	fmt.Printf("FSB2 = %.2f\n", analysis.BigramAnalysis.FSB2)
+	fmt.Printf("ICS  = %.2f\n", analysis.BigramAnalysis.ICS)
+	fmt.Printf("SymSFB = %.2f\n", analysis.BigramAnalysis.SymSFB)
+	fmt.Printf("RowJump = %.2f\n", analysis.BigramAnalysis.RowJump)
+	fmt.Printf("LSB_all = %.2f\n", analysis.BigramAnalysis.LSBAll)
+	fmt.Printf("Покрытие биграмм = %.2f%%\n", analysis.BigramCoverage)
+	travel := make([]string, 8)
+	for finger, value := range analysis.BigramAnalysis.FingerTravel {
+		travel[finger] = fmt.Sprintf("%.2f", value)
+	}
+	fmt.Printf("Перемещение пальцев F1-F8 = %s\n", strings.Join(travel, " "))
+	fmt.Printf("FTP  = %.2f\n", analysis.FTP)
+	if len(layout.NumberRow) == 10 {
+		fmt.Printf("Цифровой ряд = %.2f%%\n", analysis.NumberRowLoad)
+	}
 
 	// Выводим проверку соотношений
 	fmt.Println() // Пустая строка перед проверкой
@@ -2467,6 +3703,10 @@ func (ch *CommandHandler) CommandDetailedInfo(args string) error {
 	return nil
 }
 
+// bigramCoverageWarnThreshold доля частоты биграмм языка (%), при покрытии ниже которой
+// команда a предупреждает, что показатели биграмм рассчитаны по неполным данным
+const bigramCoverageWarnThreshold = 95.0
+
 // CommandLayoutAnalysis выводит подробный анализ конкретной раскладки
 func (ch *CommandHandler) CommandLayoutAnalysis(args string) error {
 	if strings.TrimSpace(args) == "" {
@@ -2538,7 +3778,7 @@ func (ch *CommandHandler) CommandLayoutAnalysis(args string) error {
 	// Выводим строки раскладки с цветным форматированием
 	for row := 0; row < 3; row++ {
 		for col := 0; col < 10; col++ {
-			if col == 5 {
+			if col == ch.config.SplitCol {
 				fmt.Print(" ")
 			}
 
@@ -2568,27 +3808,37 @@ func (ch *CommandHandler) CommandLayoutAnalysis(args string) error {
 
 			// Display with frequency-based color
 			fmt.Printf("\033[38;2;%d;%d;%dm%s\033[0m ", r, g, b, key)
+			if ch.showFreqOverlay {
+				fmt.Print(" ")
+			}
 		}
 		fmt.Println()
+		if ch.showFreqOverlay {
+			ch.printFreqOverlayRow(layout, row)
+		}
 	}
 
 	// Пустая строка
 	fmt.Println()
 
 	// Выводим строку с информацией по усилиям раскладки (аналогично команде l)
-	fmt.Printf(" %-3s %-16s %5s %5s %5s %5s %5s %5s %5s %5s %6s %5s %5s %6s %5s %5s %4s %5s %7s %7s\n",
-		"№", "Layout", "F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "R1", "R2", "R3", "Left", "Right", "HDI", "FDI", "MEP", "Effort", "Score")
-	fmt.Println(strings.Repeat("-", 134))
+	fmt.Println(FormatAnalysisHeader(ch.config))
 	fmt.Println(FormatAnalysisWithHighlights(analysis))
+	fmt.Printf("Среднее усилие на нажатие (без нормировки): %.3f\n", analysis.RawEffort)
+	if ch.config.Weights.HandBias != 0 {
+		fmt.Printf("Нагрузка по рукам: левая %.1f%%, правая %.1f%% (цель для правой руки %g%%, отклонение HandBias %.1f)\n",
+			analysis.EffortByHalf[0], analysis.EffortByHalf[1], ch.config.HandBiasTarget, HandBiasDeviation(ch.config, analysis))
+	}
 
 	// Пустая строка
 	fmt.Println()
 
 	// Выводим строку с информацией по биграммам (аналогично команде lb)
-	fmt.Printf(" %-3s %-16s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %6s %8s %7s\n",
-		"№", "Layout", "SHB", "SFB", "HVB", "FVB", "HDB", "FDB", "HFB", "HSB", "FSB", "LSB", "SRB", "AFI", "AFO", "TIB", "Total", "Score")
-	fmt.Println(strings.Repeat("-", 136))
+	fmt.Println(FormatBigramAnalysisHeader(ch.config))
 	fmt.Println(FormatBigramAnalysisWithHighlights(analysis))
+	if analysis.BigramCoverage < bigramCoverageWarnThreshold {
+		fmt.Printf("Предупреждение: в анализе учтено только %.1f%% частоты биграмм языка, в раскладке отсутствуют частые символы, показатели биграмм могут завышать качество раскладки\n", analysis.BigramCoverage)
+	}
 
 	// Пустая строка
 	fmt.Println()
@@ -2599,23 +3849,70 @@ func (ch *CommandHandler) CommandLayoutAnalysis(args string) error {
 	// Пустая строка
 	fmt.Println()
 
+	// Выводим распределение SFB по парам рядов
+	ch.printSameFingerRowPairs(layout)
+
+	// Пустая строка
+	fmt.Println()
+
 	// Выводим биграммы по типам
 	ch.printBigramTypeAnalysis(layout, analysis, numRows)
 
 	return nil
 }
 
+// CommandFreqOverlay включает или выключает вывод частот символов под клавишами
+func (ch *CommandHandler) CommandFreqOverlay(args string) error {
+	switch strings.TrimSpace(args) {
+	case "":
+		ch.showFreqOverlay = !ch.showFreqOverlay
+	case "on":
+		ch.showFreqOverlay = true
+	case "off":
+		ch.showFreqOverlay = false
+	default:
+		return fmt.Errorf("используйте: freq [on|off]")
+	}
+
+	if ch.showFreqOverlay {
+		fmt.Println("Вывод частот символов включен")
+	} else {
+		fmt.Println("Вывод частот символов выключен")
+	}
+	return nil
+}
+
+// printFreqOverlayRow выводит строку с частотами символов ряда раскладки в процентах
+func (ch *CommandHandler) printFreqOverlayRow(layout *Layout, row int) {
+	keyPos := buildKeyPositions(layout, ch.config, ch.langData)
+	for col := 0; col < 10; col++ {
+		if col == ch.config.SplitCol {
+			fmt.Print(" ")
+		}
+
+		key := layout.Keys[row][col]
+		freq := 0.0
+		if f, exists := ch.langData.Characters[key]; exists {
+			freq = f
+		} else if lowerKey := strings.ToLower(key); !ch.config.CaseSensitive && keyPos[lowerKey] == [2]int{row, col} {
+			// Заглавная буква (закрепленная позиция) получает частоту строчной, как при анализе
+			freq = ch.langData.Characters[lowerKey]
+		}
+		fmt.Printf("\033[38;2;128;128;128m%-2.0f\033[0m ", freq*100.0)
+	}
+	fmt.Println()
+}
+
 // colorizeBigramByFrequency подсвечивает биграмму в зависимости от её частоты и добавляет нормированную частоту
 func (ch *CommandHandler) colorizeBigramByFrequency(bigram string, freq float64, maxFreq float64, maxFreqInTable float64) string {
 	if maxFreqInTable == 0 {
 		// Если максимальная частота в таблице равна 0, используем серый цвет
 		colored := fmt.Sprintf("\033[38;2;215;215;215m%s\033[0m", bigram)
-		percentage := 0
+		percentage := 0.0
 		if maxFreq > 0 {
-			percentage = int(freq * 100.0 / maxFreq)
+			percentage = freq * 100.0 / maxFreq
 		}
-		percentageStr := fmt.Sprintf("%2d", percentage)
-		return fmt.Sprintf("  %s %s", colored, percentageStr)
+		return fmt.Sprintf("  %s %s", colored, ch.formatBigramPercent(percentage))
 	}
 
 	// Интерполируем цвет от (215,215,215) (серый, минимальная частота) до (215,0,0) (красный, максимальная частота в таблице)
@@ -2630,17 +3927,31 @@ func (ch *CommandHandler) colorizeBigramByFrequency(bigram string, freq float64,
 	colored := fmt.Sprintf("\033[38;2;%d;%d;%dm%s\033[0m", r, g, b, bigram)
 
 	// Вычисляем нормированную частоту как процент от максимальной частоты в языке
-	percentage := 0
+	percentage := 0.0
 	if maxFreq > 0 {
-		percentage = int(freq * 100.0 / maxFreq)
+		percentage = freq * 100.0 / maxFreq
 	}
-	// Ограничиваем значение 99 для максимальной частоты, чтобы сохранить форматирование двузначного числа
-	if percentage > 99 {
-		percentage = 99
-	}
-	percentageStr := fmt.Sprintf("%2d", percentage)
 
-	return fmt.Sprintf("  %s %s", colored, percentageStr)
+	return fmt.Sprintf("  %s %s", colored, ch.formatBigramPercent(percentage))
+}
+
+// Режимы вывода нормированной частоты биграмм в две позиции (percent_display)
+const (
+	percentDisplayClamp = "clamp" // Значения больше 99 выводятся как 99
+	percentDisplayMark  = "mark"  // Значения от 99.5 выводятся как **, чтобы не путать их с 99
+)
+
+// formatBigramPercent форматирует нормированную частоту биграммы (%) в две позиции с учетом
+// режима percent_display, в режиме clamp значения ограничиваются 99
+func (ch *CommandHandler) formatBigramPercent(percent float64) string {
+	if ch.config.PercentDisplay == percentDisplayMark && percent >= 99.5 {
+		return "**"
+	}
+	value := int(percent)
+	if value > 99 {
+		value = 99
+	}
+	return fmt.Sprintf("%2d", value)
 }
 
 // printBigramAnalysisByCriteria выводит n самых частых биграмм по различным критериям
@@ -2673,14 +3984,11 @@ func (ch *CommandHandler) printBigramAnalysisByCriteria(layout *Layout, analysis
 	var halfBigrams [2][]BigramFreq
 
 	for _, bg := range allBigrams {
-		runes := []rune(bg.Bigram)
-		if len(runes) != 2 {
+		char1, char2, ok := splitBigram(bg.Bigram, keyPos)
+		if !ok {
 			continue
 		}
 
-		char1 := string(runes[0])
-		char2 := string(runes[1])
-
 		pos1, exists1 := keyPos[char1]
 		pos2, exists2 := keyPos[char2]
 
@@ -2864,245 +4172,2066 @@ func (ch *CommandHandler) printBigramAnalysisByCriteria(layout *Layout, analysis
 }
 
 
-// printBigramTypeAnalysis выводит n самых частых биграмм по типам
-func (ch *CommandHandler) printBigramTypeAnalysis(layout *Layout, analysis *LayoutAnalysis, numRows int) {
-	// Выводим заголовок для таблицы биграмм по типам
-	fmt.Printf(" %-3s %-16s %s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s\n", "№", "Layout", "  ", "SHB", "SFB", "HVB", "FVB", "HDB", "FDB", "HFB", "HSB", "FSB", "LSB", "SRB", "AFI", "AFO")
-	fmt.Println(strings.Repeat("-", 124))
-
-	// Создаём таблицу позиций буквы -> (row, col)
-	keyPos := make(map[string][2]int)
-	for row := 0; row < 3; row++ {
-		for col := 0; col < 10; col++ {
-			key := layout.Keys[row][col]
-			if key != "" {
-				keyPos[key] = [2]int{row, col}
-			}
-		}
-	}
-
-	// Сортируем все биграммы по частоте
-	var allBigrams []BigramFreq
-	for bigram, freq := range ch.langData.Bigrams {
-		allBigrams = append(allBigrams, BigramFreq{Bigram: bigram, Freq: freq})
-	}
-
-	// Сортируем по убыванию частоты
-	sort.Slice(allBigrams, func(i, j int) bool {
-		return allBigrams[i].Freq > allBigrams[j].Freq
-	})
-
-	// Подготавливаем биграммы для каждого типа
-	var shbBigrams, sfbBigrams, hvbBigrams, fvbBigrams, hdbBigrams, fdbBigrams, hfbBigrams, hsbBigrams, fsbBigrams, lsbBigrams, srbBigrams, afiBigrams, afoBigrams []BigramFreq
+// sameFingerRowPairs рассчитывает SFB с разбивкой по парам рядов: 0 - один ряд, 1 - R1-R2,
+// 2 - R2-R3, 3 - R1-R3, 4 - пары с цифровым рядом. Биграммы отбираются и масштабируются
+// так же, как в calculateBigrams, поэтому сумма столбцов равна SFB в таблице lb
+func sameFingerRowPairs(layout *Layout, config *KeyboardConfig, langData *LanguageData) [5]float64 {
+	keyPos := buildKeyPositions(layout, config, langData)
+	meanEffort := meanKeyEffort(config)
 
-	// Подсчитываем биграммы по типам как в calculateBigrams
-	for _, bg := range allBigrams {
-		runes := []rune(bg.Bigram)
-		if len(runes) != 2 {
+	var rowPairs [5]float64
+	totalBigramFreq := 0.0
+	for bigram, freq := range langData.Bigrams {
+		char1, char2, ok := splitBigram(bigram, keyPos)
+		if !ok {
 			continue
 		}
 
-		char1 := string(runes[0])
-		char2 := string(runes[1])
-
 		pos1, exists1 := keyPos[char1]
 		pos2, exists2 := keyPos[char2]
-
-		if !exists1 || !exists2 {
+		if isSpaceBigram(config, char1, char2, exists1, exists2) || !exists1 || !exists2 {
 			continue
 		}
+		totalBigramFreq += freq
 
-		row1, col1 := pos1[0], pos1[1]
-		row2, col2 := pos2[0], pos2[1]
-
-		// Проверяем половинки (левая/правая)
-		half1 := getHalf(col1)
-		half2 := getHalf(col2)
-
-		// Проверяем пальцы для обоих символов
-		finger1 := getFingerForKey(row1, col1)
-		finger2 := getFingerForKey(row2, col2)
-
-		// Вычисляем расстояния
-		rowDiff := abs(row1 - row2)
-		colDiff := abs(col1 - col2)
-
-		// SHB - Same Hand Bigram (процент биграмм, которые набираются одной рукой)
-		if half1 == half2 {
-			shbBigrams = append(shbBigrams, bg)
+		row1, row2 := physicalRow(pos1[0]), physicalRow(pos2[0])
+		if fingerForKey(config, row1, pos1[1]) != fingerForKey(config, row2, pos2[1]) {
+			continue
 		}
 
-		// SFB - Same Finger Bigrams (процент биграмм, которые набираются одним пальцем)
-		if finger1 == finger2 {
-			sfbBigrams = append(sfbBigrams, bg)
+		penaltyFreq := freq * bigramEffortScale(config, pos1, pos2, meanEffort)
+		switch {
+		case row1 < 0 || row2 < 0:
+			rowPairs[4] += penaltyFreq
+		case row1 == row2:
+			rowPairs[0] += penaltyFreq
+		case row1+row2 == 1:
+			rowPairs[1] += penaltyFreq
+		case row1+row2 == 3:
+			rowPairs[2] += penaltyFreq
+		default:
+			rowPairs[3] += penaltyFreq
 		}
+	}
 
-		// Рассчитываем метрики только если оба символа на одной половинке
-		if half1 == half2 {
-			// HVB - Half Vertical Bigrams (один палец, одна колонка, соседние ряды, исключая колонки 5 и 6)
-			if finger1 == finger2 && col1 == col2 && rowDiff == 1 && col1 != 4 && col1 != 5 {
-				hvbBigrams = append(hvbBigrams, bg)
-			}
-
-			// FVB - Full Vertical Bigrams (один палец, одна колонка, через ряд, исключая колонки 5 и 6)
-			if finger1 == finger2 && col1 == col2 && rowDiff == 2 && col1 != 4 && col1 != 5 {
-				fvbBigrams = append(fvbBigrams, bg)
-			}
-
-			// HDB - Half Diagonal Bigrams (один палец, соседние колонки и соседние ряды)
-			if finger1 == finger2 && rowDiff == 1 && colDiff == 1 {
-				hdbBigrams = append(hdbBigrams, bg)
-			}
-
-			// FDB - Full Diagonal Bigrams (один палец, соседние колонки через ряд)
-			if finger1 == finger2 && rowDiff == 2 && colDiff == 1 {
-				fdbBigrams = append(fdbBigrams, bg)
-			}
-
-			// HFB - Horizontal Finger Bigrams (один палец, один ряд, соседние колонки)
-			if finger1 == finger2 && row1 == row2 && colDiff == 1 {
-				hfbBigrams = append(hfbBigrams, bg)
-			}
-
-			// SRB - Same Row Bigrams (одна рука, один ряд, исключая колонки 5 и 6)
-			if row1 == row2 && col1 != 4 && col1 != 5 && col2 != 4 && col2 != 5 {
-				srbBigrams = append(srbBigrams, bg)
-			}
-
-			// Определяем специфичные пальцы (2, 3, 6, 7 - индексы 1, 2, 5, 6)
-			finger1IsSpecial := (finger1 == 1 || finger1 == 2 || finger1 == 5 || finger1 == 6)
-			finger2IsSpecial := (finger2 == 1 || finger2 == 2 || finger2 == 5 || finger2 == 6)
+	if totalBigramFreq > 0 {
+		for i := range rowPairs {
+			rowPairs[i] = rowPairs[i] / totalBigramFreq * 100.0
+		}
+	}
+	return rowPairs
+}
 
-			// HSB - Half Scissors Bigrams: одна рука, разные пальцы, соседние ряды,
-			// на НИЖНЕМ из двух рядов находятся пальцы 2, 3, 6 или 7, исключая колонки 5 и 6
-			if finger1 != finger2 && rowDiff == 1 && col1 != 4 && col1 != 5 && col2 != 4 && col2 != 5 && half1 == half2 {
-				lowerRow := row1
-				if row2 > row1 {
-					lowerRow = row2
-				} // Нижний (с большим номером) из двух рядов
-				if lowerRow == row1 && finger1IsSpecial {
-					hsbBigrams = append(hsbBigrams, bg)
-				} else if lowerRow == row2 && finger2IsSpecial {
-					hsbBigrams = append(hsbBigrams, bg)
-				}
-			}
+// printSameFingerRowPairs выводит частоту биграмм, набираемых одним пальцем, с разбивкой
+// по парам рядов, чтобы отличать короткие переходы от дальних
+func (ch *CommandHandler) printSameFingerRowPairs(layout *Layout) {
+	rowPairs := sameFingerRowPairs(layout, ch.config, ch.langData)
+	total := 0.0
+	for _, value := range rowPairs {
+		total += value
+	}
 
-			// FSB - Full Scissors Bigrams: одна рука, разные пальцы, 1 и 3 ряд,
-			// на 3 ряду (row=2 в 0-indexed) находятся пальцы 2, 3, 6 или 7, исключая колонки 5 и 6
-			if finger1 != finger2 && rowDiff == 2 && ((row1 == 0 && row2 == 2) || (row1 == 2 && row2 == 0)) && col1 != 4 && col1 != 5 && col2 != 4 && col2 != 5 && half1 == half2 {
-				// В 3-м ряду (индекс 2) должен быть палец 2, 3, 6 или 7
-				if (row1 == 2 && finger1IsSpecial) || (row2 == 2 && finger2IsSpecial) {
-					fsbBigrams = append(fsbBigrams, bg)
-				}
-			}
+	fmt.Println("SFB по парам рядов:")
+	fmt.Println(" Один ряд   R1-R2   R2-R3   R1-R3   Цифр.   Всего")
+	fmt.Println(strings.Repeat("-", 50))
+	fmt.Printf(" %8.2f %7.2f %7.2f %7.2f %7.2f %7.2f\n", rowPairs[0], rowPairs[1], rowPairs[2], rowPairs[3], rowPairs[4], total)
+}
 
-			// LSB - Lateral Stretch Bigram (указательный и средний на одной руке через вертикальный ряд, колонки 3-5 или 6-8)
-			// Палец 1 = колонка 1 (индекс 1), Палец 2 = колонка 2 (индекс 2), Палец 5 = колонка 7 (индекс 6), Палец 6 = колонка 8 (индекс 7)
-			// Это колонки 2-4 или 5-7 (в индексах 0-9)
-			isLSBPattern := (col1 == 2 && col2 == 4) || (col1 == 4 && col2 == 2) || (col1 == 5 && col2 == 7) || (col1 == 7 && col2 == 5) // колонки 3-5 или 6-8
-			if isLSBPattern {
-				lsbBigrams = append(lsbBigrams, bg)
-			}
+// printBigramTypeAnalysis выводит n самых частых биграмм по типам
+func (ch *CommandHandler) printBigramTypeAnalysis(layout *Layout, analysis *LayoutAnalysis, numRows int) {
+	// Выводим заголовок для таблицы биграмм по типам
+	fmt.Printf(" %-3s %-16s %s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s %-7s\n", "№", "Layout", "  ", "SHB", "SFB", "HVB", "FVB", "HDB", "FDB", "HFB", "HSB", "FSB", "LSB", "SRB", "AFI", "AFO")
+	fmt.Println(strings.Repeat("-", 124))
 
-			// AFI - Adjacent Fingers In (соседние клавиши в одном ряду нажимаются по направлению к центру)
-			// AFO - Adjacent Fingers Out (соседние клавиши в одном ряду нажимаются по направлению от центра)
-			// Центр между колонками 4 и 5 (индексы 4 и 5)
-			// AFI: первый символ дальше от центра, второй - ближе к центру
-			// AFO: первый символ ближе к центру, второй - дальше от центра
-			if row1 == row2 && colDiff == 1 { // одни ряд, соседние колонки
-				var centerDist1, centerDist2 float64
-				// Расстояние до центра (между колонками 4 и 5)
-				centerDist1 = math.Abs(float64(col1) - 4.5)
-				centerDist2 = math.Abs(float64(col2) - 4.5)
+	lists := bigramTypeLists(layout, ch.config, ch.langData)
+	for i := range lists {
+		if len(lists[i]) > numRows {
+			lists[i] = lists[i][:numRows]
+		}
+	}
 
-				if centerDist1 > centerDist2 { // первый символ дальше от центра
-					afiBigrams = append(afiBigrams, bg) // движение к центру
-				} else if centerDist1 < centerDist2 { // первый символ ближе к центру
-					afoBigrams = append(afoBigrams, bg) // движение от центра
-				}
-			}
+	// Находим максимальную частоту среди всех биграмм для нормировки
+	maxFreq := 0.0
+	for _, freq := range ch.langData.Bigrams {
+		if freq > maxFreq {
+			maxFreq = freq
 		}
 	}
 
-	// Ограничиваем количество биграмм до numRows для каждого типа
-	bigramLists := [][]BigramFreq{shbBigrams, sfbBigrams, hvbBigrams, fvbBigrams, hdbBigrams, fdbBigrams, hfbBigrams, hsbBigrams, fsbBigrams, lsbBigrams, srbBigrams, afiBigrams, afoBigrams}
+	// Находим максимальную частоту среди всех выводимых биграмм для подсветки
+	maxFreqInTable := 0.0
+	for _, list := range lists {
+		for _, bg := range list {
+			if bg.Freq > maxFreqInTable {
+				maxFreqInTable = bg.Freq
+			}
+		}
+	}
+
+	// Подготавливаем и выводим numRows строк биграмм для каждого типа с цветовой подсветкой
+	for i := 0; i < numRows; i++ {
+		if i < 1 {
+			fmt.Printf("%-4s %-16s", fmt.Sprintf("[%d]", analysis.LayoutIndex), analysis.LayoutName)
+		} else {
+			fmt.Printf("%-4s %-16s", "", "")
+		}
+
+		// Проверяем и выводим биграммы для каждого типа с цветовой подсветкой
+		for _, list := range lists {
+			if i < len(list) {
+				bigram := list[i].Bigram
+				freq := list[i].Freq
+				colorizedBigram := ch.colorizeBigramByFrequency(bigram, freq, maxFreq, maxFreqInTable)
+				fmt.Printf("%-7s ", colorizedBigram)
+			} else {
+				fmt.Printf("%-7s ", "")
+			}
+		}
+
+		// Не выводим Total и Score для этих строк, только биграммы
+		fmt.Println()
+	}
+}
+
+// bigramTypeColumns метрики биграмм в порядке колонок таблицы биграмм команды a
+var bigramTypeColumns = []string{"SHB", "SFB", "HVB", "FVB", "HDB", "FDB", "HFB", "HSB", "FSB", "LSB", "SRB", "AFI", "AFO"}
+
+// bigramTypeLists возвращает для каждой метрики bigramTypeColumns биграммы языка, которые она учитывает,
+// по убыванию частоты. Биграммы классифицируются функцией calculateBigrams (через AnalyzeBigramContributions),
+// поэтому таблица команды a содержит те же биграммы, что учтены в метриках таблицы lb
+func bigramTypeLists(layout *Layout, config *KeyboardConfig, langData *LanguageData) [][]BigramFreq {
+	columns := make(map[string]int, len(bigramTypeColumns))
+	for i, name := range bigramTypeColumns {
+		columns[name] = i
+	}
+
+	lists := make([][]BigramFreq, len(bigramTypeColumns))
+	for _, contribution := range AnalyzeBigramContributions(layout, config, langData) {
+		bg := BigramFreq{Bigram: contribution.Bigram, Freq: langData.Bigrams[contribution.Bigram]}
+		for _, category := range contribution.Categories {
+			if i, exists := columns[category]; exists {
+				lists[i] = append(lists[i], bg)
+			}
+		}
+	}
+	for _, list := range lists {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Freq != list[j].Freq {
+				return list[i].Freq > list[j].Freq
+			}
+			return list[i].Bigram < list[j].Bigram
+		})
+	}
+	return lists
+}
+
+// CommandLanguageInfo выводит сведения о загруженном файле языка
+func (ch *CommandHandler) CommandLanguageInfo(args string) error {
+	charSum := 0.0
+	for _, freq := range ch.langData.Characters {
+		charSum += freq
+	}
+
+	bigramSum := 0.0
+	for _, freq := range ch.langData.Bigrams {
+		bigramSum += freq
+	}
+
+	fmt.Printf("Файл:         %s\n", ch.langFile)
+	fmt.Printf("Язык:         %s\n", ch.langData.Language)
+	if ch.langData.Source != "" {
+		fmt.Printf("Источник:     %s\n", ch.langData.Source)
+	}
+	if ch.langData.SampleSize > 0 {
+		fmt.Printf("Объем текста: %d\n", ch.langData.SampleSize)
+	}
+	if ch.langData.GeneratedAt != "" {
+		fmt.Printf("Сформирован:  %s\n", ch.langData.GeneratedAt)
+	}
+	if ch.langData.Notes != "" {
+		fmt.Printf("Примечания:   %s\n", ch.langData.Notes)
+	}
+	fmt.Printf("Символов:     %d (сумма частот %.2f%%)\n", len(ch.langData.Characters), charSum*100.0)
+	fmt.Printf("Биграмм:      %d (сумма частот %.2f%%)\n", len(ch.langData.Bigrams), bigramSum*100.0)
+
+	return nil
+}
+
+// CommandTopBigrams выводит биграммы раскладки с наибольшим вкладом во взвешенную оценку биграмм
+func (ch *CommandHandler) CommandTopBigrams(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 1 || len(parts) > 2 {
+		return fmt.Errorf("используйте: topbigrams N [k] (N - номер раскладки, k - количество биграмм)")
+	}
+
+	layoutNum, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNum)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNum)
+	}
+
+	count := 20
+	if len(parts) == 2 {
+		count, err = strconv.Atoi(parts[1])
+		if err != nil || count < 1 {
+			return fmt.Errorf("некорректное количество биграмм: %s", parts[1])
+		}
+	}
+
+	contributions := AnalyzeBigramContributions(layout, ch.config, ch.langData)
+	sort.Slice(contributions, func(i, j int) bool {
+		if contributions[i].Contribution != contributions[j].Contribution {
+			return contributions[i].Contribution > contributions[j].Contribution
+		}
+		if contributions[i].Freq != contributions[j].Freq {
+			return contributions[i].Freq > contributions[j].Freq
+		}
+		return contributions[i].Bigram < contributions[j].Bigram
+	})
+	if len(contributions) > count {
+		contributions = contributions[:count]
+	}
+
+	fmt.Printf("[%d] %s - биграммы с наибольшим вкладом в оценку\n", layoutNum, layout.Name)
+	fmt.Printf(" %-4s %-6s %7s %7s  %s\n", "№", "Bigram", "Freq", "Cost", "Metrics")
+	fmt.Println(strings.Repeat("-", 60))
+	for i, c := range contributions {
+		fmt.Printf(" %-4d %-6s %7.2f %7.2f  %s\n", i+1, c.Bigram, c.Freq, c.Contribution, strings.Join(c.Categories, " "))
+	}
+
+	return nil
+}
+
+// variantSwaps количество случайных перестановок при создании варианта раскладки
+const variantSwaps = 3
+
+// variantMaxAttemptsPerLayout ограничивает число попыток найти новый уникальный вариант
+const variantMaxAttemptsPerLayout = 100
+
+// Параметры гистограммы команды sample: количество интервалов и ширина самого длинного столбца
+const (
+	sampleHistogramBuckets = 10
+	sampleHistogramWidth   = 50
+)
+
+// CommandSample генерирует k случайных раскладок из символов первой загруженной раскладки (как поиск
+// от случайной раскладки) и выводит распределение их общей оценки: минимум, среднее, медиану, максимум,
+// гистограмму и долю случайных раскладок, которые хуже каждой из загруженных раскладок
+func (ch *CommandHandler) CommandSample(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 1 {
+		return fmt.Errorf("используйте: sample k (k - количество случайных раскладок)")
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count < 1 {
+		return fmt.Errorf("некорректное количество случайных раскладок: %s", parts[0])
+	}
+
+	scores := make([]float64, count)
+	sum := 0.0
+	for i := range scores {
+		var layout Layout
+		if len(ch.layouts.Layouts) > 0 {
+			layout = GenerateRandomLayoutFromLayouts(ch.config, ch.layouts, ch.langData)
+		} else {
+			layout = generateRandomLayout(ch.config, ch.langData)
+		}
+		scores[i] = AnalyzeLayout(&layout, ch.config, ch.langData).WeightedScore
+		sum += scores[i]
+	}
+	sort.Float64s(scores)
+
+	minScore, maxScore := scores[0], scores[count-1]
+	median := scores[count/2]
+	if count%2 == 0 {
+		median = (scores[count/2-1] + scores[count/2]) / 2
+	}
+	fmt.Printf("Случайных раскладок: %d, Score: минимум %.2f, среднее %.2f, медиана %.2f, максимум %.2f\n\n",
+		count, minScore, sum/float64(count), median, maxScore)
+
+	// Гистограмма: интервалы одинаковой ширины от минимальной до максимальной оценки
+	buckets := sampleHistogramBuckets
+	width := (maxScore - minScore) / float64(buckets)
+	if width == 0 {
+		buckets = 1
+	}
+	counts := make([]int, buckets)
+	maxCount := 0
+	for _, score := range scores {
+		bucket := 0
+		if width > 0 {
+			bucket = int((score - minScore) / width)
+		}
+		if bucket >= buckets {
+			bucket = buckets - 1
+		}
+		counts[bucket]++
+		if counts[bucket] > maxCount {
+			maxCount = counts[bucket]
+		}
+	}
+
+	fmt.Printf("%17s %7s\n", "Score", "Кол-во")
+	for bucket, bucketCount := range counts {
+		from := minScore + float64(bucket)*width
+		bar := strings.Repeat("#", bucketCount*sampleHistogramWidth/maxCount)
+		fmt.Printf("%7.2f - %7.2f %7d %s\n", from, from+width, bucketCount, bar)
+	}
+
+	if len(ch.layouts.Layouts) == 0 {
+		return nil
+	}
+
+	// Положение загруженных раскладок среди случайных
+	fmt.Printf("\n%-4s %-16s %7s %14s\n", "№", "Layout", "Score", "Случайных хуже")
+	for i := range ch.layouts.Layouts {
+		score := AnalyzeLayout(&ch.layouts.Layouts[i], ch.config, ch.langData).WeightedScore
+		worse := count - sort.Search(count, func(j int) bool { return scores[j] > score })
+		fmt.Printf("%-4s %-16s %7.2f %13.1f%%\n", fmt.Sprintf("[%d]", i+1), ch.layouts.Layouts[i].Name, score, float64(worse)/float64(count)*100)
+	}
+	return nil
+}
+
+// CommandVariants создает k различных вариантов раскладки N случайными перестановками
+// незафиксированных клавиш и дописывает их в указанный файл
+func (ch *CommandHandler) CommandVariants(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 3 {
+		return fmt.Errorf("используйте: variants N k файл (N - номер раскладки, k - количество вариантов)")
+	}
+
+	layoutNum, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNum)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNum)
+	}
+
+	count, err := strconv.Atoi(parts[1])
+	if err != nil || count < 1 {
+		return fmt.Errorf("некорректное количество вариантов: %s", parts[1])
+	}
+	fileName := parts[2]
+
+	// Собираем уникальные варианты, исходная раскладка тоже считается уже занятой
+	variants := []Layout{*layout}
+	for attempt := 0; len(variants) <= count && attempt < count*variantMaxAttemptsPerLayout; attempt++ {
+		variant := *layout
+		for i := 0; i < variantSwaps; i++ {
+			variant = generateNeighbor(&variant, ch.config)
+		}
+
+		unique := true
+		for i := range variants {
+			if variant.Equals(&variants[i]) {
+				unique = false
+				break
+			}
+		}
+		if unique {
+			variants = append(variants, variant)
+		}
+	}
+	variants = variants[1:]
+
+	if len(variants) < count {
+		return fmt.Errorf("удалось получить только %d различных вариантов из %d: недостаточно незафиксированных клавиш", len(variants), count)
+	}
+
+	fmt.Printf("Варианты раскладки [%d] %s:\n", layoutNum, layout.Name)
+	for i, variant := range variants {
+		if err := ch.saveLayoutToFile(variant, fileName); err != nil {
+			return err
+		}
+		analysis := AnalyzeLayout(&variant, ch.config, ch.langData)
+		fmt.Printf(" %2d. Score: %.2f\n", i+1, analysis.WeightedScore)
+	}
+	fmt.Printf("Сохранено вариантов: %d в файл %s\n", len(variants), fileName)
+
+	return nil
+}
+
+// CommandGreedy строит детерминированную жадную раскладку и сохраняет ее в буфер [0]
+func (ch *CommandHandler) CommandGreedy(args string) error {
+	var baseLayout *Layout
+	args = strings.TrimSpace(args)
+	if args != "" {
+		layoutNum, err := strconv.Atoi(args)
+		if err != nil {
+			return fmt.Errorf("некорректный номер раскладки: %s", args)
+		}
+		layout, exists := ch.getLayoutByIndex(layoutNum)
+		if !exists {
+			return fmt.Errorf("раскладка с номером %d не найдена", layoutNum)
+		}
+		baseLayout = layout
+	} else if len(ch.layouts.Layouts) > 0 {
+		baseLayout = &ch.layouts.Layouts[0]
+	}
+
+	greedyLayout := GenerateGreedyLayout(ch.config, ch.langData, baseLayout)
+	analysis := AnalyzeLayout(&greedyLayout, ch.config, ch.langData)
+	analysis.LayoutName = greedyLayout.Name
+
+	fmt.Printf("\n%s\n", greedyLayout.Name)
+	fmt.Println(strings.Repeat("-", len(greedyLayout.Name)))
+	ch.printColoredLayout(&greedyLayout)
+	fmt.Println()
+	fmt.Println(FormatAnalysisHeader(ch.config))
+	fmt.Println(FormatAnalysisWithHighlights(analysis))
+
+	// Сохраняем результат во временный буфер [0]
+	ch.searchResultLayout = &greedyLayout
+	ch.isInvertedLayoutActive = false
+	ch.invertedLayout = nil
+
+	return nil
+}
+
+// CommandDistance сравнивает расположение клавиш двух раскладок: количество символов
+// на тех же позициях, на тех же пальцах и список отличающихся позиций
+func (ch *CommandHandler) CommandDistance(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 2 {
+		return fmt.Errorf("используйте: dist N M (номера раскладок)")
+	}
+
+	var layouts [2]*Layout
+	var nums [2]int
+	for i, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			return fmt.Errorf("некорректный номер раскладки: %s", part)
+		}
+		layout, exists := ch.getLayoutByIndex(num)
+		if !exists {
+			return fmt.Errorf("раскладка с номером %d не найдена", num)
+		}
+		layouts[i] = layout
+		nums[i] = num
+	}
+
+	// Заглавные буквы отмечают закрепленные позиции, поэтому регистр не учитывается
+	positions := make(map[string][2]int)
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			if key := strings.ToLower(layouts[1].Keys[row][col]); key != "" {
+				positions[key] = [2]int{row, col}
+			}
+		}
+	}
+
+	total, samePosition, sameFinger := 0, 0, 0
+	var differences []string
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			key := strings.ToLower(layouts[0].Keys[row][col])
+			if key == "" {
+				continue
+			}
+			total++
+
+			other := strings.ToLower(layouts[1].Keys[row][col])
+			if other == key {
+				samePosition++
+				sameFinger++
+				continue
+			}
+			differences = append(differences, fmt.Sprintf("R%dC%d: %s -> %s", row+1, col+1, key, other))
+
+			if pos, exists := positions[key]; exists && fingerForKey(ch.config, pos[0], pos[1]) == fingerForKey(ch.config, row, col) {
+				sameFinger++
+			}
+		}
+	}
+
+	similarity := 0.0
+	if total > 0 {
+		similarity = float64(samePosition) / float64(total)
+	}
+
+	fmt.Printf("[%d] %s -> [%d] %s\n", nums[0], layouts[0].Name, nums[1], layouts[1].Name)
+	fmt.Printf("Символы на тех же позициях: %d из %d\n", samePosition, total)
+	fmt.Printf("Символы на тех же пальцах:  %d из %d\n", sameFinger, total)
+	fmt.Printf("Сходство: %.2f\n", similarity)
+	if len(differences) > 0 {
+		fmt.Println("Отличающиеся позиции:")
+		for _, difference := range differences {
+			fmt.Printf("  %s\n", difference)
+		}
+	}
+
+	return nil
+}
+
+// setLanguageData сохраняет загруженные языковые данные и применяет к ним исключенные символы
+func (ch *CommandHandler) setLanguageData(langData *LanguageData) {
+	if ch.bigramFreq != nil {
+		langData = OverrideBigramFrequencies(langData, ch.bigramFreq)
+	}
+	if ch.keyFreq != nil {
+		langData = OverrideCharacterFrequencies(langData, ch.keyFreq)
+	}
+	ch.fullLangData = langData
+	ch.langData = FilterLanguageData(langData, ch.excludedChars, ch.excludedBigrams)
+	ch.warnIfNoBigrams()
+}
+
+// warnIfNoBigrams предупреждает, что в данных языка нет биграмм и показатели биграмм не рассчитываются.
+// Предупреждение выводится один раз, пока биграммы не появятся снова (например, после перезагрузки файла языка)
+func (ch *CommandHandler) warnIfNoBigrams() {
+	if len(ch.langData.Bigrams) > 0 {
+		ch.noBigramsWarned = false
+	} else if !ch.noBigramsWarned {
+		ch.noBigramsWarned = true
+		fmt.Println("Предупреждение: в данных языка нет биграмм, показатели биграмм недоступны (N/A в таблице lb) и не входят в оценку Score")
+	}
+}
+
+// CommandExclude исключает символы или биграммы (exclude bigrams XY ...) из анализа
+// или выводит список исключенных
+func (ch *CommandHandler) CommandExclude(args string) error {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0 || (len(fields) == 1 && fields[0] == "list"):
+	case fields[0] == "bigrams":
+		if len(fields) == 1 {
+			return fmt.Errorf("используйте: exclude bigrams XY [XY ...]")
+		}
+		for _, bigram := range fields[1:] {
+			if len([]rune(bigram)) < 2 {
+				return fmt.Errorf("некорректная биграмма %q: ожидается не меньше двух символов", bigram)
+			}
+		}
+		for _, bigram := range fields[1:] {
+			ch.excludedBigrams[bigram] = true
+		}
+		ch.setLanguageData(ch.fullLangData)
+	default:
+		for _, r := range strings.Join(fields, "") {
+			ch.excludedChars[string(r)] = true
+		}
+		ch.setLanguageData(ch.fullLangData)
+	}
+
+	ch.printExcluded()
+	return nil
+}
+
+// CommandInclude возвращает символы или биграммы (include bigrams [XY ...]) в анализ,
+// без аргументов возвращает все исключенные символы и биграммы
+func (ch *CommandHandler) CommandInclude(args string) error {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		ch.excludedChars = make(map[string]bool)
+		ch.excludedBigrams = make(map[string]bool)
+	case fields[0] == "bigrams" && len(fields) == 1:
+		ch.excludedBigrams = make(map[string]bool)
+	case fields[0] == "bigrams":
+		for _, bigram := range fields[1:] {
+			delete(ch.excludedBigrams, bigram)
+		}
+	default:
+		for _, r := range strings.Join(fields, "") {
+			delete(ch.excludedChars, string(r))
+		}
+	}
+	ch.setLanguageData(ch.fullLangData)
+
+	ch.printExcluded()
+	return nil
+}
+
+// printExcluded выводит исключенные символы и биграммы и долю исключенных частот
+func (ch *CommandHandler) printExcluded() {
+	if len(ch.excludedChars) == 0 && len(ch.excludedBigrams) == 0 {
+		fmt.Println("Исключенных символов и биграмм нет")
+		return
+	}
+
+	if len(ch.excludedChars) > 0 {
+		fmt.Printf("Исключенные символы: %s\n", strings.Join(sortedKeys(ch.excludedChars), " "))
+	}
+	if len(ch.excludedBigrams) > 0 {
+		fmt.Printf("Исключенные биграммы: %s\n", strings.Join(sortedKeys(ch.excludedBigrams), " "))
+	}
+
+	charTotal, charExcluded := 0.0, 0.0
+	for char, freq := range ch.fullLangData.Characters {
+		charTotal += freq
+		if ch.excludedChars[char] {
+			charExcluded += freq
+		}
+	}
+	bigramTotal, bigramKept := 0.0, 0.0
+	for bigram, freq := range ch.fullLangData.Bigrams {
+		bigramTotal += freq
+		if _, kept := ch.langData.Bigrams[bigram]; kept {
+			bigramKept += freq
+		}
+	}
+
+	if charTotal > 0 {
+		fmt.Printf("Исключено частоты символов: %.2f%%\n", charExcluded/charTotal*100.0)
+	}
+	if bigramTotal > 0 {
+		fmt.Printf("Исключено частоты биграмм:  %.2f%%\n", (bigramTotal-bigramKept)/bigramTotal*100.0)
+	}
+}
+
+// sortedKeys возвращает отсортированные ключи множества
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CommandThreshold задает пороги показателей, по которым окрашиваются ячейки таблиц l и lb:
+// threshold METRIC VALUE (значение не больше VALUE), threshold METRIC >VALUE (не меньше VALUE),
+// threshold METRIC off, threshold clear. Без аргументов выводит заданные пороги
+func (ch *CommandHandler) CommandThreshold(args string) error {
+	parts := strings.Fields(args)
+	switch {
+	case len(parts) == 0:
+		ch.printThresholds()
+		return nil
+	case len(parts) == 1 && parts[0] == "clear":
+		ch.thresholds = make(map[string]MetricThreshold)
+		fmt.Println("Все пороги удалены")
+		return nil
+	case len(parts) != 2:
+		return fmt.Errorf("используйте: threshold [METRIC VALUE|METRIC >VALUE|METRIC off|clear]")
+	}
+
+	metric := ""
+	for _, column := range append(append([]string{}, analysisColumns...), bigramColumns...) {
+		if strings.EqualFold(column, parts[0]) {
+			metric = column
+			break
+		}
+	}
+	if metric == "" {
+		return fmt.Errorf("неизвестный показатель: %s (допустимы колонки таблиц l и lb: %s, %s)",
+			parts[0], strings.Join(analysisColumns, " "), strings.Join(bigramColumns, " "))
+	}
+
+	if parts[1] == "off" {
+		delete(ch.thresholds, metric)
+		fmt.Printf("Порог для %s удален\n", metric)
+		return nil
+	}
+
+	threshold := MetricThreshold{}
+	value := parts[1]
+	if strings.HasPrefix(value, ">") {
+		threshold.AtLeast = true
+		value = strings.TrimPrefix(strings.TrimPrefix(value, ">"), "=")
+	}
+	limit, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("некорректное значение порога: %s", parts[1])
+	}
+	threshold.Limit = limit
+	ch.thresholds[metric] = threshold
+
+	ch.printThresholds()
+	return nil
+}
+
+// printThresholds выводит заданные пороги показателей
+func (ch *CommandHandler) printThresholds() {
+	if len(ch.thresholds) == 0 {
+		fmt.Println("Пороги показателей не заданы")
+		return
+	}
+
+	fmt.Println("Пороги показателей:")
+	for _, column := range tableColumnNames() {
+		threshold, exists := ch.thresholds[column]
+		if !exists {
+			continue
+		}
+		if threshold.AtLeast {
+			fmt.Printf("  %-6s >= %g\n", column, threshold.Limit)
+		} else {
+			fmt.Printf("  %-6s <= %g\n", column, threshold.Limit)
+		}
+	}
+}
+
+// CommandHistory выводит историю изменений за сессию или сохраняет ее в файл (history save файл)
+func (ch *CommandHandler) CommandHistory(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) == 0 {
+		if len(ch.history) == 0 {
+			fmt.Println("История изменений пуста")
+			return nil
+		}
+		for _, entry := range ch.history {
+			fmt.Printf("[%s] %s\n", entry.Time.Format("15:04:05"), entry.Command)
+		}
+		return nil
+	}
+
+	if len(parts) != 2 || parts[0] != "save" {
+		return fmt.Errorf("используйте: history [save файл]")
+	}
+
+	var sb strings.Builder
+	for _, entry := range ch.history {
+		sb.WriteString(fmt.Sprintf("[%s] %s\n", entry.Time.Format("2006-01-02 15:04:05"), entry.Command))
+	}
+	if err := os.WriteFile(parts[1], []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("ошибка при записи файла истории: %w", err)
+	}
+	fmt.Printf("История изменений (%d) сохранена в файл %s\n", len(ch.history), parts[1])
+	return nil
+}
+
+// referenceAnalysis возвращает анализ эталонной раскладки для режима --normalize
+// или nil, если режим выключен
+func (ch *CommandHandler) referenceAnalysis() *LayoutAnalysis {
+	if !ch.normalize {
+		return nil
+	}
+	layout := ReferenceLayout(ch.langData)
+	analysis := AnalyzeLayout(layout, ch.config, ch.langData)
+	analysis.LayoutName = layout.Name
+	return analysis
+}
+
+// relativeAnalysis возвращает анализ встроенной раскладки, выбранной командой rel,
+// или nil, если колонка Rel выключена
+func (ch *CommandHandler) relativeAnalysis() *LayoutAnalysis {
+	if ch.relative == "" {
+		return nil
+	}
+	layout, found := findReferenceLayout(ch.relative)
+	if !found {
+		layout = ReferenceLayout(ch.langData)
+	}
+	analysis := AnalyzeLayout(layout, ch.config, ch.langData)
+	analysis.LayoutName = layout.Name
+	return analysis
+}
+
+// tableOptions возвращает настройки вывода таблиц l и lb: эталонную раскладку режима
+// --normalize, пороги показателей, выбранные командой columns колонки и раскладку команды rel
+func (ch *CommandHandler) tableOptions() TableOptions {
+	return TableOptions{
+		Reference:  ch.referenceAnalysis(),
+		Thresholds: ch.thresholds,
+		Columns:    ch.columns,
+		Relative:   ch.relativeAnalysis(),
+	}
+}
+
+// CommandRelative включает колонку Rel таблиц l и lb с улучшением Score в процентах относительно
+// встроенной раскладки (qwerty или йцукен, без аргумента - подходящей к алфавиту языка) или выключает ее
+func (ch *CommandHandler) CommandRelative(args string) error {
+	name := strings.TrimSpace(args)
+	switch name {
+	case "off":
+		ch.relative = ""
+		fmt.Println("Колонка Rel выключена")
+		return nil
+	case "", "auto":
+		ch.relative = "auto"
+	default:
+		layout, found := findReferenceLayout(name)
+		if !found {
+			var names []string
+			for _, reference := range referenceLayouts {
+				names = append(names, reference.Name)
+			}
+			return fmt.Errorf("неизвестная раскладка: %s (допустимы: %s, auto, off)", name, strings.Join(names, ", "))
+		}
+		ch.relative = layout.Name
+	}
+
+	reference := ch.relativeAnalysis()
+	if reference.WeightedScore == 0 {
+		ch.relative = ""
+		return fmt.Errorf("раскладка %s не содержит символов языка, улучшение относительно нее не определено", reference.LayoutName)
+	}
+	fmt.Printf("Колонка Rel: улучшение Score относительно раскладки %s (Score %.2f) в процентах\n", reference.LayoutName, reference.WeightedScore)
+	return nil
+}
+
+// parseSortOption выделяет из аргументов команд l, lb и ll параметр sort=COLUMN
+// (sort=-COLUMN - по убыванию) и возвращает оставшиеся аргументы и функцию сравнения
+// анализов. Без параметра раскладки сравниваются по общей оценке (WeightedScore),
+// при равенстве значений колонки порядок также определяет общая оценка
+func parseSortOption(args string, columns []string) (string, func(a, b *LayoutAnalysis) bool, error) {
+	byScore := func(a, b *LayoutAnalysis) bool {
+		return a.WeightedScore < b.WeightedScore
+	}
+
+	var rest []string
+	column := ""
+	descending := false
+	for _, part := range strings.Fields(args) {
+		if !strings.HasPrefix(part, "sort=") {
+			rest = append(rest, part)
+			continue
+		}
+		name := strings.TrimPrefix(part, "sort=")
+		descending = strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		column = ""
+		for _, c := range columns {
+			if strings.EqualFold(c, name) {
+				column = c
+				break
+			}
+		}
+		if column == "" {
+			return "", nil, fmt.Errorf("неизвестная колонка для сортировки: %s (допустимы: %s)", name, strings.Join(columns, " "))
+		}
+	}
+	if column == "" {
+		return strings.Join(rest, " "), byScore, nil
+	}
+
+	less := func(a, b *LayoutAnalysis) bool {
+		valueA, _ := MetricValue(a, column)
+		valueB, _ := MetricValue(b, column)
+		if valueA != valueB {
+			if descending {
+				return valueA > valueB
+			}
+			return valueA < valueB
+		}
+		return byScore(a, b)
+	}
+	return strings.Join(rest, " "), less, nil
+}
+
+// printAnalysisHeader выводит заголовок таблицы статистики по нажатиям клавиш,
+// в режиме --normalize и с колонкой Rel дополнительно указывается эталонная раскладка
+func (ch *CommandHandler) printAnalysisHeader(opts TableOptions) {
+	if opts.Reference != nil {
+		fmt.Printf("Значения указаны относительно эталонной раскладки %s\n", opts.Reference.LayoutName)
+	}
+	if opts.Relative != nil {
+		fmt.Printf("Rel - улучшение Score относительно раскладки %s в процентах\n", opts.Relative.LayoutName)
+	}
+	fmt.Println(FormatAnalysisHeaderWithOptions(ch.config, opts))
+}
+
+// printBigramAnalysisHeader выводит заголовок таблицы статистики по биграммам,
+// в режиме --normalize и с колонкой Rel дополнительно указывается эталонная раскладка
+func (ch *CommandHandler) printBigramAnalysisHeader(opts TableOptions) {
+	if opts.Reference != nil {
+		fmt.Printf("Значения указаны относительно эталонной раскладки %s\n", opts.Reference.LayoutName)
+	}
+	if opts.Relative != nil {
+		fmt.Printf("Rel - улучшение Score относительно раскладки %s в процентах\n", opts.Relative.LayoutName)
+	}
+	fmt.Println(FormatBigramAnalysisHeaderWithOptions(ch.config, opts))
+}
+
+// CommandBigramCoeffs импортирует индивидуальные коэффициенты биграмм из файла
+// или экспортирует текущие коэффициенты в файл
+func (ch *CommandHandler) CommandBigramCoeffs(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 2 || (parts[0] != "import" && parts[0] != "export") {
+		return fmt.Errorf("используйте: bic import|export file (.json или строки \"pos1 pos2 coeff\")")
+	}
+
+	if parts[0] == "export" {
+		if err := SaveBigramCoeffs(parts[1], ch.config.BigramIndividualCoeffs); err != nil {
+			return err
+		}
+		fmt.Printf("Индивидуальные коэффициенты биграмм (%d) сохранены в файл %s\n", len(ch.config.BigramIndividualCoeffs), parts[1])
+		return nil
+	}
+
+	coeffs, err := LoadBigramCoeffs(parts[1])
+	if err != nil {
+		return err
+	}
+	ch.config.BigramIndividualCoeffs = coeffs
+	ch.configTracker.SetBigramIndividualCoeffs(coeffs)
+	ch.analyses = nil
+	fmt.Printf("Загружено индивидуальных коэффициентов биграмм: %d (предыдущие коэффициенты заменены)\n", len(coeffs))
+	return nil
+}
+
+// tableColumnNames возвращает названия всех колонок таблиц l и lb без повторов
+func tableColumnNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, column := range append(append([]string{}, analysisColumns...), bigramColumns...) {
+		if !seen[column] {
+			seen[column] = true
+			names = append(names, column)
+		}
+	}
+	return names
+}
+
+// CommandColumns выбирает колонки, которые выводятся в таблицах l и lb
+func (ch *CommandHandler) CommandColumns(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) == 1 && parts[0] == "reset" {
+		ch.columns = make(map[string]bool)
+		parts = nil
+	}
+
+	if len(parts) > 0 {
+		columns := make(map[string]bool)
+		for _, part := range parts {
+			column := ""
+			for _, known := range tableColumnNames() {
+				if strings.EqualFold(known, part) {
+					column = known
+					break
+				}
+			}
+			if column == "" {
+				return fmt.Errorf("неизвестная колонка: %s (допустимо: %s)", part, strings.Join(tableColumnNames(), " "))
+			}
+			columns[column] = true
+		}
+		ch.columns = columns
+	}
+
+	if len(ch.columns) == 0 {
+		fmt.Println("Выводятся все колонки таблиц")
+		return nil
+	}
+	var selected []string
+	for _, column := range tableColumnNames() {
+		if ch.columns[column] {
+			selected = append(selected, column)
+		}
+	}
+	fmt.Printf("Выбранные колонки: %s\n", strings.Join(selected, " "))
+	return nil
+}
+
+// cyrillicKeys клавиши US раскладки, на которых находятся буквы стандартной раскладки ЙЦУКЕН
+var cyrillicKeys = map[string]string{
+	"й": "q", "ц": "w", "у": "e", "к": "r", "е": "t", "н": "y", "г": "u", "ш": "i", "щ": "o", "з": "p", "х": "[", "ъ": "]",
+	"ф": "a", "ы": "s", "в": "d", "а": "f", "п": "g", "р": "h", "о": "j", "л": "k", "д": "l", "ж": ";", "э": "'",
+	"я": "z", "ч": "x", "с": "c", "м": "v", "и": "b", "т": "n", "ь": "m", "б": ",", "ю": ".", "ё": "`",
+}
+
+// keymapSymbols коды символов, отличных от латинских букв и цифр, в форматах QMK и ZMK
+var keymapSymbols = map[string][2]string{
+	";":  {"KC_SCLN", "SEMI"},
+	",":  {"KC_COMM", "COMMA"},
+	".":  {"KC_DOT", "DOT"},
+	"/":  {"KC_SLSH", "FSLH"},
+	"'":  {"KC_QUOT", "SQT"},
+	"[":  {"KC_LBRC", "LBKT"},
+	"]":  {"KC_RBRC", "RBKT"},
+	"`":  {"KC_GRV", "GRAVE"},
+	"-":  {"KC_MINS", "MINUS"},
+	"=":  {"KC_EQL", "EQUAL"},
+	"\\": {"KC_BSLS", "BSLH"},
+}
+
+// keymapToken возвращает код клавиши для прошивки QMK (zmk = false) или ZMK (zmk = true).
+// Кириллические буквы заменяются клавишами, на которых они находятся в раскладке ЙЦУКЕН,
+// символы без кода и пустые клавиши отображаются в KC_NO или &none
+func keymapToken(key string, zmk bool) string {
+	key = strings.ToLower(key)
+	if latin, exists := cyrillicKeys[key]; exists {
+		key = latin
+	}
+
+	code := ""
+	if len(key) == 1 && (key[0] >= 'a' && key[0] <= 'z' || key[0] >= '0' && key[0] <= '9') {
+		code = strings.ToUpper(key)
+		if zmk && key[0] <= '9' {
+			code = "N" + code
+		}
+	} else if symbol, exists := keymapSymbols[key]; exists {
+		if zmk {
+			return "&kp " + symbol[1]
+		}
+		return symbol[0]
+	}
+
+	switch {
+	case code == "" && zmk:
+		return "&none"
+	case code == "":
+		return "KC_NO"
+	case zmk:
+		return "&kp " + code
+	default:
+		return "KC_" + code
+	}
+}
+
+// CommandKeymap выводит раскладку в виде фрагмента слоя keymap для прошивок QMK или ZMK
+func (ch *CommandHandler) CommandKeymap(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 1 || len(parts) > 2 {
+		return fmt.Errorf("используйте: keymap N [qmk|zmk]")
+	}
+	layoutNumber, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNumber)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+	format := "qmk"
+	if len(parts) == 2 {
+		format = strings.ToLower(parts[1])
+	}
+	if format != "qmk" && format != "zmk" {
+		return fmt.Errorf("неизвестный формат: %s (допустимо qmk или zmk)", parts[1])
+	}
+	zmk := format == "zmk"
+
+	fmt.Printf("// [%d] %s\n", layoutNumber, layout.Name)
+	rows := layoutFileRows(*layout)
+	for i, keys := range rows {
+		var sb strings.Builder
+		for col, key := range keys {
+			if col == 5 {
+				sb.WriteString("   ")
+			}
+			token := keymapToken(key, zmk)
+			if zmk {
+				sb.WriteString(fmt.Sprintf("%-10s", token))
+			} else {
+				// В QMK коды клавиш разделяются запятыми, после последней клавиши слоя запятая не ставится
+				if i < len(rows)-1 || col < 9 {
+					token += ","
+				}
+				sb.WriteString(fmt.Sprintf("%-9s", token))
+			}
+		}
+		fmt.Println(strings.TrimRight(sb.String(), " "))
+	}
+
+	return nil
+}
+
+// CommandScoreBreakdown выводит слагаемые итоговой оценки раскладки: значение показателя,
+// вес и вклад в оценку, по убыванию вклада
+func (ch *CommandHandler) CommandScoreBreakdown(args string) error {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return fmt.Errorf("используйте: scoreb N (номер раскладки)")
+	}
+	layoutNumber, err := strconv.Atoi(args)
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", args)
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNumber)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+
+	analysis := AnalyzeLayout(layout, ch.config, ch.langData)
+	terms := ScoreTerms(ch.config, analysis)
+	sort.SliceStable(terms, func(i, j int) bool {
+		return terms[i].Contribution() > terms[j].Contribution()
+	})
+
+	fmt.Printf("[%d] %s\n", layoutNumber, layout.Name)
+	fmt.Printf("%-12s %9s %9s %9s %7s\n", "Term", "Value", "Weight", "Score", "Доля")
+	fmt.Println(strings.Repeat("-", 50))
+	total := 0.0
+	for _, term := range terms {
+		total += term.Contribution()
+	}
+	for _, term := range terms {
+		share := 0.0
+		if total != 0 {
+			share = term.Contribution() / total * 100
+		}
+		fmt.Printf("%-12s %9.2f %9.3f %9.2f %6.1f%%\n", term.Name, term.Value, term.Weight, term.Contribution(), share)
+	}
+	fmt.Println(strings.Repeat("-", 50))
+	fmt.Printf("%-12s %9s %9s %9.2f\n", "Total", "", "", analysis.WeightedScore)
+
+	return nil
+}
+
+// whyTolerance разница вклада слагаемого в оценку, меньше которой слагаемое не выводится командой why
+const whyTolerance = 0.005
+
+// whyReasons количество слагаемых, которые перечисляются в итоговом объяснении команды why
+const whyReasons = 3
+
+// CommandWhy объясняет, почему одна из раскладок N и M оценивается лучше другой: выводит слагаемые
+// оценки, вклад которых в Score различается, по убыванию абсолютной разницы и перечисляет слагаемые,
+// за счет которых лучшая раскладка выигрывает, и слагаемые, по которым она уступает
+func (ch *CommandHandler) CommandWhy(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 2 {
+		return fmt.Errorf("используйте: why N M (номера раскладок)")
+	}
+
+	var layouts [2]*Layout
+	var nums [2]int
+	for i, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			return fmt.Errorf("некорректный номер раскладки: %s", part)
+		}
+		layout, exists := ch.getLayoutByIndex(num)
+		if !exists {
+			return fmt.Errorf("раскладка с номером %d не найдена", num)
+		}
+		layouts[i] = layout
+		nums[i] = num
+	}
+
+	analyses := [2]*LayoutAnalysis{
+		AnalyzeLayout(layouts[0], ch.config, ch.langData),
+		AnalyzeLayout(layouts[1], ch.config, ch.langData),
+	}
+	fmt.Printf("[%d] %s: Score %.2f, [%d] %s: Score %.2f\n", nums[0], layouts[0].Name, analyses[0].WeightedScore,
+		nums[1], layouts[1].Name, analyses[1].WeightedScore)
+
+	// Слагаемые сравниваются с точки зрения лучшей раскладки: отрицательная разница - преимущество
+	better, worse := 0, 1
+	if analyses[1].WeightedScore < analyses[0].WeightedScore {
+		better, worse = 1, 0
+	}
+	scoreDiff := analyses[better].WeightedScore - analyses[worse].WeightedScore
+
+	type termDiff struct {
+		term  ScoreTerm
+		other ScoreTerm
+		diff  float64
+	}
+	worseTerms := ScoreTerms(ch.config, analyses[worse])
+	var diffs []termDiff
+	for i, term := range ScoreTerms(ch.config, analyses[better]) {
+		diff := term.Contribution() - worseTerms[i].Contribution()
+		if math.Abs(diff) >= whyTolerance {
+			diffs = append(diffs, termDiff{term, worseTerms[i], diff})
+		}
+	}
+	if len(diffs) == 0 {
+		fmt.Println("Вклад всех слагаемых оценки одинаков")
+		return nil
+	}
+	sort.SliceStable(diffs, func(i, j int) bool { return math.Abs(diffs[i].diff) > math.Abs(diffs[j].diff) })
+
+	fmt.Println()
+	fmt.Printf("Слагаемые оценки, вклад которых различается ([%d] относительно [%d]):\n", nums[better], nums[worse])
+	fmt.Printf("%-12s %9s %9s %9s %9s %9s\n", "Term", fmt.Sprintf("[%d]", nums[better]), fmt.Sprintf("[%d]", nums[worse]),
+		"Weight", "Разница", "Score")
+	fmt.Println(strings.Repeat("-", 63))
+	for _, d := range diffs {
+		fmt.Printf("%-12s %9.2f %9.2f %9.3f %+9.2f %+9.2f\n", d.term.Name, d.term.Value, d.other.Value, d.term.Weight,
+			d.term.Value-d.other.Value, d.diff)
+	}
+	fmt.Println(strings.Repeat("-", 63))
+	fmt.Printf("%-12s %9.2f %9.2f %9s %9s %+9.2f\n", "Score", analyses[better].WeightedScore, analyses[worse].WeightedScore, "", "", scoreDiff)
+
+	var gains, losses []string
+	for _, d := range diffs {
+		reason := fmt.Sprintf("%s %.2f -> %.2f (%+.2f к оценке)", d.term.Name, d.other.Value, d.term.Value, d.diff)
+		if d.diff < 0 && len(gains) < whyReasons {
+			gains = append(gains, reason)
+		} else if d.diff > 0 && len(losses) < whyReasons {
+			losses = append(losses, reason)
+		}
+	}
+
+	fmt.Println()
+	if math.Abs(scoreDiff) < whyTolerance {
+		fmt.Println("Оценки раскладок совпадают: различия слагаемых компенсируют друг друга")
+		return nil
+	}
+	if len(gains) > 0 {
+		fmt.Printf("[%d] лучше [%d] на %.2f главным образом за счет: %s\n", nums[better], nums[worse], -scoreDiff, strings.Join(gains, ", "))
+	}
+	if len(losses) > 0 {
+		fmt.Printf("Несмотря на то, что уступает по: %s\n", strings.Join(losses, ", "))
+	}
+	return nil
+}
+
+// CommandConfigDiff сравнивает анализ раскладки N с текущей конфигурацией и с конфигурацией
+// из другого файла: значения всех показателей и вклад слагаемых в итоговую оценку
+func (ch *CommandHandler) CommandConfigDiff(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 2 {
+		return fmt.Errorf("используйте: cfgdiff file N (файл конфигурации и номер раскладки)")
+	}
+	layoutNumber, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[1])
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNumber)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+
+	otherConfig, err := LoadKeyboardConfig(parts[0])
+	if err != nil {
+		return err
+	}
+
+	current := AnalyzeLayout(layout, ch.config, ch.langData)
+	other := AnalyzeLayout(layout, otherConfig, ch.langData)
+
+	// Разница округляется, чтобы погрешности вычислений не выводились как -0.00
+	diff := func(currentValue, otherValue float64) float64 {
+		return math.Round((otherValue-currentValue)*100)/100 + 0
+	}
+
+	fmt.Printf("[%d] %s: текущая конфигурация и %s\n", layoutNumber, layout.Name, parts[0])
+	fmt.Printf("%-12s %10s %10s %10s\n", "Metric", "Текущая", "Файл", "Разница")
+	fmt.Println(strings.Repeat("-", 45))
+	for _, metric := range tableColumnNames() {
+		if metric == "Score" {
+			continue
+		}
+		currentValue, _ := MetricValue(current, metric)
+		otherValue, _ := MetricValue(other, metric)
+		fmt.Printf("%-12s %10.2f %10.2f %+10.2f\n", metric, currentValue, otherValue, diff(currentValue, otherValue))
+	}
+
+	fmt.Println()
+	fmt.Println("Вклад слагаемых в оценку:")
+	fmt.Printf("%-12s %10s %10s %10s\n", "Term", "Текущая", "Файл", "Разница")
+	fmt.Println(strings.Repeat("-", 45))
+	otherTerms := ScoreTerms(otherConfig, other)
+	for i, term := range ScoreTerms(ch.config, current) {
+		otherContribution := otherTerms[i].Contribution()
+		fmt.Printf("%-12s %10.2f %10.2f %+10.2f\n", term.Name, term.Contribution()+0, otherContribution+0, diff(term.Contribution(), otherContribution))
+	}
+	fmt.Println(strings.Repeat("-", 45))
+	fmt.Printf("%-12s %10.2f %10.2f %+10.2f\n", "Score", current.WeightedScore, other.WeightedScore, diff(current.WeightedScore, other.WeightedScore))
+
+	return nil
+}
+
+// multilangColumnWidth ширина колонки одного файла языка в таблице multilang
+const multilangColumnWidth = 12
 
-	for i := range bigramLists {
-		if len(bigramLists[i]) > numRows {
-			bigramLists[i] = bigramLists[i][:numRows]
+// CommandMultiLang выводит показатели раскладки N для нескольких файлов языка рядом,
+// чтобы были видны компромиссы раскладки между языками. Файлы анализируются по
+// отдельности с текущей конфигурацией, без смешивания статистики
+func (ch *CommandHandler) CommandMultiLang(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 2 {
+		return fmt.Errorf("используйте: multilang N file1 [file2 ...] (номер раскладки и файлы языка)")
+	}
+	layoutNumber, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNumber)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+
+	files := parts[1:]
+	analyses := make([]*LayoutAnalysis, len(files))
+	names := make([]string, len(files))
+	for i, file := range files {
+		langData, err := LoadLanguageData(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		analyses[i] = AnalyzeLayout(layout, ch.config, langData)
+
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if runes := []rune(name); len(runes) > multilangColumnWidth {
+			name = string(runes[:multilangColumnWidth])
+		}
+		names[i] = name
+	}
+
+	lineWidth := 8 + (multilangColumnWidth+1)*len(files)
+	fmt.Printf("[%d] %s\n", layoutNumber, layout.Name)
+	fmt.Printf("%-8s", "Metric")
+	for _, name := range names {
+		fmt.Printf(" %*s", multilangColumnWidth, name)
+	}
+	fmt.Println()
+	fmt.Println(strings.Repeat("-", lineWidth))
+	for _, metric := range tableColumnNames() {
+		if metric == "Score" {
+			continue
+		}
+		fmt.Printf("%-8s", metric)
+		for _, analysis := range analyses {
+			value, _ := MetricValue(analysis, metric)
+			fmt.Printf(" %*.2f", multilangColumnWidth, value)
+		}
+		fmt.Println()
+	}
+	fmt.Println(strings.Repeat("-", lineWidth))
+	fmt.Printf("%-8s", "Coverage")
+	for _, analysis := range analyses {
+		fmt.Printf(" %*s", multilangColumnWidth, fmt.Sprintf("%.2f%%", analysis.BigramCoverage))
+	}
+	fmt.Println()
+	fmt.Printf("%-8s", "Score")
+	for _, analysis := range analyses {
+		fmt.Printf(" %*.2f", multilangColumnWidth, analysis.WeightedScore)
+	}
+	fmt.Println()
+
+	return nil
+}
+
+// CommandCharEfforts выводит вклад каждого символа раскладки в среднее усилие:
+// частоту символа, усилие его клавиши и их произведение, по убыванию вклада
+func (ch *CommandHandler) CommandCharEfforts(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 1 || len(parts) > 2 {
+		return fmt.Errorf("используйте: chars N [k] (номер раскладки и количество символов)")
+	}
+	layoutNumber, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNumber)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+	limit := 0
+	if len(parts) == 2 {
+		limit, err = strconv.Atoi(parts[1])
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("некорректное количество символов: %s", parts[1])
+		}
+	}
+
+	type charEffort struct {
+		char         string
+		row, col     int
+		freq         float64
+		effort       float64
+		contribution float64
+	}
+
+	keyPos := buildKeyPositions(layout, ch.config, ch.langData)
+	var chars []charEffort
+	totalContribution, totalFreq := 0.0, 0.0
+	for char, freq := range ch.langData.Characters {
+		pos, exists := keyPos[char]
+		if !exists {
+			continue
+		}
+		effort := keyEffort(ch.config, pos[0], pos[1])
+		chars = append(chars, charEffort{char, pos[0], pos[1], freq, effort, effort * freq})
+		totalContribution += effort * freq
+		totalFreq += freq
+	}
+	sort.Slice(chars, func(i, j int) bool {
+		if chars[i].contribution != chars[j].contribution {
+			return chars[i].contribution > chars[j].contribution
+		}
+		return chars[i].char < chars[j].char
+	})
+	if limit > 0 && len(chars) > limit {
+		chars = chars[:limit]
+	}
+
+	fmt.Printf("[%d] %s\n", layoutNumber, layout.Name)
+	fmt.Println("Символ  Позиция  Частота  Усилие   Вклад    Доля")
+	fmt.Println(strings.Repeat("-", 49))
+	for _, c := range chars {
+		position := fmt.Sprintf("R%dC%d", c.row+1, c.col+1)
+		if c.row == numberRowIndex {
+			position = fmt.Sprintf("R0C%d", c.col+1)
+		}
+		share := 0.0
+		if totalContribution > 0 {
+			share = c.contribution / totalContribution * 100
+		}
+		fmt.Printf("%-6s  %-7s  %6.2f%%  %6.2f  %7.4f  %5.1f%%\n", c.char, position, c.freq*100, c.effort, c.contribution, share)
+	}
+	if totalFreq > 0 {
+		fmt.Printf("Среднее усилие на нажатие (без нормировки): %.3f\n", totalContribution/totalFreq)
+	}
+
+	return nil
+}
+
+// Параметры подбора веса командой tune
+const (
+	tuneDoublings        = 6    // Максимальное количество удвоений веса при поиске верхней границы
+	tuneBisections       = 5    // Количество шагов деления отрезка пополам
+	tuneSearchRestarts   = 2    // Рестарты сокращенного поиска для каждого значения веса
+	tuneSearchIterations = 3000 // Итерации сокращенного поиска для каждого значения веса
+)
+
+// tunableWeight возвращает вес с тем же названием, что и показатель в таблицах l и lb
+func tunableWeight(weights *WeightConfig, metric string) *float64 {
+	switch metric {
+	case "HDI":
+		return &weights.HDI
+	case "FDI":
+		return &weights.FDI
+	case "SHB":
+		return &weights.SHB
+	case "SFB":
+		return &weights.SFB
+	case "HVB":
+		return &weights.HVB
+	case "FVB":
+		return &weights.FVB
+	case "HDB":
+		return &weights.HDB
+	case "FDB":
+		return &weights.FDB
+	case "HFB":
+		return &weights.HFB
+	case "HSB":
+		return &weights.HSB
+	case "FSB":
+		return &weights.FSB
+	case "LSB":
+		return &weights.LSB
+	case "SRB":
+		return &weights.SRB
+	case "AFI":
+		return &weights.AFI
+	case "AFO":
+		return &weights.AFO
+	}
+	return nil
+}
+
+// CommandTune подбирает вес показателя так, чтобы у лучшей раскладки сокращенного поиска
+// значение показателя не превышало целевое: вес удваивается до достижения цели, затем
+// отрезок между последним неудачным и удачным значением делится пополам
+func (ch *CommandHandler) CommandTune(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 2 || len(parts) > 3 || !strings.HasPrefix(parts[1], "target=") {
+		return fmt.Errorf("используйте: tune METRIC target=VALUE [N] (например, tune SFB target=1.0)")
+	}
+	metric := strings.ToUpper(parts[0])
+	weight := tunableWeight(&ch.config.Weights, metric)
+	if weight == nil {
+		return fmt.Errorf("показатель %s не поддерживается, допустимо: HDI FDI SHB SFB HVB FVB HDB FDB HFB HSB FSB LSB SRB AFI AFO", parts[0])
+	}
+	target, err := strconv.ParseFloat(strings.TrimPrefix(parts[1], "target="), 64)
+	if err != nil {
+		return fmt.Errorf("некорректное целевое значение: %s", strings.TrimPrefix(parts[1], "target="))
+	}
+
+	var startLayout *Layout
+	if len(parts) == 3 {
+		layoutNum, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return fmt.Errorf("некорректный номер раскладки: %s", parts[2])
+		}
+		layout, exists := ch.getLayoutByIndex(layoutNum)
+		if !exists {
+			return fmt.Errorf("раскладка с номером %d не найдена", layoutNum)
+		}
+		startLayout = layout
+	} else if len(ch.layouts.Layouts) == 0 {
+		return fmt.Errorf("нет загруженных раскладок для определения алфавита")
+	}
+
+	// evaluate выполняет сокращенный поиск с заданным значением веса и возвращает
+	// лучшую найденную раскладку и значение показателя для нее
+	evaluate := func(value float64) (SimulatedAnnealingResult, float64) {
+		*weight = value
+		params := ch.searchParams()
+		params.Restarts = tuneSearchRestarts
+		params.Iterations = tuneSearchIterations
+		var results []SimulatedAnnealingResult
+		if startLayout != nil {
+			results = SearchOptimalLayoutFromSpecificLayout(ch.config, ch.langData, ch.layouts, params, 1, *startLayout)
+		} else {
+			results = SearchOptimalLayoutFromRandomLayout(ch.config, ch.langData, ch.layouts, params, 1)
+		}
+		result := results[0]
+		if result.Analysis == nil {
+			result.Analysis = AnalyzeLayout(&result.Layout, ch.config, ch.langData)
+		}
+		metricValue, _ := MetricValue(result.Analysis, metric)
+		fmt.Printf("Вес %s = %g: %s лучшей раскладки %.2f\n", metric, value, metric, metricValue)
+		return result, metricValue
+	}
+
+	original := *weight
+	low := original
+	best, value := evaluate(low)
+	if value > target {
+		high := low * 2
+		if high <= 0 {
+			high = 1
+		}
+		found := false
+		for i := 0; i < tuneDoublings; i++ {
+			if best, value = evaluate(high); value <= target {
+				found = true
+				break
+			}
+			low, high = high, high*2
+		}
+		if !found {
+			*weight = original
+			return fmt.Errorf("не удалось достичь %s <= %g, вес %s оставлен равным %g", metric, target, metric, original)
+		}
+
+		// Делим отрезок пополам, сохраняя наименьший вес, при котором цель достигнута
+		for i := 0; i < tuneBisections; i++ {
+			middle := (low + high) / 2
+			if result, middleValue := evaluate(middle); middleValue <= target {
+				high, best, value = middle, result, middleValue
+			} else {
+				low = middle
+			}
+		}
+		*weight = high
+		ch.configTracker.SetWeight(metric, high)
+	}
+
+	fmt.Printf("\nВес %s: %g (было %g), %s лучшей раскладки: %.2f (цель %g)\n", metric, *weight, original, metric, value, target)
+
+	// Сохраняем лучшую раскладку последнего удачного поиска во временный буфер [0]
+	bestLayout := best.Layout
+	ch.searchResultLayout = &bestLayout
+	ch.isInvertedLayoutActive = false
+	ch.invertedLayout = nil
+
+	return nil
+}
+
+// balanceBigramTerms слагаемые оценки, которые команда balance относит к биграммам
+var balanceBigramTerms = map[string]bool{
+	"SHB": true, "SFB": true, "HVB": true, "FVB": true, "HDB": true, "FDB": true, "HFB": true, "HSB": true,
+	"FSB": true, "LSB": true, "SRB": true, "AFI": true, "AFO": true, "ICS": true, "SymSFB": true, "RowJump": true,
+	"LSB_all": true, "SKB": true,
+}
+
+// balanceBigramWeights возвращает веса биграмм, которые команда balance масштабирует одним
+// множителем, с именами, используемыми в ConfigChangeTracker
+func balanceBigramWeights(weights *WeightConfig) []struct {
+	name  string
+	value *float64
+} {
+	return []struct {
+		name  string
+		value *float64
+	}{
+		{"SHB", &weights.SHB}, {"SFB", &weights.SFB}, {"HVB", &weights.HVB}, {"FVB", &weights.FVB},
+		{"HDB", &weights.HDB}, {"FDB", &weights.FDB}, {"HFB", &weights.HFB}, {"HSB", &weights.HSB},
+		{"FSB", &weights.FSB}, {"LSB", &weights.LSB}, {"SRB", &weights.SRB}, {"AFI", &weights.AFI},
+		{"AFO", &weights.AFO}, {"ICS", &weights.ICS}, {"SymSFB", &weights.SymSFB}, {"RowJumpNorm", &weights.RowJumpNorm},
+		{"LSBAll", &weights.LSBAll}, {"SKB", &weights.SKB},
+	}
+}
+
+// CommandBalance задает соотношение усилия и биграмм в оценке раскладки N: вес усилия
+// TotalEffortNorm и все веса биграмм пропорционально изменяются так, чтобы при той же
+// суммарной величине усилие составляло долю x, а биграммы - долю 1-x
+func (ch *CommandHandler) CommandBalance(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 1 || len(parts) > 2 {
+		return fmt.Errorf("используйте: balance x [N] (x - доля усилия от 0 до 1, N - номер раскладки, по умолчанию 1)")
+	}
+	share, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || share < 0 || share > 1 {
+		return fmt.Errorf("некорректная доля усилия: %s (допустимо от 0 до 1)", parts[0])
+	}
+	layoutNumber := 1
+	if len(parts) == 2 {
+		layoutNumber, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("некорректный номер раскладки: %s", parts[1])
+		}
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNumber)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+
+	// Текущие вклады усилия и биграмм в оценку раскладки
+	analysis := AnalyzeLayout(layout, ch.config, ch.langData)
+	effort, bigrams := 0.0, 0.0
+	for _, term := range ScoreTerms(ch.config, analysis) {
+		if term.Name == "Effort" {
+			effort = term.Contribution()
+		} else if balanceBigramTerms[term.Name] {
+			bigrams += term.Contribution()
+		}
+	}
+	if effort <= 0 || bigrams <= 0 {
+		return fmt.Errorf("вклады усилия (%.2f) и биграмм (%.2f) в оценку раскладки [%d] должны быть положительными", effort, bigrams, layoutNumber)
+	}
+
+	total := effort + bigrams
+	effortScale := share * total / effort
+	bigramScale := (1 - share) * total / bigrams
+
+	weights := &ch.config.Weights
+	fmt.Printf("Раскладка [%d]: усилие %.2f (%.0f%%), биграммы %.2f (%.0f%%)\n", layoutNumber, effort, effort/total*100, bigrams, bigrams/total*100)
+	fmt.Printf("%-16s %10s %10s\n", "Вес", "Было", "Стало")
+	fmt.Println(strings.Repeat("-", 38))
+	fmt.Printf("%-16s %10.4f %10.4f\n", "TotalEffortNorm", weights.TotalEffortNorm, weights.TotalEffortNorm*effortScale)
+	weights.TotalEffortNorm *= effortScale
+	ch.configTracker.SetWeight("TotalEffortNorm", weights.TotalEffortNorm)
+	for _, weight := range balanceBigramWeights(weights) {
+		if *weight.value == 0 {
+			continue
+		}
+		fmt.Printf("%-16s %10.4f %10.4f\n", weight.name, *weight.value, *weight.value*bigramScale)
+		*weight.value *= bigramScale
+		ch.configTracker.SetWeight(weight.name, *weight.value)
+	}
+	fmt.Println(strings.Repeat("-", 38))
+	fmt.Printf("Новое соотношение: усилие %.2f (%.0f%%), биграммы %.2f (%.0f%%)\n", effort*effortScale, share*100, bigrams*bigramScale, (1-share)*100)
+
+	return nil
+}
+
+// CommandBaseline фиксирует текущие значения коэффициентов как базовые: изменения,
+// сделанные командами set, balance и bic, больше не считаются изменениями и не
+// применяются повторно при перезагрузке конфигурации командами r и rr
+func (ch *CommandHandler) CommandBaseline(args string) error {
+	if strings.TrimSpace(args) != "" {
+		return fmt.Errorf("используйте: baseline (без аргументов)")
+	}
+
+	names := ch.configTracker.GetModifiedWeightNames()
+	bigramCoeffsModified := ch.configTracker.IsBigramCoeffsModified()
+	if len(names) == 0 && !bigramCoeffsModified {
+		fmt.Println("Измененных коэффициентов нет, базовые значения не изменились")
+		return nil
+	}
+
+	ch.configTracker.CommitModifications()
+
+	if len(names) > 0 {
+		fmt.Println("Новыми базовыми значениями стали коэффициенты:", strings.Join(names, ", "))
+	}
+	if bigramCoeffsModified {
+		fmt.Println("Новыми базовыми значениями стали индивидуальные коэффициенты биграмм")
+	}
+	fmt.Println("При перезагрузке конфигурации (r, rr) значения будут взяты из файла без повторного применения изменений")
+	return nil
+}
+
+// defaultVowels гласные, используемые командой vc, если в конфигурации не задан параметр vowels
+const defaultVowels = "аеёиоуыэюяaeiouy"
+
+// CommandFingerBalance выводит нагрузку на каждую пару симметричных пальцев, ее разницу
+// и вклад пары в FDI, чтобы было видно, какую пару и в какую сторону нужно выравнивать
+func (ch *CommandHandler) CommandFingerBalance(args string) error {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return fmt.Errorf("используйте: fingerbalance N (номер раскладки)")
+	}
+	layoutNumber, err := strconv.Atoi(args)
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", args)
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNumber)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+
+	analysis := AnalyzeLayout(layout, ch.config, ch.langData)
+
+	fmt.Printf("[%d] %s\n", layoutNumber, layout.Name)
+	fmt.Println("Пара     Левый  Правый  Разница  Коэф.    Вклад в FDI")
+	maxPair, maxContribution := -1, 0.0
+	for i, pair := range mirroredFingerPairs {
+		left := analysis.EffortByFinger[pair.left]
+		right := analysis.EffortByFinger[pair.right]
+		weight := pair.weight(&ch.config.Weights)
+		contribution := weight * math.Abs(left-right)
+
+		// Направление дисбаланса: какой из двух пальцев нагружен сильнее
+		direction := ""
+		if left > right {
+			direction = fmt.Sprintf("  (перегружен P%d)", pair.left+1)
+		} else if right > left {
+			direction = fmt.Sprintf("  (перегружен P%d)", pair.right+1)
+		}
+
+		fmt.Printf("P%d/P%d  %6.2f  %6.2f  %+7.2f  %-3s=%-4g %7.2f%s\n",
+			pair.left+1, pair.right+1, left, right, left-right, pair.weightName, weight, contribution, direction)
+		if contribution > maxContribution {
+			maxPair, maxContribution = i, contribution
+		}
+	}
+	fmt.Printf("FDI = %.2f\n", analysis.FDI)
+	if maxPair >= 0 {
+		pair := mirroredFingerPairs[maxPair]
+		fmt.Printf("Наибольший вклад в FDI: пара P%d/P%d\n", pair.left+1, pair.right+1)
+	}
+
+	return nil
+}
+
+// CommandVowelBalance выводит распределение частот гласных и согласных по половинкам клавиатуры
+// и долю биграмм гласная-согласная, в которых руки чередуются
+func (ch *CommandHandler) CommandVowelBalance(args string) error {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return fmt.Errorf("используйте: vc N (номер раскладки)")
+	}
+	layoutNumber, err := strconv.Atoi(args)
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", args)
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNumber)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNumber)
+	}
+
+	vowels := ch.config.Vowels
+	if vowels == "" {
+		vowels = defaultVowels
+	}
+	// Символ относится к гласным или согласным, только если это буква, возможно
+	// с комбинируемыми знаками (например, ударением)
+	isVowel := func(char string) (vowel bool, letter bool) {
+		runes := []rune(char)
+		if len(runes) == 0 || !unicode.IsLetter(runes[0]) {
+			return false, false
+		}
+		for _, mark := range runes[1:] {
+			if !unicode.Is(unicode.Mn, mark) {
+				return false, false
+			}
+		}
+		return strings.ContainsRune(vowels, unicode.ToLower(runes[0])), true
+	}
+
+	keyPos := buildKeyPositions(layout, ch.config, ch.langData)
+
+	// [0] - гласные, [1] - согласные; вторая координата - левая и правая половинки
+	var halves [2][2]float64
+	for char, freq := range ch.langData.Characters {
+		pos, exists := keyPos[char]
+		if !exists {
+			continue
+		}
+		vowel, letter := isVowel(char)
+		if !letter {
+			continue
+		}
+		kind := 1
+		if vowel {
+			kind = 0
+		}
+		halves[kind][getHalf(pos[1])] += freq
+	}
+
+	// Биграммы из гласной и согласной, набираемые разными руками
+	mixedTotal, mixedAlternating := 0.0, 0.0
+	for bigram, freq := range ch.langData.Bigrams {
+		first, second, ok := splitBigram(bigram, keyPos)
+		if !ok {
+			continue
+		}
+		pos1, exists1 := keyPos[first]
+		pos2, exists2 := keyPos[second]
+		if !exists1 || !exists2 {
+			continue
+		}
+		vowel1, letter1 := isVowel(first)
+		vowel2, letter2 := isVowel(second)
+		if !letter1 || !letter2 || vowel1 == vowel2 {
+			continue
+		}
+		mixedTotal += freq
+		if getHalf(pos1[1]) != getHalf(pos2[1]) {
+			mixedAlternating += freq
+		}
+	}
+
+	percent := func(value, total float64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return value / total * 100
+	}
+
+	fmt.Printf("[%d] %s\n", layoutNumber, layout.Name)
+	fmt.Printf("Гласные: %s\n", vowels)
+	fmt.Println("              Левая   Правая")
+	labels := [2]string{"Гласные  ", "Согласные"}
+	for kind := 0; kind < 2; kind++ {
+		total := halves[kind][0] + halves[kind][1]
+		fmt.Printf("%s   %6.2f%%  %6.2f%%\n", labels[kind], percent(halves[kind][0], total), percent(halves[kind][1], total))
+	}
+	fmt.Printf("Биграммы гласная-согласная с чередованием рук: %.2f%%\n", percent(mixedAlternating, mixedTotal))
+
+	return nil
+}
+
+// CommandPrecision выводит или изменяет количество дополнительных знаков после запятой в таблицах l и lb
+func (ch *CommandHandler) CommandPrecision(args string) error {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		fmt.Printf("Точность вывода таблиц: %d\n", ch.config.Precision)
+		return nil
+	}
+
+	value, err := strconv.Atoi(args)
+	if err != nil || value < minTablePrecision || value > maxTablePrecision {
+		return fmt.Errorf("используйте: precision [N] (N от %d до %d)", minTablePrecision, maxTablePrecision)
+	}
+
+	ch.config.Precision = value
+	fmt.Printf("Точность вывода таблиц установлена в значение: %d\n", value)
+	return nil
+}
+
+// CommandPercentDisplay выводит или изменяет режим вывода нормированной частоты биграмм в командах a и b
+func (ch *CommandHandler) CommandPercentDisplay(args string) error {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		mode := ch.config.PercentDisplay
+		if mode == "" {
+			mode = percentDisplayClamp
+		}
+		fmt.Printf("Режим вывода частоты биграмм: %s\n", mode)
+		return nil
+	}
+
+	if args != percentDisplayClamp && args != percentDisplayMark {
+		return fmt.Errorf("используйте: percent [%s|%s]", percentDisplayClamp, percentDisplayMark)
+	}
+
+	ch.config.PercentDisplay = args
+	fmt.Printf("Режим вывода частоты биграмм установлен в значение: %s\n", args)
+	return nil
+}
+
+// effortGradientColor возвращает цвет для значения усилия: от зеленого
+// (минимальное усилие) до красного (максимальное усилие)
+func effortGradientColor(value, minValue, maxValue float64) (int, int, int) {
+	relative := 0.0
+	if maxValue > minValue {
+		relative = (value - minValue) / (maxValue - minValue)
+	}
+
+	// Интерполируем цвет: зеленый (158,206,88) -> красный (215,0,0)
+	r := 158 + int(relative*(215.0-158.0))
+	g := 206 - int(relative*206.0)
+	b := 88 - int(relative*88.0)
+	return r, g, b
+}
+
+// CommandEffortMatrix выводит матрицу усилий из конфигурации с цветовым выделением,
+// а также ограничения максимальной нагрузки по пальцам и рядам
+func (ch *CommandHandler) CommandEffortMatrix(args string) error {
+	if strings.TrimSpace(args) != "" {
+		return fmt.Errorf("команда ematrix не принимает аргументов")
+	}
+
+	// Строки матрицы в порядке расположения на клавиатуре (цифровой ряд, если задан, сверху)
+	var rows [][10]float64
+	var labels []string
+	if len(ch.config.NumberRowEfforts) == 10 {
+		var numberRow [10]float64
+		copy(numberRow[:], ch.config.NumberRowEfforts)
+		rows = append(rows, numberRow)
+		labels = append(labels, "R0")
+	}
+	for row := 0; row < 3; row++ {
+		rows = append(rows, ch.config.EffortMatrix[row])
+		labels = append(labels, fmt.Sprintf("R%d", row+1))
+	}
+
+	// Определяем минимальное и максимальное усилие для нормирования цвета
+	minEffort := math.MaxFloat64
+	maxEffort := -math.MaxFloat64
+	for _, row := range rows {
+		for _, value := range row {
+			minEffort = math.Min(minEffort, value)
+			maxEffort = math.Max(maxEffort, value)
+		}
+	}
+
+	fmt.Printf("Матрица усилий (min %.1f, max %.1f):\n", minEffort, maxEffort)
+	for i, row := range rows {
+		fmt.Printf("%-3s", labels[i])
+		for col, value := range row {
+			if col == ch.config.SplitCol {
+				fmt.Print(" ")
+			}
+			r, g, b := effortGradientColor(value, minEffort, maxEffort)
+			fmt.Printf(" \033[38;2;%d;%d;%dm%5.1f\033[0m", r, g, b, value)
 		}
+		fmt.Println()
 	}
 
-	// Восстанавливаем срезы
-	shbBigrams, sfbBigrams, hvbBigrams, fvbBigrams, hdbBigrams, fdbBigrams, hfbBigrams, hsbBigrams, fsbBigrams, lsbBigrams, srbBigrams, afiBigrams, afoBigrams =
-		bigramLists[0], bigramLists[1], bigramLists[2], bigramLists[3], bigramLists[4], bigramLists[5], bigramLists[6], bigramLists[7], bigramLists[8], bigramLists[9], bigramLists[10], bigramLists[11], bigramLists[12]
+	fmt.Println()
+	fmt.Println("Максимальная нагрузка по пальцам (0 - без ограничения):")
+	fmt.Print("   ")
+	for finger := 0; finger < 8; finger++ {
+		fmt.Printf(" %5s", fmt.Sprintf("F%d", finger+1))
+	}
+	fmt.Println()
+	fmt.Print("max")
+	for _, value := range ch.config.MaxFingerEfforts {
+		fmt.Printf(" %5.1f", value)
+	}
+	fmt.Println()
+	fmt.Print("pen")
+	for _, value := range ch.config.FingerEffortPenalties {
+		fmt.Printf(" %5.1f", value)
+	}
+	fmt.Println()
+
+	fmt.Println()
+	fmt.Println("Максимальная нагрузка по рядам (0 - без ограничения):")
+	fmt.Printf("    %5s %5s %5s\n", "R1", "R2", "R3")
+	fmt.Printf("max %5.1f %5.1f %5.1f\n", ch.config.MaxRowEfforts[0], ch.config.MaxRowEfforts[1], ch.config.MaxRowEfforts[2])
+	fmt.Printf("pen %5.1f %5.1f %5.1f\n", ch.config.RowEffortPenalties[0], ch.config.RowEffortPenalties[1], ch.config.RowEffortPenalties[2])
+
+	return nil
+}
+
+// CommandSession сохраняет или восстанавливает состояние сессии (раскладки, конфигурация, выделения и буфер [0])
+func (ch *CommandHandler) CommandSession(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 2 || (parts[0] != "save" && parts[0] != "load") {
+		return fmt.Errorf("используйте: session save|load файл.json")
+	}
+	fileName := parts[1]
 
-	// Находим максимальную частоту среди всех биграмм для нормировки
-	maxFreq := 0.0
-	for _, bg := range allBigrams {
-		if bg.Freq > maxFreq {
-			maxFreq = bg.Freq
+	if parts[0] == "save" {
+		state := &SessionState{
+			Version:              sessionVersion,
+			Layouts:              ch.layouts,
+			Config:               ch.config,
+			BaseWeights:          ch.configTracker.GetOriginalWeights(),
+			ModifiedWeights:      ch.configTracker.GetModifiedWeightNames(),
+			BigramCoeffsModified: ch.configTracker.IsBigramCoeffsModified(),
+			Highlighted:          sortedHighlighted(ch.highlightedLayouts),
+		}
+		if layout, exists := ch.getLayoutByIndex(0); exists {
+			state.Buffer = layout
 		}
-	}
 
-	// Находим максимальную частоту среди всех выводимых биграмм для подсветки
-	maxFreqInTable := 0.0
-	lists := [][]BigramFreq{shbBigrams, sfbBigrams, hvbBigrams, fvbBigrams, hdbBigrams, fdbBigrams, hfbBigrams, hsbBigrams, fsbBigrams, lsbBigrams, srbBigrams, afiBigrams, afoBigrams}
-	for _, list := range lists {
-		for _, bg := range list {
-			if bg.Freq > maxFreqInTable {
-				maxFreqInTable = bg.Freq
-			}
+		if err := SaveSession(state, fileName); err != nil {
+			return err
 		}
+		fmt.Printf("Сессия сохранена в файл %s\n", fileName)
+		return nil
 	}
 
-	// Подготавливаем и выводим numRows строк биграмм для каждого типа с цветовой подсветкой
-	for i := 0; i < numRows; i++ {
-		if i < 1 {
-			fmt.Printf("%-4s %-16s", fmt.Sprintf("[%d]", analysis.LayoutIndex), analysis.LayoutName)
-		} else {
-			fmt.Printf("%-4s %-16s", "", "")
-		}
+	state, err := LoadSession(fileName)
+	if err != nil {
+		return err
+	}
 
-		// Проверяем и выводим биграммы для каждого типа с цветовой подсветкой
-		for _, list := range lists {
-			if i < len(list) {
-				bigram := list[i].Bigram
-				freq := list[i].Freq
-				colorizedBigram := ch.colorizeBigramByFrequency(bigram, freq, maxFreq, maxFreqInTable)
-				fmt.Printf("%-7s ", colorizedBigram)
-			} else {
-				fmt.Printf("%-7s ", "")
-			}
-		}
+	// Пересоздаем трекер изменений, чтобы последующие перезагрузки сохраняли измененные веса
+	tracker := NewConfigChangeTracker(state.BaseWeights)
+	tracker.RestoreModifiedWeights(state.Config.Weights, state.ModifiedWeights)
+	if state.BigramCoeffsModified {
+		tracker.SetBigramIndividualCoeffs(state.Config.BigramIndividualCoeffs)
+	}
 
-		// Не выводим Total и Score для этих строк, только биграммы
-		fmt.Println()
+	ch.layouts = state.Layouts
+	ch.config = state.Config
+	ch.configTracker = tracker
+	ch.analyses = nil
+	ch.bestResults = make([]SimulatedAnnealingResult, 0)
+	ch.searchResultLayout = state.Buffer
+	ch.invertedLayout = nil
+	ch.isInvertedLayoutActive = false
+	ch.highlightedLayouts = make(map[int]bool)
+	for _, index := range state.Highlighted {
+		ch.highlightedLayouts[index] = true
 	}
+
+	fmt.Printf("Сессия загружена из файла %s (раскладок: %d)\n", fileName, len(ch.layouts.Layouts))
+	return nil
 }
 
 // printHelp выводит справку по командам
 func printHelp() {
 	helpText := `Доступные команды:
   - p [N,M,L-K]   - Вывести раскладки (все или указанные)
-  - l [N,M,L-K]   - Анализ раскладок (все или указанные)
-  - lb [N,M,L-K]  - Анализ биграмм (все или указанные)
-  - ll [N,M,L-K]  - Анализ раскладок и биграмм (все или указанные)
+  - l [N,M,L-K] [sort=COL]  - Анализ раскладок (все или указанные), sort=COL сортирует по колонке таблицы (sort=-COL - по убыванию), по умолчанию по Score
+  - lb [N,M,L-K] [sort=COL] - Анализ биграмм (все или указанные), sort=COL сортирует по колонке таблицы (например, sort=SFB)
+  - ll [N,M,L-K] [sort=COL] - Анализ раскладок и биграмм (все или указанные), sort=COL сортирует по колонке любой из таблиц
+  - md [N,M,L-K] [sort=COL] - Вывести таблицы l и lb (все или указанные раскладки) в формате Markdown
   - h [N,M,L-K]   - Выделить раскладки N, M и диапазон от L до K желтым цветом, снять выделение с этих раскладок, или, без аргумента снять все выделения
   - a N [n]       - Подробный анализ раскладки N, можно отдельно указать количество строк для вывода в таблицах со статистикой
   - b N [string]  - Визуализация статистики по биграммам для раскладки N, можно указать строку символов для визуализации
-  - g [N]         - Поиск оптимальной раскладки, можно указать номер базовой раскладки для поиска
-  - gg [N] [file] - Непрерывный поиск оптимальной раскладки, можно указать номер базовой раскладки для поиска и имя файла для сохранения найденных раскладок
+  - topbigrams N [k] - Вывести k биграмм раскладки N с наибольшим вкладом во взвешенную оценку биграмм и метрики, которые их учитывают (по умолчанию k = 20)
+  - variants N k файл - Создать k различных вариантов раскладки N случайными перестановками незафиксированных клавиш и дописать их в файл
+  - sample k        - Сгенерировать k случайных раскладок из символов загруженных раскладок и вывести распределение их оценки Score (минимум, среднее, медиана, максимум, гистограмма) и долю случайных раскладок, которые хуже каждой из загруженных
+  - greedy [N]     - Построить жадную раскладку в буфер [0]: самые частые символы на самых легких позициях (символы и фиксированные позиции берутся из раскладки N, по умолчанию 1)
+  - dist N M       - Сравнить расположение клавиш раскладок N и M: символы на тех же позициях и пальцах, сходство (0-1) и отличающиеся позиции
+  - coverage N     - Вывести долю биграмм раскладки N для каждого показателя и проверить, складываются ли составляющие в родительские показатели (SHB и чередование рук, SFB = HVB + FVB + HDB + FDB + HFB + SKB и т.д.), с непокрытыми биграммами и пересечениями показателей
+  - vc N           - Показать распределение гласных и согласных по половинкам и долю биграмм гласная-согласная с чередованием рук (гласные задаются параметром vowels)
+  - fingerbalance N - Показать нагрузку на симметричные пары пальцев (P1/P8, P2/P7, P3/P6, P4/P5), их разницу и вклад каждой пары в FDI
+  - chars N [k]    - Показать вклад символов раскладки N в усилие (частота x усилие клавиши) по убыванию, k - количество символов
+  - scoreb N       - Показать слагаемые итоговой оценки раскладки N: значение показателя, вес и вклад в Score по убыванию вклада
+  - why N M - Объяснить, почему одна из раскладок N и M оценивается лучше: слагаемые оценки, вклад которых различается, по убыванию абсолютной разницы и основные слагаемые, за счет которых лучшая раскладка выигрывает и по которым уступает
+  - comfort [N] - Оценка удобства раскладки N от 0 до 100 с фиксированными коэффициентами, не зависящими от весов конфигурации (SFB, ножницы, боковые растяжения, нагрузка на мизинцы и баланс рук), без N - оценки всех раскладок
+  - cfgdiff file N - Сравнить показатели и вклад слагаемых в оценку раскладки N с текущей конфигурацией и с конфигурацией из файла
+  - multilang N file1 [file2 ...] - Показатели и оценка раскладки N для каждого из файлов языка рядом (файлы не смешиваются)
+  - columns [NAMES|reset] - выбрать колонки таблиц l и lb (без аргументов - показать выбор)
+  - rel [qwerty|йцукен|off] - Добавить в таблицы l и lb колонку Rel с улучшением Score в процентах относительно встроенной раскладки (без аргумента - подходящей к алфавиту языка), off - убрать колонку
+  - keymap N [qmk|zmk] - Вывести раскладку N в виде фрагмента слоя keymap для прошивки QMK (по умолчанию) или ZMK, кириллица выводится кодами клавиш раскладки ЙЦУКЕН
+  - exclude [chars|bigrams XY ...|list] - Исключить символы (вместе с биграммами, в которые они входят) или отдельные биграммы из анализа (частоты остальных символов и биграмм перенормируются) или вывести список исключенных
+  - include [chars|bigrams [XY ...]] - Вернуть символы или биграммы в анализ (include bigrams без списка возвращает все биграммы), без аргументов вернуть все исключенные символы и биграммы
+  - threshold [METRIC VALUE|METRIC >VALUE|METRIC off|clear] - Задать порог показателя таблиц l и lb: ячейки в пределах порога выводятся зеленым, остальные красным
+  - history [save file] - Вывести изменения за сессию (sw, inv, set, n, d, s, sort) или сохранить их в файл
+  - freq [on|off] - Включить или выключить вывод частот символов (в процентах) под клавишами в командах p и a
+  - g [N] [file] [T] [expr="..."] - Поиск оптимальной раскладки, можно указать номер базовой раскладки для поиска, имя файла, в который добавляются найденные раскладки, время поиска T (например, 10s или 2m), в течение которого выполняются рестарты, и целевую функцию expr вместо общей оценки (например, expr="0.5*SFB + 2*HSB + Effort": колонки таблиц l и lb, слагаемые оценки, + - * / ^, скобки, abs, sqrt, min, max; раскладки, для которых выражение не определено, например при делении на ноль, получают значение +Inf). Ctrl+C прерывает поиск, найденные к этому моменту раскладки сохраняются в буфер [0]
+  - gg [N] [file] [min=V] - Непрерывный поиск оптимальной раскладки, можно указать номер базовой раскладки для поиска, имя файла для сохранения найденных раскладок и минимальное улучшение оценки для новой лучшей раскладки (по умолчанию gg_min_improvement). Без доступа к терминалу выполняется 10 итераций
+  - beat k [N] [file] [T] - Поиск оптимальной раскладки с параметрами как у g, в буфер [0] и файл попадают только раскладки лучше k-й лучшей из загруженных, остальные результаты отбрасываются
+  - gf N [M]      - Поиск оптимальной раскладки от раскладки N с закреплением букв за пальцами (перестановки только между клавишами одного пальца), можно указать количество результатов M
+  - gh left|right [N] [M] - Поиск оптимальной раскладки от раскладки N (по умолчанию 1, 0 - временная) с перестановками только внутри левой (колонки 1-5) или правой (колонки 6-10) половины, можно указать количество результатов M
+  - ghr N [M]      - Поиск оптимальной раскладки от раскладки N (0 - временная) с закрепленным средним рядом (перестановки только в верхнем и нижнем рядах), можно указать количество результатов M
   - n N имя       - Переименовать раскладку N в новое имя
-  - inv [N]       - Инвертирование активной или указанной раскладке
+  - inv [N] [stats] - Инвертирование активной или указанной раскладке, stats - вывести анализ исходной и инвертированной раскладок
+  - orient N [swap] - Сравнение раскладки N с ее зеркальной копией (с swap также с вариантами с переставленными половинками), лучшая ориентация записывается во временную раскладку [0]
+  - moved N        - Вывести раскладку N рядом с временной раскладкой [0], клавиши [0] выделяются цветом: серым - на месте, желтым - перемещенные, зеленым - отсутствующие в раскладке N
   - sw [N] ab     - Перестановка двух букв в активной или указанной раскладке
-  - s [N] [name]  - Сохранить активную раскладку или указанной раскладки с текущим или указанным именем в файл
+  - sw? [N] ab    - Предварительный просмотр перестановки двух букв с анализом без изменения буфера [0]
+  - closeto T N [depth] - Найти наименьшее количество перестановок незафиксированных клавиш раскладки N (до depth, по умолчанию 2, не больше 5), после которых ее оценка лучше оценки раскладки T: перебираются все одиночные перестановки, затем 30 лучших вариантов дополняются следующей перестановкой, результат сохраняется во временный буфер [0]
+  - place c N - Найти лучшую позицию для символа c в раскладке N: символ ставится на каждую незафиксированную позицию, вытесненная клавиша переносится на прежнее место символа (или на свободную позицию, иначе убирается из раскладки), выводятся 5 лучших позиций, лучшая раскладка сохраняется во временный буфер [0]
+  - edit [N]      - Интерактивно редактировать раскладку N (по умолчанию [0]): стрелки перемещают курсор, символ ставится в позицию курсора с обменом, Esc сохраняет результат в буфер [0], Ctrl+C - отмена
+  - s [N] [name]  - Сохранить активную раскладку или указанной раскладки с текущим или указанным именем в файл (раскладка с уже имеющимся в файле расположением клавиш не сохраняется)
   - d [N,M,L-K]   - Удалить раскладки (по номерам или диапазонам)
   - sort          - Сортировать раскладки по возрастанию общей оценки и перезаписать файл
+  - reseat        - Нормализовать файл с раскладками (удалить пустые раскладки, задать имена безымянным, выровнять форматирование)
+  - verify        - Проверить, что активная и загруженные раскладки записываются в файл и читаются обратно без изменений (s и sort проверяют файл перед заменой и при ошибке оставляют его без изменений)
+  - append file     - Добавить к загруженным раскладкам раскладки из другого файла (в p выводится исходный файл, совпадающие имена дополняются именем файла); команды d, n, reseat, watch и sort в исходный файл, перезаписывающие файл раскладок, после этого недоступны
   - c             - Вывести используемые коэффициенты из конфигурационного файла
   - set N value   - Установить коэффициент N в значение value
+  - set? N value  - Показать, как изменятся оценки и места загруженных раскладок при коэффициенте N = value, не изменяя его
+  - bic import|export file - Загрузить индивидуальные коэффициенты биграмм из файла (.json в формате bigram_coeffs или строки "pos1 pos2 coeff") вместо текущих или сохранить текущие в файл
+  - tune METRIC target=V [N] - Подобрать вес показателя (SFB, LSB и др.) так, чтобы у лучшей раскладки сокращенного поиска значение было не больше V; N - исходная раскладка поиска, результат сохраняется в буфер [0]
+  - balance x [N]  - Пропорционально изменить вес усилия TotalEffortNorm и веса биграмм так, чтобы в оценке раскладки N (по умолчанию 1) усилие составляло долю x (от 0 до 1), а биграммы - долю 1-x
+  - baseline       - Зафиксировать текущие значения коэффициентов как базовые: изменения больше не применяются повторно при перезагрузке конфигурации (r, rr)
   - r             - Перезагрузить файл конфигурации и файл с раскладками
+  - rr            - Перезагрузить файл конфигурации и файл языка без файла раскладок, сохранив временную раскладку [0] и выделенные раскладки, и вывести оценку [0] с новой конфигурацией
+  - watch [N,M,L-K] - Перезагружать раскладки и выводить таблицу ll при каждом изменении файла раскладок
   - t             - Вывести тестовую информацию
+  - langinfo      - Вывести сведения о файле языка (источник, объем текста, количество символов и биграмм)
+  - ematrix       - Вывести матрицу усилий из конфигурации с цветовым выделением и ограничения нагрузки по пальцам и рядам
+  - precision [N] - Вывести или изменить количество дополнительных знаков после запятой в таблицах l и lb (от -1 до 3, 0 - по умолчанию)
+  - percent [clamp|mark] - Вывести или изменить режим вывода нормированной частоты биграмм в командах a и b: clamp - значения больше 99 выводятся как 99, mark - значения от 99.5 выводятся как **
+  - session save|load file - Сохранить или восстановить состояние сессии (раскладки, конфигурация, выделения, буфер [0]) в JSON файле
+  - buf [list|save N [имя]|load N|show N|del N] - Слоты для временного хранения раскладок в памяти: скопировать буфер [0] в слот N, загрузить слот в [0], показать или очистить слот
   - help          - Справка по командам
   - exit/quit/q   - Выход
 
@@ -3110,6 +6239,9 @@ func printHelp() {
   F1-F8  - Нагрузка по пальцам
   HDI    - Hand Disbalance Index. Дисбаланс в нагрузке по рукам.
   FDI    - Finger Disbalance Index. Дисбаланс в нагрузке по пальцам.
+  FSD    - Finger Standard Deviation. Стандартное отклонение нагрузки по восьми пальцам.
+  Pinky  - Суммарная нагрузка на мизинцы.
+  Home   - Доля нажатий на домашние позиции (по умолчанию 8 клавиш среднего ряда без центральных колонок).
   Effort - Суммарная нагрузка на пальцы по раскладке.
   Score  - Общая оценка раскладки с учетом нагрузки по пальцам и по биграммам.
 
@@ -3147,6 +6279,10 @@ func printHelp() {
            ряду нажимаются по направлению к центру (движение от внешней клавиши к внутренней).
   AFO    - Adjacent Fingers Out. Процент биграмм, при которых соседние клавиши в одном
            ряду нажимаются по направлению от центра (движение от внутренней клавиши к внешней).
+  SKB    - Same Key Bigrams. Процент биграмм из двух одинаковых символов, то есть повторных
+           нажатий одной клавиши.
+  SymSFB - Symmetric Same Finger Bigrams. Процент биграмм, набираемых одним и тем же пальцем
+           без учета руки (например, левым и правым указательным подряд).
   Total  - Взвешенная сумма с учетом коэффициентов по биграммам.
   Score  - Общая оценка раскладки с учетом нагрузки по пальцам и по биграммам.
 `
@@ -3187,32 +6323,333 @@ func (ch *CommandHandler) saveLayoutToFile(layout Layout, fileName string) error
 	}
 
 	// Записываем строки раскладки
+	for _, keys := range layoutFileRows(layout) {
+		line := formatLayoutRow(keys, ch.layouts.Separator)
+		if _, err := file.WriteString(line + "\n"); err != nil {
+			return fmt.Errorf("ошибка записи строки раскладки: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// CommandSwapLetters переставляет две буквы в раскладке и сохраняет результат во временный буфер [0]
+func (ch *CommandHandler) CommandSwapLetters(args string) error {
+	swappedLayout, err := ch.buildSwappedLayout(args, "sw")
+	if err != nil {
+		return err
+	}
+
+	// Выводим результат
+	fmt.Printf("\n%s\n", swappedLayout.Name)
+	fmt.Println(strings.Repeat("-", len(swappedLayout.Name)))
+	ch.printColoredLayout(swappedLayout)
+	fmt.Println()
+
+	// Сохраняем результат во временный буфер [0]
+	ch.searchResultLayout = swappedLayout
+
+	// Reset the inverted layout active flag since we now have a regular swapped layout in [0]
+	ch.isInvertedLayoutActive = false
+	// Also clear the inverted layout since it's no longer active
+	ch.invertedLayout = nil
+
+	return nil
+}
+
+// Параметры перебора команды closeto: глубина по умолчанию, наибольшая глубина и количество лучших
+// раскладок каждого уровня, которые дополняются еще одной перестановкой на следующем уровне
+const (
+	closeToDefaultDepth = 2
+	closeToMaxDepth     = 5
+	closeToBeamWidth    = 30
+)
+
+// closeToCandidate раскладка, полученная из исходной несколькими перестановками
+type closeToCandidate struct {
+	layout Layout
+	score  float64
+	swaps  []int // Номера переставленных пар позиций по порядку
+}
+
+// expandCloseToCandidates дополняет каждую раскладку уровня одной перестановкой из pairs
+// (кроме отмены последней) и возвращает раскладки следующего уровня
+func expandCloseToCandidates(candidates []closeToCandidate, pairs [][2][2]int, config *KeyboardConfig, langData *LanguageData) []closeToCandidate {
+	var next []closeToCandidate
+	for _, candidate := range candidates {
+		for i, pair := range pairs {
+			if len(candidate.swaps) > 0 && candidate.swaps[len(candidate.swaps)-1] == i {
+				continue
+			}
+			swapped := candidate.layout
+			pos1, pos2 := pair[0], pair[1]
+			swapped.Keys[pos1[0]][pos1[1]], swapped.Keys[pos2[0]][pos2[1]] = swapped.Keys[pos2[0]][pos2[1]], swapped.Keys[pos1[0]][pos1[1]]
+			next = append(next, closeToCandidate{
+				layout: swapped,
+				score:  AnalyzeLayout(&swapped, config, langData).WeightedScore,
+				swaps:  append(append([]int{}, candidate.swaps...), i),
+			})
+		}
+	}
+	sort.SliceStable(next, func(i, j int) bool { return next[i].score < next[j].score })
+	return next
+}
+
+// CommandCloseTo ищет наименьшее количество перестановок клавиш раскладки N, после которых ее оценка
+// становится лучше оценки раскладки T. Перебираются все одиночные перестановки, затем лучшие раскладки
+// дополняются второй перестановкой и так далее до глубины depth. Среди раскладок с наименьшим количеством
+// перестановок выбирается лучшая по оценке, результат сохраняется во временный буфер [0]
+func (ch *CommandHandler) CommandCloseTo(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("используйте: closeto T N [depth] (T - раскладка, которую нужно превзойти, N - изменяемая раскладка, depth - наибольшее количество перестановок, по умолчанию %d)", closeToDefaultDepth)
+	}
+
+	targetNum, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+	target, exists := ch.getLayoutByIndex(targetNum)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", targetNum)
+	}
+	layoutNum, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[1])
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNum)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNum)
+	}
+	depth := closeToDefaultDepth
+	if len(parts) == 3 {
+		depth, err = strconv.Atoi(parts[2])
+		if err != nil || depth < 1 || depth > closeToMaxDepth {
+			return fmt.Errorf("некорректная глубина перебора: %s (допустимо от 1 до %d)", parts[2], closeToMaxDepth)
+		}
+	}
+
+	targetScore := AnalyzeLayout(target, ch.config, ch.langData).WeightedScore
+	layoutScore := AnalyzeLayout(layout, ch.config, ch.langData).WeightedScore
+	fmt.Printf("[%d] %s: Score %.2f, [%d] %s: Score %.2f\n", targetNum, target.Name, targetScore, layoutNum, layout.Name, layoutScore)
+	if layoutScore < targetScore-scoreTieTolerance {
+		fmt.Printf("Раскладка [%d] уже лучше раскладки [%d], перестановки не нужны\n", layoutNum, targetNum)
+		return nil
+	}
+
+	// Перестановки выполняются только между незафиксированными клавишами, как при поиске от базовой раскладки
+	lowercaseLayout, uppercasePositions := createLowercaseLayout(layout)
+	positions := baseLayoutSwapPositions(lowercaseLayout, ch.config, layout, uppercasePositions)
+	var pairs [][2][2]int
+	for i := range positions {
+		for j := i + 1; j < len(positions); j++ {
+			pairs = append(pairs, [2][2]int{positions[i], positions[j]})
+		}
+	}
+
+	// Уровень k содержит раскладки из k перестановок, на следующий уровень переходят только
+	// closeToBeamWidth лучших раскладок, поэтому для k > 1 перебор не полный
+	level := []closeToCandidate{{layout: *lowercaseLayout, score: layoutScore}}
+	checked := 0
+	var best *closeToCandidate
+	for swapCount := 1; swapCount <= depth && best == nil; swapCount++ {
+		if len(level) > closeToBeamWidth {
+			level = level[:closeToBeamWidth]
+		}
+		level = expandCloseToCandidates(level, pairs, ch.config, ch.langData)
+		checked += len(level)
+		if len(level) > 0 && level[0].score < targetScore-scoreTieTolerance {
+			best = &level[0]
+		}
+	}
+	if best == nil {
+		fmt.Printf("Не найдено сочетаний до %d перестановок, после которых [%d] лучше [%d] (проверено раскладок: %d)\n",
+			depth, layoutNum, targetNum, checked)
+		return nil
+	}
+
+	// Перестановки применяются к исходной раскладке, зафиксированные заглавные буквы не переставляются
+	result := *layout
+	var swapNames []string
+	for _, pairIndex := range best.swaps {
+		pos1, pos2 := pairs[pairIndex][0], pairs[pairIndex][1]
+		key1, key2 := result.Keys[pos1[0]][pos1[1]], result.Keys[pos2[0]][pos2[1]]
+		swapNames = append(swapNames, key1+key2)
+		result.Keys[pos1[0]][pos1[1]], result.Keys[pos2[0]][pos2[1]] = key2, key1
+	}
+	result.Name = fmt.Sprintf("%s (sw %s)", layout.Name, strings.Join(swapNames, " "))
+	result.Source = ""
+
+	fmt.Printf("Наименьшее количество перестановок: %d (%s), Score %.2f -> %.2f, лучше [%d] на %.2f (проверено раскладок: %d)\n",
+		len(best.swaps), strings.Join(swapNames, ", "), layoutScore, best.score, targetNum, targetScore-best.score, checked)
+	fmt.Printf("\n%s\n", result.Name)
+	fmt.Println(strings.Repeat("-", len([]rune(result.Name))))
+	ch.printColoredLayout(&result)
+	fmt.Println()
+
+	// Сохраняем результат во временный буфер [0]
+	ch.searchResultLayout = &result
+	ch.isInvertedLayoutActive = false
+	ch.invertedLayout = nil
+	return nil
+}
+
+// placeShownCount количество лучших позиций, которые выводит команда place
+const placeShownCount = 5
+
+// placeCandidate раскладка, в которой символ поставлен на одну из незафиксированных позиций
+type placeCandidate struct {
+	layout    Layout
+	score     float64
+	pos       [2]int
+	displaced string // Клавиша, которую символ вытеснил с позиции
+	moved     string // Куда перенесена вытесненная клавиша ("R1C1" или пустая строка, если она убрана)
+}
+
+// CommandPlace ищет лучшую позицию для одного символа в раскладке N. Символ ставится на каждую
+// незафиксированную позицию: если он уже есть в раскладке, вытесненная клавиша переносится на его
+// прежнее место, иначе - на свободную позицию раскладки, а при ее отсутствии убирается из раскладки.
+// Лучшая по оценке раскладка сохраняется во временный буфер [0]
+func (ch *CommandHandler) CommandPlace(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 2 {
+		return fmt.Errorf("используйте: place c N (c - символ, N - номер раскладки)")
+	}
+	char := strings.ToLower(parts[0])
+
+	layoutNum, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[1])
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNum)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNum)
+	}
+
+	// Символ ставится только на незафиксированные позиции, как при поиске от базовой раскладки
+	lowercaseLayout, uppercasePositions := createLowercaseLayout(layout)
+	positions := baseLayoutSwapPositions(lowercaseLayout, ch.config, layout, uppercasePositions)
+
+	current, blank := [2]int{-1, -1}, [2]int{-1, -1}
 	for row := 0; row < 3; row++ {
-		line := ""
 		for col := 0; col < 10; col++ {
-			if col > 0 {
-				line += " "
+			key := lowercaseLayout.Keys[row][col]
+			switch {
+			case key == char:
+				current = [2]int{row, col}
+			case (key == "" || key == " ") && blank[0] < 0:
+				blank = [2]int{row, col}
 			}
-			line += layout.Keys[row][col]
-			// Добавляем дополнительный пробел между половинками (между 5 и 6 столбцом)
-			if col == 4 {
-				line += " "
+		}
+	}
+	if current[0] >= 0 {
+		movable := false
+		for _, pos := range positions {
+			if pos == current {
+				movable = true
+				break
 			}
 		}
-		if _, err := file.WriteString(line + "\n"); err != nil {
-			return fmt.Errorf("ошибка записи строки раскладки: %v", err)
+		if !movable {
+			return fmt.Errorf("символ %s находится на зафиксированной позиции R%dC%d", char, current[0]+1, current[1]+1)
+		}
+	}
+
+	layoutScore := AnalyzeLayout(layout, ch.config, ch.langData).WeightedScore
+	var candidates []placeCandidate
+	for _, pos := range positions {
+		if pos == current {
+			continue
 		}
+		candidate := placeCandidate{layout: *layout, pos: pos, displaced: layout.Keys[pos[0]][pos[1]]}
+		candidate.layout.Keys[pos[0]][pos[1]] = char
+		target := current
+		if target[0] < 0 {
+			target = blank
+		}
+		if target[0] >= 0 {
+			candidate.layout.Keys[target[0]][target[1]] = candidate.displaced
+			candidate.moved = fmt.Sprintf("R%dC%d", target[0]+1, target[1]+1)
+		}
+		candidate.score = AnalyzeLayout(&candidate.layout, ch.config, ch.langData).WeightedScore
+		candidates = append(candidates, candidate)
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("в раскладке %d нет незафиксированных позиций для символа %s", layoutNum, char)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score < candidates[j].score })
+
+	fmt.Printf("[%d] %s: Score %.2f\n", layoutNum, layout.Name, layoutScore)
+	if current[0] >= 0 {
+		fmt.Printf("Символ %s сейчас на позиции R%dC%d, проверено позиций: %d\n", char, current[0]+1, current[1]+1, len(candidates))
+	} else {
+		fmt.Printf("Символа %s нет в раскладке, проверено позиций: %d\n", char, len(candidates))
+	}
+	shown := candidates
+	if len(shown) > placeShownCount {
+		shown = shown[:placeShownCount]
+	}
+	for i, candidate := range shown {
+		displacement := fmt.Sprintf("%s убирается из раскладки", candidate.displaced)
+		if candidate.moved != "" {
+			displacement = fmt.Sprintf("%s -> %s", candidate.displaced, candidate.moved)
+		}
+		fmt.Printf(" %d. R%dC%d (%s): Score %.2f (%+.2f)\n", i+1, candidate.pos[0]+1, candidate.pos[1]+1,
+			displacement, candidate.score, candidate.score-layoutScore)
+	}
+
+	best := candidates[0]
+	if current[0] >= 0 && best.score >= layoutScore-scoreTieTolerance {
+		fmt.Printf("Текущая позиция символа %s лучше всех проверенных, раскладка не изменена\n", char)
+		return nil
+	}
+
+	result := best.layout
+	result.Name = fmt.Sprintf("%s (place %s R%dC%d)", layout.Name, char, best.pos[0]+1, best.pos[1]+1)
+	result.Source = ""
+	fmt.Printf("\n%s\n", result.Name)
+	fmt.Println(strings.Repeat("-", len([]rune(result.Name))))
+	ch.printColoredLayout(&result)
+	fmt.Println()
+
+	// Сохраняем результат во временный буфер [0]
+	ch.searchResultLayout = &result
+	ch.isInvertedLayoutActive = false
+	ch.invertedLayout = nil
+	return nil
+}
+
+// CommandSwapPreview выводит раскладку с переставленными буквами и ее анализ без сохранения в буфер [0]
+func (ch *CommandHandler) CommandSwapPreview(args string) error {
+	swappedLayout, err := ch.buildSwappedLayout(args, "sw?")
+	if err != nil {
+		return err
 	}
 
+	fmt.Printf("\n%s\n", swappedLayout.Name)
+	fmt.Println(strings.Repeat("-", len(swappedLayout.Name)))
+	ch.printColoredLayout(swappedLayout)
+	fmt.Println()
+
+	analysis := AnalyzeLayout(swappedLayout, ch.config, ch.langData)
+	analysis.LayoutName = truncateRunes(analysis.LayoutName, 20)
+
+	fmt.Println(FormatAnalysisHeader(ch.config))
+	fmt.Println(FormatAnalysisWithHighlights(analysis))
+	fmt.Println()
+	fmt.Println(FormatBigramAnalysisHeader(ch.config))
+	fmt.Println(FormatBigramAnalysisWithHighlights(analysis))
+	fmt.Println()
+
 	return nil
 }
 
-// CommandSwapLetters переставляет две буквы в раскладке и сохраняет результат во временный буфер [0]
-func (ch *CommandHandler) CommandSwapLetters(args string) error {
+// buildSwappedLayout создает копию указанной или активной раскладки с переставленными буквами
+func (ch *CommandHandler) buildSwappedLayout(args string, command string) (*Layout, error) {
 	// Парсим аргументы
 	args = strings.TrimSpace(args)
 	if args == "" {
-		return fmt.Errorf("используйте: sw [N] ab (где N - номер раскладки, ab - две буквы для перестановки)")
+		return nil, fmt.Errorf("используйте: %s [N] ab (где N - номер раскладки, ab - две буквы для перестановки)", command)
 	}
 
 	parts := strings.Fields(args)
@@ -3225,11 +6662,11 @@ func (ch *CommandHandler) CommandSwapLetters(args string) error {
 		// Синтаксис: sw N ab
 		n, err := strconv.Atoi(parts[0])
 		if err != nil {
-			return fmt.Errorf("некорректный номер раскладки: %v", err)
+			return nil, fmt.Errorf("некорректный номер раскладки: %v", err)
 		}
 
 		if n <= 0 || n > len(ch.layouts.Layouts) {
-			return fmt.Errorf("номер раскладки %d вне диапазона (1-%d)", n, len(ch.layouts.Layouts))
+			return nil, fmt.Errorf("номер раскладки %d вне диапазона (1-%d)", n, len(ch.layouts.Layouts))
 		}
 
 		layoutIndex = n - 1 // преобразуем в индекс с 0
@@ -3242,27 +6679,27 @@ func (ch *CommandHandler) CommandSwapLetters(args string) error {
 		// Получаем активную раскладку под индексом [0] с учетом всех типов временных раскладок
 		activeLayout, exists := ch.getLayoutByIndex(0)
 		if !exists || activeLayout == nil {
-			return fmt.Errorf("нет активной раскладки в буфере [0] для перестановки букв")
+			return nil, fmt.Errorf("нет активной раскладки в буфере [0] для перестановки букв")
 		}
 
 		// Используем текущую активную раскладку
 		sourceLayout = activeLayout
 	} else {
-		return fmt.Errorf("некорректное количество аргументов, используйте: sw [N] ab или sw ab")
+		return nil, fmt.Errorf("некорректное количество аргументов, используйте: %s [N] ab или %s ab", command, command)
 	}
 
-	if len([]rune(letters)) != 2 {
-		return fmt.Errorf("указанная строка \"%s\" не содержит ровно 2 буквы для перестановки", letters)
+	// Клавиши раскладки могут состоять из нескольких символов Unicode, поэтому строка
+	// разделяется на две клавиши исходной раскладки, а не на два символа
+	char1, char2, ok := splitBigram(letters, buildKeyPositions(sourceLayout, ch.config, ch.langData))
+	if !ok {
+		return nil, fmt.Errorf("указанная строка \"%s\" не содержит ровно 2 буквы для перестановки", letters)
 	}
 
-	runes := []rune(letters)
-	char1 := string(runes[0])
-	char2 := string(runes[1])
-
 	// Создаем копию раскладки для модификации
 	swappedLayout := Layout{
-		Name: sourceLayout.Name + " (sw " + char1 + char2 + ")",
-		Keys: [3][10]string{},
+		Name:      sourceLayout.Name + " (sw " + char1 + char2 + ")",
+		Keys:      [3][10]string{},
+		NumberRow: sourceLayout.NumberRow,
 	}
 
 	// Копируем ключи
@@ -3289,18 +6726,139 @@ func (ch *CommandHandler) CommandSwapLetters(args string) error {
 	}
 
 	if !foundChar1 || !foundChar2 {
-		return fmt.Errorf("не удалось найти обе буквы \"%s\" и/или \"%s\" в раскладке", char1, char2)
+		return nil, fmt.Errorf("не удалось найти обе буквы \"%s\" и/или \"%s\" в раскладке", char1, char2)
 	}
 
-	// Выводим результат
-	fmt.Printf("\n%s\n", swappedLayout.Name)
-	fmt.Println(strings.Repeat("-", len(swappedLayout.Name)))
+	return &swappedLayout, nil
+}
+
+// editorLines количество строк, которые занимает вывод редактора раскладки
+const editorLines = 6
+
+// CommandEdit открывает интерактивный редактор раскладки: стрелки перемещают курсор по сетке
+// 3x10, нажатие символа ставит его в позицию курсора, меняя местами с его прежней позицией.
+// После каждого изменения выводится оценка раскладки. Esc сохраняет результат в буфер [0],
+// Ctrl+C завершает редактирование без сохранения
+func (ch *CommandHandler) CommandEdit(args string) error {
+	layoutNum := 0
+	if strings.TrimSpace(args) != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(args))
+		if err != nil {
+			return fmt.Errorf("некорректный номер раскладки: %v", err)
+		}
+		layoutNum = n
+	}
+
+	sourceLayout, exists := ch.getLayoutByIndex(layoutNum)
+	if !exists || sourceLayout == nil {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNum)
+	}
+
+	editedLayout := Layout{
+		Name:      sourceLayout.Name + " (edit)",
+		Keys:      sourceLayout.Keys,
+		NumberRow: sourceLayout.NumberRow,
+	}
+	initialScore := AnalyzeLayout(sourceLayout, ch.config, ch.langData).WeightedScore
+
+	if err := keyboard.Open(); err != nil {
+		return fmt.Errorf("не удалось открыть клавиатуру: %w", err)
+	}
+	defer keyboard.Close()
+
+	fmt.Println("\x1b[38;2;215;100;100m\nСтрелки - перемещение, символ - поставить в позицию курсора, Esc - сохранить в буфер [0], Ctrl+C - отмена.\n\x1b[0m")
+
+	row, col := 1, 0
+	changes := 0
+	status := ""
+	ch.printEditor(&editedLayout, row, col, initialScore, status)
+
+	for {
+		char, key, err := keyboard.GetKey()
+		if err != nil {
+			return fmt.Errorf("ошибка чтения клавиатуры: %w", err)
+		}
+
+		status = ""
+		switch {
+		case key == keyboard.KeyEsc:
+			if changes == 0 {
+				fmt.Println("Раскладка не изменена")
+				return nil
+			}
+			ch.searchResultLayout = &editedLayout
+			ch.isInvertedLayoutActive = false
+			ch.invertedLayout = nil
+			fmt.Printf("Раскладка сохранена в буфер [0] (изменений: %d)\n", changes)
+			return nil
+		case key == keyboard.KeyCtrlC:
+			fmt.Println("Редактирование отменено")
+			return nil
+		case key == keyboard.KeyArrowUp:
+			row = (row + 2) % 3
+		case key == keyboard.KeyArrowDown:
+			row = (row + 1) % 3
+		case key == keyboard.KeyArrowLeft:
+			col = (col + 9) % 10
+		case key == keyboard.KeyArrowRight:
+			col = (col + 1) % 10
+		case char != 0:
+			symbol := strings.ToLower(string(char))
+			found := false
+			for r := 0; r < 3 && !found; r++ {
+				for c := 0; c < 10; c++ {
+					if strings.ToLower(editedLayout.Keys[r][c]) == symbol {
+						editedLayout.Keys[r][c], editedLayout.Keys[row][col] = editedLayout.Keys[row][col], editedLayout.Keys[r][c]
+						found = true
+						break
+					}
+				}
+			}
+			if !found {
+				status = fmt.Sprintf("Символ %s отсутствует в раскладке", symbol)
+			} else {
+				changes++
+			}
+		default:
+			continue
+		}
+
+		// Перемещаем курсор терминала к началу вывода редактора и перерисовываем его
+		fmt.Printf("\x1b[%dA\x1b[J", editorLines)
+		ch.printEditor(&editedLayout, row, col, initialScore, status)
+	}
+}
+
+// printEditor выводит раскладку редактора с выделенной позицией курсора, ее оценку
+// и строку состояния (всего editorLines строк)
+func (ch *CommandHandler) printEditor(layout *Layout, cursorRow, cursorCol int, initialScore float64, status string) {
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			if col == ch.config.SplitCol {
+				fmt.Print(" ")
+			}
+			if row == cursorRow && col == cursorCol {
+				fmt.Printf("\x1b[7m%s\x1b[0m ", layout.Keys[row][col])
+			} else {
+				fmt.Printf("%s ", layout.Keys[row][col])
+			}
+		}
+		fmt.Println()
+	}
+	fmt.Println()
+
+	score := AnalyzeLayout(layout, ch.config, ch.langData).WeightedScore
+	fmt.Printf("Score: %.2f (исходная %.2f, изменение %+.2f)\n", score, initialScore, score-initialScore)
+	fmt.Println(status)
+}
 
+// printColoredLayout выводит раскладку с подсветкой символов в зависимости от частоты
+func (ch *CommandHandler) printColoredLayout(layout *Layout) {
 	// Определяем максимальную частоту для нормализации цвета
 	maxFreq := 0.0
 	for row := 0; row < 3; row++ {
 		for col := 0; col < 10; col++ {
-			key := swappedLayout.Keys[row][col]
+			key := layout.Keys[row][col]
 			if freq, exists := ch.langData.Characters[key]; exists {
 				if freq > maxFreq {
 					maxFreq = freq
@@ -3312,11 +6870,11 @@ func (ch *CommandHandler) CommandSwapLetters(args string) error {
 	// Выводим строки раскладки с цветовым форматированием
 	for row := 0; row < 3; row++ {
 		for col := 0; col < 10; col++ {
-			if col == 5 {
+			if col == ch.config.SplitCol {
 				fmt.Print(" ")
 			}
 
-			key := swappedLayout.Keys[row][col]
+			key := layout.Keys[row][col]
 			freq := 0.0
 			if f, exists := ch.langData.Characters[key]; exists {
 				freq = f
@@ -3345,18 +6903,33 @@ func (ch *CommandHandler) CommandSwapLetters(args string) error {
 		}
 		fmt.Println()
 	}
-	fmt.Println()
+}
 
-	// Сохраняем результат во временный буфер [0]
-	ch.searchResultLayout = &swappedLayout
+// printNumberRow выводит цифровой ряд раскладки, если он задан в файле раскладок
+func (ch *CommandHandler) printNumberRow(layout *Layout, maxFreq float64) {
+	if len(layout.NumberRow) != 10 {
+		return
+	}
 
-	// Reset the inverted layout active flag since we now have a regular swapped layout in [0]
-	ch.isInvertedLayoutActive = false
-	// Also clear the inverted layout since it's no longer active
-	ch.invertedLayout = nil
+	for col, key := range layout.NumberRow {
+		if col == ch.config.SplitCol {
+			fmt.Print(" ")
+		}
 
-	return nil
-	return nil
+		// Цвет интерполируется так же, как для основных рядов: серый -> красный
+		r, g, b := 215, 215, 215
+		if freq, exists := ch.langData.Characters[key]; exists && maxFreq > 0 {
+			percent := math.Min(freq/maxFreq, 1.0)
+			g = 215 - int(percent*215.0)
+			b = g
+		}
+
+		fmt.Printf("\033[38;2;%d;%d;%dm%s\033[0m ", r, g, b, key)
+		if ch.showFreqOverlay {
+			fmt.Print(" ")
+		}
+	}
+	fmt.Println()
 }
 
 // CommandBigramLetter выводит визуализацию частот биграмм для заданной буквы в раскладке
@@ -3558,7 +7131,7 @@ func (ch *CommandHandler) printBigramBlock(layout *Layout, letter string, isLeft
 
 		// Выводим раскладку для текущего ряда
 		for col := 0; col < 10; col++ {
-			if col == 5 {
+			if col == ch.config.SplitCol {
 				fmt.Print(" ") // Пробел между половинками
 			}
 
@@ -3686,10 +7259,6 @@ func (ch *CommandHandler) printBigramBlock(layout *Layout, letter string, isLeft
 				if maxFreqInLanguage > 0 {
 					percent = (freq / maxFreqInLanguage) * 100.0
 				}
-				displayPercent := int(percent)
-				if displayPercent > 99 {
-					displayPercent = 99 // Для максимальной частоты используем 99
-				}
 
 				// Определяем цвет биграммы (от красного к серому)
 				bigramPercent := 0.0
@@ -3709,7 +7278,7 @@ func (ch *CommandHandler) printBigramBlock(layout *Layout, letter string, isLeft
 				// Выводим биграмму и частоту в квадратных скобках: [биграмма частота]
 				fmt.Printf("\033[38;2;%d;%d;%dm[\033[0m", 215, 215, 215) // Серый цвет для скобок
 				fmt.Printf("\033[38;2;%d;%d;%dm%s\033[0m", br, bg_color, bb, bigram) // Цветная биграмма
-				fmt.Printf("\033[38;2;215;215;215m %2d]\033[0m  ", displayPercent) // Серый цвет для частоты и 2 пробела
+				fmt.Printf("\033[38;2;215;215;215m %s]\033[0m  ", ch.formatBigramPercent(percent)) // Серый цвет для частоты и 2 пробела
 			}
 		}
 
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureOutput возвращает текст, выведенный функцией fn в стандартный вывод
+func captureOutput(t *testing.T, fn func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	fn()
+	writer.Close()
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
+
+// bigramTypeConfigs варианты встроенной конфигурации selftest с разными исключаемыми колонками
+func bigramTypeConfigs(t *testing.T) map[string]*KeyboardConfig {
+	configs := make(map[string]*KeyboardConfig)
+
+	configs["по умолчанию"] = selftestTestConfig(t)
+
+	thumb := selftestTestConfig(t)
+	thumb.ThumbCols = []int{4, 5}
+	configs["thumb_cols=5,6"] = thumb
+
+	excluded := selftestTestConfig(t)
+	excluded.ExcludedCols = []int{0, 9}
+	configs["excluded_cols=1,10"] = excluded
+
+	loose := selftestTestConfig(t)
+	loose.ExcludedCols = []int{3}
+	loose.Weights.HSBStrictMode = 0
+	loose.Weights.FSBStrictMode = 0
+	loose.Weights.LSBStrictMode = 0
+	configs["excluded_cols=4, нестрогий режим"] = loose
+
+	return configs
+}
+
+func TestBigramTypeListsMatchAnalysis(t *testing.T) {
+	langData := selftestLanguage()
+	for name, config := range bigramTypeConfigs(t) {
+		analysis := AnalyzeLayout(&selftestLayout, config, langData)
+
+		total := 0.0
+		for _, contribution := range AnalyzeBigramContributions(&selftestLayout, config, langData) {
+			total += langData.Bigrams[contribution.Bigram]
+		}
+
+		lists := bigramTypeLists(&selftestLayout, config, langData)
+		for i, metric := range bigramTypeColumns {
+			sum := 0.0
+			for _, bg := range lists[i] {
+				sum += bg.Freq
+			}
+			want, _ := MetricValue(analysis, metric)
+			if got := sum / total * 100.0; math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s: %s в таблице команды a %.6f, в анализе %.6f", name, metric, got, want)
+			}
+		}
+	}
+}
+
+func TestInnerColumnsCountWhenNotExcluded(t *testing.T) {
+	langData := selftestLanguage()
+	configs := bigramTypeConfigs(t)
+	hvb := -1
+	for i, metric := range bigramTypeColumns {
+		if metric == "HVB" {
+			hvb = i
+		}
+	}
+
+	// tg - один палец, колонка 5, соседние ряды
+	contains := func(config *KeyboardConfig) bool {
+		for _, bg := range bigramTypeLists(&selftestLayout, config, langData)[hvb] {
+			if bg.Bigram == "tg" {
+				return true
+			}
+		}
+		return false
+	}
+	if contains(configs["по умолчанию"]) {
+		t.Errorf("биграмма внутренней колонки учтена в HVB без excluded_cols")
+	}
+	if contains(configs["thumb_cols=5,6"]) {
+		t.Errorf("биграмма колонки большого пальца учтена в HVB")
+	}
+	if !contains(configs["excluded_cols=1,10"]) {
+		t.Errorf("биграмма внутренней колонки не учтена в HVB при excluded_cols без колонки 5")
+	}
+}
+
+func TestSwapMultiRuneKeys(t *testing.T) {
+	layout := selftestLayout
+	layout.Keys[0][2] = combinedE
+	layout.Keys[2][5] = combinedN
+	langData := replaceKeys(selftestLanguage(), map[string]string{"e": combinedE, "n": combinedN})
+	ch := NewCommandHandler(langData, selftestTestConfig(t), &ParsedLayouts{Layouts: []Layout{layout}}, "", "", "", "", "")
+
+	for _, letters := range []string{combinedE + combinedN, combinedE + "t", "t" + combinedN} {
+		swapped, err := ch.buildSwappedLayout("1 "+letters, "sw")
+		if err != nil {
+			t.Fatalf("sw 1 %s: %v", letters, err)
+		}
+		keyPos := buildKeyPositions(&layout, ch.config, langData)
+		first, second, _ := splitBigram(letters, keyPos)
+		pos1, pos2 := keyPos[first], keyPos[second]
+		if swapped.Keys[pos1[0]][pos1[1]] != second || swapped.Keys[pos2[0]][pos2[1]] != first {
+			t.Errorf("sw 1 %s: клавиши %q и %q не переставлены", letters, first, second)
+		}
+	}
+
+	if _, err := ch.buildSwappedLayout("1 "+combinedE, "sw"); err == nil {
+		t.Errorf("одна составная клавиша принята как две буквы для перестановки")
+	}
+}
+
+func TestPrintThresholdsOncePerMetric(t *testing.T) {
+	ch := NewCommandHandler(selftestLanguage(), selftestTestConfig(t), &ParsedLayouts{Layouts: []Layout{selftestLayout}}, "", "", "", "", "")
+	for _, args := range []string{"Score 100", "SFB 1.5", "Effort >10"} {
+		if err := ch.CommandThreshold(args); err != nil {
+			t.Fatalf("threshold %s: %v", args, err)
+		}
+	}
+
+	output := captureOutput(t, ch.printThresholds)
+	for _, metric := range []string{"Score", "SFB", "Effort"} {
+		if count := strings.Count(output, "  "+metric+" "); count != 1 {
+			t.Errorf("порог %s выведен %d раз:\n%s", metric, count, output)
+		}
+	}
+}
+
+func TestSameFingerRowPairsSumToSFB(t *testing.T) {
+	layout := selftestLayout
+	layout.NumberRow = []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "0"}
+	langData := selftestSpaceLanguage()
+	langData.Characters["1"] = 0.01
+	langData.Characters["4"] = 0.01
+	for bigram, freq := range map[string]float64{"1q": 0.004, "4r": 0.006, "f4": 0.003} {
+		langData.Bigrams[bigram] = freq
+	}
+
+	for _, scaled := range []bool{false, true} {
+		config := selftestTestConfig(t)
+		config.SpaceCol = 5
+		config.EffortScaledBigrams = scaled
+		analysis := AnalyzeLayout(&layout, config, langData)
+
+		rowPairs := sameFingerRowPairs(&layout, config, langData)
+		if rowPairs[4] == 0 {
+			t.Fatalf("effort_scaled_bigrams=%v: нет SFB с цифровым рядом", scaled)
+		}
+		total := 0.0
+		for _, value := range rowPairs {
+			total += value
+		}
+		if math.Abs(total-analysis.BigramAnalysis.SFB) > 1e-9 {
+			t.Errorf("effort_scaled_bigrams=%v: сумма по парам рядов %.6f, SFB %.6f", scaled, total, analysis.BigramAnalysis.SFB)
+		}
+	}
+}
+
+func TestExcludeBigrams(t *testing.T) {
+	langData := selftestLanguage()
+	ch := NewCommandHandler(langData, selftestTestConfig(t), &ParsedLayouts{Layouts: []Layout{selftestLayout}}, "", "", "", "", "")
+
+	bigram := ""
+	for candidate := range langData.Bigrams {
+		bigram = candidate
+		break
+	}
+	captureOutput(t, func() {
+		if err := ch.CommandExclude("bigrams " + bigram); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, exists := ch.langData.Bigrams[bigram]; exists {
+		t.Fatalf("биграмма %q не исключена", bigram)
+	}
+	if len(ch.langData.Characters) != len(langData.Characters) {
+		t.Errorf("исключение биграммы изменило набор символов")
+	}
+	total, want := 0.0, 0.0
+	for _, freq := range ch.langData.Bigrams {
+		total += freq
+	}
+	for _, freq := range langData.Bigrams {
+		want += freq
+	}
+	if math.Abs(total-want) > 1e-9 {
+		t.Errorf("сумма частот биграмм после исключения %.6f, до исключения %.6f", total, want)
+	}
+
+	if err := ch.CommandExclude("bigrams x"); err == nil {
+		t.Errorf("ожидалась ошибка для биграммы из одного символа")
+	}
+
+	captureOutput(t, func() { ch.CommandInclude("bigrams") })
+	if _, exists := ch.langData.Bigrams[bigram]; !exists {
+		t.Errorf("биграмма %q не возвращена в анализ", bigram)
+	}
+}
+
+func TestFreqOverlayPinnedUppercase(t *testing.T) {
+	config := selftestTestConfig(t)
+	pinned := selftestLayout
+	pinned.Keys[0][2] = "E"
+	ch := NewCommandHandler(selftestLanguage(), config, &ParsedLayouts{Layouts: []Layout{selftestLayout, pinned}}, "", "", "", "", "")
+
+	plain := captureOutput(t, func() { ch.printFreqOverlayRow(&selftestLayout, 0) })
+	if output := captureOutput(t, func() { ch.printFreqOverlayRow(&pinned, 0) }); output != plain {
+		t.Errorf("частоты ряда с закрепленной E:\n%s\nбез закрепления:\n%s", output, plain)
+	}
+
+	// При case_sensitive=1 заглавная буква не получает частоту строчной
+	config.CaseSensitive = true
+	if output := captureOutput(t, func() { ch.printFreqOverlayRow(&pinned, 0) }); output == plain {
+		t.Errorf("при case_sensitive=1 заглавная E получила частоту строчной буквы")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keyboardConfigJSON описывает структуру конфигурационного файла в формате JSON.
+// Формат содержит те же блоки, что и config.txt, но не зависит от порядка строк
+type keyboardConfigJSON struct {
+	EffortMatrix          [][]float64        `json:"effort_matrix"`           // 3 строки по 10 значений или 4 строки, если первая задает цифровой ряд
+	MaxFingerEfforts      [8]float64         `json:"max_finger_efforts"`      // Максимальная нагрузка по пальцам
+	FingerEffortPenalties [8]float64         `json:"finger_effort_penalties"` // Штрафы за превышение нагрузки по пальцам
+	MaxFingerTravel       [8]float64         `json:"max_finger_travel"`       // Максимальное перемещение пальцев в биграммах одного пальца
+	FingerTravelPenalties [8]float64         `json:"finger_travel_penalties"` // Штрафы за превышение перемещения пальцев
+	FixedPositions        []string           `json:"fixed_positions"`         // 3 строки по 10 значений "." или "x" через пробел
+	Weights               map[string]float64 `json:"weights"`                 // Параметры с теми же именами, что и в config.txt (SHB, MR1, ...)
+	BigramCoeffs          []bigramCoeffJSON  `json:"bigram_coeffs"`           // Индивидуальные коэффициенты для биграмм
+	ThumbCols             []int              `json:"thumb_cols"`              // Колонки больших пальцев (1-10)
+	ExcludedCols          []int              `json:"excluded_cols"`           // Колонки, исключаемые из HVB, FVB, SRB, HSB и FSB (1-10)
+	SplitCol              *int               `json:"split_col"`               // По умолчанию 5
+	Precision             int                `json:"precision"`
+	PercentDisplay        string             `json:"percent_display"` // clamp или mark, по умолчанию clamp
+	Vowels                string             `json:"vowels"`          // Гласные для команды vc
+	HomeKeys              []int              `json:"home_keys"`       // Домашние позиции (1-30) для показателя HomeUse
+}
+
+// jsonWeightNames параметры config.txt, которые задаются в блоке weights. Остальные параметры
+// задаются отдельными полями JSON, а неизвестное имя (например, с опечаткой) считается ошибкой,
+// а не пропускается, как строка config.txt, которая не совпала ни с одним параметром
+var jsonWeightNames = map[string]bool{
+	"effort": true, "hand_switch": true, "same_finger": true, "same_finger_jump": true, "inroll": true, "outroll": true,
+	"total_effort_norm": true, "MR1": true, "MR2": true, "MR3": true, "PR1": true, "PR2": true, "PR3": true,
+	"HDI": true, "FDI": true, "HandBias": true, "hand_bias_target": true, "D18": true, "D27": true, "D36": true, "D45": true,
+	"PinkyNorm": true, "HomeUseNorm": true,
+	"SHB": true, "SFB": true, "HVB": true, "FVB": true, "HDB": true, "FDB": true, "HFB": true, "HSB": true, "FSB": true,
+	"LSB": true, "SRB": true, "AFI": true, "AFO": true, "ICS": true, "SymSFB": true, "RowJumpNorm": true, "LSB_all": true,
+	"SKB": true, "HSB_strict_mode": true, "FSB_strict_mode": true, "LSB_strict_mode": true,
+	"space_col": true, "space_effort": true, "gg_min_improvement": true, "perturbation_swaps": true,
+	"perturbation_fraction": true, "effort_scaled_bigrams": true, "case_sensitive": true,
+}
+
+// bigramCoeffJSON задает коэффициент для списка биграмм, позиции нумеруются с 1 до 30
+type bigramCoeffJSON struct {
+	Coeff   float64  `json:"coeff"`
+	Bigrams [][2]int `json:"bigrams"`
+}
+
+// LoadKeyboardConfigJSON загружает конфигурацию клавиатуры из JSON файла
+func LoadKeyboardConfigJSON(filename string) (*KeyboardConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при чтении файла конфигурации: %w", err)
+	}
+
+	var raw keyboardConfigJSON
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("ошибка при парсинге JSON конфигурации: %w", err)
+	}
+
+	config := &KeyboardConfig{
+		MaxFingerEfforts:      raw.MaxFingerEfforts,
+		FingerEffortPenalties: raw.FingerEffortPenalties,
+	}
+
+	// Матрица усилий
+	rows := raw.EffortMatrix
+	if len(rows) != 3 && len(rows) != 4 {
+		return nil, fmt.Errorf("матрица усилий должна содержать 3 или 4 строки, найдено %d", len(rows))
+	}
+	for i, row := range rows {
+		if len(row) != 10 {
+			return nil, fmt.Errorf("строка %d матрицы усилий должна содержать 10 значений", i+1)
+		}
+	}
+	if len(rows) == 4 {
+		config.NumberRowEfforts = rows[0]
+		rows = rows[1:]
+	}
+	for row := 0; row < 3; row++ {
+		copy(config.EffortMatrix[row][:], rows[row])
+	}
+
+	// Фиксированные позиции
+	if len(raw.FixedPositions) != 3 {
+		return nil, fmt.Errorf("матрица фиксированных позиций должна содержать 3 строки")
+	}
+	for row, line := range raw.FixedPositions {
+		parts := strings.Fields(line)
+		if len(parts) != 10 {
+			return nil, fmt.Errorf("строка позиций %d должна содержать 10 значений", row+1)
+		}
+		for col := 0; col < 10; col++ {
+			config.FixedPositions[row][col] = parts[col]
+		}
+	}
+
+	// Коэффициенты весов разбираем тем же кодом, что и текстовый формат,
+	// чтобы значения по умолчанию и синхронизация MR/PR совпадали
+	names := make([]string, 0, len(raw.Weights))
+	for name := range raw.Weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !jsonWeightNames[name] {
+			return nil, fmt.Errorf("неизвестный параметр в блоке weights: %s", name)
+		}
+	}
+	weightLines := make([]string, 0, len(names)+2)
+	for _, name := range names {
+		weightLines = append(weightLines, name+"="+strconv.FormatFloat(raw.Weights[name], 'g', -1, 64))
+	}
+	if raw.SplitCol != nil {
+		weightLines = append(weightLines, "split_col="+strconv.Itoa(*raw.SplitCol))
+	}
+	weightLines = append(weightLines, "precision="+strconv.Itoa(raw.Precision))
+	if raw.PercentDisplay != "" {
+		weightLines = append(weightLines, "percent_display="+raw.PercentDisplay)
+	}
+	if len(raw.ThumbCols) > 0 {
+		thumbCols := make([]string, len(raw.ThumbCols))
+		for i, col := range raw.ThumbCols {
+			thumbCols[i] = strconv.Itoa(col)
+		}
+		weightLines = append(weightLines, "thumb_cols="+strings.Join(thumbCols, ","))
+	}
+	if len(raw.ExcludedCols) > 0 {
+		excludedCols := make([]string, len(raw.ExcludedCols))
+		for i, col := range raw.ExcludedCols {
+			excludedCols[i] = strconv.Itoa(col)
+		}
+		weightLines = append(weightLines, "excluded_cols="+strings.Join(excludedCols, ","))
+	}
+	weightLines = append(weightLines,
+		"max_finger_travel="+formatFingerValues(raw.MaxFingerTravel),
+		"finger_travel_penalties="+formatFingerValues(raw.FingerTravelPenalties))
+	if len(raw.HomeKeys) > 0 {
+		homeKeys := make([]string, len(raw.HomeKeys))
+		for i, pos := range raw.HomeKeys {
+			homeKeys[i] = strconv.Itoa(pos)
+		}
+		weightLines = append(weightLines, "home_keys="+strings.Join(homeKeys, ","))
+	}
+	if raw.Vowels != "" {
+		weightLines = append(weightLines, "vowels="+raw.Vowels)
+	}
+	if err := parseWeights(weightLines, config); err != nil {
+		return nil, err
+	}
+
+	// Индивидуальные коэффициенты для биграмм
+	for _, group := range raw.BigramCoeffs {
+		for _, bigram := range group.Bigrams {
+			if !validBigramPositions(bigram[0], bigram[1]) {
+				return nil, fmt.Errorf("некорректная биграмма %d-%d (допустимы позиции 1-30)", bigram[0], bigram[1])
+			}
+			config.BigramIndividualCoeffs = append(config.BigramIndividualCoeffs, BigramIndividualCoeff{
+				Pos1:  bigram[0] - 1,
+				Pos2:  bigram[1] - 1,
+				Coeff: group.Coeff,
+			})
+		}
+	}
+
+	return config, nil
+}
+
+// formatFingerValues форматирует 8 значений по пальцам через запятую для parseWeights
+func formatFingerValues(values [8]float64) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigJSONMatchesText(t *testing.T) {
+	textConfig, err := LoadKeyboardConfig("../../configs/config.txt")
+	if err != nil {
+		t.Fatalf("ошибка загрузки config.txt: %v", err)
+	}
+	jsonConfig, err := LoadKeyboardConfigJSON("../../configs/config.json")
+	if err != nil {
+		t.Fatalf("ошибка загрузки config.json: %v", err)
+	}
+
+	textValue := reflect.ValueOf(*textConfig)
+	jsonValue := reflect.ValueOf(*jsonConfig)
+	for i := 0; i < textValue.NumField(); i++ {
+		if !reflect.DeepEqual(textValue.Field(i).Interface(), jsonValue.Field(i).Interface()) {
+			t.Errorf("%s: config.txt %v, config.json %v", textValue.Type().Field(i).Name, textValue.Field(i).Interface(), jsonValue.Field(i).Interface())
+		}
+	}
+}
+
+func TestConfigJSONRejectsUnknownWeight(t *testing.T) {
+	data, err := os.ReadFile("../../configs/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"SFBX", "Sfb", "split_col"} {
+		content := strings.Replace(string(data), `"weights": {`, `"weights": {"`+name+`": 1, `, 1)
+		filename := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadKeyboardConfigJSON(filename); err == nil {
+			t.Errorf("параметр %s в блоке weights принят", name)
+		}
+	}
+}
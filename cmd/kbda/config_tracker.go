@@ -24,6 +24,7 @@ func NewConfigChangeTracker(weights WeightConfig) *ConfigChangeTracker {
             "HSBStrictMode", "FSBStrictMode", "LSBStrictMode",
             "MaxRowEffort1", "MaxRowEffort2", "MaxRowEffort3",
             "RowPenalty1", "RowPenalty2", "RowPenalty3",
+            "PinkyNorm", "ICS", "SymSFB", "HomeUseNorm", "RowJumpNorm", "LSBAll", "SKB", "HandBias",
         },
         originalBigramIndividualCoeffs: []BigramIndividualCoeff{},
         modifiedBigramIndividualCoeffs: []BigramIndividualCoeff{},
@@ -59,6 +60,35 @@ func (ct *ConfigChangeTracker) GetAllWeights() WeightConfig {
     return ct.modifiedWeights
 }
 
+// GetOriginalWeights возвращает веса из конфигурационного файла без учета изменений
+func (ct *ConfigChangeTracker) GetOriginalWeights() WeightConfig {
+    return ct.originalWeights
+}
+
+// GetModifiedWeightNames возвращает имена измененных весов
+func (ct *ConfigChangeTracker) GetModifiedWeightNames() []string {
+    var names []string
+    for _, name := range ct.allWeightNames {
+        if ct.IsWeightModified(name) {
+            names = append(names, name)
+        }
+    }
+    return names
+}
+
+// IsBigramCoeffsModified проверяет, были ли изменены индивидуальные коэффициенты биграмм
+func (ct *ConfigChangeTracker) IsBigramCoeffsModified() bool {
+    return ct.bigramCoeffsModified
+}
+
+// RestoreModifiedWeights восстанавливает изменения весов из сохраненной конфигурации
+func (ct *ConfigChangeTracker) RestoreModifiedWeights(weights WeightConfig, names []string) {
+    ct.modifiedWeights = weights
+    for _, name := range names {
+        ct.MarkWeightModified(name)
+    }
+}
+
 // SetWeight устанавливает новое значение веса
 func (ct *ConfigChangeTracker) SetWeight(weightName string, value float64) {
     switch weightName {
@@ -122,6 +152,22 @@ func (ct *ConfigChangeTracker) SetWeight(weightName string, value float64) {
         ct.modifiedWeights.RowPenalty2 = value
     case "RowPenalty3":
         ct.modifiedWeights.RowPenalty3 = value
+    case "PinkyNorm":
+        ct.modifiedWeights.PinkyNorm = value
+    case "ICS":
+        ct.modifiedWeights.ICS = value
+    case "SymSFB":
+        ct.modifiedWeights.SymSFB = value
+    case "HomeUseNorm":
+        ct.modifiedWeights.HomeUseNorm = value
+    case "RowJumpNorm":
+        ct.modifiedWeights.RowJumpNorm = value
+    case "LSBAll":
+        ct.modifiedWeights.LSBAll = value
+    case "SKB":
+        ct.modifiedWeights.SKB = value
+    case "HandBias":
+        ct.modifiedWeights.HandBias = value
     }
     ct.MarkWeightModified(weightName)
 }
@@ -247,6 +293,30 @@ func (ct *ConfigChangeTracker) ApplyToConfig(config *KeyboardConfig) {
         config.Weights.RowPenalty3 = ct.modifiedWeights.RowPenalty3
         config.RowEffortPenalties[2] = ct.modifiedWeights.RowPenalty3
     }
+    if ct.IsWeightModified("PinkyNorm") {
+        config.Weights.PinkyNorm = ct.modifiedWeights.PinkyNorm
+    }
+    if ct.IsWeightModified("ICS") {
+        config.Weights.ICS = ct.modifiedWeights.ICS
+    }
+    if ct.IsWeightModified("SymSFB") {
+        config.Weights.SymSFB = ct.modifiedWeights.SymSFB
+    }
+    if ct.IsWeightModified("HomeUseNorm") {
+        config.Weights.HomeUseNorm = ct.modifiedWeights.HomeUseNorm
+    }
+    if ct.IsWeightModified("RowJumpNorm") {
+        config.Weights.RowJumpNorm = ct.modifiedWeights.RowJumpNorm
+    }
+    if ct.IsWeightModified("LSBAll") {
+        config.Weights.LSBAll = ct.modifiedWeights.LSBAll
+    }
+    if ct.IsWeightModified("SKB") {
+        config.Weights.SKB = ct.modifiedWeights.SKB
+    }
+    if ct.IsWeightModified("HandBias") {
+        config.Weights.HandBias = ct.modifiedWeights.HandBias
+    }
 
     // Применяем измененные индивидуальные коэффициенты биграмм
     if ct.bigramCoeffsModified {
@@ -328,6 +398,22 @@ func (ct *ConfigChangeTracker) UpdateBaseConfig(newWeights WeightConfig) {
                 modifiedValues[name] = ct.modifiedWeights.RowPenalty2
             case "RowPenalty3":
                 modifiedValues[name] = ct.modifiedWeights.RowPenalty3
+            case "PinkyNorm":
+                modifiedValues[name] = ct.modifiedWeights.PinkyNorm
+            case "ICS":
+                modifiedValues[name] = ct.modifiedWeights.ICS
+            case "SymSFB":
+                modifiedValues[name] = ct.modifiedWeights.SymSFB
+            case "HomeUseNorm":
+                modifiedValues[name] = ct.modifiedWeights.HomeUseNorm
+            case "RowJumpNorm":
+                modifiedValues[name] = ct.modifiedWeights.RowJumpNorm
+            case "LSBAll":
+                modifiedValues[name] = ct.modifiedWeights.LSBAll
+            case "SKB":
+                modifiedValues[name] = ct.modifiedWeights.SKB
+            case "HandBias":
+                modifiedValues[name] = ct.modifiedWeights.HandBias
             }
         }
     }
@@ -410,6 +496,22 @@ func (ct *ConfigChangeTracker) UpdateBaseConfig(newWeights WeightConfig) {
             ct.modifiedWeights.RowPenalty2 = value.(float64)
         case "RowPenalty3":
             ct.modifiedWeights.RowPenalty3 = value.(float64)
+        case "PinkyNorm":
+            ct.modifiedWeights.PinkyNorm = value.(float64)
+        case "ICS":
+            ct.modifiedWeights.ICS = value.(float64)
+        case "SymSFB":
+            ct.modifiedWeights.SymSFB = value.(float64)
+        case "HomeUseNorm":
+            ct.modifiedWeights.HomeUseNorm = value.(float64)
+        case "RowJumpNorm":
+            ct.modifiedWeights.RowJumpNorm = value.(float64)
+        case "LSBAll":
+            ct.modifiedWeights.LSBAll = value.(float64)
+        case "SKB":
+            ct.modifiedWeights.SKB = value.(float64)
+        case "HandBias":
+            ct.modifiedWeights.HandBias = value.(float64)
         }
     }
 
@@ -502,6 +604,22 @@ func (ct *ConfigChangeTracker) GetAllModifiedParams() map[string]interface{} {
                 modifiedParams[name] = ct.modifiedWeights.RowPenalty2
             case "RowPenalty3":
                 modifiedParams[name] = ct.modifiedWeights.RowPenalty3
+            case "PinkyNorm":
+                modifiedParams[name] = ct.modifiedWeights.PinkyNorm
+            case "ICS":
+                modifiedParams[name] = ct.modifiedWeights.ICS
+            case "SymSFB":
+                modifiedParams[name] = ct.modifiedWeights.SymSFB
+            case "HomeUseNorm":
+                modifiedParams[name] = ct.modifiedWeights.HomeUseNorm
+            case "RowJumpNorm":
+                modifiedParams[name] = ct.modifiedWeights.RowJumpNorm
+            case "LSBAll":
+                modifiedParams[name] = ct.modifiedWeights.LSBAll
+            case "SKB":
+                modifiedParams[name] = ct.modifiedWeights.SKB
+            case "HandBias":
+                modifiedParams[name] = ct.modifiedWeights.HandBias
             }
         }
     }
@@ -527,4 +645,17 @@ func (ct *ConfigChangeTracker) ResetModifiedParams() {
     // Восстанавливаем оригинальные значения
     ct.modifiedWeights = ct.originalWeights
     ct.modifiedBigramIndividualCoeffs = ct.originalBigramIndividualCoeffs
-}
\ No newline at end of file
+}
+// CommitModifications делает текущие значения весов новой базой: после этого
+// изменений нет и перезагрузка конфигурации не будет их повторно применять
+func (ct *ConfigChangeTracker) CommitModifications() {
+    ct.originalWeights = ct.modifiedWeights
+    for name := range ct.weightsModified {
+        ct.weightsModified[name] = false
+    }
+
+    if ct.bigramCoeffsModified {
+        ct.originalBigramIndividualCoeffs = ct.modifiedBigramIndividualCoeffs
+        ct.bigramCoeffsModified = false
+    }
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Дополнительные категории биграмм команды coverage, которые не являются отдельными показателями
+const (
+	coverageAlternation   = "Alt"  // Чередование рук (биграмма не входит в SHB)
+	coverageSameHandOther = "SHDF" // Одна рука, разные пальцы (SHB без SFB)
+)
+
+// coverageLabels названия дополнительных категорий для вывода
+var coverageLabels = map[string]string{
+	coverageAlternation:   "Чередование рук",
+	coverageSameHandOther: "Одна рука, разные пальцы",
+}
+
+// coverageTolerance расхождение (%), меньше которого сумма составляющих считается совпадающей
+// с родительским показателем (погрешность округления до двух знаков)
+const coverageTolerance = 0.005
+
+// coverageExamples количество биграмм, которые выводятся как примеры не покрытых составляющими
+const coverageExamples = 5
+
+// coverageGroup группа показателей биграмм, составляющие которой по смыслу должны в сумме
+// давать родительский показатель. Пустой родительский показатель означает все биграммы
+type coverageGroup struct {
+	Parent string
+	Parts  []string
+}
+
+// coverageGroups группы показателей, которые проверяет команда coverage
+var coverageGroups = []coverageGroup{
+	{"", []string{"SHB", coverageAlternation}},
+	{"SHB", []string{"SFB", coverageSameHandOther}},
+	{"SFB", []string{"HVB", "FVB", "HDB", "FDB", "HFB", "SKB"}},
+	{coverageSameHandOther, []string{"HSB", "HSB2", "FSB", "FSB2", "LSB", "LSB2", "SRB"}},
+}
+
+// coverageMetrics показатели биграмм в порядке вывода (без TIB, который не является долей биграмм)
+var coverageMetrics = []string{
+	"SHB", coverageAlternation, "SFB", coverageSameHandOther, "HVB", "FVB", "HDB", "FDB", "HFB", "SKB",
+	"HSB", "HSB2", "FSB", "FSB2", "LSB", "LSB2", "SRB", "AFI", "AFO", "ICS", "SymSFB", "RowJump",
+	"LSB_all",
+}
+
+// coverageLabel возвращает название показателя или группы для вывода
+func coverageLabel(name string) string {
+	if name == "" {
+		return "Все биграммы"
+	}
+	if label, exists := coverageLabels[name]; exists {
+		return label
+	}
+	return name
+}
+
+// coverageBigram биграмма раскладки с долей среди всех биграмм и категориями, в которые она попадает
+type coverageBigram struct {
+	Bigram     string
+	Freq       float64
+	Categories map[string]bool
+}
+
+// coverageBigrams классифицирует биграммы раскладки теми же правилами, что и calculateBigrams,
+// и добавляет дополнительные категории чередования рук и разных пальцев одной руки
+func coverageBigrams(layout *Layout, config *KeyboardConfig, langData *LanguageData) []coverageBigram {
+	// Биграммы с пробелом учитываются только в SHB и нормируются отдельно, поэтому не проверяются
+	letterConfig := *config
+	letterConfig.SpaceCol = 0
+
+	var bigrams []coverageBigram
+	for _, contribution := range AnalyzeBigramContributions(layout, &letterConfig, langData) {
+		categories := make(map[string]bool)
+		for _, category := range contribution.Categories {
+			categories[category] = true
+		}
+		if !categories["SHB"] {
+			categories[coverageAlternation] = true
+		} else if !categories["SFB"] {
+			categories[coverageSameHandOther] = true
+		}
+		bigrams = append(bigrams, coverageBigram{contribution.Bigram, contribution.Freq, categories})
+	}
+	return bigrams
+}
+
+// coverageCheck результат проверки группы показателей: значение родительского показателя,
+// сумма составляющих и причины расхождения между ними
+type coverageCheck struct {
+	Group    coverageGroup
+	Parent   float64
+	Sum      float64
+	Gap      float64            // Биграммы родительского показателя, не вошедшие ни в одну составляющую
+	Outside  map[string]float64 // Составляющие и доля их биграмм, не входящих в родительский показатель
+	Overlaps map[string]float64 // Пары составляющих "A и B" и доля биграмм, учтенных в обеих
+	Examples []coverageBigram   // Наиболее частые биграммы, не вошедшие ни в одну составляющую
+}
+
+// Consistent проверяет, совпадает ли сумма составляющих с родительским показателем без пересечений
+func (c *coverageCheck) Consistent() bool {
+	extra := 0.0
+	for _, share := range c.Overlaps {
+		extra += share
+	}
+	for _, share := range c.Outside {
+		extra += share
+	}
+	return c.Gap < coverageTolerance && extra < coverageTolerance
+}
+
+// checkCoverageGroup рассчитывает сумму составляющих группы и раскладывает расхождение с родительским
+// показателем на непокрытые биграммы, пересечения составляющих и биграммы вне родительского показателя
+func checkCoverageGroup(group coverageGroup, bigrams []coverageBigram) coverageCheck {
+	check := coverageCheck{Group: group, Outside: make(map[string]float64), Overlaps: make(map[string]float64)}
+	var gaps []coverageBigram
+	for _, bigram := range bigrams {
+		inParent := group.Parent == "" || bigram.Categories[group.Parent]
+		if inParent {
+			check.Parent += bigram.Freq
+		}
+
+		var parts []string
+		for _, part := range group.Parts {
+			if bigram.Categories[part] {
+				parts = append(parts, part)
+				check.Sum += bigram.Freq
+			}
+		}
+
+		switch {
+		case inParent && len(parts) == 0:
+			check.Gap += bigram.Freq
+			gaps = append(gaps, bigram)
+		case !inParent:
+			for _, part := range parts {
+				check.Outside[part] += bigram.Freq
+			}
+		}
+		for i := 0; i < len(parts); i++ {
+			for j := i + 1; j < len(parts); j++ {
+				check.Overlaps[parts[i]+" и "+parts[j]] += bigram.Freq
+			}
+		}
+	}
+
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].Freq != gaps[j].Freq {
+			return gaps[i].Freq > gaps[j].Freq
+		}
+		return gaps[i].Bigram < gaps[j].Bigram
+	})
+	if len(gaps) > coverageExamples {
+		gaps = gaps[:coverageExamples]
+	}
+	check.Examples = gaps
+	return check
+}
+
+// significantShares возвращает ключи с долей не меньше coverageTolerance по убыванию доли
+func significantShares(shares map[string]float64) []string {
+	keys := make([]string, 0, len(shares))
+	for key, share := range shares {
+		if share >= coverageTolerance {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if shares[keys[i]] != shares[keys[j]] {
+			return shares[keys[i]] > shares[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// CommandCoverage выводит долю биграмм каждого показателя и проверяет, складываются ли составляющие
+// групп показателей (например, SFB = HVB + FVB + HDB + FDB + HFB + SKB) в родительский показатель.
+// Для расхождений выводятся непокрытые биграммы и пересекающиеся по определению показатели
+func (ch *CommandHandler) CommandCoverage(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 1 {
+		return fmt.Errorf("используйте: coverage N (N - номер раскладки)")
+	}
+	layoutNum, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("некорректный номер раскладки: %s", parts[0])
+	}
+	layout, exists := ch.getLayoutByIndex(layoutNum)
+	if !exists {
+		return fmt.Errorf("раскладка с номером %d не найдена", layoutNum)
+	}
+
+	bigrams := coverageBigrams(layout, ch.config, ch.langData)
+	if len(bigrams) == 0 {
+		return fmt.Errorf("в языковых данных нет биграмм, которые можно набрать на раскладке %d", layoutNum)
+	}
+
+	shares := make(map[string]float64)
+	for _, bigram := range bigrams {
+		for category := range bigram.Categories {
+			shares[category] += bigram.Freq
+		}
+	}
+
+	fmt.Printf("[%d] %s - доля биграмм каждого показателя среди всех биграмм раскладки (%%)\n", layoutNum, layout.Name)
+	if ch.config.EffortScaledBigrams {
+		fmt.Println("Доли рассчитаны по частоте биграмм без домножения на усилие (effort_scaled_bigrams), поэтому могут отличаться от таблицы lb")
+	}
+	fmt.Println(strings.Repeat("-", 36))
+	for _, metric := range coverageMetrics {
+		fmt.Printf(" %-26s %7.2f\n", coverageLabel(metric), shares[metric])
+	}
+
+	failed := 0
+	for _, group := range coverageGroups {
+		check := checkCoverageGroup(group, bigrams)
+
+		labels := make([]string, len(group.Parts))
+		for i, part := range group.Parts {
+			labels[i] = coverageLabel(part)
+		}
+		fmt.Printf("\n%s = %s\n", coverageLabel(group.Parent), strings.Join(labels, " + "))
+
+		status := "сходится"
+		if !check.Consistent() {
+			status = "не сходится"
+			failed++
+		}
+		fmt.Printf("  %.2f, сумма составляющих %.2f: %s\n", check.Parent, check.Sum, status)
+
+		if check.Gap >= coverageTolerance {
+			examples := make([]string, len(check.Examples))
+			for i, bigram := range check.Examples {
+				examples[i] = fmt.Sprintf("%s %.2f", bigram.Bigram, bigram.Freq)
+			}
+			fmt.Printf("  не входят ни в одну составляющую: %.2f (%s)\n", check.Gap, strings.Join(examples, ", "))
+		}
+		for _, part := range significantShares(check.Outside) {
+			fmt.Printf("  %s учитывает биграммы не из %s: %.2f\n", coverageLabel(part), coverageLabel(group.Parent), check.Outside[part])
+		}
+		for _, pair := range significantShares(check.Overlaps) {
+			fmt.Printf("  пересекаются по определению %s: %.2f учтены дважды\n", pair, check.Overlaps[pair])
+		}
+	}
+
+	fmt.Println()
+	if failed > 0 {
+		fmt.Printf("Групп, составляющие которых не складываются в родительский показатель: %d из %d\n", failed, len(coverageGroups))
+	} else {
+		fmt.Printf("Составляющие всех групп (%d) складываются в родительские показатели\n", len(coverageGroups))
+	}
+	return nil
+}
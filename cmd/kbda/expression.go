@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression скомпилированное арифметическое выражение над показателями анализа раскладки,
+// используется как пользовательская целевая функция поиска (g expr="...")
+type Expression struct {
+	Source    string
+	eval      func(analysis *LayoutAnalysis) float64
+	nonFinite int // Количество вычислений, результат которых заменен на +Inf
+}
+
+// Evaluate вычисляет значение выражения для результата анализа. Бесконечный или неопределенный
+// результат (деление на ноль, корень из отрицательного числа) заменяется на +Inf, чтобы поиск
+// отбрасывал такие раскладки, а не считал их лучшими
+func (e *Expression) Evaluate(analysis *LayoutAnalysis) float64 {
+	value := e.eval(analysis)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		e.nonFinite++
+		return math.Inf(1)
+	}
+	return value
+}
+
+// NonFinite возвращает количество вычислений выражения с бесконечным или неопределенным результатом
+func (e *Expression) NonFinite() int {
+	return e.nonFinite
+}
+
+// expressionFunctions функции, допустимые в выражениях, и количество их аргументов
+var expressionFunctions = map[string]int{"abs": 1, "sqrt": 1, "min": 2, "max": 2}
+
+// expressionVariables возвращает допустимые в выражениях переменные: колонки таблиц l и lb
+// и слагаемые оценки ScoreTerms. Ключ - название в нижнем регистре, значение - название
+// показателя с исходным регистром
+func expressionVariables() map[string]string {
+	variables := make(map[string]string)
+	for _, column := range analysisColumns {
+		variables[strings.ToLower(column)] = column
+	}
+	for _, column := range bigramColumns {
+		variables[strings.ToLower(column)] = column
+	}
+	for _, term := range ScoreTerms(&KeyboardConfig{}, &LayoutAnalysis{}) {
+		if _, exists := variables[strings.ToLower(term.Name)]; !exists {
+			variables[strings.ToLower(term.Name)] = term.Name
+		}
+	}
+	return variables
+}
+
+// expressionVariable возвращает функцию получения значения показателя по его названию.
+// Позиция показателя в строке таблицы или в списке ScoreTerms находится один раз при разборе
+func expressionVariable(name string) func(analysis *LayoutAnalysis) float64 {
+	for i, column := range analysisColumns {
+		if column == name {
+			index := i + 2
+			return func(analysis *LayoutAnalysis) float64 {
+				return analysisRowValues(analysis)[index].(float64)
+			}
+		}
+	}
+	for i, column := range bigramColumns {
+		if column == name {
+			index := i + 2
+			return func(analysis *LayoutAnalysis) float64 {
+				return bigramRowValues(analysis)[index].(float64)
+			}
+		}
+	}
+	for i, term := range ScoreTerms(&KeyboardConfig{}, &LayoutAnalysis{}) {
+		if term.Name == name {
+			index := i
+			return func(analysis *LayoutAnalysis) float64 {
+				return ScoreTerms(analysis.Config, analysis)[index].Value
+			}
+		}
+	}
+	return func(*LayoutAnalysis) float64 { return 0 }
+}
+
+// ParseExpression разбирает выражение с числами, показателями анализа (например, SFB, HSB,
+// Effort, названия без учета регистра), операциями + - * / ^, скобками и функциями abs, sqrt,
+// min и max. Неизвестные показатели и функции считаются ошибкой разбора
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("пустое выражение")
+	}
+
+	p := &expressionParser{tokens: tokens, variables: expressionVariables()}
+	eval, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("неожиданный символ %q в выражении", p.tokens[p.pos])
+	}
+	return &Expression{Source: source, eval: eval}, nil
+}
+
+// tokenizeExpression разбивает выражение на числа, идентификаторы и операторы
+func tokenizeExpression(source string) ([]string, error) {
+	var tokens []string
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case strings.ContainsRune("+-*/^(),", r):
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			return nil, fmt.Errorf("недопустимый символ %q в выражении", r)
+		}
+	}
+	return tokens, nil
+}
+
+// expressionParser разбирает выражение методом рекурсивного спуска и строит функцию его вычисления
+type expressionParser struct {
+	tokens    []string
+	pos       int
+	variables map[string]string
+}
+
+// peek возвращает текущий токен или пустую строку в конце выражения
+func (p *expressionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// expect пропускает ожидаемый токен или возвращает ошибку
+func (p *expressionParser) expect(token string) error {
+	if p.peek() != token {
+		if p.peek() == "" {
+			return fmt.Errorf("ожидается %q, выражение закончилось", token)
+		}
+		return fmt.Errorf("ожидается %q вместо %q", token, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+// parseSum разбирает сумму и разность слагаемых
+func (p *expressionParser) parseSum() (func(*LayoutAnalysis) float64, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.peek()
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "+" {
+			left = func(a *LayoutAnalysis) float64 { return l(a) + right(a) }
+		} else {
+			left = func(a *LayoutAnalysis) float64 { return l(a) - right(a) }
+		}
+	}
+	return left, nil
+}
+
+// parseProduct разбирает произведение и частное множителей
+func (p *expressionParser) parseProduct() (func(*LayoutAnalysis) float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" {
+		op := p.peek()
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "*" {
+			left = func(a *LayoutAnalysis) float64 { return l(a) * right(a) }
+		} else {
+			left = func(a *LayoutAnalysis) float64 { return l(a) / right(a) }
+		}
+	}
+	return left, nil
+}
+
+// parseUnary разбирает унарный минус или плюс
+func (p *expressionParser) parseUnary() (func(*LayoutAnalysis) float64, error) {
+	if p.peek() == "-" || p.peek() == "+" {
+		op := p.peek()
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "-" {
+			return func(a *LayoutAnalysis) float64 { return -operand(a) }, nil
+		}
+		return operand, nil
+	}
+	return p.parsePower()
+}
+
+// parsePower разбирает возведение в степень (правоассоциативное)
+func (p *expressionParser) parsePower() (func(*LayoutAnalysis) float64, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "^" {
+		return base, nil
+	}
+	p.pos++
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return func(a *LayoutAnalysis) float64 { return math.Pow(base(a), exponent(a)) }, nil
+}
+
+// parsePrimary разбирает число, показатель, вызов функции или выражение в скобках
+func (p *expressionParser) parsePrimary() (func(*LayoutAnalysis) float64, error) {
+	token := p.peek()
+	if token == "" {
+		return nil, fmt.Errorf("неожиданный конец выражения")
+	}
+	p.pos++
+
+	if token == "(" {
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	first := []rune(token)[0]
+	if unicode.IsDigit(first) || first == '.' {
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("некорректное число %q в выражении", token)
+		}
+		return func(*LayoutAnalysis) float64 { return value }, nil
+	}
+
+	if !unicode.IsLetter(first) && first != '_' {
+		return nil, fmt.Errorf("неожиданный символ %q в выражении", token)
+	}
+
+	name := strings.ToLower(token)
+	if arity, isFunction := expressionFunctions[name]; isFunction && p.peek() == "(" {
+		return p.parseCall(name, arity)
+	}
+
+	variable, exists := p.variables[name]
+	if !exists {
+		return nil, fmt.Errorf("неизвестный показатель %q в выражении (допустимо: %s)", token, strings.Join(p.variableNames(), " "))
+	}
+	return expressionVariable(variable), nil
+}
+
+// parseCall разбирает аргументы вызова функции
+func (p *expressionParser) parseCall(name string, arity int) (func(*LayoutAnalysis) float64, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []func(*LayoutAnalysis) float64
+	for {
+		arg, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peek() != "," {
+			break
+		}
+		p.pos++
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("функция %s принимает аргументов: %d, указано: %d", name, arity, len(args))
+	}
+
+	switch name {
+	case "abs":
+		return func(a *LayoutAnalysis) float64 { return math.Abs(args[0](a)) }, nil
+	case "sqrt":
+		return func(a *LayoutAnalysis) float64 { return math.Sqrt(args[0](a)) }, nil
+	case "min":
+		return func(a *LayoutAnalysis) float64 { return math.Min(args[0](a), args[1](a)) }, nil
+	default:
+		return func(a *LayoutAnalysis) float64 { return math.Max(args[0](a), args[1](a)) }, nil
+	}
+}
+
+// variableNames возвращает отсортированные названия допустимых показателей
+func (p *expressionParser) variableNames() []string {
+	names := make([]string, 0, len(p.variables))
+	for _, name := range p.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
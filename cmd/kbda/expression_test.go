@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExpressionVariables(t *testing.T) {
+	config := selftestTestConfig(t)
+	analysis := AnalyzeLayout(&selftestLayout, config, selftestLanguage())
+
+	terms := make(map[string]float64)
+	for _, term := range ScoreTerms(config, analysis) {
+		terms[term.Name] = term.Value
+	}
+	sfb, _ := MetricValue(analysis, "SFB")
+	for source, want := range map[string]float64{
+		"sfb":                sfb,
+		"2*SFB - 1":          2*sfb - 1,
+		"SymSFB":             terms["SymSFB"],
+		"max(HandBias, 0.5)": math.Max(terms["HandBias"], 0.5),
+	} {
+		expression, err := ParseExpression(source)
+		if err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		if got := expression.Evaluate(analysis); math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s = %.6f, ожидалось %.6f", source, got, want)
+		}
+	}
+
+	if _, err := ParseExpression("SFB + unknown"); err == nil {
+		t.Errorf("неизвестный показатель не считается ошибкой разбора")
+	}
+}
+
+func TestExpressionNonFinite(t *testing.T) {
+	config := selftestTestConfig(t)
+	analysis := AnalyzeLayout(&selftestLayout, config, selftestLanguage())
+
+	for _, source := range []string{"SFB / 0", "-SFB / 0", "sqrt(-SFB)"} {
+		expression, err := ParseExpression(source)
+		if err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		if got := expression.Evaluate(analysis); !math.IsInf(got, 1) {
+			t.Errorf("%s = %v, ожидалось +Inf", source, got)
+		}
+		if expression.NonFinite() != 1 {
+			t.Errorf("%s: неопределенных вычислений %d, ожидалось 1", source, expression.NonFinite())
+		}
+	}
+}
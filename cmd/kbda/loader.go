@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -25,19 +28,255 @@ func LoadLanguageData(filename string) (*LanguageData, error) {
 	return &langData, nil
 }
 
+// FilterLanguageData возвращает копию языковых данных без указанных символов, биграмм,
+// содержащих эти символы, и отдельно указанных биграмм. Частоты оставшихся символов и биграмм
+// масштабируются так, чтобы их сумма совпадала с суммой до исключения
+func FilterLanguageData(langData *LanguageData, excludedChars, excludedBigrams map[string]bool) *LanguageData {
+	if len(excludedChars) == 0 && len(excludedBigrams) == 0 {
+		return langData
+	}
+
+	filtered := *langData
+	filtered.Characters = renormalizeFrequencies(langData.Characters, func(char string) bool {
+		return excludedChars[char]
+	})
+	filtered.Bigrams = renormalizeFrequencies(langData.Bigrams, func(bigram string) bool {
+		if excludedBigrams[bigram] {
+			return true
+		}
+		for _, r := range bigram {
+			if excludedChars[string(r)] {
+				return true
+			}
+		}
+		return false
+	})
+	return &filtered
+}
+
+// LoadKeyFrequencies загружает собственные частоты символов (например, данные кейлоггера).
+// Файл с расширением .json содержит объект {"символ": частота}, остальные файлы - строки
+// "символ частота". Символы приводятся к нижнему регистру, если не задан caseSensitive
+// (case_sensitive=1), частоты могут быть абсолютными
+func LoadKeyFrequencies(filename string, caseSensitive bool) (map[string]float64, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при чтении файла частот символов: %w", err)
+	}
+
+	raw := make(map[string]float64)
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("ошибка при парсинге JSON частот символов: %w", err)
+		}
+	} else {
+		for i, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("строка %d: ожидается \"символ частота\": %s", i+1, strings.TrimSpace(line))
+			}
+			freq, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("строка %d: некорректная частота %s", i+1, fields[1])
+			}
+			raw[fields[0]] += freq
+		}
+	}
+
+	freqs := make(map[string]float64, len(raw))
+	for char, freq := range raw {
+		if char == "" || freq < 0 {
+			return nil, fmt.Errorf("некорректная запись частоты символа %q: %g", char, freq)
+		}
+		if !caseSensitive {
+			char = strings.ToLower(char)
+		}
+		freqs[char] += freq
+	}
+	if len(freqs) == 0 {
+		return nil, fmt.Errorf("файл частот символов %s не содержит ни одного символа", filename)
+	}
+	return freqs, nil
+}
+
+// OverrideCharacterFrequencies заменяет частоты символов языковых данных собственными частотами,
+// масштабированными к сумме частот языкового файла. Биграммы остаются из языкового файла
+func OverrideCharacterFrequencies(langData *LanguageData, freqs map[string]float64) *LanguageData {
+	total, customTotal := 0.0, 0.0
+	for _, freq := range langData.Characters {
+		total += freq
+	}
+	for _, freq := range freqs {
+		customTotal += freq
+	}
+
+	overridden := *langData
+	overridden.Characters = make(map[string]float64, len(freqs))
+	for char, freq := range freqs {
+		if total > 0 && customTotal > 0 {
+			freq = freq * total / customTotal
+		}
+		overridden.Characters[char] = freq
+	}
+	return &overridden
+}
+
+// bigramCharsMissingFrequency возвращает символы биграмм языкового файла, для которых
+// нет собственной частоты (такие биграммы не согласуются с частотами символов)
+func bigramCharsMissingFrequency(langData *LanguageData, freqs map[string]float64) []string {
+	seen := make(map[string]bool)
+	var missing []string
+	for bigram := range langData.Bigrams {
+		for _, r := range bigram {
+			char := string(r)
+			if _, exists := freqs[char]; !exists && !seen[char] {
+				seen[char] = true
+				missing = append(missing, char)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// LoadBigramFrequencies загружает частоты биграмм из текстового файла со строками "биграмма<TAB>количество".
+// Вместо табуляции допускаются запятая, точка с запятой и пробелы (количество - после последнего
+// разделителя), первая строка без числа считается заголовком, строки с # пропускаются. Количества
+// суммируются для повторяющихся биграмм и нормируются к сумме 1. Биграммы приводятся к нижнему
+// регистру, если не задан caseSensitive (case_sensitive=1)
+func LoadBigramFrequencies(filename string, caseSensitive bool) (map[string]float64, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при чтении файла биграмм: %w", err)
+	}
+
+	counts := make(map[string]float64)
+	total := 0.0
+	headerAllowed := true
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		sep := strings.LastIndexAny(line, "\t,; ")
+		if sep <= 0 {
+			return nil, fmt.Errorf("строка %d: ожидается \"биграмма<TAB>количество\": %s", i+1, line)
+		}
+		bigram := line[:sep]
+		switch line[sep] {
+		case '\t':
+			bigram = strings.TrimRight(bigram, "\t")
+		case ' ':
+			bigram = strings.TrimRight(bigram, " \t")
+		}
+		count, err := strconv.ParseFloat(strings.TrimSpace(line[sep+1:]), 64)
+		if err != nil {
+			if headerAllowed {
+				headerAllowed = false
+				continue
+			}
+			return nil, fmt.Errorf("строка %d: некорректное количество %s", i+1, strings.TrimSpace(line[sep+1:]))
+		}
+		headerAllowed = false
+
+		if !caseSensitive {
+			bigram = strings.ToLower(bigram)
+		}
+		if len([]rune(bigram)) != 2 {
+			return nil, fmt.Errorf("строка %d: биграмма %q должна состоять из двух символов", i+1, bigram)
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("строка %d: отрицательное количество %g", i+1, count)
+		}
+		counts[bigram] += count
+		total += count
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("файл биграмм %s не содержит ни одной биграммы с ненулевым количеством", filename)
+	}
+
+	freqs := make(map[string]float64, len(counts))
+	for bigram, count := range counts {
+		freqs[bigram] = count / total
+	}
+	return freqs, nil
+}
+
+// OverrideBigramFrequencies заменяет биграммы языковых данных частотами из файла --bigrams.
+// Частоты символов остаются из языкового файла, а если он их не содержит, рассчитываются по
+// биграммам: частота символа - половина суммы частот биграмм, в которые он входит
+func OverrideBigramFrequencies(langData *LanguageData, bigrams map[string]float64) *LanguageData {
+	overridden := *langData
+	overridden.Bigrams = bigrams
+	if len(langData.Characters) == 0 {
+		overridden.Characters = make(map[string]float64)
+		for bigram, freq := range bigrams {
+			for _, r := range bigram {
+				overridden.Characters[string(r)] += freq / 2
+			}
+		}
+	}
+	return &overridden
+}
+
+// renormalizeFrequencies удаляет отобранные элементы и масштабирует частоты оставшихся
+func renormalizeFrequencies(freqs map[string]float64, drop func(string) bool) map[string]float64 {
+	total, kept := 0.0, 0.0
+	for key, freq := range freqs {
+		total += freq
+		if !drop(key) {
+			kept += freq
+		}
+	}
+
+	result := make(map[string]float64, len(freqs))
+	for key, freq := range freqs {
+		if drop(key) {
+			continue
+		}
+		if kept > 0 {
+			freq = freq * total / kept
+		}
+		result[key] = freq
+	}
+	return result
+}
+
+// errNoLayouts возвращается, если в файле раскладок не найдено ни одной раскладки
+var errNoLayouts = errors.New("не найдено ни одной раскладки")
+
 // LoadKeyboardConfig загружает конфигурацию клавиатуры из текстового файла
 func LoadKeyboardConfig(filename string) (*KeyboardConfig, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		return LoadKeyboardConfigJSON(filename)
+	}
+
 	file, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка при чтении файла конфигурации: %w", err)
 	}
 
-	lines := strings.Split(strings.TrimSpace(string(file)), "\n")
+	return parseKeyboardConfig(strings.Split(strings.TrimSpace(string(file)), "\n"))
+}
+
+// parseKeyboardConfig разбирает строки конфигурации в текстовом формате
+func parseKeyboardConfig(lines []string) (*KeyboardConfig, error) {
 	config := &KeyboardConfig{}
 
-	if err := parseEffortMatrix(lines, config); err != nil {
+	// Матрица усилий задается явно или вычисляется из finger_costs и row_multipliers
+	hasFactors, err := parseEffortFactors(lines, config)
+	if err != nil {
 		return nil, err
 	}
+	if !hasFactors {
+		if err := parseEffortMatrix(lines, config); err != nil {
+			return nil, err
+		}
+	}
 
 	if err := parseMaxFingerEfforts(lines, config); err != nil {
 		return nil, err
@@ -54,12 +293,19 @@ func LoadKeyboardConfig(filename string) (*KeyboardConfig, error) {
 	return config, nil
 }
 
-// parseEffortMatrix парсит матрицу усилий из конфигурации
+// parseEffortMatrix парсит матрицу усилий из конфигурации.
+// Матрица задается подряд идущими строками до первой пустой строки. Если в
+// блоке 4 строки, первая из них задает усилия необязательного цифрового ряда.
 func parseEffortMatrix(lines []string, config *KeyboardConfig) error {
-	rowIndex := 0
-	for i := 0; i < len(lines) && rowIndex < 3; i++ {
+	var rows [][]float64
+	for i := 0; i < len(lines) && len(rows) < 4; i++ {
 		line := lines[i]
 
+		// Пустая строка после трех строк матрицы завершает блок
+		if strings.TrimSpace(line) == "" && len(rows) >= 3 {
+			break
+		}
+
 		// Удаляем комментарии (все после #)
 		commentIdx := strings.Index(line, "#")
 		if commentIdx != -1 {
@@ -75,27 +321,108 @@ func parseEffortMatrix(lines []string, config *KeyboardConfig) error {
 
 		parts := strings.Fields(line)
 		if len(parts) < 10 {
-			return fmt.Errorf("строка %d имеет менее 10 значений усилий", rowIndex+1)
+			return fmt.Errorf("строка %d имеет менее 10 значений усилий", len(rows)+1)
 		}
 
+		row := make([]float64, 10)
 		for col := 0; col < 10; col++ {
 			val, err := strconv.ParseFloat(parts[col], 64)
 			if err != nil {
-				return fmt.Errorf("ошибка парсинга усилия [%d][%d]: %w", rowIndex, col, err)
+				return fmt.Errorf("ошибка парсинга усилия [%d][%d]: %w", len(rows), col, err)
 			}
-			config.EffortMatrix[rowIndex][col] = val
+			row[col] = val
 		}
 
-		rowIndex++
+		rows = append(rows, row)
 	}
 
-	if rowIndex < 3 {
+	if len(rows) < 3 {
 		return fmt.Errorf("недостаточно строк для матрицы усилий")
 	}
 
+	if len(rows) == 4 {
+		config.NumberRowEfforts = rows[0]
+		rows = rows[1:]
+	}
+
+	for row := 0; row < 3; row++ {
+		copy(config.EffortMatrix[row][:], rows[row])
+	}
+
 	return nil
 }
 
+// parseEffortFactors вычисляет матрицу усилий из параметров finger_costs (базовые усилия
+// 8 пальцев или 10 колонок через запятую) и row_multipliers (множители 3 рядов через запятую):
+// усилие клавиши равно базовому усилию ее пальца или колонки, умноженному на множитель ряда.
+// Возвращает false, если параметры не заданы и матрица должна задаваться явно
+func parseEffortFactors(lines []string, config *KeyboardConfig) (bool, error) {
+	var fingerCosts, rowMultipliers string
+	hasFingerCosts, hasRowMultipliers := false, false
+	for _, line := range lines {
+		if commentIdx := strings.Index(line, "#"); commentIdx != -1 {
+			line = line[:commentIdx]
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "finger_costs=") {
+			fingerCosts, hasFingerCosts = strings.TrimPrefix(line, "finger_costs="), true
+		} else if strings.HasPrefix(line, "row_multipliers=") {
+			rowMultipliers, hasRowMultipliers = strings.TrimPrefix(line, "row_multipliers="), true
+		}
+	}
+
+	if !hasFingerCosts && !hasRowMultipliers {
+		return false, nil
+	}
+	if !hasFingerCosts || !hasRowMultipliers {
+		return false, fmt.Errorf("для вычисления матрицы усилий необходимо задать и finger_costs, и row_multipliers")
+	}
+	if parseEffortMatrix(lines, &KeyboardConfig{}) == nil {
+		return false, fmt.Errorf("матрица усилий задана одновременно явно и параметрами finger_costs и row_multipliers")
+	}
+
+	costs, err := parseEffortFactorValues("finger_costs", fingerCosts)
+	if err != nil {
+		return false, err
+	}
+	if len(costs) != 8 && len(costs) != 10 {
+		return false, fmt.Errorf("finger_costs должен содержать 8 значений (по пальцам) или 10 значений (по колонкам), найдено %d", len(costs))
+	}
+	multipliers, err := parseEffortFactorValues("row_multipliers", rowMultipliers)
+	if err != nil {
+		return false, err
+	}
+	if len(multipliers) != 3 {
+		return false, fmt.Errorf("row_multipliers должен содержать 3 значения, найдено %d", len(multipliers))
+	}
+
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			index := col
+			if len(costs) == 8 {
+				index = getFingerForKey(row, col)
+			}
+			cost := costs[index]
+			config.EffortMatrix[row][col] = cost * multipliers[row]
+		}
+	}
+
+	return true, nil
+}
+
+// parseEffortFactorValues парсит неотрицательные значения через запятую
+func parseEffortFactorValues(name, value string) ([]float64, error) {
+	var values []float64
+	for _, part := range strings.Split(value, ",") {
+		val, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("некорректное значение в %s: %s", name, strings.TrimSpace(part))
+		}
+		values = append(values, val)
+	}
+	return values, nil
+}
+
 // parseMaxFingerEfforts парсит максимальные значения усилия для каждого пальца
 func parseMaxFingerEfforts(lines []string, config *KeyboardConfig) error {
 	// Начинаем поиск с начала списка строк, пропуская комментарии и пустые строки
@@ -117,9 +444,9 @@ func parseMaxFingerEfforts(lines []string, config *KeyboardConfig) error {
 			continue
 		}
 
-		// Проверяем, содержит ли строка 8 числовых значений (максимальные усилия для пальцев)
+		// Проверяем, содержит ли строка 8 числовых значений, строки матрицы усилий из 10 значений пропускаем (максимальные усилия для пальцев)
 		parts := strings.Fields(line)
-		if len(parts) >= 8 {
+		if len(parts) >= 8 && len(parts) < 10 {
 			// Проверяем, являются ли первые 8 элементов числами
 			allNumbers := true
 			for i := 0; i < 8 && i < len(parts); i++ {
@@ -173,9 +500,9 @@ func parseMaxFingerEfforts(lines []string, config *KeyboardConfig) error {
 			continue
 		}
 
-		// Проверяем, содержит ли строка 8 числовых значений (штрафы за превышение)
+		// Проверяем, содержит ли строка 8 числовых значений, строки матрицы усилий из 10 значений пропускаем (штрафы за превышение)
 		parts := strings.Fields(line)
-		if len(parts) >= 8 {
+		if len(parts) >= 8 && len(parts) < 10 {
 			// Проверяем, являются ли первые 8 элементов числами
 			allNumbers := true
 			for i := 0; i < 8 && i < len(parts); i++ {
@@ -279,9 +606,9 @@ func parseFixedPositions(lines []string, config *KeyboardConfig) error {
 	return nil
 }
 
-// parseWeights парсит коэффициенты весов
-func parseWeights(lines []string, config *KeyboardConfig) error {
-	config.Weights = WeightConfig{
+// defaultWeights возвращает коэффициенты весов, используемые, если параметр не задан в конфигурации
+func defaultWeights() WeightConfig {
+	return WeightConfig{
 		Effort:         0.3,
 		HandSwitch:     0.2,
 		SameFinger:     0.15,
@@ -312,6 +639,23 @@ func parseWeights(lines []string, config *KeyboardConfig) error {
 		HSBStrictMode:   1,  // Strict mode ON by default
 		FSBStrictMode:   1,  // Strict mode ON by default
 	}
+}
+
+// parseWeights парсит коэффициенты весов
+func parseWeights(lines []string, config *KeyboardConfig) error {
+	config.Weights = defaultWeights()
+
+	// Разделение половинок при выводе раскладок по умолчанию
+	config.SplitCol = 5
+
+	// Целевое распределение нагрузки по рукам по умолчанию - поровну
+	config.HandBiasTarget = defaultHandBiasTarget
+
+	// Перестановки при возмущении по умолчанию, сами рестарты с возмущением отключены (perturbation_fraction=0)
+	config.PerturbationSwaps = defaultPerturbationSwaps
+
+	// Домашние позиции по умолчанию - 8 клавиш среднего ряда под пальцами в исходном положении
+	config.HomeKeys = append([]int(nil), defaultHomeKeys...)
 
 	// Создаем флаги для отслеживания, были ли прочитаны все параметры
 	flags := make(map[string]bool)
@@ -520,6 +864,125 @@ func parseWeights(lines []string, config *KeyboardConfig) error {
 		} else if strings.HasPrefix(line, "PR3=") {
 			val, _ := strconv.ParseFloat(strings.TrimPrefix(line, "PR3="), 64)
 			config.RowEffortPenalties[2] = val
+		} else if strings.HasPrefix(line, "PinkyNorm=") {
+			val, _ := strconv.ParseFloat(strings.TrimPrefix(line, "PinkyNorm="), 64)
+			config.Weights.PinkyNorm = val
+		} else if strings.HasPrefix(line, "HomeUseNorm=") {
+			val, _ := strconv.ParseFloat(strings.TrimPrefix(line, "HomeUseNorm="), 64)
+			config.Weights.HomeUseNorm = val
+		} else if strings.HasPrefix(line, "RowJumpNorm=") {
+			val, _ := strconv.ParseFloat(strings.TrimPrefix(line, "RowJumpNorm="), 64)
+			config.Weights.RowJumpNorm = val
+		} else if strings.HasPrefix(line, "LSB_all=") {
+			val, _ := strconv.ParseFloat(strings.TrimPrefix(line, "LSB_all="), 64)
+			config.Weights.LSBAll = val
+		} else if strings.HasPrefix(line, "SKB=") {
+			val, _ := strconv.ParseFloat(strings.TrimPrefix(line, "SKB="), 64)
+			config.Weights.SKB = val
+		} else if strings.HasPrefix(line, "HandBias=") {
+			val, _ := strconv.ParseFloat(strings.TrimPrefix(line, "HandBias="), 64)
+			config.Weights.HandBias = val
+		} else if strings.HasPrefix(line, "hand_bias_target=") {
+			val, err := strconv.ParseFloat(strings.TrimPrefix(line, "hand_bias_target="), 64)
+			if err != nil || val < 0 || val > 100 {
+				return fmt.Errorf("некорректное значение hand_bias_target: %s (допустимо 0-100)", strings.TrimPrefix(line, "hand_bias_target="))
+			}
+			config.HandBiasTarget = val
+		} else if strings.HasPrefix(line, "ICS=") {
+			val, _ := strconv.ParseFloat(strings.TrimPrefix(line, "ICS="), 64)
+			config.Weights.ICS = val
+		} else if strings.HasPrefix(line, "SymSFB=") {
+			val, _ := strconv.ParseFloat(strings.TrimPrefix(line, "SymSFB="), 64)
+			config.Weights.SymSFB = val
+		} else if strings.HasPrefix(line, "split_col=") {
+			val, err := strconv.Atoi(strings.TrimPrefix(line, "split_col="))
+			if err != nil || val < 0 || val > 10 {
+				return fmt.Errorf("некорректное значение split_col: %s (допустимо 0-10)", strings.TrimPrefix(line, "split_col="))
+			}
+			config.SplitCol = val
+		} else if strings.HasPrefix(line, "precision=") {
+			val, err := strconv.Atoi(strings.TrimPrefix(line, "precision="))
+			if err != nil || val < minTablePrecision || val > maxTablePrecision {
+				return fmt.Errorf("некорректное значение precision: %s (допустимо %d-%d)", strings.TrimPrefix(line, "precision="), minTablePrecision, maxTablePrecision)
+			}
+			config.Precision = val
+		} else if strings.HasPrefix(line, "thumb_cols=") {
+			thumbCols, err := parseColumnList("thumb_cols", strings.TrimPrefix(line, "thumb_cols="))
+			if err != nil {
+				return err
+			}
+			config.ThumbCols = thumbCols
+		} else if strings.HasPrefix(line, "excluded_cols=") {
+			excludedCols, err := parseColumnList("excluded_cols", strings.TrimPrefix(line, "excluded_cols="))
+			if err != nil {
+				return err
+			}
+			config.ExcludedCols = excludedCols
+		} else if strings.HasPrefix(line, "space_col=") {
+			val, err := strconv.Atoi(strings.TrimPrefix(line, "space_col="))
+			if err != nil || val < 0 || val > 10 {
+				return fmt.Errorf("некорректное значение space_col: %s (допустимо 0-10)", strings.TrimPrefix(line, "space_col="))
+			}
+			config.SpaceCol = val
+		} else if strings.HasPrefix(line, "space_effort=") {
+			val, _ := strconv.ParseFloat(strings.TrimPrefix(line, "space_effort="), 64)
+			config.SpaceEffort = val
+		} else if strings.HasPrefix(line, "gg_min_improvement=") {
+			val, err := strconv.ParseFloat(strings.TrimPrefix(line, "gg_min_improvement="), 64)
+			if err != nil || val < 0 {
+				return fmt.Errorf("некорректное значение gg_min_improvement: %s", strings.TrimPrefix(line, "gg_min_improvement="))
+			}
+			config.GGMinImprovement = val
+		} else if strings.HasPrefix(line, "perturbation_swaps=") {
+			val, err := strconv.Atoi(strings.TrimPrefix(line, "perturbation_swaps="))
+			if err != nil || val < 0 {
+				return fmt.Errorf("некорректное значение perturbation_swaps: %s", strings.TrimPrefix(line, "perturbation_swaps="))
+			}
+			config.PerturbationSwaps = val
+		} else if strings.HasPrefix(line, "perturbation_fraction=") {
+			val, err := strconv.ParseFloat(strings.TrimPrefix(line, "perturbation_fraction="), 64)
+			if err != nil || val < 0 || val > 1 {
+				return fmt.Errorf("некорректное значение perturbation_fraction: %s (допустимо от 0 до 1)", strings.TrimPrefix(line, "perturbation_fraction="))
+			}
+			config.PerturbationFraction = val
+		} else if strings.HasPrefix(line, "percent_display=") {
+			val := strings.TrimSpace(strings.TrimPrefix(line, "percent_display="))
+			if val != percentDisplayClamp && val != percentDisplayMark {
+				return fmt.Errorf("некорректное значение percent_display: %s (допустимо %s или %s)", val, percentDisplayClamp, percentDisplayMark)
+			}
+			config.PercentDisplay = val
+		} else if strings.HasPrefix(line, "effort_scaled_bigrams=") {
+			val := strings.TrimSpace(strings.TrimPrefix(line, "effort_scaled_bigrams="))
+			if val != "0" && val != "1" {
+				return fmt.Errorf("некорректное значение effort_scaled_bigrams: %s (допустимо 0 или 1)", val)
+			}
+			config.EffortScaledBigrams = val == "1"
+		} else if strings.HasPrefix(line, "case_sensitive=") {
+			val := strings.TrimSpace(strings.TrimPrefix(line, "case_sensitive="))
+			if val != "0" && val != "1" {
+				return fmt.Errorf("некорректное значение case_sensitive: %s (допустимо 0 или 1)", val)
+			}
+			config.CaseSensitive = val == "1"
+		} else if strings.HasPrefix(line, "max_finger_travel=") {
+			values, err := parseFingerValues("max_finger_travel", strings.TrimPrefix(line, "max_finger_travel="))
+			if err != nil {
+				return err
+			}
+			config.MaxFingerTravel = values
+		} else if strings.HasPrefix(line, "finger_travel_penalties=") {
+			values, err := parseFingerValues("finger_travel_penalties", strings.TrimPrefix(line, "finger_travel_penalties="))
+			if err != nil {
+				return err
+			}
+			config.FingerTravelPenalties = values
+		} else if strings.HasPrefix(line, "home_keys=") {
+			homeKeys, err := parseHomeKeys(strings.TrimPrefix(line, "home_keys="))
+			if err != nil {
+				return err
+			}
+			config.HomeKeys = homeKeys
+		} else if strings.HasPrefix(line, "vowels=") {
+			config.Vowels = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "vowels=")))
 		}
 	}
 
@@ -534,6 +997,64 @@ func parseWeights(lines []string, config *KeyboardConfig) error {
 	return nil
 }
 
+// parseColumnList парсит список колонок параметра name (thumb_cols, excluded_cols) в формате "5,6"
+// (номера колонок 1-10) и возвращает номера колонок 0-9
+func parseColumnList(name, value string) ([]int, error) {
+	var columns []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		col, err := strconv.Atoi(part)
+		if err != nil || col < 1 || col > 10 {
+			return nil, fmt.Errorf("некорректная колонка в %s: %s (допустимо 1-10)", name, part)
+		}
+		columns = append(columns, col-1)
+	}
+	return columns, nil
+}
+
+// parseFingerValues парсит 8 значений по пальцам через запятую (пустое значение - все нули)
+func parseFingerValues(name, value string) ([8]float64, error) {
+	var values [8]float64
+	if strings.TrimSpace(value) == "" {
+		return values, nil
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) != 8 {
+		return values, fmt.Errorf("%s должен содержать 8 значений через запятую, найдено %d", name, len(parts))
+	}
+	for i, part := range parts {
+		val, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || val < 0 {
+			return values, fmt.Errorf("некорректное значение %s для пальца %d: %s", name, i+1, strings.TrimSpace(part))
+		}
+		values[i] = val
+	}
+	return values, nil
+}
+
+// defaultHomeKeys домашние позиции по умолчанию: колонки 1-4 и 7-10 среднего ряда
+var defaultHomeKeys = []int{10, 11, 12, 13, 16, 17, 18, 19}
+
+// parseHomeKeys парсит список домашних позиций в формате "11,12,13,14" (номера позиций 1-30)
+func parseHomeKeys(value string) ([]int, error) {
+	var homeKeys []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pos, err := strconv.Atoi(part)
+		if err != nil || pos < 1 || pos > 30 {
+			return nil, fmt.Errorf("некорректная позиция в home_keys: %s (допустимо 1-30)", part)
+		}
+		homeKeys = append(homeKeys, pos-1)
+	}
+	return homeKeys, nil
+}
+
 // isBigramIndividualCoeffLine проверяет, является ли строка индивидуальным коэффициентом для биграммы
 func isBigramIndividualCoeffLine(line string) bool {
 	// Проверяем, начинается ли строка с числа (возможно с минусом и точкой), за которым следует двоеточие
@@ -551,6 +1072,98 @@ func isBigramIndividualCoeffLine(line string) bool {
 	return err == nil
 }
 
+// validBigramPositions проверяет номера позиций биграммы с индивидуальным коэффициентом (1-30)
+func validBigramPositions(pos1, pos2 int) bool {
+	return pos1 >= 1 && pos1 <= 30 && pos2 >= 1 && pos2 <= 30
+}
+
+// LoadBigramCoeffs загружает индивидуальные коэффициенты биграмм из файла. Файл с расширением
+// .json содержит список групп в формате bigram_coeffs из config.json, остальные файлы - строки
+// "pos1 pos2 coeff" (значения разделяются пробелами, запятыми или точкой с запятой)
+func LoadBigramCoeffs(filename string) ([]BigramIndividualCoeff, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при чтении файла коэффициентов: %w", err)
+	}
+
+	coeffs := []BigramIndividualCoeff{}
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		var groups []bigramCoeffJSON
+		if err := json.Unmarshal(data, &groups); err != nil {
+			return nil, fmt.Errorf("ошибка при парсинге JSON коэффициентов: %w", err)
+		}
+		for _, group := range groups {
+			for _, bigram := range group.Bigrams {
+				if !validBigramPositions(bigram[0], bigram[1]) {
+					return nil, fmt.Errorf("некорректная биграмма %d-%d (допустимы позиции 1-30)", bigram[0], bigram[1])
+				}
+				coeffs = append(coeffs, BigramIndividualCoeff{Pos1: bigram[0] - 1, Pos2: bigram[1] - 1, Coeff: group.Coeff})
+			}
+		}
+		return coeffs, nil
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ';' || r == ' ' || r == '\t'
+		})
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("строка %d: ожидается \"pos1 pos2 coeff\": %s", i+1, line)
+		}
+		pos1, err1 := strconv.Atoi(fields[0])
+		pos2, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil || !validBigramPositions(pos1, pos2) {
+			return nil, fmt.Errorf("строка %d: некорректная биграмма %s-%s (допустимы позиции 1-30)", i+1, fields[0], fields[1])
+		}
+		coeff, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("строка %d: некорректный коэффициент %s", i+1, fields[2])
+		}
+		coeffs = append(coeffs, BigramIndividualCoeff{Pos1: pos1 - 1, Pos2: pos2 - 1, Coeff: coeff})
+	}
+	return coeffs, nil
+}
+
+// SaveBigramCoeffs сохраняет индивидуальные коэффициенты биграмм в файл в формате LoadBigramCoeffs
+func SaveBigramCoeffs(filename string, coeffs []BigramIndividualCoeff) error {
+	var data []byte
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		// Группируем биграммы по коэффициентам в порядке их первого появления
+		groups := []bigramCoeffJSON{}
+		groupIndex := make(map[float64]int)
+		for _, coeff := range coeffs {
+			idx, exists := groupIndex[coeff.Coeff]
+			if !exists {
+				idx = len(groups)
+				groupIndex[coeff.Coeff] = idx
+				groups = append(groups, bigramCoeffJSON{Coeff: coeff.Coeff, Bigrams: [][2]int{}})
+			}
+			groups[idx].Bigrams = append(groups[idx].Bigrams, [2]int{coeff.Pos1 + 1, coeff.Pos2 + 1})
+		}
+		encoded, err := json.MarshalIndent(groups, "", "  ")
+		if err != nil {
+			return fmt.Errorf("ошибка при формировании JSON коэффициентов: %w", err)
+		}
+		data = append(encoded, '\n')
+	} else {
+		var sb strings.Builder
+		sb.WriteString("# pos1 pos2 coeff\n")
+		for _, coeff := range coeffs {
+			sb.WriteString(fmt.Sprintf("%d %d %s\n", coeff.Pos1+1, coeff.Pos2+1, strconv.FormatFloat(coeff.Coeff, 'g', -1, 64)))
+		}
+		data = []byte(sb.String())
+	}
+
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("ошибка при записи файла коэффициентов: %w", err)
+	}
+	return nil
+}
+
 // parseBigramIndividualCoeffLine парсит строку с индивидуальными коэффициентами для биграмм
 func parseBigramIndividualCoeffLine(line string) ([]BigramIndividualCoeff, error) {
 	// Ищем двоеточие
@@ -589,7 +1202,7 @@ func parseBigramIndividualCoeffLine(line string) ([]BigramIndividualCoeff, error
 		pos2, err2 := strconv.Atoi(bigramParts[1])
 
 		// Проверяем, что номера позиций валидны (1-30)
-		if err1 != nil || err2 != nil || pos1 < 1 || pos1 > 30 || pos2 < 1 || pos2 > 30 {
+		if err1 != nil || err2 != nil || !validBigramPositions(pos1, pos2) {
 			continue // Пропускаем неправильные биграммы
 		}
 
@@ -631,12 +1244,19 @@ func LoadLayouts(filename string) (*ParsedLayouts, error) {
 			if currentLayout == nil {
 				// Если раскладка еще не начата, это комментарий в заголовке
 				if inHeader {
+					if strings.HasPrefix(trimmedLine, layoutSeparatorDirective) {
+						separator, err := parseLayoutSeparator(strings.TrimPrefix(trimmedLine, layoutSeparatorDirective))
+						if err != nil {
+							return nil, err
+						}
+						layouts.Separator = separator
+					}
 					layouts.FileHeaderComments = append(layouts.FileHeaderComments, originalLine)
 				} else {
 					// Комментарии до раскладки сохраняем как PreComments для следующей раскладки
 					preLayoutComments = append(preLayoutComments, originalLine)
 				}
-			} else if rowCount == 3 {
+			} else if rowCount >= 3 {
 				// Комментарии после полной раскладки сохраняем как PostComments
 				currentLayout.PostComments = append(currentLayout.PostComments, originalLine)
 			} else {
@@ -649,7 +1269,7 @@ func LoadLayouts(filename string) (*ParsedLayouts, error) {
 
 		// Пропускаем пустые строки
 		if trimmedLine == "" {
-			if currentLayout != nil && rowCount == 3 {
+			if currentLayout != nil && rowCount >= 3 {
 				// Завершаем текущую раскладку
 				currentLayout.PreComments = preLayoutComments
 				layouts.Layouts = append(layouts.Layouts, *currentLayout)
@@ -673,24 +1293,328 @@ func LoadLayouts(filename string) (*ParsedLayouts, error) {
 			preLayoutComments = []string{}  // Сбрасываем, чтобы не использовать повторно
 		} else if rowCount < 3 {
 			// Парсим строку раскладки (включая возможные комментарии в конце строки)
-			currentLayout.Keys[rowCount] = parseLayoutRowWithComments(line)
+			currentLayout.Keys[rowCount] = parseLayoutRowWithComments(line, layouts.Separator)
+			rowCount++
+		} else if rowCount == 3 {
+			// Четвертая строка означает, что первая строка была цифровым рядом
+			numberRow := currentLayout.Keys[0]
+			currentLayout.NumberRow = numberRow[:]
+			currentLayout.Keys[0] = currentLayout.Keys[1]
+			currentLayout.Keys[1] = currentLayout.Keys[2]
+			currentLayout.Keys[2] = parseLayoutRowWithComments(line, layouts.Separator)
+			// Комментарии между строками раскладки не считаются PostComments
+			preLayoutComments = append(preLayoutComments, currentLayout.PostComments...)
+			currentLayout.PostComments = nil
 			rowCount++
 		}
 	}
 
 	// Добавляем последнюю раскладку, если она есть
-	if currentLayout != nil && rowCount == 3 {
+	if currentLayout != nil && rowCount >= 3 {
 		currentLayout.PreComments = preLayoutComments
 		layouts.Layouts = append(layouts.Layouts, *currentLayout)
 	}
 
 	if len(layouts.Layouts) == 0 {
-		return nil, fmt.Errorf("не найдено ни одной раскладки")
+		return nil, errNoLayouts
 	}
 
 	return layouts, nil
 }
 
+// layoutSeparatorDirective директива в заголовке файла раскладок, задающая разделитель
+// клавиш в строках раскладок, например "# separator: tab". Без директивы клавиши
+// разделяются пробелами
+const layoutSeparatorDirective = "# separator:"
+
+// layoutSeparatorNames именованные значения директивы separator
+var layoutSeparatorNames = map[string]string{
+	"tab":       "\t",
+	"comma":     ",",
+	"semicolon": ";",
+	"pipe":      "|",
+}
+
+// parseLayoutSeparator возвращает разделитель клавиш по значению директивы separator:
+// имени (space, tab, comma, semicolon, pipe) или одному символу
+func parseLayoutSeparator(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "space" {
+		return "", nil
+	}
+	if separator, exists := layoutSeparatorNames[value]; exists {
+		return separator, nil
+	}
+	if len([]rune(value)) != 1 || value == "#" {
+		return "", fmt.Errorf("некорректный разделитель клавиш в файле раскладок: %q (допустимо space, tab, comma, semicolon, pipe или один символ)", value)
+	}
+	return value, nil
+}
+
+// layoutSeparatorHeader возвращает строку директивы separator для записи в начало
+// файла раскладок или пустую строку для разделения пробелами
+func layoutSeparatorHeader(separator string) string {
+	if separator == "" {
+		return ""
+	}
+	for name, value := range layoutSeparatorNames {
+		if value == separator {
+			return layoutSeparatorDirective + " " + name + "\n"
+		}
+	}
+	return layoutSeparatorDirective + " " + separator + "\n"
+}
+
+// formatLayoutRow форматирует ряд раскладки для записи в файл. Без разделителя клавиши
+// разделяются пробелами с дополнительным пробелом между половинками (после 5 столбца)
+func formatLayoutRow(keys [10]string, separator string) string {
+	if separator != "" {
+		return strings.Join(keys[:], separator)
+	}
+	line := ""
+	for col := 0; col < 10; col++ {
+		if col > 0 {
+			line += " "
+		}
+		line += keys[col]
+		// Добавляем дополнительный пробел между половинками (между 5 и 6 столбцом)
+		if col == 4 {
+			line += " "
+		}
+	}
+	return line
+}
+
+// layoutFileRows возвращает ряды раскладки в порядке записи в файл:
+// цифровой ряд (если он есть) и три основных ряда
+func layoutFileRows(layout Layout) [][10]string {
+	rows := make([][10]string, 0, 4)
+	if len(layout.NumberRow) == 10 {
+		var numberRow [10]string
+		copy(numberRow[:], layout.NumberRow)
+		rows = append(rows, numberRow)
+	}
+	for row := 0; row < 3; row++ {
+		rows = append(rows, layout.Keys[row])
+	}
+	return rows
+}
+
+// layoutFileListSeparator разделитель имен файлов в --layout для загрузки раскладок из нескольких файлов
+const layoutFileListSeparator = ","
+
+// splitLayoutFiles возвращает имена файлов раскладок из значения --layout (a.txt,b.txt)
+func splitLayoutFiles(layoutFile string) []string {
+	var files []string
+	for _, file := range strings.Split(layoutFile, layoutFileListSeparator) {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// LoadLayoutFiles загружает раскладки из файла или, если в layoutFile перечислено несколько
+// файлов через запятую, объединяет раскладки из всех файлов функцией LoadLayoutsMerged
+func LoadLayoutFiles(layoutFile string) (*ParsedLayouts, error) {
+	files := splitLayoutFiles(layoutFile)
+	if len(files) <= 1 {
+		return LoadLayoutsOrEmpty(layoutFile)
+	}
+	return LoadLayoutsMerged(files)
+}
+
+// LoadLayoutsMerged загружает раскладки из нескольких файлов в один список в порядке файлов.
+// Каждая раскладка помечается исходным файлом, комментарии заголовка и разделитель клавиш
+// берутся из первого файла. Файлы без раскладок пропускаются
+func LoadLayoutsMerged(filenames []string) (*ParsedLayouts, error) {
+	merged := &ParsedLayouts{
+		Layouts:            []Layout{},
+		FileHeaderComments: []string{},
+	}
+	for i, filename := range filenames {
+		layouts, err := LoadLayouts(filename)
+		if errors.Is(err, errNoLayouts) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		if i == 0 {
+			merged.FileHeaderComments = layouts.FileHeaderComments
+			merged.Separator = layouts.Separator
+		}
+		appendLayouts(merged, layouts.Layouts, filepath.Base(filename))
+	}
+	return merged, nil
+}
+
+// appendLayouts добавляет раскладки из файла source в конец списка, помечая их исходным файлом.
+// Раскладка с именем, которое уже занято раскладкой из другого файла, получает имя "имя (файл)"
+func appendLayouts(parsedLayouts *ParsedLayouts, layouts []Layout, source string) {
+	for _, layout := range layouts {
+		layout.Source = source
+		name := layout.Name
+		for suffix := 1; layoutNameTakenByOtherSource(parsedLayouts, name, source); suffix++ {
+			if suffix == 1 {
+				name = fmt.Sprintf("%s (%s)", layout.Name, source)
+			} else {
+				name = fmt.Sprintf("%s (%s %d)", layout.Name, source, suffix)
+			}
+		}
+		layout.Name = name
+		parsedLayouts.Layouts = append(parsedLayouts.Layouts, layout)
+	}
+}
+
+// layoutNameTakenByOtherSource проверяет, есть ли в списке раскладка с таким именем из другого файла
+func layoutNameTakenByOtherSource(parsedLayouts *ParsedLayouts, name, source string) bool {
+	for _, layout := range parsedLayouts.Layouts {
+		if layout.Name == name && layout.Source != source {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadLayoutsOrEmpty загружает раскладки, допуская отсутствующий или пустой файл.
+// В этом случае сессия начинается без раскладок, а найденные раскладки можно
+// сохранить командой s
+func LoadLayoutsOrEmpty(filename string) (*ParsedLayouts, error) {
+	layouts, err := LoadLayouts(filename)
+	if errors.Is(err, errNoLayouts) || errors.Is(err, os.ErrNotExist) {
+		return &ParsedLayouts{
+			Layouts:            []Layout{},
+			FileHeaderComments: []string{},
+		}, nil
+	}
+	return layouts, err
+}
+
+// CheckLayoutsFileComplete проверяет, что файл раскладок записан полностью: в нем есть хотя бы
+// одна раскладка, в каждой раскладке заполнены все клавиши, а последняя раскладка не оборвана.
+// LoadLayoutsOrEmpty принимает пустой файл, а LoadLayouts пропускает неполную последнюю раскладку,
+// поэтому файл, который редактор еще не дописал, без этой проверки читается без ошибки
+func CheckLayoutsFileComplete(filename string) error {
+	layouts, err := LoadLayouts(filename)
+	if err != nil {
+		return err
+	}
+	for _, layout := range layouts.Layouts {
+		for row := 0; row < 3; row++ {
+			for col := 0; col < 10; col++ {
+				if layout.Keys[row][col] == "" {
+					return fmt.Errorf("раскладка %s в файле %s неполная: ряд %d содержит меньше 10 клавиш", layout.Name, filename, row+1)
+				}
+			}
+		}
+	}
+
+	// Строки раскладки (название и ряды) идут подряд, блок короче названия и трех рядов - оборванная раскладка
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("ошибка при чтении файла раскладок: %w", err)
+	}
+	blockLines := 0
+	for _, line := range append(strings.Split(string(data), "\n"), "") {
+		trimmedLine := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmedLine, "#") {
+			continue
+		}
+		if trimmedLine != "" {
+			blockLines++
+			continue
+		}
+		if blockLines > 0 && blockLines < 4 {
+			return fmt.Errorf("файл %s содержит неполную раскладку", filename)
+		}
+		blockLines = 0
+	}
+	return nil
+}
+
+// VerifyLayoutsFile перечитывает записанный файл раскладок и сравнивает прочитанные раскладки
+// с записанными. Клавиши разделяются пробелами и читаются через strings.Fields, поэтому пустая
+// клавиша, клавиша с пробелом или символом комментария # после записи читаются иначе
+func VerifyLayoutsFile(filename string, written []Layout) error {
+	parsed, err := LoadLayoutsOrEmpty(filename)
+	if err != nil {
+		return fmt.Errorf("ошибка проверки записанного файла %s: %w", filename, err)
+	}
+	if len(parsed.Layouts) != len(written) {
+		return fmt.Errorf("файл %s после записи читается неверно: раскладок %d вместо %d", filename, len(parsed.Layouts), len(written))
+	}
+	for i := range written {
+		if diff := layoutRoundTripDiff(&written[i], &parsed.Layouts[i]); diff != "" {
+			return fmt.Errorf("раскладка [%d] %s после записи в файл %s читается иначе: %s", i+1, written[i].Name, filename, diff)
+		}
+	}
+	return nil
+}
+
+// ReplaceLayoutsFile записывает содержимое content во временный файл в каталоге файла filename,
+// проверяет через VerifyLayoutsFile, что из него читаются раскладки written, и только после
+// этого заменяет им filename. Если проверка не прошла, исходный файл остается без изменений
+func ReplaceLayoutsFile(filename, content string, written []Layout) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), ".kbda-*.tmp")
+	if err != nil {
+		return fmt.Errorf("ошибка создания временного файла для %s: %v", filename, err)
+	}
+	tempName := file.Name()
+	defer os.Remove(tempName)
+
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return fmt.Errorf("ошибка записи временного файла для %s: %v", filename, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("ошибка записи временного файла для %s: %v", filename, err)
+	}
+
+	if err := VerifyLayoutsFile(tempName, written); err != nil {
+		return fmt.Errorf("файл %s не изменен: %w", filename, err)
+	}
+
+	// Сохраняем права доступа существующего файла
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(tempName, mode); err != nil {
+		return fmt.Errorf("ошибка установки прав доступа временного файла для %s: %v", filename, err)
+	}
+	if err := os.Rename(tempName, filename); err != nil {
+		return fmt.Errorf("ошибка замены файла %s: %v", filename, err)
+	}
+	return nil
+}
+
+// layoutRoundTripDiff описывает первое отличие раскладки, прочитанной из файла, от записанной
+// или возвращает пустую строку, если раскладки совпадают
+func layoutRoundTripDiff(written, read *Layout) string {
+	if read.Name != written.Name {
+		return fmt.Sprintf("название %q вместо %q", read.Name, written.Name)
+	}
+	if len(read.NumberRow) != len(written.NumberRow) {
+		return fmt.Sprintf("цифровой ряд из %d клавиш вместо %d", len(read.NumberRow), len(written.NumberRow))
+	}
+	for col := range written.NumberRow {
+		if read.NumberRow[col] != written.NumberRow[col] {
+			return fmt.Sprintf("цифровой ряд, колонка %d: %q вместо %q", col+1, read.NumberRow[col], written.NumberRow[col])
+		}
+	}
+	if !read.Equals(written) {
+		for row := 0; row < 3; row++ {
+			for col := 0; col < 10; col++ {
+				if read.Keys[row][col] != written.Keys[row][col] {
+					return fmt.Sprintf("ряд %d, колонка %d: %q вместо %q", row+1, col+1, read.Keys[row][col], written.Keys[row][col])
+				}
+			}
+		}
+	}
+	return ""
+}
+
 // WriteLayoutsToFile записывает раскладки в файл с сохранением комментариев
 func WriteLayoutsToFile(parsedLayouts *ParsedLayouts, filename string) error {
 	file, err := os.Create(filename)
@@ -728,18 +1652,8 @@ func WriteLayoutsToFile(parsedLayouts *ParsedLayouts, filename string) error {
 		}
 
 		// Записываем строки раскладки
-		for row := 0; row < 3; row++ {
-			line := ""
-			for col := 0; col < 10; col++ {
-				if col > 0 {
-					line += " "
-				}
-				line += layout.Keys[row][col]
-				// Добавляем дополнительный пробел между половинками (между 5 и 6 столбцом)
-				if col == 4 {
-					line += " "
-				}
-			}
+		for _, keys := range layoutFileRows(layout) {
+			line := formatLayoutRow(keys, parsedLayouts.Separator)
 			if _, err := file.WriteString(line + "\n"); err != nil {
 				return fmt.Errorf("ошибка записи строки раскладки: %v", err)
 			}
@@ -763,8 +1677,10 @@ func WriteLayoutsToFile(parsedLayouts *ParsedLayouts, filename string) error {
 	return nil
 }
 
-// parseLayoutRowWithComments разбирает строку раскладки, извлекая клавиши и комментарии
-func parseLayoutRowWithComments(line string) [10]string {
+// parseLayoutRowWithComments разбирает строку раскладки, извлекая клавиши и комментарии.
+// Если задан разделитель separator, клавиши разделяются им и могут содержать пробелы,
+// пустое значение между разделителями означает пустую клавишу
+func parseLayoutRowWithComments(line string, separator string) [10]string {
 	// Ищем комментарий в конце строки (после #)
 	commentStart := strings.Index(line, "#")
 	var layoutPart string
@@ -775,7 +1691,14 @@ func parseLayoutRowWithComments(line string) [10]string {
 	}
 
 	// Разбираем клавиши
-	parts := strings.Fields(layoutPart)
+	var parts []string
+	if separator == "" {
+		parts = strings.Fields(layoutPart)
+	} else {
+		for _, part := range strings.Split(layoutPart, separator) {
+			parts = append(parts, strings.TrimSpace(part))
+		}
+	}
 	var result [10]string
 
 	for i := 0; i < 10; i++ {
@@ -822,47 +1745,28 @@ func FindAndReplaceLayoutByIndex(parsedLayouts *ParsedLayouts, index int, newLay
 	return true
 }
 
-// LoadEffortMatrix загружает только матрицу усилий из файла
-func LoadEffortMatrix(filename string) ([3][10]float64, error) {
-	var effortMatrix [3][10]float64
-
+// LoadEffortMatrix загружает только матрицу усилий (и усилия цифрового ряда, если они заданы) из файла
+func LoadEffortMatrix(filename string) ([3][10]float64, []float64, error) {
 	file, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return effortMatrix, fmt.Errorf("ошибка при чтения файла матрицы усилий: %w", err)
+		return [3][10]float64{}, nil, fmt.Errorf("ошибка при чтения файла матрицы усилий: %w", err)
 	}
 
 	// Разбиваем файл на строки
-	allLines := strings.Split(strings.TrimSpace(string(file)), "\n")
+	lines := strings.Split(strings.TrimSpace(string(file)), "\n")
 
-	// Фильтруем строки, исключая комментарии и пустые строки
-	var lines []string
-	for _, line := range allLines {
-		trimmedLine := strings.TrimSpace(line)
-		if trimmedLine != "" && !strings.HasPrefix(trimmedLine, "#") {
-			lines = append(lines, line)
-		}
+	var config KeyboardConfig
+	hasFactors, err := parseEffortFactors(lines, &config)
+	if err != nil {
+		return [3][10]float64{}, nil, err
 	}
-
-	for row := 0; row < 3; row++ {
-		if row >= len(lines) {
-			return effortMatrix, fmt.Errorf("недостаточно строк для матрицы усилий")
-		}
-
-		parts := strings.Fields(lines[row])
-		if len(parts) < 10 {
-			return effortMatrix, fmt.Errorf("строка %d имеет менее 10 значений усилий", row+1)
-		}
-
-		for col := 0; col < 10; col++ {
-			val, err := strconv.ParseFloat(parts[col], 64)
-			if err != nil {
-				return effortMatrix, fmt.Errorf("ошибка парсинга усилия [%d][%d]: %w", row, col, err)
-			}
-			effortMatrix[row][col] = val
+	if !hasFactors {
+		if err := parseEffortMatrix(lines, &config); err != nil {
+			return [3][10]float64{}, nil, err
 		}
 	}
 
-	return effortMatrix, nil
+	return config.EffortMatrix, config.NumberRowEfforts, nil
 }
 
 // LoadAllData загружает все необходимые данные
@@ -877,7 +1781,7 @@ func LoadAllData(langFile, configFile, layoutFile string) (*LanguageData, *Keybo
 		return nil, nil, nil, err
 	}
 
-	layouts, err := LoadLayouts(layoutFile)
+	layouts, err := LoadLayoutFiles(layoutFile)
 	if err != nil {
 		return nil, nil, nil, err
 	}
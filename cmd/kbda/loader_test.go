@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testLayoutsFile = `# Раскладки для проверки
+qwerty
+q w e r t  y u i o p
+a s d f g  h j k l ;
+z x c v b  n m , . /
+
+dvorak
+' , . p y  f g c r l
+a o e u i  d h t n s
+; q j k x  b m w v z
+`
+
+func TestCheckLayoutsFileComplete(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name     string
+		content  string
+		complete bool
+	}{
+		{"полный файл", testLayoutsFile, true},
+		{"пустой файл", "", false},
+		{"только комментарии", "# Раскладки для проверки\n", false},
+		{"оборван ряд", testLayoutsFile[:strings.Index(testLayoutsFile, "; q j")+5], false},
+		{"оборвана раскладка", testLayoutsFile[:strings.Index(testLayoutsFile, "a o e")], false},
+		{"только название", testLayoutsFile[:strings.Index(testLayoutsFile, "' , .")], false},
+	}
+	for i, c := range cases {
+		filename := filepath.Join(dir, strings.Repeat("x", i+1)+".txt")
+		if err := os.WriteFile(filename, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := CheckLayoutsFileComplete(filename)
+		if c.complete && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.complete && err == nil {
+			t.Errorf("%s: неполный файл принят", c.name)
+		}
+	}
+}
+
+func TestCheckLayoutsFileCompleteBundled(t *testing.T) {
+	files, _ := filepath.Glob("../../configs/layout/*.txt")
+	for _, filename := range append(files, "../../configs/layout.txt") {
+		if err := CheckLayoutsFileComplete(filename); err != nil {
+			t.Errorf("%s: %v", filename, err)
+		}
+	}
+}
+
+func TestLoadLayoutsSeparators(t *testing.T) {
+	dir := t.TempDir()
+	// Клавиши не содержат разделителей, клавиша <| состоит из двух символов
+	want := [3][10]string{
+		{"<|", "'", "-", ".", "p", "y", "f", "g", "c", "r"},
+		{"a", "o", "e", "u", "i", "d", "h", "t", "n", "s"},
+		{"/", "q", "j", "k", "x", "b", "m", "w", "v", "z"},
+	}
+	for name, separator := range map[string]string{"tab": "\t", "comma": ",", "semicolon": ";"} {
+		content := "# separator: " + name + "\nmulti\n"
+		for _, row := range want {
+			content += strings.Join(row[:], separator) + "\n"
+		}
+		filename := filepath.Join(dir, name+".txt")
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		layouts, err := LoadLayouts(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if layouts.Separator != separator {
+			t.Errorf("%s: разделитель %q, ожидался %q", name, layouts.Separator, separator)
+		}
+		if got := layouts.Layouts[0].Keys; got != want {
+			t.Errorf("%s: прочитаны клавиши %q, ожидались %q", name, got, want)
+		}
+	}
+}
+
+// selftestConfigWithFactors возвращает строки встроенной конфигурации selftest, в которой матрица
+// усилий заменена параметрами factors
+func selftestConfigWithFactors(factors ...string) []string {
+	lines := strings.Split(strings.TrimSpace(selftestConfig), "\n")
+	return append(append([]string{}, lines[4:]...), factors...)
+}
+
+func TestEffortMatrixFromFactors(t *testing.T) {
+	config, err := parseKeyboardConfig(selftestConfigWithFactors("finger_costs=4,3,2,1,1,2,3,4", "row_multipliers=1.5,1,2"))
+	if err != nil {
+		t.Fatalf("ошибка разбора finger_costs и row_multipliers: %v", err)
+	}
+	costs := []float64{4, 3, 2, 1, 1, 2, 3, 4}
+	multipliers := []float64{1.5, 1, 2}
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			if want := costs[getFingerForKey(row, col)] * multipliers[row]; config.EffortMatrix[row][col] != want {
+				t.Errorf("усилие [%d][%d] = %.2f, ожидалось %.2f", row, col, config.EffortMatrix[row][col], want)
+			}
+		}
+	}
+
+	// 10 значений задают усилие по колонкам, в том числе разное для внутренних колонок
+	config, err = parseKeyboardConfig(selftestConfigWithFactors("finger_costs=10,9,8,7,6,6,7,8,9,10", "row_multipliers=1,1,1"))
+	if err != nil {
+		t.Fatalf("ошибка разбора finger_costs по колонкам: %v", err)
+	}
+	if config.EffortMatrix[1][3] != 7 || config.EffortMatrix[1][4] != 6 {
+		t.Errorf("усилия колонок 4 и 5: %.2f и %.2f, ожидалось 7 и 6", config.EffortMatrix[1][3], config.EffortMatrix[1][4])
+	}
+
+	invalid := map[string][]string{
+		"без row_multipliers":         selftestConfigWithFactors("finger_costs=1,1,1,1,1,1,1,1"),
+		"неверное количество рядов":   selftestConfigWithFactors("finger_costs=1,1,1,1,1,1,1,1", "row_multipliers=1,1"),
+		"неверное количество пальцев": selftestConfigWithFactors("finger_costs=1,1,1", "row_multipliers=1,1,1"),
+		"отрицательное усилие":        selftestConfigWithFactors("finger_costs=1,1,1,-1,1,1,1,1", "row_multipliers=1,1,1"),
+		"вместе с явной матрицей": append(strings.Split(strings.TrimSpace(selftestConfig), "\n"),
+			"finger_costs=1,1,1,1,1,1,1,1", "row_multipliers=1,1,1"),
+	}
+	for name, lines := range invalid {
+		if _, err := parseKeyboardConfig(lines); err == nil {
+			t.Errorf("%s: ожидалась ошибка", name)
+		}
+	}
+}
+
+func TestReplaceLayoutsFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "layouts.txt")
+	if err := os.WriteFile(filename, []byte(testLayoutsFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	layout := selftestLayout
+	content := layout.Name + "\n"
+	for _, keys := range layoutFileRows(layout) {
+		content += formatLayoutRow(keys, "") + "\n"
+	}
+
+	// Пустая клавиша после записи читается со сдвигом, файл не должен измениться
+	broken := layout
+	broken.Keys[1][3] = ""
+	if err := ReplaceLayoutsFile(filename, strings.Replace(content, " f ", "  ", 1), []Layout{broken}); err == nil {
+		t.Fatalf("ожидалась ошибка проверки раскладки с пустой клавишей")
+	}
+	if data, err := os.ReadFile(filename); err != nil || string(data) != testLayoutsFile {
+		t.Fatalf("исходный файл изменен после неудачной проверки")
+	}
+
+	if err := ReplaceLayoutsFile(filename, content, []Layout{layout}); err != nil {
+		t.Fatalf("ошибка замены файла: %v", err)
+	}
+	if data, err := os.ReadFile(filename); err != nil || string(data) != content {
+		t.Fatalf("файл не заменен проверенным содержимым")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(filename)); len(entries) != 1 {
+		t.Errorf("во временном каталоге осталось файлов: %d", len(entries))
+	}
+}
@@ -13,6 +13,9 @@ const (
 	defaultLangFile   = "language.json"
 	defaultConfigFile = "config.txt"
 	defaultLayoutFile = "layout.txt"
+
+	// Код завершения режима --text, если в тексте встретились не все символы алфавита
+	textMissingCharsExitCode = 2
 )
 
 func main() {
@@ -20,12 +23,20 @@ func main() {
 	helpFlag := flag.Bool("h", false, "Показать справку")
 	helpLongFlag := flag.Bool("help", false, "Показать справку")
 	configFileFlag := flag.String("config", defaultConfigFile, "Имя файла с конфигурацией")
-	layoutFileFlag := flag.String("layout", defaultLayoutFile, "Имя файла с раскладками")
+	layoutFileFlag := flag.String("layout", defaultLayoutFile, "Имя файла с раскладками (несколько файлов через запятую объединяются)")
 	outputFileFlag := flag.String("output", "", "Имя файла для сохранения новых раскладок (если не указано, используется файл из --layout)")
 	langFileFlag := flag.String("lang", defaultLangFile, "Имя файла со статистикой букв в языке")
 	effortFileFlag := flag.String("effort", "", "Имя файла с матрицей усилий по пальцам (если не указано, используется из конфигурационного файла)")
 	textFileFlag := flag.String("text", "", "Имя файла с текстом для генерации языковой статистики")
+	textListFlag := flag.String("text-list", "", "Имя файла со списком заданий \"текст алфавит выходной_файл\" для генерации нескольких языковых файлов")
 	alphabetFlag := flag.String("alphabet", "", "Алфавит для генерации языкового файла (все символы из строки рассматриваются как часть алфавита)")
+	wordBoundariesFlag := flag.Bool("word-boundaries", false, "Учитывать пробел между словами и биграммы на границах слов при генерации языкового файла")
+	caseSensitiveFlag := flag.Bool("case-sensitive", false, "Не приводить текст к нижнему регистру при генерации языкового файла (заглавные буквы алфавита учитываются отдельно)")
+	decimalsFlag := flag.Int("decimals", -1, "Количество знаков после запятой для частот в генерируемом языковом файле")
+	digitsFlag := flag.Int("digits", 0, "Количество значащих цифр для частот в генерируемом языковом файле")
+	normalizeFlag := flag.Bool("normalize", false, "Выводить показатели в таблицах l и lb относительно встроенной эталонной раскладки")
+	keyFreqFlag := flag.String("keyfreq", "", "Имя файла с собственными частотами символов, заменяющими частоты из файла языка")
+	bigramsFlag := flag.String("bigrams", "", "Имя текстового файла с частотами биграмм (строки \"биграмма<TAB>количество\"), заменяющими биграммы из файла языка")
 
 	// Parse флаги
 	flag.Parse()
@@ -36,6 +47,27 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Пакетный режим генерации языковых файлов по списку заданий
+	if *textListFlag != "" {
+		textListModeFlags := map[string]bool{
+			"text-list": true, "word-boundaries": true, "case-sensitive": true, "decimals": true, "digits": true,
+		}
+		conflicting := false
+		flag.Visit(func(f *flag.Flag) {
+			if !textListModeFlags[f.Name] {
+				conflicting = true
+			}
+		})
+		if conflicting {
+			fmt.Fprintf(os.Stderr, "Error: In --text-list mode, only --text-list, --word-boundaries, --case-sensitive, --decimals and --digits flags are allowed\n")
+			printShortHelp()
+			os.Exit(1)
+		}
+
+		numberFormat := NumberFormat{Decimals: *decimalsFlag, Digits: *digitsFlag}
+		os.Exit(processTextList(*textListFlag, *wordBoundariesFlag, *caseSensitiveFlag, numberFormat))
+	}
+
 	// Check if we're in text processing mode
 	if *textFileFlag != "" {
 		// Validate required arguments for text mode
@@ -52,18 +84,38 @@ func main() {
 		}
 
 		// Validate that no other conflicting arguments are present
-		if flag.NFlag() > 3 { // text, output, alphabet = 3 flags
-			fmt.Fprintf(os.Stderr, "Error: In --text mode, only --text, --output, and --alphabet flags are allowed\n")
+		textModeFlags := map[string]bool{
+			"text": true, "output": true, "alphabet": true, "word-boundaries": true, "case-sensitive": true, "decimals": true, "digits": true,
+		}
+		conflicting := false
+		flag.Visit(func(f *flag.Flag) {
+			if !textModeFlags[f.Name] {
+				conflicting = true
+			}
+		})
+		if conflicting {
+			fmt.Fprintf(os.Stderr, "Error: In --text mode, only --text, --output, --alphabet, --word-boundaries, --case-sensitive, --decimals and --digits flags are allowed\n")
 			printShortHelp()
 			os.Exit(1)
 		}
 
 		// Process the text file
-		err := ProcessTextFile(*textFileFlag, *alphabetFlag, *outputFileFlag)
+		numberFormat := NumberFormat{Decimals: *decimalsFlag, Digits: *digitsFlag}
+		summary, err := ProcessTextFile(*textFileFlag, *alphabetFlag, *outputFileFlag, *wordBoundariesFlag, *caseSensitiveFlag, numberFormat)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error processing text file: %v\n", err)
 			os.Exit(1)
 		}
+		fmt.Printf("Слов: %d, символов: %d, различных символов: %d, биграмм с ненулевой частотой: %d\n",
+			summary.Words, summary.Characters, summary.UniqueCharacters, summary.Bigrams)
+
+		// Отсутствие в тексте символов алфавита сообщается отдельным кодом завершения,
+		// чтобы несоответствие текста и алфавита можно было обнаружить в скриптах
+		if len(summary.MissingCharacters) > 0 {
+			fmt.Fprintf(os.Stderr, "В тексте не встретились символы алфавита (%d): %s\n",
+				len(summary.MissingCharacters), strings.Join(summary.MissingCharacters, " "))
+			os.Exit(textMissingCharsExitCode)
+		}
 		os.Exit(0)
 	}
 
@@ -95,14 +147,23 @@ func main() {
 
 	langFile := filepath.Join(workDir, *langFileFlag)
 	configFile := filepath.Join(workDir, *configFileFlag)
-	layoutFile := filepath.Join(workDir, *layoutFileFlag)
+	// В --layout можно перечислить несколько файлов через запятую, раскладки из них объединяются
+	var layoutFiles []string
+	for _, file := range splitLayoutFiles(*layoutFileFlag) {
+		layoutFiles = append(layoutFiles, filepath.Join(workDir, file))
+	}
+	if len(layoutFiles) == 0 {
+		layoutFiles = []string{filepath.Join(workDir, defaultLayoutFile)}
+	}
+	layoutFile := strings.Join(layoutFiles, layoutFileListSeparator)
 
 	// Если указан выходной файл, используем его, иначе используем тот же файл что и для загрузки
+	// (при нескольких файлах раскладок - первый из них)
 	var outputFile string
 	if *outputFileFlag != "" {
 		outputFile = filepath.Join(workDir, *outputFileFlag)
 	} else {
-		outputFile = layoutFile
+		outputFile = layoutFiles[0]
 	}
 
 	// Загружаем данные
@@ -111,10 +172,13 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Ошибка загрузки данных: %v\n", err)
 		os.Exit(1)
 	}
+	if len(layouts.Layouts) == 0 {
+		fmt.Fprintf(os.Stderr, "Предупреждение: в файле %s не найдено ни одной раскладки, сессия начата без раскладок\n", layoutFile)
+	}
 
 	// Если указан отдельный файл с усилиями, загружаем матрицу усилий из него
 	if *effortFileFlag != "" {
-		effortMatrix, err := LoadEffortMatrix(*effortFileFlag)
+		effortMatrix, numberRowEfforts, err := LoadEffortMatrix(*effortFileFlag)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Ошибка загрузки матрицы усилий: %v\n", err)
 			os.Exit(1)
@@ -122,15 +186,134 @@ func main() {
 
 		// Обновляем матрицу усилий в конфигурации
 		config.EffortMatrix = effortMatrix
+		config.NumberRowEfforts = numberRowEfforts
+	}
+
+	// Если указан файл частот биграмм, они заменяют биграммы из файла языка
+	var bigramFreq map[string]float64
+	if *bigramsFlag != "" {
+		bigramFreq, err = LoadBigramFrequencies(*bigramsFlag, config.CaseSensitive)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка загрузки частот биграмм: %v\n", err)
+			os.Exit(1)
+		}
+		langData = OverrideBigramFrequencies(langData, bigramFreq)
+		if missing := bigramCharsMissingFrequency(langData, langData.Characters); len(missing) > 0 && *keyFreqFlag == "" {
+			fmt.Fprintf(os.Stderr, "Предупреждение: биграммы из %s содержат символы без частоты в файле языка (%s), биграммы не согласуются с частотами символов\n",
+				*bigramsFlag, strings.Join(missing, " "))
+		}
 	}
 
 	// Создаём обработчик команд
 	handler := NewCommandHandler(langData, config, layouts, langFile, configFile, layoutFile, outputFile, *effortFileFlag)
+	handler.normalize = *normalizeFlag
+	handler.bigramFreq = bigramFreq
+
+	// Если указан файл собственных частот символов, они заменяют частоты из файла языка
+	if *keyFreqFlag != "" {
+		keyFreq, err := LoadKeyFrequencies(*keyFreqFlag, config.CaseSensitive)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка загрузки частот символов: %v\n", err)
+			os.Exit(1)
+		}
+		if missing := bigramCharsMissingFrequency(handler.fullLangData, keyFreq); len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "Предупреждение: биграммы содержат символы без частоты в %s (%s), биграммы не согласуются с частотами символов\n",
+				*keyFreqFlag, strings.Join(missing, " "))
+		}
+		handler.keyFreq = keyFreq
+		handler.setLanguageData(langData)
+	}
 
 	// Командный режим (REPL)
 	interactiveMode(handler, langFile, configFile, layoutFile)
 }
 
+// textListEntry задание пакетного режима --text-list: текстовый файл, алфавит и выходной файл
+type textListEntry struct {
+	line       int
+	textFile   string
+	alphabet   string
+	outputFile string
+}
+
+// parseTextList читает список заданий режима --text-list. Каждая непустая строка, кроме
+// комментариев (#), содержит три поля через пробел: текстовый файл, алфавит и выходной файл.
+// Относительные пути отсчитываются от каталога файла со списком
+func parseTextList(manifestFile string) ([]textListEntry, error) {
+	data, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir := filepath.Dir(manifestFile)
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(baseDir, path)
+	}
+
+	var entries []textListEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("строка %d: ожидается \"текстовый_файл алфавит выходной_файл\", получено полей: %d", i+1, len(fields))
+		}
+		entries = append(entries, textListEntry{
+			line:       i + 1,
+			textFile:   resolve(fields[0]),
+			alphabet:   fields[1],
+			outputFile: resolve(fields[2]),
+		})
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("в файле %s нет ни одного задания", manifestFile)
+	}
+	return entries, nil
+}
+
+// processTextList выполняет ProcessTextFile для каждого задания из списка, продолжая работу
+// после ошибок, и возвращает код завершения: 1, если хотя бы одно задание завершилось ошибкой,
+// textMissingCharsExitCode, если в каком-то тексте встретились не все символы алфавита, иначе 0
+func processTextList(manifestFile string, wordBoundaries, caseSensitive bool, numberFormat NumberFormat) int {
+	entries, err := parseTextList(manifestFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading text list: %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	incomplete := 0
+	for _, entry := range entries {
+		summary, err := ProcessTextFile(entry.textFile, entry.alphabet, entry.outputFile, wordBoundaries, caseSensitive, numberFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[строка %d] %s: ошибка: %v\n", entry.line, entry.textFile, err)
+			failed++
+			continue
+		}
+		fmt.Printf("[строка %d] %s -> %s: слов: %d, символов: %d, различных символов: %d, биграмм с ненулевой частотой: %d\n",
+			entry.line, entry.textFile, entry.outputFile, summary.Words, summary.Characters, summary.UniqueCharacters, summary.Bigrams)
+		if len(summary.MissingCharacters) > 0 {
+			fmt.Fprintf(os.Stderr, "[строка %d] В тексте не встретились символы алфавита (%d): %s\n",
+				entry.line, len(summary.MissingCharacters), strings.Join(summary.MissingCharacters, " "))
+			incomplete++
+		}
+	}
+
+	fmt.Printf("Обработано заданий: %d, успешно: %d, с ошибками: %d\n", len(entries), len(entries)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	if incomplete > 0 {
+		return textMissingCharsExitCode
+	}
+	return 0
+}
+
 // interactiveMode запускает интерактивный режим с историей команд
 func interactiveMode(handler *CommandHandler, langFile, configFile, layoutFile string) {
 	line := liner.NewLiner()
@@ -190,18 +373,37 @@ func printMainHelp() {
 
 Параметры командной строки:
   -h, --help        - Показать справку по использованию программы
-  --config FILE     - Указать имя файла с конфигурацией (по умолчанию config.txt)
-  --layout FILE     - Указать имя файла с раскладками (по умолчанию layout.txt)
+  --config FILE     - Указать имя файла с конфигурацией (по умолчанию config.txt),
+                      файл с расширением .json читается в JSON формате
+  --layout FILE     - Указать имя файла с раскладками (по умолчанию layout.txt), несколько файлов
+                      через запятую (a.txt,b.txt) объединяются в один список раскладок
   --lang FILE       - Указать имя файла со статистикой букв в языке (по умолчанию language.json)
   --effort FILE     - Указать имя файла с матрицей усилий по пальцам (если не указано, используется из конфигурационного файла)
-  --output FILE     - Указать имя файла для сохранения новых раскладок (если не указано, используется файл из --layout)
+  --output FILE     - Указать имя файла для сохранения новых раскладок (если не указано, используется файл из --layout, при нескольких файлах - первый)
   --text FILE       - Указать имя текстового файла для генерации языковой статистики
   --alphabet STRING - Указать алфавит для генерации языковой статистики (все символы из строки рассматриваются как часть алфавита)
+  --word-boundaries - Учитывать при генерации языковой статистики пробел между словами и биграммы
+                      "последняя буква + пробел" и "пробел + первая буква"
+  --case-sensitive  - Не приводить текст к нижнему регистру: заглавные буквы алфавита учитываются
+                      отдельно (для анализа с параметром case_sensitive=1)
+  --decimals N      - Записывать частоты в языковой файл с N знаками после запятой
+  --digits N        - Записывать частоты в языковой файл с N значащими цифрами (без экспоненты)
+  --text-list FILE  - Сгенерировать несколько языковых файлов по списку заданий из FILE
+                      (строки "текстовый_файл алфавит выходной_файл")
+  --normalize       - Выводить показатели в таблицах l и lb относительно встроенной эталонной раскладки
+                      (йцукен или qwerty в зависимости от алфавита языка)
+  --keyfreq FILE    - Указать файл с собственными частотами символов (строки "символ частота" или JSON
+                      объект), которые заменяют частоты из файла языка, биграммы берутся из файла языка
+  --bigrams FILE    - Указать текстовый файл с частотами биграмм (строки "биграмма<TAB>количество"),
+                      которые заменяют биграммы из файла языка (без символов в файле языка частоты
+                      символов рассчитываются по биграммам)
 
 Режим генерации языковой статистики:
   kbda --text file.txt --alphabet string --output lang.json
   В этом режиме обязательно должны быть указаны опции --output и --alphabet.
   Формат строки для задания алфавитаж описан в файле README.md.
+  kbda --text-list corpus.txt
+  Пакетная генерация языковых файлов по списку заданий.
 
 Примеры:
   kbda                           # Запуск в интерактивном режиме с файлами по умолчанию
@@ -217,24 +419,73 @@ func printMainHelp() {
 
 Доступные команды в интерактивном режиме:
   - p [N,M,L-K]   - Вывести раскладки (все или указанные)
-  - l [N,M,L-K]   - Анализ раскладок (все или указанные)
-  - lb [N,M,L-K]  - Анализ биграмм (все или указанные)
-  - ll [N,M,L-K]  - Анализ раскладок и биграмм (все или указанные)
+  - l [N,M,L-K] [sort=COL]  - Анализ раскладок (все или указанные), sort=COL сортирует по колонке таблицы (sort=-COL - по убыванию), по умолчанию по Score
+  - lb [N,M,L-K] [sort=COL] - Анализ биграмм (все или указанные), sort=COL сортирует по колонке таблицы (например, sort=SFB)
+  - ll [N,M,L-K] [sort=COL] - Анализ раскладок и биграмм (все или указанные), sort=COL сортирует по колонке любой из таблиц
+  - md [N,M,L-K] [sort=COL] - Вывести таблицы l и lb (все или указанные раскладки) в формате Markdown
   - h [N,M,L-K]   - Выделить раскладки N, M и диапазон от L до K желтым цветом, снять выделение с этих раскладок, или, без аргумента снять все выделения
   - a N [n]       - Подробный анализ раскладки N, можно отдельно указать количество строк для вывода в таблицах со статистикой
   - b N [string]  - Визуализация статистики по биграммам для раскладки N, можно указать строку символов для визуализации
-  - g [N]         - Поиск оптимальной раскладки, можно указать номер базовой раскладки для поиска
-  - gg [N] [file] - Непрерывный поиск оптимальной раскладки, можно указать номер базовой раскладки для поиска и имя файла для сохранения найденных раскладок
+  - topbigrams N [k] - Вывести k биграмм раскладки N с наибольшим вкладом во взвешенную оценку биграмм и метрики, которые их учитывают (по умолчанию k = 20)
+  - variants N k файл - Создать k различных вариантов раскладки N случайными перестановками незафиксированных клавиш и дописать их в файл
+  - sample k        - Сгенерировать k случайных раскладок из символов загруженных раскладок и вывести распределение их оценки Score (минимум, среднее, медиана, максимум, гистограмма) и долю случайных раскладок, которые хуже каждой из загруженных
+  - greedy [N]     - Построить жадную раскладку в буфер [0]: самые частые символы на самых легких позициях (символы и фиксированные позиции берутся из раскладки N, по умолчанию 1)
+  - dist N M       - Сравнить расположение клавиш раскладок N и M: символы на тех же позициях и пальцах, сходство (0-1) и отличающиеся позиции
+  - coverage N     - Вывести долю биграмм раскладки N для каждого показателя и проверить, складываются ли составляющие в родительские показатели (SHB и чередование рук, SFB = HVB + FVB + HDB + FDB + HFB + SKB и т.д.), с непокрытыми биграммами и пересечениями показателей
+  - vc N           - Показать распределение гласных и согласных по половинкам и долю биграмм гласная-согласная с чередованием рук (гласные задаются параметром vowels)
+  - fingerbalance N - Показать нагрузку на симметричные пары пальцев (P1/P8, P2/P7, P3/P6, P4/P5), их разницу и вклад каждой пары в FDI
+  - chars N [k]    - Показать вклад символов раскладки N в усилие (частота x усилие клавиши) по убыванию, k - количество символов
+  - scoreb N       - Показать слагаемые итоговой оценки раскладки N: значение показателя, вес и вклад в Score по убыванию вклада
+  - why N M - Объяснить, почему одна из раскладок N и M оценивается лучше: слагаемые оценки, вклад которых различается, по убыванию абсолютной разницы и основные слагаемые, за счет которых лучшая раскладка выигрывает и по которым уступает
+  - comfort [N] - Оценка удобства раскладки N от 0 до 100 с фиксированными коэффициентами, не зависящими от весов конфигурации (SFB, ножницы, боковые растяжения, нагрузка на мизинцы и баланс рук), без N - оценки всех раскладок
+  - cfgdiff file N - Сравнить показатели и вклад слагаемых в оценку раскладки N с текущей конфигурацией и с конфигурацией из файла
+  - multilang N file1 [file2 ...] - Показатели и оценка раскладки N для каждого из файлов языка рядом (файлы не смешиваются)
+  - columns [NAMES|reset] - выбрать колонки таблиц l и lb (без аргументов - показать выбор)
+  - rel [qwerty|йцукен|off] - Добавить в таблицы l и lb колонку Rel с улучшением Score в процентах относительно встроенной раскладки (без аргумента - подходящей к алфавиту языка), off - убрать колонку
+  - keymap N [qmk|zmk] - Вывести раскладку N в виде фрагмента слоя keymap для прошивки QMK (по умолчанию) или ZMK, кириллица выводится кодами клавиш раскладки ЙЦУКЕН
+  - exclude [chars|bigrams XY ...|list] - Исключить символы (вместе с биграммами, в которые они входят) или отдельные биграммы из анализа (частоты остальных символов и биграмм перенормируются) или вывести список исключенных
+  - include [chars|bigrams [XY ...]] - Вернуть символы или биграммы в анализ (include bigrams без списка возвращает все биграммы), без аргументов вернуть все исключенные символы и биграммы
+  - threshold [METRIC VALUE|METRIC >VALUE|METRIC off|clear] - Задать порог показателя таблиц l и lb: ячейки в пределах порога выводятся зеленым, остальные красным
+  - history [save file] - Вывести изменения за сессию (sw, inv, set, n, d, s, sort) или сохранить их в файл
+  - freq [on|off] - Включить или выключить вывод частот символов (в процентах) под клавишами в командах p и a
+  - g [N] [file] [T] [expr="..."] - Поиск оптимальной раскладки, можно указать номер базовой раскладки для поиска, имя файла, в который добавляются найденные раскладки, время поиска T (например, 10s или 2m), в течение которого выполняются рестарты, и целевую функцию expr вместо общей оценки (например, expr="0.5*SFB + 2*HSB + Effort": колонки таблиц l и lb, слагаемые оценки, + - * / ^, скобки, abs, sqrt, min, max; раскладки, для которых выражение не определено, например при делении на ноль, получают значение +Inf). Ctrl+C прерывает поиск, найденные к этому моменту раскладки сохраняются в буфер [0]
+  - gg [N] [file] [min=V] - Непрерывный поиск оптимальной раскладки, можно указать номер базовой раскладки для поиска, имя файла для сохранения найденных раскладок и минимальное улучшение оценки для новой лучшей раскладки (по умолчанию gg_min_improvement). Без доступа к терминалу выполняется 10 итераций
+  - beat k [N] [file] [T] - Поиск оптимальной раскладки с параметрами как у g, в буфер [0] и файл попадают только раскладки лучше k-й лучшей из загруженных, остальные результаты отбрасываются
+  - gf N [M]      - Поиск оптимальной раскладки от раскладки N с закреплением букв за пальцами (перестановки только между клавишами одного пальца), можно указать количество результатов M
+  - gh left|right [N] [M] - Поиск оптимальной раскладки от раскладки N (по умолчанию 1, 0 - временная) с перестановками только внутри левой (колонки 1-5) или правой (колонки 6-10) половины, можно указать количество результатов M
+  - ghr N [M]      - Поиск оптимальной раскладки от раскладки N (0 - временная) с закрепленным средним рядом (перестановки только в верхнем и нижнем рядах), можно указать количество результатов M
   - n N имя       - Переименовать раскладку N в новое имя
-  - inv [N]       - Инвертирование активной или указанной раскладке
+  - inv [N] [stats] - Инвертирование активной или указанной раскладке, stats - вывести анализ исходной и инвертированной раскладок
+  - orient N [swap] - Сравнение раскладки N с ее зеркальной копией (с swap также с вариантами с переставленными половинками), лучшая ориентация записывается во временную раскладку [0]
+  - moved N        - Вывести раскладку N рядом с временной раскладкой [0], клавиши [0] выделяются цветом: серым - на месте, желтым - перемещенные, зеленым - отсутствующие в раскладке N
   - sw [N] ab     - Перестановка двух букв в активной или указанной раскладке
-  - s [N] [name]  - Сохранить активную раскладку или указанной раскладки с текущим или указанным именем в файл
+  - sw? [N] ab    - Предварительный просмотр перестановки двух букв с анализом без изменения буфера [0]
+  - closeto T N [depth] - Найти наименьшее количество перестановок незафиксированных клавиш раскладки N (до depth, по умолчанию 2, не больше 5), после которых ее оценка лучше оценки раскладки T: перебираются все одиночные перестановки, затем 30 лучших вариантов дополняются следующей перестановкой, результат сохраняется во временный буфер [0]
+  - place c N - Найти лучшую позицию для символа c в раскладке N: символ ставится на каждую незафиксированную позицию, вытесненная клавиша переносится на прежнее место символа (или на свободную позицию, иначе убирается из раскладки), выводятся 5 лучших позиций, лучшая раскладка сохраняется во временный буфер [0]
+  - edit [N]      - Интерактивно редактировать раскладку N (по умолчанию [0]): стрелки перемещают курсор, символ ставится в позицию курсора с обменом, Esc сохраняет результат в буфер [0], Ctrl+C - отмена
+  - s [N] [name]  - Сохранить активную раскладку или указанной раскладки с текущим или указанным именем в файл (раскладка с уже имеющимся в файле расположением клавиш не сохраняется)
   - d [N,M,L-K]   - Удалить раскладки (по номерам или диапазонам)
   - sort          - Сортировать раскладки по возрастанию общей оценки и перезаписать файл
+  - reseat        - Нормализовать файл с раскладками (удалить пустые раскладки, задать имена безымянным, выровнять форматирование)
+  - verify        - Проверить, что активная и загруженные раскладки записываются в файл и читаются обратно без изменений (s и sort проверяют файл перед заменой и при ошибке оставляют его без изменений)
+  - append file     - Добавить к загруженным раскладкам раскладки из другого файла (в p выводится исходный файл, совпадающие имена дополняются именем файла); команды d, n, reseat, watch и sort в исходный файл, перезаписывающие файл раскладок, после этого недоступны
   - c             - Вывести используемые коэффициенты из конфигурационного файла
   - set N value   - Установить коэффициент N в значение value
+  - set? N value  - Показать, как изменятся оценки и места загруженных раскладок при коэффициенте N = value, не изменяя его
+  - bic import|export file - Загрузить индивидуальные коэффициенты биграмм из файла (.json в формате bigram_coeffs или строки "pos1 pos2 coeff") вместо текущих или сохранить текущие в файл
+  - tune METRIC target=V [N] - Подобрать вес показателя (SFB, LSB и др.) так, чтобы у лучшей раскладки сокращенного поиска значение было не больше V; N - исходная раскладка поиска, результат сохраняется в буфер [0]
+  - balance x [N]  - Пропорционально изменить вес усилия TotalEffortNorm и веса биграмм так, чтобы в оценке раскладки N (по умолчанию 1) усилие составляло долю x (от 0 до 1), а биграммы - долю 1-x
+  - baseline       - Зафиксировать текущие значения коэффициентов как базовые: изменения больше не применяются повторно при перезагрузке конфигурации (r, rr)
   - r             - Перезагрузить файл конфигурации и файл с раскладками
+  - rr            - Перезагрузить файл конфигурации и файл языка без файла раскладок, сохранив временную раскладку [0] и выделенные раскладки, и вывести оценку [0] с новой конфигурацией
+  - watch [N,M,L-K] - Перезагружать раскладки и выводить таблицу ll при каждом изменении файла раскладок
   - t             - Вывести тестовую информацию
+  - langinfo      - Вывести сведения о файле языка (источник, объем текста, количество символов и биграмм)
+  - ematrix       - Вывести матрицу усилий из конфигурации с цветовым выделением и ограничения нагрузки по пальцам и рядам
+  - precision [N] - Вывести или изменить количество дополнительных знаков после запятой в таблицах l и lb (от -1 до 3, 0 - по умолчанию)
+  - percent [clamp|mark] - Вывести или изменить режим вывода нормированной частоты биграмм в командах a и b: clamp - значения больше 99 выводятся как 99, mark - значения от 99.5 выводятся как **
+  - session save|load file - Сохранить или восстановить состояние сессии (раскладки, конфигурация, выделения, буфер [0]) в JSON файле
+  - buf [list|save N [имя]|load N|show N|del N] - Слоты для временного хранения раскладок в памяти: скопировать буфер [0] в слот N, загрузить слот в [0], показать или очистить слот
   - help          - Справка по командам
   - exit/quit/q   - Выход
 
@@ -256,18 +507,29 @@ func printShortHelp() {
 
 Опции командной строки:
   -h            - Показать полную справку
-  --config FILE - Указать имя файла с конфигурацией (по умолчанию config.txt)
-  --layout FILE - Указать имя файла с раскладками (по умолчанию layout.txt)
+  --config FILE - Указать имя файла с конфигурацией (по умолчанию config.txt),
+                  файл с расширением .json читается в JSON формате
+  --layout FILE - Указать имя файла с раскладками (по умолчанию layout.txt), несколько файлов через запятую
   --lang FILE   - Указать имя файла со статистикой букв в языке (по умолчанию language.json)
   --effort FILE - Указать имя файла с матрицей усилий по пальцам
   --output FILE - Указать имя файла для сохранения новых раскладок
   --text FILE   - Указать имя текстового файла для генерации языковой статистики
   --alphabet STRING - Указать алфавит для генерации языкового файла
+  --word-boundaries - Учитывать пробел между словами при генерации языкового файла
+  --case-sensitive - Учитывать заглавные буквы отдельно при генерации языкового файла
+  --decimals N  - Количество знаков после запятой для частот в языковом файле
+  --digits N    - Количество значащих цифр для частот в языковом файле
+  --text-list FILE - Сгенерировать языковые файлы по списку заданий
+  --normalize   - Выводить показатели относительно эталонной раскладки
+  --keyfreq FILE - Указать файл с собственными частотами символов
+  --bigrams FILE - Указать текстовый файл с частотами биграмм
 
 Режим генерации языковой статистики:
   kbda --text file.txt --alphabet string --output lang.json
   В этом режиме обязательно должны быть указаны опции --output и --alphabet.
   Наличие в этом режиме других аргументов приведет к ошибке.
+  kbda --text-list corpus.txt
+  Пакетная генерация языковых файлов по списку заданий.
 
 Примеры:
   kbda                           # Запуск в интерактивном режиме с файлами по умолчанию
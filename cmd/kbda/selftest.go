@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// selftestConfig встроенная конфигурация для команды selftest. Веса подобраны так, чтобы
+// в итоговую оценку входили все слагаемые, строгие режимы HSB, FSB и LSB включены, чтобы
+// рассчитывались и показатели вне строгого режима
+const selftestConfig = `
+14.0 5.0 4.0 6.0 11.0  11.0 6.0 4.0 5.0 14.0
+13.0 3.0 2.0 1.0 10.0  10.0 1.0 2.0 3.0 13.0
+15.0 9.0 8.0 7.0 12.0  12.0 7.0 8.0 9.0 15.0
+
+8.0  10.0 15.0 18.0   18.0 15.0 10.0 8.0
+2.0  1.5  1.0  1.0    1.0  1.0  1.5  2.0
+
+. . . . .  . . . . .
+. . . . .  . . . . .
+. . . . .  . . . . .
+
+total_effort_norm=1
+MR1=30.0
+MR2=50.0
+MR3=20.0
+PR1=0.5
+PR2=0.5
+PR3=0.5
+HDI=0.3
+HandBias=0.15
+hand_bias_target=55
+FDI=0.2
+D18=1
+D27=1
+D36=1
+D45=1
+PinkyNorm=0.1
+HomeUseNorm=0.05
+max_finger_travel=0.5,0.5,1,1,1,1,0.5,0.5
+finger_travel_penalties=1,1,1,1,1,1,1,1
+SHB=0.1
+SFB=2
+HVB=1
+FVB=1.5
+HDB=0.5
+FDB=0.8
+HFB=0.7
+HSB=0.4
+FSB=0.6
+LSB=0.9
+SRB=-0.1
+AFI=-0.2
+AFO=0.2
+ICS=0.3
+SymSFB=0.25
+RowJumpNorm=0.35
+LSB_all=0.15
+SKB=0.05
+HSB_strict_mode=1
+FSB_strict_mode=1
+LSB_strict_mode=1
+1.5: 5-16 3-4 12-13
+split_col=5
+precision=0
+`
+
+// selftestLayout встроенная раскладка для команды selftest
+var selftestLayout = Layout{
+	Name: "selftest",
+	Keys: [3][10]string{
+		{"q", "w", "e", "r", "t", "y", "u", "i", "o", "p"},
+		{"a", "s", "d", "f", "g", "h", "j", "k", "l", ";"},
+		{"z", "x", "c", "v", "b", "n", "m", ",", ".", "/"},
+	},
+}
+
+// selftestLanguage возвращает встроенные языковые данные для команды selftest. Биграммы
+// подобраны так, чтобы в каждую категорию calculateBigrams попадала хотя бы одна биграмма
+func selftestLanguage() *LanguageData {
+	return &LanguageData{
+		Language: "selftest",
+		Characters: map[string]float64{
+			"e": 0.12, "t": 0.09, "a": 0.08, "o": 0.075, "i": 0.07, "n": 0.067, "s": 0.063,
+			"h": 0.061, "r": 0.06, "d": 0.043, "l": 0.04, "c": 0.028, "u": 0.028, "m": 0.024,
+			"w": 0.024, "f": 0.022, "g": 0.02, "y": 0.02, "p": 0.019, "b": 0.015, "v": 0.01,
+			"k": 0.008, "x": 0.002, "q": 0.001, "z": 0.001, "j": 0.002, ",": 0.01, ".": 0.01,
+		},
+		Bigrams: map[string]float64{
+			"th": 0.027, "he": 0.023, "in": 0.020, "er": 0.018, "an": 0.016, "re": 0.014,
+			"on": 0.013, "at": 0.012, "en": 0.011, "nd": 0.010, "ti": 0.010, "es": 0.010,
+			"or": 0.009, "te": 0.009, "ed": 0.008, "is": 0.008, "it": 0.008, "al": 0.008,
+			"ar": 0.008, "st": 0.008, "to": 0.007, "nt": 0.007, "ng": 0.007, "se": 0.007,
+			"ha": 0.007, "ou": 0.006, "de": 0.006, "ce": 0.006, "ec": 0.004, "un": 0.004,
+			"my": 0.003, "ny": 0.003, "br": 0.002, "gr": 0.002, "ct": 0.003, "ft": 0.002,
+			"rt": 0.003, "lo": 0.004, "ol": 0.003, "ki": 0.002, "ik": 0.002, "ws": 0.001,
+			"xe": 0.001, "ze": 0.0005, "az": 0.0005, "qu": 0.001, "ju": 0.001, "ee": 0.003,
+			"ll": 0.004, "ss": 0.004, "e,": 0.003, "s.": 0.004, "p.": 0.001, "yo": 0.002,
+			"hi": 0.005, "ve": 0.006, "fe": 0.002, "bv": 0.0005, "tg": 0.0005, "hj": 0.0005,
+		},
+	}
+}
+
+// selftestDump анализирует встроенную раскладку со встроенными конфигурацией и языковыми
+// данными и возвращает все поля LayoutAnalysis в виде строк "Поле = значение"
+func selftestDump() ([]string, error) {
+	config, err := parseKeyboardConfig(strings.Split(strings.TrimSpace(selftestConfig), "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора встроенной конфигурации: %w", err)
+	}
+	analysis := AnalyzeLayout(&selftestLayout, config, selftestLanguage())
+
+	var lines []string
+	dumpValue(&lines, "", reflect.ValueOf(*analysis))
+	return lines, nil
+}
+
+// dumpValue добавляет в lines значения полей структуры, массивов и чисел с фиксированной
+// точностью, чтобы погрешности порядка суммирования не приводили к расхождениям
+func dumpValue(lines *[]string, name string, value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			fieldName := field.Name
+			if name != "" {
+				fieldName = name + "." + field.Name
+			}
+			dumpValue(lines, fieldName, value.Field(i))
+		}
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			dumpValue(lines, fmt.Sprintf("%s[%d]", name, i), value.Index(i))
+		}
+	case reflect.Float64:
+		*lines = append(*lines, fmt.Sprintf("%s = %.6f", name, value.Float()+0))
+	case reflect.Int:
+		*lines = append(*lines, fmt.Sprintf("%s = %d", name, value.Int()))
+	case reflect.String:
+		*lines = append(*lines, fmt.Sprintf("%s = %s", name, value.String()))
+	case reflect.Ptr:
+		// Ссылка на конфигурацию не является результатом анализа
+	}
+}
+
+// CommandSelfTest выводит результат анализа встроенной раскладки для сравнения с эталоном
+// или сравнивает его с эталонным файлом, записанным ранее командой selftest
+func (ch *CommandHandler) CommandSelfTest(args string) error {
+	lines, err := selftestDump()
+	if err != nil {
+		return err
+	}
+
+	goldenFile := strings.TrimSpace(args)
+	if goldenFile == "" {
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return nil
+	}
+
+	data, err := os.ReadFile(goldenFile)
+	if err != nil {
+		return fmt.Errorf("ошибка при чтении эталонного файла: %w", err)
+	}
+	expected := make(map[string]string)
+	var expectedOrder []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value := splitSelftestLine(line)
+		expected[name] = value
+		expectedOrder = append(expectedOrder, name)
+	}
+
+	var mismatches []string
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		name, value := splitSelftestLine(line)
+		seen[name] = true
+		expectedValue, exists := expected[name]
+		switch {
+		case !exists:
+			mismatches = append(mismatches, fmt.Sprintf("  + %s (нет в эталоне)", line))
+		case expectedValue != value:
+			mismatches = append(mismatches, fmt.Sprintf("  %s (эталон: %s)", line, expectedValue))
+		}
+	}
+	for _, name := range expectedOrder {
+		if !seen[name] {
+			mismatches = append(mismatches, fmt.Sprintf("  - %s = %s (нет в результате)", name, expected[name]))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		for _, mismatch := range mismatches {
+			fmt.Println(mismatch)
+		}
+		return fmt.Errorf("результат анализа отличается от эталона %s: расхождений %d", goldenFile, len(mismatches))
+	}
+	fmt.Printf("Результат анализа совпадает с эталоном %s (показателей: %d)\n", goldenFile, len(lines))
+	return nil
+}
+
+// splitSelftestLine разделяет строку результата selftest на название поля и значение
+func splitSelftestLine(line string) (string, string) {
+	name, value, _ := strings.Cut(line, "=")
+	return strings.TrimSpace(name), strings.TrimSpace(value)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// sessionVersion версия формата файла сессии
+const sessionVersion = 1
+
+// SessionState содержит снимок рабочего состояния анализатора
+type SessionState struct {
+	Version              int             `json:"version"`
+	Layouts              *ParsedLayouts  `json:"layouts"`
+	Config               *KeyboardConfig `json:"config"`
+	BaseWeights          WeightConfig    `json:"base_weights"`           // Веса из конфигурационного файла без учета изменений
+	ModifiedWeights      []string        `json:"modified_weights"`       // Имена весов, измененных командой set
+	BigramCoeffsModified bool            `json:"bigram_coeffs_modified"` // Были ли изменены индивидуальные коэффициенты биграмм
+	Highlighted          []int           `json:"highlighted"`
+	Buffer               *Layout         `json:"buffer,omitempty"` // Раскладка из буфера [0]
+}
+
+// SaveSession сохраняет состояние сессии в JSON файл
+func SaveSession(state *SessionState, filename string) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("ошибка при формировании JSON сессии: %w", err)
+	}
+
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("ошибка при записи файла сессии: %w", err)
+	}
+
+	return nil
+}
+
+// LoadSession загружает состояние сессии из JSON файла и проверяет его структуру
+func LoadSession(filename string) (*SessionState, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при чтении файла сессии: %w", err)
+	}
+
+	var state SessionState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("ошибка при парсинге JSON сессии: %w", err)
+	}
+
+	if state.Version != sessionVersion {
+		return nil, fmt.Errorf("неподдерживаемая версия файла сессии: %d", state.Version)
+	}
+	if state.Config == nil {
+		return nil, fmt.Errorf("в файле сессии отсутствует конфигурация")
+	}
+	// Сессия без раскладок допустима так же, как запуск с пустым файлом раскладок
+	if state.Layouts == nil {
+		state.Layouts = &ParsedLayouts{}
+	}
+	if state.Config.SplitCol < 0 || state.Config.SplitCol > 10 {
+		return nil, fmt.Errorf("некорректное значение split_col в файле сессии: %d", state.Config.SplitCol)
+	}
+	for _, index := range state.Highlighted {
+		if index < 0 || index > len(state.Layouts.Layouts) {
+			return nil, fmt.Errorf("некорректный номер выделенной раскладки в файле сессии: %d", index)
+		}
+	}
+
+	return &state, nil
+}
+
+// sortedHighlighted возвращает отсортированный список выделенных раскладок
+func sortedHighlighted(highlighted map[int]bool) []int {
+	indices := make([]int, 0, len(highlighted))
+	for index, on := range highlighted {
+		if on {
+			indices = append(indices, index)
+		}
+	}
+	sort.Ints(indices)
+	return indices
+}
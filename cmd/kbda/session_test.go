@@ -0,0 +1,26 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionWithoutLayouts(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "session.json")
+	state := &SessionState{
+		Version: sessionVersion,
+		Layouts: &ParsedLayouts{},
+		Config:  selftestTestConfig(t),
+	}
+	if err := SaveSession(state, filename); err != nil {
+		t.Fatalf("ошибка сохранения сессии: %v", err)
+	}
+
+	loaded, err := LoadSession(filename)
+	if err != nil {
+		t.Fatalf("сессия без раскладок не загружается: %v", err)
+	}
+	if loaded.Layouts == nil || len(loaded.Layouts.Layouts) != 0 {
+		t.Errorf("ожидался пустой список раскладок, получено %v", loaded.Layouts)
+	}
+}
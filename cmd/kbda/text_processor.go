@@ -1,17 +1,83 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// ProcessTextFile processes a text file to generate language statistics
-func ProcessTextFile(textFile, alphabetString, outputFile string) error {
+// TextSummary contains counts collected while processing a text file
+type TextSummary struct {
+	Words             int      // Number of words with at least one alphabet character
+	Characters        int      // Number of counted characters (including word boundary spaces)
+	UniqueCharacters  int      // Number of characters with nonzero frequency
+	Bigrams           int      // Number of bigrams with nonzero frequency
+	MissingCharacters []string // Alphabet characters that never appeared in the text, sorted
+}
+
+// NumberFormat controls how frequencies are written to the generated language file.
+// Numbers are always written in plain decimal notation with a dot separator, so the
+// output is valid JSON that does not depend on locale or scientific notation support
+type NumberFormat struct {
+	Decimals int // Fixed number of digits after the decimal point (negative - not fixed)
+	Digits   int // Number of significant digits (0 - shortest exact representation)
+}
+
+// Validate checks that at most one of the precision options is set
+func (f NumberFormat) Validate() error {
+	if f.Decimals >= 0 && f.Digits > 0 {
+		return fmt.Errorf("decimal places and significant digits cannot be set together")
+	}
+	if f.Digits < 0 {
+		return fmt.Errorf("invalid number of significant digits: %d", f.Digits)
+	}
+	return nil
+}
+
+// Format formats a frequency without exponent
+func (f NumberFormat) Format(value float64) string {
+	if f.Decimals >= 0 {
+		return strconv.FormatFloat(value, 'f', f.Decimals, 64)
+	}
+	if f.Digits > 0 && value != 0 {
+		// Round to the requested significant digits, then print the rounded value in plain notation
+		rounded, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'e', f.Digits-1, 64), 64)
+		if !math.IsInf(rounded, 0) {
+			value = rounded
+		}
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// jsonString returns s as a quoted JSON string with escaping
+func jsonString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// ProcessTextFile processes a text file to generate language statistics.
+// If wordBoundaries is set, a space is counted between consecutive words together
+// with the bigrams "last letter + space" and "space + first letter".
+// If caseSensitive is set, the text keeps its case, so uppercase letters of the alphabet
+// get their own frequencies instead of being counted as lowercase ones.
+// Frequencies are written with numberFormat.
+// The returned summary lists alphabet characters that never appeared in the text
+func ProcessTextFile(textFile, alphabetString, outputFile string, wordBoundaries, caseSensitive bool, numberFormat NumberFormat) (*TextSummary, error) {
+	if err := numberFormat.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Parse the alphabet string to handle special cases
-	alphabet, charGroups := parseAlphabet(alphabetString)
+	alphabet, charGroups, err := parseAlphabet(alphabetString)
+	if err != nil {
+		return nil, err
+	}
 
 	// Debug: Print the parsed alphabet
 	// fmt.Printf("Parsed alphabet: %+v\n", alphabet)
@@ -20,11 +86,14 @@ func ProcessTextFile(textFile, alphabetString, outputFile string) error {
 	// Read the text file
 	content, err := os.ReadFile(textFile)
 	if err != nil {
-		return fmt.Errorf("error reading text file: %v", err)
+		return nil, fmt.Errorf("error reading text file: %v", err)
 	}
 
-	// Convert to lowercase for processing
-	text := strings.ToLower(string(content))
+	// Convert to lowercase for processing unless the case is significant
+	text := string(content)
+	if !caseSensitive {
+		text = strings.ToLower(text)
+	}
 
 	// Get all unique characters that will be in the final output (after applying character groups)
 	uniqueChars := make(map[string]bool)
@@ -36,6 +105,17 @@ func ProcessTextFile(textFile, alphabetString, outputFile string) error {
 		uniqueChars[mappedChar] = true
 	}
 
+	// Remember the alphabet characters to report those that never appeared in the text
+	alphabetChars := make([]string, 0, len(uniqueChars))
+	for char := range uniqueChars {
+		alphabetChars = append(alphabetChars, char)
+	}
+	sort.Strings(alphabetChars)
+
+	if wordBoundaries {
+		uniqueChars[" "] = true
+	}
+
 	// Initialize counts
 	bigramCounts := make(map[string]int)
 	unigramCounts := make(map[string]int)
@@ -54,6 +134,11 @@ func ProcessTextFile(textFile, alphabetString, outputFile string) error {
 	// Split text into words based on spaces
 	words := strings.Fields(textWithoutPunct)
 
+	// Last character of the previous word for word boundary bigrams
+	previousLast := ""
+
+	summary := &TextSummary{}
+
 	for _, word := range words {
 		if len(word) == 0 {
 			continue
@@ -70,9 +155,22 @@ func ProcessTextFile(textFile, alphabetString, outputFile string) error {
 
 		// Process the cleaned word
 		if len(cleanWord) > 0 {
+			summary.Words++
+
 			// Convert to runes to properly handle Unicode characters
 			runes := []rune(cleanWord)
 
+			// Count the space between words and the word boundary bigrams
+			if wordBoundaries {
+				first := mapToCharacterGroup(string(runes[0]), charGroups)
+				if previousLast != "" {
+					unigramCounts[" "]++
+					bigramCounts[previousLast+" "]++
+					bigramCounts[" "+first]++
+				}
+				previousLast = mapToCharacterGroup(string(runes[len(runes)-1]), charGroups)
+			}
+
 			// Count unigrams
 			for _, char := range runes {
 				charStr := string(char)
@@ -113,6 +211,20 @@ func ProcessTextFile(textFile, alphabetString, outputFile string) error {
 	totalUnigrams := 0
 	for _, count := range unigramCounts {
 		totalUnigrams += count
+		if count > 0 {
+			summary.UniqueCharacters++
+		}
+	}
+	summary.Characters = totalUnigrams
+	for _, count := range bigramCounts {
+		if count > 0 {
+			summary.Bigrams++
+		}
+	}
+	for _, char := range alphabetChars {
+		if unigramCounts[char] == 0 {
+			summary.MissingCharacters = append(summary.MissingCharacters, char)
+		}
 	}
 
 	// Initialize all possible bigrams and unigrams with 0 frequency
@@ -152,7 +264,7 @@ func ProcessTextFile(textFile, alphabetString, outputFile string) error {
 	// Write to output file manually to ensure proper ordering
 	file, err := os.Create(outputFile)
 	if err != nil {
-		return fmt.Errorf("error creating output file: %v", err)
+		return nil, fmt.Errorf("error creating output file: %v", err)
 	}
 	defer file.Close()
 
@@ -160,14 +272,19 @@ func ProcessTextFile(textFile, alphabetString, outputFile string) error {
 	fmt.Fprintf(file, "{\n")
 	fmt.Fprintf(file, "  \"language\": \"Generated from text file\",\n")
 
+	// Записываем сведения об источнике статистики
+	fmt.Fprintf(file, "  \"source\": %s,\n", jsonString(textFile))
+	fmt.Fprintf(file, "  \"sample_size\": %d,\n", totalUnigrams)
+	fmt.Fprintf(file, "  \"generated_at\": \"%s\",\n", time.Now().Format(time.RFC3339))
+
 	// Write characters in sorted order
 	fmt.Fprintf(file, "  \"characters\": {\n")
 	charPairs := getSortedPairs(unigramFreqs)
 	for i, pair := range charPairs {
 		if i == len(charPairs)-1 {
-			fmt.Fprintf(file, "    \"%s\": %g\n", pair.Key, pair.Value)
+			fmt.Fprintf(file, "    %s: %s\n", jsonString(pair.Key), numberFormat.Format(pair.Value))
 		} else {
-			fmt.Fprintf(file, "    \"%s\": %g,\n", pair.Key, pair.Value)
+			fmt.Fprintf(file, "    %s: %s,\n", jsonString(pair.Key), numberFormat.Format(pair.Value))
 		}
 	}
 	fmt.Fprintf(file, "  },\n")
@@ -177,9 +294,9 @@ func ProcessTextFile(textFile, alphabetString, outputFile string) error {
 	bigramPairs := getSortedPairs(bigramFreqs)
 	for i, pair := range bigramPairs {
 		if i == len(bigramPairs)-1 {
-			fmt.Fprintf(file, "    \"%s\": %g\n", pair.Key, pair.Value)
+			fmt.Fprintf(file, "    %s: %s\n", jsonString(pair.Key), numberFormat.Format(pair.Value))
 		} else {
-			fmt.Fprintf(file, "    \"%s\": %g,\n", pair.Key, pair.Value)
+			fmt.Fprintf(file, "    %s: %s,\n", jsonString(pair.Key), numberFormat.Format(pair.Value))
 		}
 	}
 	fmt.Fprintf(file, "  }\n")
@@ -187,7 +304,7 @@ func ProcessTextFile(textFile, alphabetString, outputFile string) error {
 
 	fmt.Printf("Обработка файла %s завершена, результаты записаны в файл %s\n", textFile, outputFile)
 
-	return nil
+	return summary, nil
 }
 
 // KeyValue represents a key-value pair for sorting
@@ -218,7 +335,7 @@ func getSortedPairs(input map[string]float64) []KeyValue {
 }
 
 // parseAlphabet parses the alphabet string and handles special cases
-func parseAlphabet(alphabetString string) (map[rune]bool, map[string]string) {
+func parseAlphabet(alphabetString string) (map[rune]bool, map[string]string, error) {
 	alphabet := make(map[rune]bool)
 	charGroups := make(map[string]string) // Maps equivalent chars to the first char in the group
 
@@ -253,6 +370,10 @@ func parseAlphabet(alphabetString string) (map[rune]bool, map[string]string) {
 				if len(groupRunes) > 0 {
 					firstChar := string(groupRunes[0])
 					for _, groupChar := range groupRunes {
+						// A character listed in two groups would be mapped ambiguously
+						if existing, exists := charGroups[string(groupChar)]; exists && existing != firstChar {
+							return nil, nil, fmt.Errorf("character %q is listed in two groups: [%s...] and [%s...]", groupChar, existing, firstChar)
+						}
 						charGroups[string(groupChar)] = firstChar
 						// Add the first character to the alphabet
 						alphabet[groupRunes[0]] = true
@@ -286,7 +407,7 @@ func parseAlphabet(alphabetString string) (map[rune]bool, map[string]string) {
 		i++
 	}
 
-	return alphabet, charGroups
+	return alphabet, charGroups, nil
 }
 
 // isInAlphabet checks if a character is in the alphabet
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// caseCorpus текст, в котором заглавных букв 3 из 8: a=3, A=2, b=2, B=1
+const caseCorpus = "aa Ab AB ba"
+
+// processCorpus формирует языковой файл из текста и загружает его
+func processCorpus(t *testing.T, text, alphabet string, caseSensitive bool) *LanguageData {
+	t.Helper()
+	dir := t.TempDir()
+	textFile := filepath.Join(dir, "corpus.txt")
+	outputFile := filepath.Join(dir, "language.json")
+	if err := os.WriteFile(textFile, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ProcessTextFile(textFile, alphabet, outputFile, false, caseSensitive, NumberFormat{Decimals: -1}); err != nil {
+		t.Fatalf("ошибка обработки текста: %v", err)
+	}
+	langData, err := LoadLanguageData(outputFile)
+	if err != nil {
+		t.Fatalf("ошибка загрузки языкового файла: %v", err)
+	}
+	return langData
+}
+
+func TestProcessTextFileCaseSensitive(t *testing.T) {
+	langData := processCorpus(t, caseCorpus, "abAB", true)
+	for char, want := range map[string]float64{"a": 3.0 / 8, "A": 2.0 / 8, "b": 2.0 / 8, "B": 1.0 / 8} {
+		if got := langData.Characters[char]; math.Abs(got-want) > 1e-9 {
+			t.Errorf("частота %q: %.6f, ожидалось %.6f", char, got, want)
+		}
+	}
+	if _, exists := langData.Bigrams["AB"]; !exists {
+		t.Errorf("нет биграммы AB с заглавными буквами")
+	}
+
+	folded := processCorpus(t, caseCorpus, "ab", false)
+	if _, exists := folded.Characters["A"]; exists {
+		t.Errorf("заглавная буква учтена отдельно без case_sensitive")
+	}
+	if got := folded.Characters["a"]; math.Abs(got-5.0/8) > 1e-9 {
+		t.Errorf("частота a без учета регистра: %.6f, ожидалось %.6f", got, 5.0/8)
+	}
+}
+
+func TestAnalyzeLayoutCaseSensitive(t *testing.T) {
+	config, err := LoadKeyboardConfig("../../configs/config.txt")
+	if err != nil {
+		t.Fatalf("ошибка загрузки config.txt: %v", err)
+	}
+	config.CaseSensitive = true
+	langData := processCorpus(t, caseCorpus, "abAB", true)
+
+	// Строчные буквы на левой руке, заглавные - на правой
+	layout := Layout{Name: "case", Keys: [3][10]string{
+		{"q", "w", "e", "r", "t", "y", "u", "i", "o", "p"},
+		{"a", "b", "d", "f", "g", "h", "j", "k", "A", "B"},
+		{"z", "x", "c", "v", "n", "m", ",", ".", "/", ";"},
+	}}
+	analysis := AnalyzeLayout(&layout, config, langData)
+	if got := analysis.EffortByHalf[1]; math.Abs(got-37.5) > 1e-6 {
+		t.Errorf("усилие правой руки %.4f%%, ожидалось 37.5%% (доля заглавных букв)", got)
+	}
+}
+
+func TestSameKeysCaseSensitive(t *testing.T) {
+	lower := selftestLayout
+	upper := selftestLayout
+	upper.Keys[1][0] = "A"
+	if !lower.SameKeys(&upper, false) {
+		t.Errorf("без case_sensitive заглавная буква должна совпадать со строчной")
+	}
+	if lower.SameKeys(&upper, true) {
+		t.Errorf("с case_sensitive заглавная буква не должна совпадать со строчной")
+	}
+}
+
+func TestParseAlphabetOverlappingGroups(t *testing.T) {
+	_, _, err := parseAlphabet("[ab]c[da]")
+	if err == nil {
+		t.Fatalf("символ из двух групп принят без ошибки")
+	}
+	if !strings.Contains(err.Error(), `'a'`) {
+		t.Errorf("в ошибке не указан повторяющийся символ: %v", err)
+	}
+
+	// Повтор той же группы не является конфликтом
+	_, charGroups, err := parseAlphabet("[ab]c[ab]")
+	if err != nil {
+		t.Fatalf("повтор той же группы: %v", err)
+	}
+	if charGroups["b"] != "a" {
+		t.Errorf("b отнесена к группе %q, ожидалась a", charGroups["b"])
+	}
+
+	dir := t.TempDir()
+	textFile := filepath.Join(dir, "corpus.txt")
+	if err := os.WriteFile(textFile, []byte("abcd"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ProcessTextFile(textFile, "[ab]c[da]", filepath.Join(dir, "language.json"), false, false, NumberFormat{Decimals: -1}); err == nil {
+		t.Errorf("языковой файл сформирован с неоднозначным алфавитом")
+	}
+}
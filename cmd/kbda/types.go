@@ -1,22 +1,46 @@
 package main
 
+import "strings"
+
 // LanguageData содержит данные о языке - частоты букв и биграмм
 type LanguageData struct {
-	Language   string             `json:"language"`
-	Characters map[string]float64 `json:"characters"`
-	Bigrams    map[string]float64 `json:"bigrams"`
+	Language    string             `json:"language"`
+	Source      string             `json:"source,omitempty"`       // Источник статистики (например, имя текстового файла)
+	SampleSize  int                `json:"sample_size,omitempty"`  // Количество символов в исходном тексте
+	GeneratedAt string             `json:"generated_at,omitempty"` // Дата и время формирования статистики
+	Notes       string             `json:"notes,omitempty"`        // Произвольные примечания
+	Characters  map[string]float64 `json:"characters"`
+	Bigrams     map[string]float64 `json:"bigrams"`
 }
 
 // KeyboardConfig содержит конфигурацию клавиатуры
 type KeyboardConfig struct {
 	EffortMatrix           [3][10]float64 // Матрица усилий (3 ряда x 10 столбцов)
+	NumberRowEfforts       []float64      // Усилия цифрового ряда (nil если в матрице усилий только 3 строки)
 	FixedPositions         [3][10]string  // Матрица фиксированных позиций ('x' или '.')
 	MaxFingerEfforts       [8]float64     // Максимальное значение усилия для каждого пальца
 	FingerEffortPenalties  [8]float64     // Значения штрафа за превышение максимальной нагрузки для каждого пальца
+	MaxFingerTravel        [8]float64     // Максимальное перемещение каждого пальца в биграммах одного пальца (0 - без ограничения)
+	FingerTravelPenalties  [8]float64     // Значения штрафа за превышение максимального перемещения для каждого пальца
 	MaxRowEfforts          [3]float64     // Максимальные значения усилия для каждого ряда (MR1, MR2, MR3)
 	RowEffortPenalties     [3]float64     // Значения штрафа за превышение максимальной нагрузки для каждого ряда (PR1, PR2, PR3)
 	Weights                WeightConfig   // Коэффициенты весов для параметров
 	BigramIndividualCoeffs []BigramIndividualCoeff // Индивидуальные коэффициенты для отдельных биграмм
+	ThumbCols              []int          // Колонки (0-9), клавиши которых нажимаются большими пальцами (пусто - нет)
+	ExcludedCols           []int          // Колонки (0-9), исключаемые из HVB, FVB, SRB, HSB и FSB (пусто - thumb_cols или колонки 5 и 6)
+	SplitCol               int            // Колонка, перед которой выводится пробел между половинками (только для отображения)
+	Precision              int            // Дополнительные знаки после запятой в таблицах l и lb (от -1 до 3, 0 - по умолчанию)
+	PercentDisplay         string         // Вывод нормированной частоты биграмм в командах a и b: clamp (по умолчанию) или mark
+	HandBiasTarget         float64        // Целевая доля нагрузки на правую руку (%) для показателя HandBias (50 - по умолчанию)
+	Vowels                 string         // Гласные для команды vc (пусто - гласные русского и английского алфавитов)
+	SpaceCol               int            // Колонка (1-10), под которой находится клавиша пробела (0 - пробел не анализируется)
+	SpaceEffort            float64        // Усилие нажатия клавиши пробела
+	GGMinImprovement       float64        // Минимальное улучшение оценки, при котором gg считает раскладку новой лучшей
+	PerturbationSwaps      int            // Количество случайных перестановок при рестарте с возмущением лучшей раскладки
+	PerturbationFraction   float64        // Доля рестартов поиска с возмущением лучшей раскладки (0 - отключено)
+	EffortScaledBigrams    bool           // Штрафные метрики биграмм домножаются на среднее усилие клавиш биграммы
+	CaseSensitive          bool           // Заглавные и строчные буквы анализируются как разные клавиши без замены заглавной буквы строчной
+	HomeKeys               []int          // Домашние позиции (0-29) для показателя HomeUse
 }
 
 // BigramIndividualCoeff структура для хранения индивидуального коэффициента для биграммы
@@ -58,6 +82,14 @@ type WeightConfig struct {
 	FSBStrictMode   int     // Strict mode for FSB calculation (1=strict, 0=non-strict)
 	LSBStrictMode   int     // Strict mode for LSB calculation (1=strict, 0=non-strict)
 	TotalEffortNorm float64 // Нормализующий коэффициент для общего усилия
+	PinkyNorm       float64 // Нормирующий коэффициент для нагрузки на мизинцы
+	HomeUseNorm     float64 // Нормирующий коэффициент для доли нажатий на домашние позиции (уменьшает оценку)
+	RowJumpNorm     float64 // Нормирующий коэффициент для переходов между верхним и нижним рядом одной руки
+	LSBAll          float64 // Lateral Stretch Bigrams для всех пар пальцев
+	SKB             float64 // Same Key Bigrams (повторное нажатие одной клавиши)
+	HandBias        float64 // Коэффициент отклонения нагрузки на правую руку от целевой доли (hand_bias_target)
+	ICS             float64 // Index Center Stretch
+	SymSFB          float64 // Symmetric Same Finger Bigrams
 	// Дополнительные параметры для MEP
 	MaxRowEffort1   float64 // Максимальное усилие для 1 ряда (MR1)
 	MaxRowEffort2   float64 // Максимальное усилие для 2 ряда (MR2)
@@ -71,8 +103,10 @@ type WeightConfig struct {
 type Layout struct {
 	Name        string
 	Keys        [3][10]string // 3 ряда x 10 столбцов
+	NumberRow   []string      // Необязательный цифровой ряд над основными рядами (nil если отсутствует)
 	PreComments []string      // Комментарии перед раскладкой
 	PostComments []string      // Комментарии после раскладки
+	Source      string        // Файл, из которого загружена раскладка, при загрузке нескольких файлов (пусто - единственный файл)
 }
 
 // LayoutAnalysis содержит результаты анализа раскладки
@@ -80,17 +114,36 @@ type LayoutAnalysis struct {
 	LayoutName     string
 	LayoutIndex    int        // Индекс раскладки в файле (1-based)
 	TotalEffort    float64    // Суммарное усилие (%)
+	RawEffort      float64    // Среднее усилие на одно нажатие по матрице усилий, без нормировки
 	EffortByRow    [3]float64 // Усилие по рядам (%)
 	EffortByFinger [8]float64 // Усилие по пальцам (%)
 	EffortByHalf   [2]float64 // Усилие по половинкам (%)
 	BigramAnalysis BigramAnalysis
 	HDI            float64       // Hand Disbalance Index
 	FDI            float64       // Finger Disbalance Index
+	FingerStdDev   float64       // Стандартное отклонение нагрузки по пальцам (%)
 	MEP            float64       // Maximum Effort Penalty
+	FTP            float64       // Finger Travel Penalty (штраф за превышение перемещения пальцев)
+	PinkyLoad      float64       // Суммарная нагрузка на мизинцы (%)
+	HomeUse        float64       // Доля нажатий на домашние позиции (%)
+	NumberRowLoad  float64       // Усилие на цифровом ряду (%), если он есть в раскладке
+	BigramCoverage float64       // Доля частоты биграмм языка (%), которые учтены в анализе биграмм
 	WeightedScore  float64       // Итоговая взвешенная оценка
 	Config         *KeyboardConfig // Reference to the configuration for accessing weights
 }
 
+// ScoreTerm слагаемое итоговой взвешенной оценки раскладки
+type ScoreTerm struct {
+	Name   string
+	Value  float64 // Значение показателя
+	Weight float64 // Вес показателя (1 для слагаемых, которые входят в оценку без веса)
+}
+
+// Contribution возвращает вклад слагаемого в итоговую оценку
+func (t ScoreTerm) Contribution() float64 {
+	return t.Weight * t.Value
+}
+
 // BigramAnalysis содержит анализ биграмм
 type BigramAnalysis struct {
 	SHB  float64 // Same Hand Bigram
@@ -111,6 +164,11 @@ type BigramAnalysis struct {
 	FSB2 float64 // Full Scissors Bigrams (вне строгого режима)
 	LSB2 float64 // Lateral Stretch Bigrams (вне строгого режима)
 	SKB  float64 // Same Key Bigrams
+	ICS  float64 // Index Center Stretch (указательный палец, основная и центральная колонка на одной руке)
+	SymSFB float64 // Symmetric Same Finger Bigrams (один и тот же палец на любой руке, например оба указательных)
+	RowJump float64 // Row Jump (переход между верхним и нижним рядом на одной руке любыми пальцами)
+	LSBAll float64 // Lateral Stretch Bigrams для всех пар пальцев (одна рука, через колонку, тот же или соседний ряд)
+	FingerTravel [8]float64 // Перемещение каждого пальца в биграммах одного пальца (доля биграмм в %, умноженная на расстояние в клавишах)
 	TIB  float64 // Total on Individual Bigrams (сумма частот биграмм с индивидуальными коэффициентами, домноженных на соответствующий коэффициент)
 }
 
@@ -118,6 +176,7 @@ type BigramAnalysis struct {
 type ParsedLayouts struct {
 	Layouts []Layout
 	FileHeaderComments []string  // Комментарии в начале файла до первой раскладки
+	Separator          string    // Разделитель клавиш в строках раскладок (пусто - пробельные символы)
 }
 
 // BigramFreq структура для хранения биграммы и её частоты
@@ -142,3 +201,32 @@ func (l *Layout) Equals(other *Layout) bool {
 
 	return true
 }
+
+// SameKeys проверяет, совпадает ли расположение клавиш двух раскладок без учета названия.
+// Регистр учитывается только при caseSensitive (case_sensitive=1), когда заглавные буквы являются
+// отдельными клавишами, иначе они отмечают только закрепленные позиции
+func (l *Layout) SameKeys(other *Layout, caseSensitive bool) bool {
+	sameKey := strings.EqualFold
+	if caseSensitive {
+		sameKey = func(a, b string) bool { return a == b }
+	}
+
+	if len(l.NumberRow) != len(other.NumberRow) {
+		return false
+	}
+	for col := range l.NumberRow {
+		if !sameKey(l.NumberRow[col], other.NumberRow[col]) {
+			return false
+		}
+	}
+
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 10; col++ {
+			if !sameKey(l.Keys[row][col], other.Keys[row][col]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}